@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// companyIDKey is the gin context key of the company the request's API token belongs to
+const companyIDKey = "company_id"
+
+// apiTokensSchema stores the SHA-256 of each token, tokens are random so the hash can't be reversed
+const apiTokensSchema = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id           BIGSERIAL PRIMARY KEY,
+	company_id   BIGINT NOT NULL,
+	name         TEXT NOT NULL DEFAULT '',
+	token_sha256 TEXT NOT NULL UNIQUE,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	revoked_at   TIMESTAMPTZ
+);`
+
+// APITokenAuth authenticates API requests with the bearer tokens in api_tokens. Each token belongs
+// to a company, and the handlers behind the middleware only serve that company's data.
+type APITokenAuth struct {
+	DB *sql.DB
+}
+
+// NewAPITokenAuth creates a new instance of APITokenAuth
+func NewAPITokenAuth(db *sql.DB) *APITokenAuth {
+	return &APITokenAuth{DB: db}
+}
+
+// EnsureSchema creates the api_tokens table if it doesn't exist
+func (a *APITokenAuth) EnsureSchema(ctx context.Context) error {
+	if _, err := a.DB.ExecContext(ctx, apiTokensSchema); err != nil {
+		return fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+	return nil
+}
+
+// Middleware rejects requests without a valid token and stores the token's company on the context
+func (a *APITokenAuth) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API token"})
+			return
+		}
+
+		sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		var companyID int64
+		err := a.DB.QueryRowContext(ctx.Request.Context(),
+			"SELECT company_id FROM api_tokens WHERE token_sha256 = $1 AND revoked_at IS NULL",
+			hex.EncodeToString(sum[:])).Scan(&companyID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API token"})
+			} else {
+				log.Error().Err(err).Msg("Failed to look up API token")
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate request"})
+			}
+			return
+		}
+
+		ctx.Set(companyIDKey, companyID)
+		ctx.Next()
+	}
+}
+
+// CompanyScope returns the company of the request's API token
+func CompanyScope(ctx *gin.Context) (int64, bool) {
+	companyID, ok := ctx.Get(companyIDKey)
+	if !ok {
+		return 0, false
+	}
+	id, ok := companyID.(int64)
+	return id, ok
+}
+
+// requireCompany returns the company the request is scoped to, or writes the error response. A
+// company_id parameter is still accepted, but must be the token's company.
+func requireCompany(ctx *gin.Context) (int64, bool) {
+	companyID, ok := CompanyScope(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Missing API token"})
+		return 0, false
+	}
+	if requested := ctx.Query("company_id"); requested != "" && requested != strconv.FormatInt(companyID, 10) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "company_id doesn't match the API token"})
+		return 0, false
+	}
+	return companyID, true
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPITokenAuthRejectsMissingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// The token is checked before the database is queried
+	router.GET("/reviews", NewAPITokenAuth(nil).Middleware(), func(ctx *gin.Context) {
+		t.Error("handler called without a token")
+	})
+
+	for _, header := range []string{"", "Basic dXNlcjpwYXNz", "Bearer  "} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/reviews", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q = %d, want 401", header, recorder.Code)
+		}
+	}
+}
+
+func TestRequireCompany(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		companyID  interface{}
+		query      string
+		wantStatus int
+	}{
+		{"token company", int64(7), "", http.StatusOK},
+		{"matching company_id", int64(7), "?company_id=7", http.StatusOK},
+		{"other company_id", int64(7), "?company_id=8", http.StatusForbidden},
+		{"not authenticated", nil, "?company_id=7", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/reviews"+tt.query, nil)
+			if tt.companyID != nil {
+				ctx.Set(companyIDKey, tt.companyID)
+			}
+
+			companyID, ok := requireCompany(ctx)
+			if ok != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("requireCompany() ok = %v, want status %d", ok, tt.wantStatus)
+			}
+			if ok && companyID != 7 {
+				t.Errorf("requireCompany() = %d, want 7", companyID)
+			}
+			if !ok && recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// NotificationController serves the notifications delivered to the in-app sink. Its routes must be
+// registered behind APITokenAuth, every query is scoped to the token's company.
+type NotificationController struct {
+	DB *sql.DB
+}
+
+// NewNotificationController creates a new instance of NotificationController
+func NewNotificationController(db *sql.DB) *NotificationController {
+	return &NotificationController{DB: db}
+}
+
+// RegisterRoutes registers the routes for NotificationController
+func (c *NotificationController) RegisterRoutes(router *gin.RouterGroup) {
+	notifications := router.Group("/notifications")
+	{
+		notifications.GET("", c.listNotifications)
+		notifications.POST("/:id/read", c.markNotificationRead)
+	}
+}
+
+// notification is a row of notifications
+type notification struct {
+	ID        int64           `json:"id"`
+	CompanyID int64           `json:"company_id"`
+	Type      string          `json:"type"`
+	Title     string          `json:"title"`
+	Message   string          `json:"message"`
+	Key       string          `json:"key"`
+	Fields    json.RawMessage `json:"fields,omitempty"`
+	Count     int             `json:"count"`
+	CreatedAt time.Time       `json:"created_at"`
+	ReadAt    *time.Time      `json:"read_at"`
+}
+
+// listNotifications handles GET /notifications
+//
+// Lists the notifications of the token's company. Filters: type and unread=true, plus limit and
+// offset for paging.
+func (c *NotificationController) listNotifications(ctx *gin.Context) {
+	companyID, ok := requireCompany(ctx)
+	if !ok {
+		return
+	}
+	conditions := []string{"company_id = $1"}
+	args := []interface{}{companyID}
+	if notificationType := ctx.Query("type"); notificationType != "" {
+		args = append(args, notificationType)
+		conditions = append(conditions, fmt.Sprintf("type = $%d", len(args)))
+	}
+	if ctx.Query("unread") == "true" {
+		conditions = append(conditions, "read_at IS NULL")
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultNotificationLimit)))
+	if err != nil || limit <= 0 || limit > maxNotificationLimit {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxNotificationLimit)})
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	args = append(args, limit, offset)
+
+	rows, err := c.DB.Query(fmt.Sprintf(`SELECT id, company_id, type, title, message, key, fields, count, created_at, read_at
+		FROM notifications WHERE %s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+		strings.Join(conditions, " AND "), len(args)-1, len(args)), args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query notifications")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notifications"})
+		return
+	}
+	defer rows.Close()
+
+	notifications := []notification{}
+	for rows.Next() {
+		var n notification
+		var title, message, key, fields sql.NullString
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.CompanyID, &n.Type, &title, &message, &key, &fields, &n.Count, &n.CreatedAt, &readAt); err != nil {
+			log.Error().Err(err).Msg("Failed to scan notification row")
+			continue
+		}
+		n.Title = title.String
+		n.Message = message.String
+		n.Key = key.String
+		if fields.Valid && fields.String != "" && fields.String != "null" {
+			n.Fields = json.RawMessage(fields.String)
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("Error iterating notification rows")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing notifications"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"limit":         limit,
+		"offset":        offset,
+	})
+}
+
+// markNotificationRead handles POST /notifications/:id/read
+//
+// Notifications of other companies are reported as not found.
+func (c *NotificationController) markNotificationRead(ctx *gin.Context) {
+	companyID, ok := requireCompany(ctx)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	result, err := c.DB.Exec("UPDATE notifications SET read_at = COALESCE(read_at, NOW()) WHERE id = $1 AND company_id = $2", id, companyID)
+	if err != nil {
+		log.Error().Err(err).Int64("notificationID", id).Msg("Failed to mark notification read")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
@@ -0,0 +1,464 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultReviewRunLimit = 50
+	maxReviewRunLimit     = 200
+)
+
+// ReviewController serves the review dashboard from the review runs recorded by the workflow. Its
+// routes must be registered behind APITokenAuth, every query is scoped to the token's company.
+type ReviewController struct {
+	DB *sql.DB
+}
+
+// NewReviewController creates a new instance of ReviewController
+func NewReviewController(db *sql.DB) *ReviewController {
+	return &ReviewController{DB: db}
+}
+
+// RegisterRoutes registers the routes for ReviewController
+func (c *ReviewController) RegisterRoutes(router *gin.RouterGroup) {
+	reviews := router.Group("/reviews")
+	{
+		reviews.GET("", c.listReviewRuns)
+		reviews.GET("/costs", c.getReviewCosts)
+		reviews.GET("/:id", c.getReviewRun)
+	}
+}
+
+// reviewRun is a row of review_runs
+type reviewRun struct {
+	ID            int64      `json:"id"`
+	CompanyID     int64      `json:"company_id"`
+	Owner         string     `json:"owner"`
+	Repo          string     `json:"repo"`
+	PRNumber      int        `json:"pr_number"`
+	RequestID     string     `json:"request_id"`
+	CommitSHA     string     `json:"commit_sha"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	DurationMs    int64      `json:"duration_ms"`
+	PostedCount   int        `json:"posted_count"`
+	FilteredCount int        `json:"filtered_count"`
+}
+
+// reviewRunStep is a row of review_run_steps
+type reviewRunStep struct {
+	Step       string          `json:"step"`
+	Failed     bool            `json:"failed"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+	LoggedAt   time.Time       `json:"logged_at"`
+	Fields     json.RawMessage `json:"fields,omitempty"`
+}
+
+// reviewRunComment is a row of review_run_comments
+type reviewRunComment struct {
+	ID               int64  `json:"id"`
+	Path             string `json:"path"`
+	Line             int    `json:"line"`
+	Type             string `json:"type"`
+	Severity         string `json:"severity"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	Body             string `json:"body"`
+	AcceptanceReason string `json:"acceptance_reason,omitempty"`
+	RejectionReason  string `json:"rejection_reason,omitempty"`
+	RejectionModel   string `json:"rejection_model,omitempty"`
+}
+
+// providerUsage sums the review_ledger_entries of one provider and model
+type providerUsage struct {
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Calls            int     `json:"calls"`
+	FailedCalls      int     `json:"failed_calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+const reviewRunColumns = `id, company_id, owner, repo, pr_number, request_id, commit_sha, status, error,
+	started_at, finished_at, duration_ms, posted_count, filtered_count`
+
+func scanReviewRun(row interface{ Scan(...any) error }) (*reviewRun, error) {
+	var run reviewRun
+	var requestID, commitSHA, runError sql.NullString
+	var finishedAt sql.NullTime
+	err := row.Scan(&run.ID, &run.CompanyID, &run.Owner, &run.Repo, &run.PRNumber, &requestID, &commitSHA,
+		&run.Status, &runError, &run.StartedAt, &finishedAt, &run.DurationMs, &run.PostedCount, &run.FilteredCount)
+	if err != nil {
+		return nil, err
+	}
+	run.RequestID = requestID.String
+	run.CommitSHA = commitSHA.String
+	run.Error = runError.String
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return &run, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps and plain dates
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// listReviewRuns handles GET /reviews
+//
+// Lists the runs of the token's company. Filters: repo ("owner/name" or "name"), pr, status, since
+// and until (RFC 3339 or YYYY-MM-DD, until is exclusive), plus limit and offset for paging.
+func (c *ReviewController) listReviewRuns(ctx *gin.Context) {
+	companyID, ok := requireCompany(ctx)
+	if !ok {
+		return
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	addCondition("company_id = $%d", companyID)
+	if repo := ctx.Query("repo"); repo != "" {
+		if owner, name, ok := strings.Cut(repo, "/"); ok {
+			addCondition("owner = $%d", owner)
+			addCondition("repo = $%d", name)
+		} else {
+			addCondition("repo = $%d", repo)
+		}
+	}
+	if pr := ctx.Query("pr"); pr != "" {
+		prNumber, err := strconv.Atoi(pr)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pr"})
+			return
+		}
+		addCondition("pr_number = $%d", prNumber)
+	}
+	if status := ctx.Query("status"); status != "" {
+		addCondition("status = $%d", status)
+	}
+	for _, param := range []struct {
+		name      string
+		condition string
+	}{
+		{"since", "started_at >= $%d"},
+		{"until", "started_at < $%d"},
+	} {
+		value := ctx.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseDateParam(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", expected RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+		addCondition(param.condition, t)
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultReviewRunLimit)))
+	if err != nil || limit <= 0 || limit > maxReviewRunLimit {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxReviewRunLimit)})
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	query := "SELECT " + reviewRunColumns + " FROM review_runs WHERE " + strings.Join(conditions, " AND ")
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY started_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := c.DB.Query(query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query review runs")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review runs"})
+		return
+	}
+	defer rows.Close()
+
+	runs := []*reviewRun{}
+	for rows.Next() {
+		run, err := scanReviewRun(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan review run row")
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("Error iterating review run rows")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing review runs"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"runs":   runs,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// getReviewRun handles GET /reviews/:id
+//
+// Runs of other companies are reported as not found.
+func (c *ReviewController) getReviewRun(ctx *gin.Context) {
+	companyID, ok := requireCompany(ctx)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review run ID"})
+		return
+	}
+
+	run, err := scanReviewRun(c.DB.QueryRow("SELECT "+reviewRunColumns+" FROM review_runs WHERE id = $1 AND company_id = $2", id, companyID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Review run not found"})
+		} else {
+			log.Error().Err(err).Int64("reviewRunID", id).Msg("Failed to query review run")
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review run"})
+		}
+		return
+	}
+
+	steps, err := c.reviewRunSteps(id)
+	if err != nil {
+		log.Error().Err(err).Int64("reviewRunID", id).Msg("Failed to query review run steps")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review run"})
+		return
+	}
+
+	posted, filtered, err := c.reviewRunComments(id)
+	if err != nil {
+		log.Error().Err(err).Int64("reviewRunID", id).Msg("Failed to query review run comments")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review run"})
+		return
+	}
+
+	usage, err := c.reviewRunUsage(id)
+	if err != nil {
+		log.Error().Err(err).Int64("reviewRunID", id).Msg("Failed to query review run token usage")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review run"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"run":   run,
+		"steps": steps,
+		"comments": gin.H{
+			"posted":   posted,
+			"filtered": filtered,
+		},
+		"token_usage": usage,
+	})
+}
+
+// getReviewCosts handles GET /reviews/costs
+//
+// Sums the token usage and cost of the token's company's model calls per provider and model.
+// Filters: repo ("owner/name" or "name"), pr, execution_id, and since and until (RFC 3339 or
+// YYYY-MM-DD, until is exclusive).
+func (c *ReviewController) getReviewCosts(ctx *gin.Context) {
+	companyID, ok := requireCompany(ctx)
+	if !ok {
+		return
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	addCondition("company_id = $%d", companyID)
+	if repo := ctx.Query("repo"); repo != "" {
+		if owner, name, ok := strings.Cut(repo, "/"); ok {
+			addCondition("owner = $%d", owner)
+			addCondition("repo = $%d", name)
+		} else {
+			addCondition("repo = $%d", repo)
+		}
+	}
+	for _, param := range []struct {
+		name      string
+		condition string
+	}{
+		{"pr", "pr_number = $%d"},
+		{"execution_id", "code_workflow_execution_id = $%d"},
+	} {
+		value := ctx.Query(param.name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name})
+			return
+		}
+		addCondition(param.condition, id)
+	}
+	for _, param := range []struct {
+		name      string
+		condition string
+	}{
+		{"since", "created_at >= $%d"},
+		{"until", "created_at < $%d"},
+	} {
+		value := ctx.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseDateParam(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ", expected RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+		addCondition(param.condition, t)
+	}
+
+	rows, err := c.DB.Query(`SELECT provider, model, COUNT(*), COUNT(*) FILTER (WHERE failed),
+		COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost_usd), 0)
+		FROM review_ledger_entries WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY provider, model ORDER BY SUM(cost_usd) DESC`, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query review costs")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve review costs"})
+		return
+	}
+	defer rows.Close()
+
+	usage := []providerUsage{}
+	totalCost := 0.0
+	for rows.Next() {
+		var u providerUsage
+		if err := rows.Scan(&u.Provider, &u.Model, &u.Calls, &u.FailedCalls, &u.PromptTokens, &u.CompletionTokens, &u.CostUSD); err != nil {
+			log.Error().Err(err).Msg("Failed to scan review cost row")
+			continue
+		}
+		usage = append(usage, u)
+		totalCost += u.CostUSD
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msg("Error iterating review cost rows")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing review costs"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"usage":          usage,
+		"total_cost_usd": totalCost,
+	})
+}
+
+// reviewRunSteps returns the steps of a run in the order they were logged
+func (c *ReviewController) reviewRunSteps(runID int64) ([]reviewRunStep, error) {
+	rows, err := c.DB.Query(`SELECT step, failed, error, duration_ms, logged_at, fields
+		FROM review_run_steps WHERE review_run_id = $1 ORDER BY logged_at, id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []reviewRunStep{}
+	for rows.Next() {
+		var step reviewRunStep
+		var stepError, fields sql.NullString
+		if err := rows.Scan(&step.Step, &step.Failed, &stepError, &step.DurationMs, &step.LoggedAt, &fields); err != nil {
+			return nil, err
+		}
+		step.Error = stepError.String
+		if fields.Valid && fields.String != "" {
+			step.Fields = json.RawMessage(fields.String)
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// reviewRunComments returns the comments of a run split by whether they were posted
+func (c *ReviewController) reviewRunComments(runID int64) (posted []reviewRunComment, filtered []reviewRunComment, err error) {
+	rows, err := c.DB.Query(`SELECT id, path, line, type, severity, provider, model, body, posted,
+		acceptance_reason, rejection_reason, rejection_model
+		FROM review_run_comments WHERE review_run_id = $1 ORDER BY path, line, id`, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	posted, filtered = []reviewRunComment{}, []reviewRunComment{}
+	for rows.Next() {
+		var comment reviewRunComment
+		var isPosted bool
+		var commentType, severity, provider, model, acceptanceReason, rejectionReason, rejectionModel sql.NullString
+		if err := rows.Scan(&comment.ID, &comment.Path, &comment.Line, &commentType, &severity, &provider, &model,
+			&comment.Body, &isPosted, &acceptanceReason, &rejectionReason, &rejectionModel); err != nil {
+			return nil, nil, err
+		}
+		comment.Type = commentType.String
+		comment.Severity = severity.String
+		comment.Provider = provider.String
+		comment.Model = model.String
+		comment.AcceptanceReason = acceptanceReason.String
+		comment.RejectionReason = rejectionReason.String
+		comment.RejectionModel = rejectionModel.String
+		if isPosted {
+			posted = append(posted, comment)
+		} else {
+			filtered = append(filtered, comment)
+		}
+	}
+	return posted, filtered, rows.Err()
+}
+
+// reviewRunUsage sums the token usage and cost of a run's model calls per provider and model
+func (c *ReviewController) reviewRunUsage(runID int64) ([]providerUsage, error) {
+	rows, err := c.DB.Query(`SELECT provider, model, COUNT(*), COUNT(*) FILTER (WHERE failed),
+		COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost_usd), 0)
+		FROM review_ledger_entries WHERE review_run_id = $1
+		GROUP BY provider, model ORDER BY provider, model`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []providerUsage{}
+	for rows.Next() {
+		var u providerUsage
+		if err := rows.Scan(&u.Provider, &u.Model, &u.Calls, &u.FailedCalls, &u.PromptTokens, &u.CompletionTokens, &u.CostUSD); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"code-review-bot-test-repo/services"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// maxWebhookPayloadBytes limits the size of webhook payloads read into memory
+const maxWebhookPayloadBytes = 25 << 20
+
+// githubRepository is the repository part of a webhook payload
+type githubRepository struct {
+	Name  string `json:"name"`
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+// githubPullRequest is the pull request part of a webhook payload
+type githubPullRequest struct {
+	Number         int    `json:"number"`
+	Draft          bool   `json:"draft"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+	Head           struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+// githubUser is the user part of a webhook payload
+type githubUser struct {
+	Login string `json:"login"`
+	Type  string `json:"type"`
+}
+
+// githubWebhookPayload holds the fields of the pull_request, issue_comment, pull_request_review
+// and pull_request_review_comment payloads that are needed to create a review job
+type githubWebhookPayload struct {
+	Action      string             `json:"action"`
+	Repository  githubRepository   `json:"repository"`
+	Sender      githubUser         `json:"sender"`
+	PullRequest *githubPullRequest `json:"pull_request"`
+	Issue       *struct {
+		Number      int       `json:"number"`
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	Comment *struct {
+		ID          int64      `json:"id"`
+		InReplyToID int64      `json:"in_reply_to_id"`
+		Body        string     `json:"body"`
+		User        githubUser `json:"user"`
+	} `json:"comment"`
+}
+
+// pullRequestReviewActions are the pull_request actions that require a new review
+var pullRequestReviewActions = map[string]bool{
+	"opened":           true,
+	"reopened":         true,
+	"synchronize":      true,
+	"ready_for_review": true,
+}
+
+// WebhookController receives GitHub webhooks and turns them into review jobs
+type WebhookController struct {
+	queue  services.ReviewJobQueue
+	secret []byte
+}
+
+// NewWebhookController creates a new instance of WebhookController
+func NewWebhookController(queue services.ReviewJobQueue, secret string) *WebhookController {
+	return &WebhookController{
+		queue:  queue,
+		secret: []byte(secret),
+	}
+}
+
+// RegisterRoutes registers the routes for WebhookController
+func (c *WebhookController) RegisterRoutes(router *gin.RouterGroup) {
+	router.POST("/webhooks/github", c.receiveGitHubWebhook)
+}
+
+// verifySignature checks the X-Hub-Signature-256 header against the HMAC of the payload
+func (c *WebhookController) verifySignature(payload []byte, signature string) bool {
+	if len(c.secret) == 0 || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// receiveGitHubWebhook handles POST /webhooks/github
+func (c *WebhookController) receiveGitHubWebhook(ctx *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxWebhookPayloadBytes))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read webhook payload")
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read payload"})
+		return
+	}
+
+	if !c.verifySignature(payload, ctx.GetHeader("X-Hub-Signature-256")) {
+		log.Warn().Str("delivery_id", ctx.GetHeader("X-GitHub-Delivery")).Msg("Rejected webhook with invalid signature")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	event := ctx.GetHeader("X-GitHub-Event")
+	if event == "ping" {
+		ctx.JSON(http.StatusOK, gin.H{"status": "pong"})
+		return
+	}
+
+	var body githubWebhookPayload
+	if err := json.Unmarshal(payload, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	job, ok := reviewJobFromWebhook(event, &body)
+	if !ok {
+		ctx.JSON(http.StatusOK, gin.H{"status": "ignored"})
+		return
+	}
+	job.DeliveryID = ctx.GetHeader("X-GitHub-Delivery")
+
+	result, err := c.queue.Enqueue(ctx.Request.Context(), *job)
+	if err != nil {
+		log.Error().Err(err).
+			Str("event", event).
+			Str("repository", job.Owner+"/"+job.Repo).
+			Int("pr_number", job.PRNumber).
+			Msg("Failed to enqueue review job")
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue review job"})
+		return
+	}
+
+	log.Info().
+		Str("event", event).
+		Str("action", job.Action).
+		Str("repository", job.Owner+"/"+job.Repo).
+		Int("pr_number", job.PRNumber).
+		Str("head_sha", job.HeadSHA).
+		Str("result", result).
+		Msg("Received GitHub webhook")
+	ctx.JSON(http.StatusAccepted, gin.H{"status": result})
+}
+
+// reviewJobFromWebhook builds the review job for a webhook event, or returns false if the event
+// doesn't need any work
+func reviewJobFromWebhook(event string, body *githubWebhookPayload) (*services.ReviewJob, bool) {
+	job := &services.ReviewJob{
+		Event:  event,
+		Action: body.Action,
+		Owner:  body.Repository.Owner.Login,
+		Repo:   body.Repository.Name,
+		Sender: body.Sender.Login,
+	}
+
+	switch event {
+	case "pull_request":
+		// Merged pull requests update the code index with the files they changed
+		if body.Action == "closed" && body.PullRequest != nil && body.PullRequest.Merged {
+			job.Kind = services.ReviewJobKindIndex
+			job.PRNumber = body.PullRequest.Number
+			job.HeadSHA = body.PullRequest.MergeCommitSHA
+			if job.HeadSHA == "" {
+				job.HeadSHA = body.PullRequest.Head.SHA
+			}
+			break
+		}
+		if body.PullRequest == nil || body.PullRequest.Draft || !pullRequestReviewActions[body.Action] {
+			return nil, false
+		}
+		job.Kind = services.ReviewJobKindReview
+		job.PRNumber = body.PullRequest.Number
+		job.HeadSHA = body.PullRequest.Head.SHA
+
+	case "issue_comment":
+		// Only comments on pull requests, and not the bot's own comments
+		if body.Action != "created" || body.Issue == nil || body.Issue.PullRequest == nil || body.Comment == nil || body.Comment.User.Type == "Bot" {
+			return nil, false
+		}
+		job.Kind = services.ReviewJobKindComment
+		job.PRNumber = body.Issue.Number
+		job.CommentID = body.Comment.ID
+		job.CommentBody = body.Comment.Body
+
+	case "pull_request_review":
+		if body.PullRequest == nil || body.Sender.Type == "Bot" || (body.Action != "submitted" && body.Action != "dismissed") {
+			return nil, false
+		}
+		job.Kind = services.ReviewJobKindReviewEvent
+		job.PRNumber = body.PullRequest.Number
+		job.HeadSHA = body.PullRequest.Head.SHA
+
+	case "pull_request_review_comment":
+		// Only replies in existing threads, whether the thread is the bot's is checked by the handler
+		if body.Action != "created" || body.PullRequest == nil || body.Comment == nil || body.Comment.InReplyToID == 0 || body.Comment.User.Type == "Bot" {
+			return nil, false
+		}
+		job.Kind = services.ReviewJobKindThreadReply
+		job.PRNumber = body.PullRequest.Number
+		job.CommentID = body.Comment.ID
+		job.InReplyToID = body.Comment.InReplyToID
+		job.CommentBody = body.Comment.Body
+
+	default:
+		return nil, false
+	}
+
+	if job.Owner == "" || job.Repo == "" || job.PRNumber == 0 {
+		return nil, false
+	}
+	return job, true
+}
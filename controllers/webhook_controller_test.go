@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code-review-bot-test-repo/services"
+	"github.com/gin-gonic/gin"
+)
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	const payload = `{"action":"opened"}`
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		want      bool
+	}{
+		{"valid", "webhook-secret", sign("webhook-secret", payload), true},
+		{"signed with another secret", "webhook-secret", sign("other-secret", payload), false},
+		{"missing", "webhook-secret", "", false},
+		{"no sha256 prefix", "webhook-secret", strings.TrimPrefix(sign("webhook-secret", payload), "sha256="), false},
+		{"sha1 prefix", "webhook-secret", "sha1=" + strings.TrimPrefix(sign("webhook-secret", payload), "sha256="), false},
+		{"not hex", "webhook-secret", "sha256=not-hex", false},
+		{"empty secret", "", sign("", payload), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewWebhookController(services.NewMemoryReviewJobQueue(), tt.secret)
+			if got := controller.verifySignature([]byte(payload), tt.signature); got != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReviewJobFromWebhook(t *testing.T) {
+	const repository = `"repository": {"name": "hello", "owner": {"login": "octo"}}, "sender": {"login": "alice", "type": "User"}`
+	tests := []struct {
+		name     string
+		event    string
+		payload  string
+		wantKind string
+		wantSHA  string
+	}{
+		{"opened", "pull_request", `{"action": "opened", "pull_request": {"number": 7, "head": {"sha": "abc"}}, ` + repository + `}`, services.ReviewJobKindReview, "abc"},
+		{"synchronize", "pull_request", `{"action": "synchronize", "pull_request": {"number": 7, "head": {"sha": "def"}}, ` + repository + `}`, services.ReviewJobKindReview, "def"},
+		{"draft", "pull_request", `{"action": "opened", "pull_request": {"number": 7, "draft": true, "head": {"sha": "abc"}}, ` + repository + `}`, "", ""},
+		{"labeled", "pull_request", `{"action": "labeled", "pull_request": {"number": 7, "head": {"sha": "abc"}}, ` + repository + `}`, "", ""},
+		{"merged", "pull_request", `{"action": "closed", "pull_request": {"number": 7, "merged": true, "merge_commit_sha": "merge", "head": {"sha": "abc"}}, ` + repository + `}`, services.ReviewJobKindIndex, "merge"},
+		{"closed unmerged", "pull_request", `{"action": "closed", "pull_request": {"number": 7, "head": {"sha": "abc"}}, ` + repository + `}`, "", ""},
+		{"pr comment", "issue_comment", `{"action": "created", "issue": {"number": 7, "pull_request": {}}, "comment": {"id": 1, "body": "/review", "user": {"type": "User"}}, ` + repository + `}`, services.ReviewJobKindComment, ""},
+		{"issue comment", "issue_comment", `{"action": "created", "issue": {"number": 7}, "comment": {"id": 1, "body": "/review", "user": {"type": "User"}}, ` + repository + `}`, "", ""},
+		{"bot comment", "issue_comment", `{"action": "created", "issue": {"number": 7, "pull_request": {}}, "comment": {"id": 1, "body": "Done", "user": {"type": "Bot"}}, ` + repository + `}`, "", ""},
+		{"review submitted", "pull_request_review", `{"action": "submitted", "pull_request": {"number": 7, "head": {"sha": "abc"}}, ` + repository + `}`, services.ReviewJobKindReviewEvent, "abc"},
+		{"review edited", "pull_request_review", `{"action": "edited", "pull_request": {"number": 7, "head": {"sha": "abc"}}, ` + repository + `}`, "", ""},
+		{"thread reply", "pull_request_review_comment", `{"action": "created", "pull_request": {"number": 7}, "comment": {"id": 2, "in_reply_to_id": 1, "body": "Fixed", "user": {"type": "User"}}, ` + repository + `}`, services.ReviewJobKindThreadReply, ""},
+		{"new thread", "pull_request_review_comment", `{"action": "created", "pull_request": {"number": 7}, "comment": {"id": 2, "body": "Nit", "user": {"type": "User"}}, ` + repository + `}`, "", ""},
+		{"push", "push", `{` + repository + `}`, "", ""},
+		{"no repository", "pull_request", `{"action": "opened", "pull_request": {"number": 7, "head": {"sha": "abc"}}}`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body githubWebhookPayload
+			if err := json.Unmarshal([]byte(tt.payload), &body); err != nil {
+				t.Fatal(err)
+			}
+			job, ok := reviewJobFromWebhook(tt.event, &body)
+			if tt.wantKind == "" {
+				if ok {
+					t.Errorf("reviewJobFromWebhook() = %+v, want the event ignored", job)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("reviewJobFromWebhook() ignored the event")
+			}
+			if job.Kind != tt.wantKind || job.HeadSHA != tt.wantSHA || job.Key() != "octo/hello#7" {
+				t.Errorf("job = %+v, want kind %s at %q for octo/hello#7", job, tt.wantKind, tt.wantSHA)
+			}
+		})
+	}
+}
+
+func TestReceiveGitHubWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	queue := services.NewMemoryReviewJobQueue()
+	router := gin.New()
+	NewWebhookController(queue, "webhook-secret").RegisterRoutes(router.Group(""))
+
+	deliver := func(deliveryID, payload, signature string) (int, string) {
+		request := httptest.NewRequest(http.MethodPost, "/webhooks/github", strings.NewReader(payload))
+		request.Header.Set("X-GitHub-Event", "pull_request")
+		request.Header.Set("X-GitHub-Delivery", deliveryID)
+		request.Header.Set("X-Hub-Signature-256", signature)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		var response map[string]string
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response["status"]
+	}
+
+	payload := `{"action": "opened", "pull_request": {"number": 7, "head": {"sha": "abc"}}, "repository": {"name": "hello", "owner": {"login": "octo"}}}`
+	if code, status := deliver("delivery-1", payload, sign("other-secret", payload)); code != http.StatusUnauthorized {
+		t.Errorf("badly signed delivery = %d %s, want 401", code, status)
+	}
+	if code, status := deliver("delivery-1", payload, sign("webhook-secret", payload)); code != http.StatusAccepted || status != services.EnqueueResultEnqueued {
+		t.Errorf("delivery = %d %s, want 202 enqueued", code, status)
+	}
+	if code, status := deliver("delivery-1", payload, sign("webhook-secret", payload)); code != http.StatusAccepted || status != services.EnqueueResultDuplicate {
+		t.Errorf("redelivery = %d %s, want 202 duplicate", code, status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job, err := queue.Dequeue(ctx)
+	if err != nil || job.Kind != services.ReviewJobKindReview || job.DeliveryID != "delivery-1" {
+		t.Errorf("queued job = %+v, %v, want the review of the delivery", job, err)
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"code-review-bot-test-repo/controllers"
+	"code-review-bot-test-repo/services"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -18,6 +20,47 @@ import (
 
 var db *sql.DB
 
+// reviewJobQueue receives the review jobs created from GitHub webhooks
+var reviewJobQueue services.ReviewJobQueue = services.NewMemoryReviewJobQueue()
+
+// startReviewWorkers switches the review job queue to the database and starts the worker pool. It
+// reports whether the jobs will be run, by the pool or by the workers of another service.
+func startReviewWorkers(ctx context.Context, db *sql.DB) (bool, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "review-worker"
+	}
+
+	queue := services.NewPostgresReviewJobQueue(db, services.PostgresReviewJobQueueOptions{
+		WorkerID:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		MaxAttempts: getEnvInt("REVIEW_JOB_MAX_ATTEMPTS", 5),
+	})
+	if err := queue.EnsureSchema(ctx); err != nil {
+		return false, err
+	}
+	reviewJobQueue = queue
+
+	// The review workflow registers its handlers from the service hosting it, with
+	// StartReviewWorkflow. Without them every job would fail until it's dead-lettered, so the jobs
+	// are left in the queue, for the workers of that service if REVIEW_WORKERS_EXTERNAL says it runs
+	// them.
+	if missing := services.MissingReviewJobHandlers(); len(missing) > 0 {
+		if getEnv("REVIEW_WORKERS_EXTERNAL", "") == "true" {
+			log.Info().Strs("missing_kinds", missing).Msg("Review jobs are run by the service hosting the review workflow")
+			return true, nil
+		}
+		log.Error().Strs("missing_kinds", missing).Msg("No handlers registered for review jobs, not starting review workers")
+		return false, nil
+	}
+
+	poolSize := getEnvInt("REVIEW_WORKER_POOL_SIZE", 4)
+	pool := services.NewReviewWorkerPool(queue, services.DispatchReviewJob, poolSize, time.Minute)
+	go pool.Run(ctx)
+
+	log.Info().Int("pool_size", poolSize).Msg("Started review workers")
+	return true, nil
+}
+
 func initDB() (*sql.DB, error) {
 	// In a real application, use environment variables or a config file
 	dbConfig := struct {
@@ -75,9 +118,26 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func setupRouter(db *sql.DB) *gin.Engine {
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warn().Str("key", key).Str("value", value).Msg("Ignoring invalid integer environment variable")
+		return defaultValue
+	}
+	return parsed
+}
+
+func setupRouter(db *sql.DB, apiAuth *controllers.APITokenAuth, acceptWebhooks bool) *gin.Engine {
 	// Initialize controllers
 	userController := controllers.NewUserController(db)
+	webhookController := controllers.NewWebhookController(reviewJobQueue, getEnv("GITHUB_WEBHOOK_SECRET", ""))
+	reviewController := controllers.NewReviewController(db)
+	notificationController := controllers.NewNotificationController(db)
 
 	// Create Gin router with recovery middleware
 	r := gin.New()
@@ -92,6 +152,17 @@ func setupRouter(db *sql.DB) *gin.Engine {
 		// User routes
 		userController.RegisterRoutes(api)
 
+		// GitHub webhook routes, only when the review jobs they enqueue will be run
+		if acceptWebhooks {
+			webhookController.RegisterRoutes(api)
+		}
+
+		// Review dashboard and in-app notification routes, scoped to the company of the API token.
+		// Their tables are created by StartReviewWorkflow in the service hosting the review workflow.
+		authenticated := api.Group("", apiAuth.Middleware())
+		reviewController.RegisterRoutes(authenticated)
+		notificationController.RegisterRoutes(authenticated)
+
 		// Health check endpoint
 		api.GET("/health", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
@@ -180,8 +251,22 @@ func main() {
 		}
 	}()
 
+	// Start the review workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	reviewWorkersRunning, err := startReviewWorkers(workerCtx, db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start review workers")
+	}
+
+	// API tokens authenticate the dashboard routes
+	apiAuth := controllers.NewAPITokenAuth(db)
+	if err := apiAuth.EnsureSchema(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to create the API token table")
+	}
+
 	// Set up router
-	r := setupRouter(db)
+	r := setupRouter(db, apiAuth, reviewWorkersRunning)
 
 	// Configure server
 	port := getEnv("PORT", "8080")
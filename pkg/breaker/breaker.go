@@ -0,0 +1,298 @@
+// Package breaker implements circuit breakers that stop calling a dependency once too many of its
+// recent calls failed or were slow, and probe it again after a cool-down.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through while tracking outcomes
+	Closed State = iota
+	// Open rejects every call until the open timeout has passed
+	Open
+	// HalfOpen lets a limited number of probe calls through to decide whether to close again
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// ErrOpen is returned by Allow when the breaker rejects a call.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Settings configure when a breaker trips and how it recovers.
+type Settings struct {
+	// WindowSize is the number of most recent calls the rates are computed over
+	WindowSize int
+	// MinCalls is the number of calls in the window before the breaker can trip
+	MinCalls int
+	// FailureRate trips the breaker when this share of the calls in the window failed
+	FailureRate float64
+	// SlowCallDuration is the latency from which a successful call counts as slow
+	SlowCallDuration time.Duration
+	// SlowCallRate trips the breaker when this share of the calls in the window was slow
+	SlowCallRate float64
+	// OpenTimeout is how long the breaker stays open before probing
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of probes that must all succeed to close the breaker
+	HalfOpenCalls int
+}
+
+// DefaultSettings suits calls to model providers, which take seconds to minutes.
+func DefaultSettings() Settings {
+	return Settings{
+		WindowSize:       20,
+		MinCalls:         5,
+		FailureRate:      0.5,
+		SlowCallDuration: 3 * time.Minute,
+		SlowCallRate:     0.8,
+		OpenTimeout:      time.Minute,
+		HalfOpenCalls:    1,
+	}
+}
+
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+// Stats describes a breaker's current state and window.
+type Stats struct {
+	State        string  `json:"state"`
+	Calls        int     `json:"calls"`
+	FailureRate  float64 `json:"failure_rate"`
+	SlowCallRate float64 `json:"slow_call_rate"`
+}
+
+// Breaker is a circuit breaker guarding one dependency. It is safe for concurrent use.
+type Breaker struct {
+	name     string
+	settings Settings
+	now      func() time.Time
+	onChange func(name string, from, to State)
+
+	mu             sync.Mutex
+	state          State
+	outcomes       []outcome
+	next           int
+	openedAt       time.Time
+	probesInFlight int
+	probeSuccesses int
+}
+
+// New creates a closed breaker.
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{
+		name:     name,
+		settings: settings,
+		now:      time.Now,
+	}
+}
+
+// Name returns the name of the dependency the breaker guards.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be followed by Record, or by
+// Release if it ended without saying anything about the dependency's health.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		if b.now().Sub(b.openedAt) < b.settings.OpenTimeout {
+			return fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.probesInFlight+b.probeSuccesses >= b.settings.HalfOpenCalls {
+			return fmt.Errorf("%s: %w, waiting for probe", b.name, ErrOpen)
+		}
+		b.probesInFlight++
+	}
+	return nil
+}
+
+// Record records the outcome of an allowed call.
+func (b *Breaker) Record(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := outcome{
+		failed: err != nil,
+		slow:   err == nil && b.settings.SlowCallDuration > 0 && latency >= b.settings.SlowCallDuration,
+	}
+
+	switch b.state {
+	case HalfOpen:
+		if b.probesInFlight > 0 {
+			b.probesInFlight--
+		}
+		if result.failed || result.slow {
+			b.trip()
+			return
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.settings.HalfOpenCalls {
+			b.setState(Closed)
+		}
+	case Closed:
+		b.add(result)
+		if b.shouldTrip() {
+			b.trip()
+		}
+	}
+}
+
+// Release gives back a call slot without recording an outcome, e.g. when the caller gave up.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == HalfOpen && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats returns the current state and rates.
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failureRate, slowRate := b.rates()
+	return Stats{
+		State:        b.state.String(),
+		Calls:        len(b.outcomes),
+		FailureRate:  failureRate,
+		SlowCallRate: slowRate,
+	}
+}
+
+func (b *Breaker) add(result outcome) {
+	if b.settings.WindowSize <= 0 {
+		return
+	}
+	if len(b.outcomes) < b.settings.WindowSize {
+		b.outcomes = append(b.outcomes, result)
+		return
+	}
+	b.outcomes[b.next] = result
+	b.next = (b.next + 1) % b.settings.WindowSize
+}
+
+func (b *Breaker) rates() (failureRate, slowRate float64) {
+	if len(b.outcomes) == 0 {
+		return 0, 0
+	}
+	failed, slow := 0, 0
+	for _, result := range b.outcomes {
+		if result.failed {
+			failed++
+		}
+		if result.slow {
+			slow++
+		}
+	}
+	return float64(failed) / float64(len(b.outcomes)), float64(slow) / float64(len(b.outcomes))
+}
+
+func (b *Breaker) shouldTrip() bool {
+	if len(b.outcomes) < b.settings.MinCalls {
+		return false
+	}
+	failureRate, slowRate := b.rates()
+	return (b.settings.FailureRate > 0 && failureRate >= b.settings.FailureRate) ||
+		(b.settings.SlowCallRate > 0 && slowRate >= b.settings.SlowCallRate)
+}
+
+func (b *Breaker) trip() {
+	b.openedAt = b.now()
+	b.setState(Open)
+}
+
+// setState switches state and resets the bookkeeping of the state being left.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.probesInFlight = 0
+	b.probeSuccesses = 0
+	if state == Closed {
+		b.outcomes = nil
+		b.next = 0
+	}
+	if b.onChange != nil && from != state {
+		// Called with the lock held, so the callback must not use the breaker
+		b.onChange(b.name, from, state)
+	}
+}
+
+// Group holds one breaker per dependency name, created on first use.
+type Group struct {
+	settings Settings
+	onChange func(name string, from, to State)
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup creates a group whose breakers use the settings. onChange, if not nil, is called on
+// every state change, with the breaker's lock held.
+func NewGroup(settings Settings, onChange func(name string, from, to State)) *Group {
+	return &Group{
+		settings: settings,
+		onChange: onChange,
+		breakers: map[string]*Breaker{},
+	}
+}
+
+// Get returns the breaker for the name.
+func (g *Group) Get(name string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[name]
+	if !ok {
+		b = New(name, g.settings)
+		b.onChange = g.onChange
+		g.breakers[name] = b
+	}
+	return b
+}
+
+// Stats returns the stats of every breaker in the group.
+func (g *Group) Stats() map[string]Stats {
+	g.mu.Lock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.mu.Unlock()
+
+	stats := make(map[string]Stats, len(breakers))
+	for _, b := range breakers {
+		stats[b.name] = b.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,169 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errCall = errors.New("call failed")
+
+// clock is a manually advanced time source.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestBreaker creates a breaker over a window of 4 calls, using the clock.
+func newTestBreaker(c *clock) *Breaker {
+	b := New("anthropic", testSettings())
+	b.now = c.Now
+	return b
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func testSettings() Settings {
+	settings := DefaultSettings()
+	settings.WindowSize = 4
+	settings.MinCalls = 4
+	return settings
+}
+
+// call makes a call through the breaker, returning the error of Allow if it was rejected.
+func call(b *Breaker, err error, latency time.Duration) error {
+	if allowErr := b.Allow(); allowErr != nil {
+		return allowErr
+	}
+	b.Record(err, latency)
+	return nil
+}
+
+func TestBreakerTripsOnFailureRate(t *testing.T) {
+	b := newTestBreaker(newClock())
+
+	// Below MinCalls the breaker stays closed whatever the outcomes
+	for i := 0; i < 3; i++ {
+		call(b, errCall, time.Second)
+	}
+	if b.State() != Closed {
+		t.Fatalf("State() = %v after 3 calls, want closed until MinCalls", b.State())
+	}
+
+	call(b, nil, time.Second)
+	if b.State() != Open {
+		t.Fatalf("State() = %v with 3 of 4 calls failed, want open", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() = %v, want ErrOpen", err)
+	}
+}
+
+func TestBreakerTripsOnSlowCalls(t *testing.T) {
+	b := newTestBreaker(newClock())
+	for i := 0; i < 4; i++ {
+		call(b, nil, 5*time.Minute)
+	}
+	if b.State() != Open {
+		t.Errorf("State() = %v after slow calls, want open", b.State())
+	}
+}
+
+func TestBreakerWindowForgetsOldCalls(t *testing.T) {
+	b := newTestBreaker(newClock())
+	call(b, errCall, time.Second)
+	for i := 0; i < 6; i++ {
+		call(b, nil, time.Second)
+	}
+	call(b, errCall, time.Second)
+
+	stats := b.Stats()
+	if stats.State != "closed" || stats.Calls != 4 || stats.FailureRate != 0.25 {
+		t.Errorf("Stats() = %+v, want the last 4 calls with one failure", stats)
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	c := newClock()
+	b := newTestBreaker(c)
+	for i := 0; i < 4; i++ {
+		call(b, errCall, time.Second)
+	}
+
+	c.Advance(30 * time.Second)
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow() before the open timeout = %v, want ErrOpen", err)
+	}
+
+	c.Advance(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after the open timeout = %v, want a probe", err)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("State() = %v, want half_open", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("second Allow() while probing = %v, want ErrOpen", err)
+	}
+
+	// A caller giving up frees the probe slot
+	b.Release()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after Release = %v", err)
+	}
+	b.Record(nil, time.Second)
+	if b.State() != Closed {
+		t.Errorf("State() after a successful probe = %v, want closed", b.State())
+	}
+	if stats := b.Stats(); stats.Calls != 0 {
+		t.Errorf("Stats().Calls = %d after closing, want a fresh window", stats.Calls)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	c := newClock()
+	b := newTestBreaker(c)
+	for i := 0; i < 4; i++ {
+		call(b, errCall, time.Second)
+	}
+	c.Advance(2 * time.Minute)
+
+	if err := call(b, errCall, time.Second); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if b.State() != Open {
+		t.Fatalf("State() after a failed probe = %v, want open", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() right after a failed probe = %v, want ErrOpen for another timeout", err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	type change struct {
+		name     string
+		from, to State
+	}
+	var changes []change
+	group := NewGroup(testSettings(), func(name string, from, to State) {
+		changes = append(changes, change{name, from, to})
+	})
+
+	if group.Get("openai") != group.Get("openai") {
+		t.Error("Get() returned two breakers for the same name")
+	}
+	for i := 0; i < 4; i++ {
+		call(group.Get("openai"), errCall, time.Second)
+	}
+	call(group.Get("google"), nil, time.Second)
+
+	if len(changes) != 1 || changes[0] != (change{"openai", Closed, Open}) {
+		t.Errorf("state changes = %+v, want openai closed to open", changes)
+	}
+	stats := group.Stats()
+	if len(stats) != 2 || stats["openai"].State != "open" || stats["google"].State != "closed" {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
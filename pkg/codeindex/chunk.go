@@ -0,0 +1,225 @@
+// Package codeindex indexes a repository's code by symbol in an embedding store and retrieves the
+// chunks most similar to a query.
+package codeindex
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxChunkLines is the size from which a symbol is split into several chunks.
+const maxChunkLines = 150
+
+// Chunk is a piece of a file, usually one top-level symbol.
+type Chunk struct {
+	Path      string `json:"path"`
+	Symbol    string `json:"symbol"`
+	Kind      string `json:"kind"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Content   string `json:"content"`
+	// Embedder is the name of the embedder that computed Vector, vectors of different embedders
+	// can't be compared
+	Embedder string    `json:"embedder"`
+	Vector   []float32 `json:"vector"`
+}
+
+// ID identifies the chunk within its repository.
+func (c Chunk) ID() string {
+	return fmt.Sprintf("%s:%d-%d", c.Path, c.StartLine, c.EndLine)
+}
+
+// embeddingText is what gets embedded, the path and symbol help queries naming either.
+func (c Chunk) embeddingText() string {
+	return c.Path + " " + c.Symbol + "\n" + c.Content
+}
+
+// Supported reports whether files at the path are chunked, only Go and TypeScript/JavaScript are.
+func Supported(filePath string) bool {
+	switch path.Ext(filePath) {
+	case ".go", ".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs":
+		return true
+	}
+	return false
+}
+
+// ChunkFile splits a file into its top-level symbols. Unsupported files have no chunks.
+func ChunkFile(filePath string, content []byte) []Chunk {
+	var chunks []Chunk
+	switch path.Ext(filePath) {
+	case ".go":
+		chunks = chunkGo(filePath, content)
+	case ".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs":
+		chunks = chunkTypeScript(filePath, string(content))
+	default:
+		return nil
+	}
+	return splitLongChunks(chunks)
+}
+
+// chunkGo chunks a Go file by declaration, with the declaration's doc comment. Files that don't
+// parse fall back to the line based chunking used for TypeScript, which also works for Go.
+func chunkGo(filePath string, content []byte) []Chunk {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	if err != nil {
+		return chunkTypeScript(filePath, string(content))
+	}
+
+	lines := strings.Split(string(content), "\n")
+	var chunks []Chunk
+	for _, decl := range file.Decls {
+		var symbol, kind string
+		start := decl.Pos()
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			symbol, kind = d.Name.Name, "func"
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				symbol = receiverName(d.Recv.List[0].Type) + "." + symbol
+				kind = "method"
+			}
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			var names []string
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+			symbol, kind = strings.Join(names, ", "), d.Tok.String()
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		default:
+			continue
+		}
+
+		startLine := fset.Position(start).Line
+		endLine := fset.Position(decl.End()).Line
+		chunks = append(chunks, Chunk{
+			Path:      filePath,
+			Symbol:    symbol,
+			Kind:      kind,
+			StartLine: startLine,
+			EndLine:   endLine,
+			Content:   strings.Join(lines[startLine-1:endLine], "\n"),
+		})
+	}
+	return chunks
+}
+
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// tsDeclaration matches a top-level declaration, the symbol name is the last group. "func" covers Go
+// files that don't parse.
+var tsDeclaration = regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:declare\s+)?(?:async\s+)?(?:abstract\s+)?(function\*?|class|interface|type|enum|const|let|var|namespace|module|func)\s+([A-Za-z_$][\w$]*)`)
+
+// chunkTypeScript chunks a file by its unindented declarations: a chunk runs from a declaration,
+// with the comments right above it, to the next one. This doesn't need a parser and copes with
+// syntax a parser for one dialect would reject.
+func chunkTypeScript(filePath, content string) []Chunk {
+	lines := strings.Split(content, "\n")
+
+	type declaration struct {
+		line   int
+		kind   string
+		symbol string
+	}
+	var declarations []declaration
+	for i, line := range lines {
+		if match := tsDeclaration.FindStringSubmatch(line); match != nil {
+			declarations = append(declarations, declaration{line: i, kind: match[1], symbol: match[2]})
+		}
+	}
+
+	var chunks []Chunk
+	for i, decl := range declarations {
+		start := decl.line
+		for start > 0 && isCommentLine(lines[start-1]) {
+			start--
+		}
+		if i > 0 && start <= declarations[i-1].line {
+			start = decl.line
+		}
+
+		end := len(lines) - 1
+		if i+1 < len(declarations) {
+			end = declarations[i+1].line - 1
+			for end > decl.line && isCommentLine(lines[end]) {
+				end--
+			}
+		}
+		for end > decl.line && strings.TrimSpace(lines[end]) == "" {
+			end--
+		}
+
+		chunks = append(chunks, Chunk{
+			Path:      filePath,
+			Symbol:    decl.symbol,
+			Kind:      decl.kind,
+			StartLine: start + 1,
+			EndLine:   end + 1,
+			Content:   strings.Join(lines[start:end+1], "\n"),
+		})
+	}
+	return chunks
+}
+
+// isCommentLine reports whether the line belongs to a comment or is a decorator, which both stay
+// with the declaration below them.
+func isCommentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*") ||
+		strings.HasPrefix(trimmed, "*") || strings.HasPrefix(trimmed, "@")
+}
+
+// splitLongChunks splits chunks longer than maxChunkLines into consecutive parts.
+func splitLongChunks(chunks []Chunk) []Chunk {
+	var split []Chunk
+	for _, chunk := range chunks {
+		lines := strings.Split(chunk.Content, "\n")
+		if len(lines) <= maxChunkLines {
+			split = append(split, chunk)
+			continue
+		}
+		for part, offset := 1, 0; offset < len(lines); part, offset = part+1, offset+maxChunkLines {
+			end := min(offset+maxChunkLines, len(lines))
+			split = append(split, Chunk{
+				Path:      chunk.Path,
+				Symbol:    fmt.Sprintf("%s (part %d)", chunk.Symbol, part),
+				Kind:      chunk.Kind,
+				StartLine: chunk.StartLine + offset,
+				EndLine:   chunk.StartLine + end - 1,
+				Content:   strings.Join(lines[offset:end], "\n"),
+			})
+		}
+	}
+	return split
+}
@@ -0,0 +1,95 @@
+package codeindex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChunkGo(t *testing.T) {
+	content := `package review
+
+import "fmt"
+
+// Severity ranks comments.
+type Severity int
+
+const maxComments = 20
+
+// Format renders the comment.
+func (c *Comment) Format() string {
+	return fmt.Sprint(c)
+}
+
+func helper() {}
+`
+	chunks := ChunkFile("review/comment.go", []byte(content))
+	var got []string
+	for _, chunk := range chunks {
+		got = append(got, fmt.Sprintf("%s %s %d-%d", chunk.Kind, chunk.Symbol, chunk.StartLine, chunk.EndLine))
+	}
+	want := []string{"type Severity 5-6", "const maxComments 8-8", "method Comment.Format 10-13", "func helper 15-15"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("chunks = %q, want %q", got, want)
+	}
+	if chunks[2].Content != "// Format renders the comment.\nfunc (c *Comment) Format() string {\n\treturn fmt.Sprint(c)\n}" {
+		t.Errorf("method chunk = %q, want the declaration with its doc comment", chunks[2].Content)
+	}
+}
+
+func TestChunkTypeScript(t *testing.T) {
+	content := `import { api } from "./api";
+
+// Loads the reviews.
+export async function loadReviews(id: string) {
+  return api.get(id);
+}
+
+@Injectable()
+export class ReviewService {
+  run() {}
+}
+
+// Exported for the tests.
+export const defaultService = new ReviewService();
+`
+	chunks := ChunkFile("web/reviews.ts", []byte(content))
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3: %+v", len(chunks), chunks)
+	}
+	if chunks[0].Symbol != "loadReviews" || chunks[0].Kind != "function" || chunks[0].StartLine != 3 || chunks[0].EndLine != 6 {
+		t.Errorf("first chunk = %+v, want loadReviews with its comment", chunks[0])
+	}
+	if chunks[1].Symbol != "ReviewService" || chunks[1].StartLine != 8 || chunks[1].EndLine != 11 {
+		t.Errorf("second chunk = %+v, want ReviewService with its decorator and without the next declaration's comment", chunks[1])
+	}
+}
+
+func TestChunkFileSplitsLongSymbols(t *testing.T) {
+	var body strings.Builder
+	body.WriteString("package big\n\nfunc long() {\n")
+	for i := 0; i < 2*maxChunkLines; i++ {
+		body.WriteString("\tx++\n")
+	}
+	body.WriteString("}\n")
+
+	chunks := ChunkFile("big.go", []byte(body.String()))
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want a long function split in 3", len(chunks))
+	}
+	if chunks[0].Symbol != "long (part 1)" || chunks[0].StartLine != 3 || chunks[0].EndLine != 2+maxChunkLines {
+		t.Errorf("first part = %s %d-%d", chunks[0].Symbol, chunks[0].StartLine, chunks[0].EndLine)
+	}
+	if chunks[1].StartLine != chunks[0].EndLine+1 || chunks[2].Symbol != "long (part 3)" {
+		t.Errorf("parts aren't consecutive: %+v", chunks[1:])
+	}
+}
+
+func TestChunkFileUnsupported(t *testing.T) {
+	if Supported("README.md") || ChunkFile("README.md", []byte("# Title")) != nil {
+		t.Error("Markdown files are chunked")
+	}
+	if !Supported("web/app.tsx") || !Supported("main.go") {
+		t.Error("TypeScript or Go files aren't supported")
+	}
+}
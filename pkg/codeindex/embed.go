@@ -0,0 +1,221 @@
+package codeindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects how related the texts are.
+type Embedder interface {
+	// Name identifies the embedder and its model, chunks are only compared with vectors of the same name
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// hashEmbedderDimensions is the vector size of HashEmbedder.
+const hashEmbedderDimensions = 512
+
+// HashEmbedder embeds the identifiers of a text with the hashing trick. It runs in process without
+// a model, and matches code sharing identifiers, which is most of what a review needs to find
+// callers, callees and related types.
+type HashEmbedder struct{}
+
+// Name implements Embedder.
+func (HashEmbedder) Name() string { return fmt.Sprintf("hash-%d", hashEmbedderDimensions) }
+
+// Embed implements Embedder.
+func (HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, hashEmbedderDimensions)
+		for term, count := range terms(text) {
+			h := fnv.New64a()
+			h.Write([]byte(term))
+			sum := h.Sum64()
+			weight := float32(1 + math.Log(float64(count)))
+			// The top bit picks the sign so colliding terms cancel out instead of adding up
+			if sum>>63 == 1 {
+				weight = -weight
+			}
+			vector[sum%hashEmbedderDimensions] += weight
+		}
+		vectors[i] = normalize(vector)
+	}
+	return vectors, nil
+}
+
+// terms counts the lower-cased identifiers of a text, along with the words of the compound ones so
+// that e.g. "reviewComment" also matches "comment".
+func terms(text string) map[string]int {
+	counts := map[string]int{}
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range words {
+		if len(word) < 2 {
+			continue
+		}
+		counts[strings.ToLower(word)]++
+		if parts := identifierParts(word); len(parts) > 1 {
+			for _, part := range parts {
+				counts[part]++
+			}
+		}
+	}
+	return counts
+}
+
+// identifierParts splits camelCase, PascalCase and snake_case identifiers into lower-cased words
+// of at least two characters.
+func identifierParts(identifier string) []string {
+	var parts []string
+	var current []rune
+	flush := func() {
+		if len(current) > 1 {
+			parts = append(parts, strings.ToLower(string(current)))
+		}
+		current = current[:0]
+	}
+
+	runes := []rune(identifier)
+	for i, r := range runes {
+		if r == '_' {
+			flush()
+			continue
+		}
+		// A word starts at an upper-case letter after a lower-case one, or at the last upper-case
+		// letter of an acronym followed by a lower-case one, as in "HTTPServer"
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || (unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			flush()
+		}
+		current = append(current, r)
+	}
+	flush()
+	return parts
+}
+
+// HTTPEmbedder calls an OpenAI compatible embeddings endpoint.
+type HTTPEmbedder struct {
+	baseURL    string
+	model      string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewHTTPEmbedder creates an embedder for the server at baseURL, e.g. "https://api.openai.com/v1".
+func NewHTTPEmbedder(baseURL, model, apiKey string) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		model:      model,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Name implements Embedder.
+func (e *HTTPEmbedder) Name() string { return "http-" + e.model }
+
+// Embed implements Embedder.
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embeddings request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embeddings endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embeddings response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range response.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings response has unexpected index %d", item.Index)
+		}
+		vectors[item.Index] = normalize(item.Embedding)
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embeddings response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// EmbedderFromEnv returns an HTTPEmbedder when EMBEDDINGS_BASE_URL is set, configured with
+// EMBEDDINGS_MODEL and EMBEDDINGS_API_KEY, and a HashEmbedder otherwise.
+func EmbedderFromEnv() Embedder {
+	baseURL := os.Getenv("EMBEDDINGS_BASE_URL")
+	if baseURL == "" {
+		return HashEmbedder{}
+	}
+	model := os.Getenv("EMBEDDINGS_MODEL")
+	if model == "" {
+		model = "text-embedding-3-small"
+	}
+	return NewHTTPEmbedder(baseURL, model, os.Getenv("EMBEDDINGS_API_KEY"))
+}
+
+// normalize scales the vector to unit length, so the dot product of two vectors is their cosine.
+func normalize(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
+
+// similarity is the cosine similarity of two normalized vectors.
+func similarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
@@ -0,0 +1,86 @@
+package codeindex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIdentifierParts(t *testing.T) {
+	for identifier, want := range map[string][]string{
+		"reviewComment":   {"review", "comment"},
+		"HTTPServer":      {"http", "server"},
+		"max_chunk_lines": {"max", "chunk", "lines"},
+		"x":               nil,
+	} {
+		if got := identifierParts(identifier); !reflect.DeepEqual(got, want) {
+			t.Errorf("identifierParts(%q) = %q, want %q", identifier, got, want)
+		}
+	}
+}
+
+func TestHashEmbedderMatchesSharedIdentifiers(t *testing.T) {
+	vectors, err := HashEmbedder{}.Embed(context.Background(), []string{
+		"func postReviewComment(comment *ReviewComment) error",
+		"comments := loadReviewComments(pr)",
+		"SELECT name FROM users WHERE active",
+	})
+	if err != nil {
+		t.Fatalf("Embed() = %v", err)
+	}
+	if self := similarity(vectors[0], vectors[0]); self < 0.999 || self > 1.001 {
+		t.Errorf("similarity of a vector with itself = %f, want 1", self)
+	}
+	if related, unrelated := similarity(vectors[0], vectors[1]), similarity(vectors[0], vectors[2]); related <= unrelated {
+		t.Errorf("similarity of related code %f <= unrelated %f", related, unrelated)
+	}
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	var request struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&request)
+		// Out of order, as the endpoint is allowed to answer
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 2]}, {"index": 0, "embedding": [3, 4]}]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewHTTPEmbedder(server.URL+"/", "small", "secret")
+	vectors, err := embedder.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed() = %v", err)
+	}
+	if request.Model != "small" || !reflect.DeepEqual(request.Input, []string{"a", "b"}) || authorization != "Bearer secret" {
+		t.Errorf("request = %+v with authorization %q", request, authorization)
+	}
+	if want := [][]float32{{0.6, 0.8}, {0, 1}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("Embed() = %v, want the normalized vectors in input order", vectors)
+	}
+	if embedder.Name() != "http-small" {
+		t.Errorf("Name() = %q", embedder.Name())
+	}
+
+	if _, err := embedder.Embed(context.Background(), []string{"a", "b", "c"}); err == nil {
+		t.Error("Embed() succeeded with a missing input")
+	}
+}
+
+func TestEmbedderFromEnv(t *testing.T) {
+	t.Setenv("EMBEDDINGS_BASE_URL", "")
+	if _, ok := EmbedderFromEnv().(HashEmbedder); !ok {
+		t.Errorf("EmbedderFromEnv() = %T without a base URL, want HashEmbedder", EmbedderFromEnv())
+	}
+	t.Setenv("EMBEDDINGS_BASE_URL", "https://api.example.com/v1")
+	t.Setenv("EMBEDDINGS_MODEL", "")
+	if embedder, ok := EmbedderFromEnv().(*HTTPEmbedder); !ok || embedder.Name() != "http-text-embedding-3-small" {
+		t.Errorf("EmbedderFromEnv() = %#v, want the default HTTP model", EmbedderFromEnv())
+	}
+}
@@ -0,0 +1,140 @@
+package codeindex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileStore keeps each repository's chunks in memory and persists them as a JSON file in a
+// directory, for deployments without a database or for local development.
+type FileStore struct {
+	dir string
+
+	mu    sync.Mutex
+	repos map[string]map[string][]Chunk
+}
+
+// NewFileStore creates a store persisting to dir, which is created when needed.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir, repos: map[string]map[string][]Chunk{}}
+}
+
+// ReplaceFile implements Store.
+func (s *FileStore) ReplaceFile(ctx context.Context, repo, path string, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.load(repo)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		delete(files, path)
+	} else {
+		files[path] = chunks
+	}
+	return s.save(repo, files)
+}
+
+// Search implements Store.
+func (s *FileStore) Search(ctx context.Context, repo, embedder string, vector []float32, k int, excludePaths map[string]bool) ([]Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.load(repo)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for path, chunks := range files {
+		if excludePaths[path] {
+			continue
+		}
+		for _, chunk := range chunks {
+			if chunk.Embedder == embedder {
+				results = append(results, Result{Chunk: chunk})
+			}
+		}
+	}
+	Score(results, vector)
+	return TopK(results, k), nil
+}
+
+// Indexed implements Store.
+func (s *FileStore) Indexed(ctx context.Context, repo, embedder string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.load(repo)
+	if err != nil {
+		return false, err
+	}
+	for _, chunks := range files {
+		for _, chunk := range chunks {
+			if chunk.Embedder == embedder {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// repoFile is where the repository's chunks are persisted, "owner/repo" becomes "owner__repo.json".
+func (s *FileStore) repoFile(repo string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(repo, "/", "__")+".json")
+}
+
+// load returns the repository's chunks by path, reading them from disk on first use.
+func (s *FileStore) load(repo string) (map[string][]Chunk, error) {
+	if files, ok := s.repos[repo]; ok {
+		return files, nil
+	}
+
+	files := map[string][]Chunk{}
+	data, err := os.ReadFile(s.repoFile(repo))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read index of %s: %w", repo, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &files); err != nil {
+			return nil, fmt.Errorf("failed to decode index of %s: %w", repo, err)
+		}
+	}
+	s.repos[repo] = files
+	return files, nil
+}
+
+// save writes the repository's chunks to a temporary file and renames it, so a crash can't leave
+// a truncated index behind.
+func (s *FileStore) save(repo string, files map[string][]Chunk) error {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return fmt.Errorf("failed to encode index of %s: %w", repo, err)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".index-*")
+	if err != nil {
+		return fmt.Errorf("failed to write index of %s: %w", repo, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write index of %s: %w", repo, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write index of %s: %w", repo, err)
+	}
+	if err := os.Rename(tmp.Name(), s.repoFile(repo)); err != nil {
+		return fmt.Errorf("failed to write index of %s: %w", repo, err)
+	}
+	return nil
+}
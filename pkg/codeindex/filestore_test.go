@@ -0,0 +1,43 @@
+package codeindex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorePersists(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	chunk := Chunk{Path: "main.go", Symbol: "main", StartLine: 1, EndLine: 3, Embedder: "hash-512", Vector: []float32{1, 0}}
+	if err := NewFileStore(dir).ReplaceFile(ctx, "octo/hello", "main.go", []Chunk{chunk}); err != nil {
+		t.Fatalf("ReplaceFile() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "octo__hello.json")); err != nil {
+		t.Fatalf("index file wasn't written: %v", err)
+	}
+
+	// A new store reads the index back from disk
+	store := NewFileStore(dir)
+	results, err := store.Search(ctx, "octo/hello", "hash-512", []float32{1, 0}, 5, nil)
+	if err != nil || len(results) != 1 || results[0].Symbol != "main" || results[0].Score != 1 {
+		t.Errorf("Search() = %+v, %v, want the persisted chunk", results, err)
+	}
+	if results, _ := store.Search(ctx, "octo/hello", "http-small", []float32{1, 0}, 5, nil); len(results) != 0 {
+		t.Errorf("Search() with another embedder = %+v, want no results", results)
+	}
+	if indexed, _ := store.Indexed(ctx, "octo/hello", "http-small"); indexed {
+		t.Error("Indexed() = true for another embedder")
+	}
+}
+
+func TestFileStoreRejectsCorruptIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "octo__hello.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(dir).Search(context.Background(), "octo/hello", "hash-512", nil, 5, nil); err == nil {
+		t.Error("Search() of a corrupt index succeeded")
+	}
+}
@@ -0,0 +1,149 @@
+package codeindex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// embedBatchSize is the number of texts embedded per Embed call.
+const embedBatchSize = 64
+
+// Result is a chunk retrieved for a query with its similarity to it.
+type Result struct {
+	Chunk
+	Score float32 `json:"score"`
+}
+
+// Store persists the chunks of repositories and finds the ones closest to a vector.
+type Store interface {
+	// ReplaceFile replaces the chunks of a file, no chunks removes the file from the index
+	ReplaceFile(ctx context.Context, repo, path string, chunks []Chunk) error
+	// Search returns the k chunks of the repository most similar to the vector, among those
+	// computed by the embedder and outside the excluded paths
+	Search(ctx context.Context, repo, embedder string, vector []float32, k int, excludePaths map[string]bool) ([]Result, error)
+	// Indexed reports whether the store has chunks of the repository computed by the embedder
+	Indexed(ctx context.Context, repo, embedder string) (bool, error)
+}
+
+// Index chunks and embeds files into a store and retrieves the chunks related to queries.
+type Index struct {
+	store    Store
+	embedder Embedder
+}
+
+// New creates an index over the store.
+func New(store Store, embedder Embedder) *Index {
+	return &Index{store: store, embedder: embedder}
+}
+
+// IndexFile replaces the indexed chunks of the file with its current content and returns the
+// number of chunks indexed. Unsupported files are removed from the index.
+func (ix *Index) IndexFile(ctx context.Context, repo, path string, content []byte) (int, error) {
+	chunks := ChunkFile(path, content)
+	if len(chunks) > 0 {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.embeddingText()
+		}
+		vectors, err := ix.embed(ctx, texts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to embed %s: %w", path, err)
+		}
+		for i := range chunks {
+			chunks[i].Vector = vectors[i]
+			chunks[i].Embedder = ix.embedder.Name()
+		}
+	}
+	if err := ix.store.ReplaceFile(ctx, repo, path, chunks); err != nil {
+		return 0, fmt.Errorf("failed to store chunks of %s: %w", path, err)
+	}
+	return len(chunks), nil
+}
+
+// RemoveFile removes the file from the index.
+func (ix *Index) RemoveFile(ctx context.Context, repo, path string) error {
+	if err := ix.store.ReplaceFile(ctx, repo, path, nil); err != nil {
+		return fmt.Errorf("failed to remove %s from the index: %w", path, err)
+	}
+	return nil
+}
+
+// Indexed reports whether the repository has chunks computed by the index's embedder. A
+// repository that isn't indexed yet, or was indexed with another embedder, needs a full indexing
+// before searches find anything.
+func (ix *Index) Indexed(ctx context.Context, repo string) (bool, error) {
+	indexed, err := ix.store.Indexed(ctx, repo, ix.embedder.Name())
+	if err != nil {
+		return false, fmt.Errorf("failed to check the index of %s: %w", repo, err)
+	}
+	return indexed, nil
+}
+
+// Search returns the k chunks most similar to any of the queries, best first. Chunks in the
+// excluded paths are skipped, e.g. the files a pull request changes.
+func (ix *Index) Search(ctx context.Context, repo string, queries []string, k int, excludePaths map[string]bool) ([]Result, error) {
+	if len(queries) == 0 || k <= 0 {
+		return nil, nil
+	}
+	vectors, err := ix.embed(ctx, queries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed queries: %w", err)
+	}
+
+	best := map[string]Result{}
+	for _, vector := range vectors {
+		results, err := ix.store.Search(ctx, repo, ix.embedder.Name(), vector, k, excludePaths)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search the index: %w", err)
+		}
+		for _, result := range results {
+			if current, ok := best[result.ID()]; !ok || result.Score > current.Score {
+				best[result.ID()] = result
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(best))
+	for _, result := range best {
+		results = append(results, result)
+	}
+	return TopK(results, k), nil
+}
+
+func (ix *Index) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch, err := ix.embedder.Embed(ctx, texts[start:min(start+embedBatchSize, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// Score sets the similarity of each result's chunk to the vector, for stores that search by
+// scanning their chunks.
+func Score(results []Result, vector []float32) {
+	for i := range results {
+		results[i].Score = similarity(results[i].Vector, vector)
+	}
+}
+
+// TopK sorts the results best first, ties broken by position for stable output, and keeps the first k.
+func TopK(results []Result, k int) []Result {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].StartLine < results[j].StartLine
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
@@ -0,0 +1,85 @@
+package codeindex
+
+import (
+	"context"
+	"testing"
+)
+
+const reviewSource = `package review
+
+// postComment posts a review comment on the pull request.
+func postComment(client *GitHubClient, comment Comment) error {
+	return client.PostPullRequestComment(comment)
+}
+`
+
+const billingSource = `package billing
+
+// chargeInvoice charges the customer's card for the invoice total.
+func chargeInvoice(invoice Invoice, card Card) error {
+	return card.Charge(invoice.Total)
+}
+`
+
+func TestIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	index := New(NewFileStore(t.TempDir()), HashEmbedder{})
+	for path, content := range map[string]string{"review/post.go": reviewSource, "billing/charge.go": billingSource} {
+		if count, err := index.IndexFile(ctx, "octo/hello", path, []byte(content)); err != nil || count != 1 {
+			t.Fatalf("IndexFile(%s) = %d, %v", path, count, err)
+		}
+	}
+
+	results, err := index.Search(ctx, "octo/hello", []string{"client.PostPullRequestComment(reviewComment)"}, 1, nil)
+	if err != nil {
+		t.Fatalf("Search() = %v", err)
+	}
+	if len(results) != 1 || results[0].Symbol != "postComment" {
+		t.Fatalf("Search() = %+v, want postComment", results)
+	}
+
+	results, err = index.Search(ctx, "octo/hello", []string{"client.PostPullRequestComment(reviewComment)"}, 5, map[string]bool{"review/post.go": true})
+	if err != nil || len(results) != 1 || results[0].Path != "billing/charge.go" {
+		t.Errorf("Search() excluding review/post.go = %+v, %v", results, err)
+	}
+	if results, _ := index.Search(ctx, "octo/other", []string{"postComment"}, 5, nil); len(results) != 0 {
+		t.Errorf("Search() of another repository = %+v", results)
+	}
+}
+
+func TestIndexRemoveFile(t *testing.T) {
+	ctx := context.Background()
+	index := New(NewFileStore(t.TempDir()), HashEmbedder{})
+	if indexed, err := index.Indexed(ctx, "octo/hello"); err != nil || indexed {
+		t.Errorf("Indexed() of an empty index = %v, %v", indexed, err)
+	}
+	if _, err := index.IndexFile(ctx, "octo/hello", "review/post.go", []byte(reviewSource)); err != nil {
+		t.Fatal(err)
+	}
+	if indexed, _ := index.Indexed(ctx, "octo/hello"); !indexed {
+		t.Error("Indexed() = false after indexing a file")
+	}
+
+	if err := index.RemoveFile(ctx, "octo/hello", "review/post.go"); err != nil {
+		t.Fatalf("RemoveFile() = %v", err)
+	}
+	if results, _ := index.Search(ctx, "octo/hello", []string{"postComment"}, 5, nil); len(results) != 0 {
+		t.Errorf("Search() after RemoveFile = %+v", results)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	results := TopK([]Result{
+		{Chunk: Chunk{Path: "b.go", StartLine: 1}, Score: 0.5},
+		{Chunk: Chunk{Path: "a.go", StartLine: 9}, Score: 0.5},
+		{Chunk: Chunk{Path: "a.go", StartLine: 1}, Score: 0.5},
+		{Chunk: Chunk{Path: "c.go", StartLine: 1}, Score: 0.9},
+	}, 3)
+	var got []string
+	for _, result := range results {
+		got = append(got, result.ID())
+	}
+	if want := []string{"c.go:1-0", "a.go:1-0", "a.go:9-0"}; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("TopK() = %q, want %q", got, want)
+	}
+}
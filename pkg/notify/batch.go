@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// deliveryTimeout bounds the delivery of a digest, which happens outside of any caller's context.
+const deliveryTimeout = 30 * time.Second
+
+// Batcher rate limits a notifier. The first event of a type and key is delivered right away and
+// opens a window; the events that follow within the window are delivered as one digest when it
+// closes. A key therefore sends at most one notification per window, however noisy it is.
+type Batcher struct {
+	next    Notifier
+	window  time.Duration
+	onError func(error)
+
+	mu      sync.Mutex
+	windows map[string]*batchWindow
+}
+
+type batchWindow struct {
+	pending []Event
+	omitted int
+	timer   *time.Timer
+}
+
+// NewBatcher wraps the notifier. onError, if not nil, receives the errors of digests delivered
+// when a window closes, which have no caller to return them to.
+func NewBatcher(next Notifier, window time.Duration, onError func(error)) *Batcher {
+	return &Batcher{
+		next:    next,
+		window:  window,
+		onError: onError,
+		windows: map[string]*batchWindow{},
+	}
+}
+
+// Notify implements Notifier. Events batched into a later digest return nil.
+func (b *Batcher) Notify(ctx context.Context, event Event) error {
+	key := event.Type + "\x00" + event.Key
+
+	b.mu.Lock()
+	if window, ok := b.windows[key]; ok {
+		if len(window.pending) < maxDigestLines {
+			window.pending = append(window.pending, event)
+		} else {
+			window.omitted++
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	window := &batchWindow{}
+	window.timer = time.AfterFunc(b.window, func() { b.closeWindow(key) })
+	b.windows[key] = window
+	b.mu.Unlock()
+
+	return b.next.Notify(ctx, event)
+}
+
+// closeWindow delivers the events batched in the window. A window that batched events is reopened
+// so a sustained burst keeps being limited.
+func (b *Batcher) closeWindow(key string) {
+	b.mu.Lock()
+	window, ok := b.windows[key]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	if len(window.pending) == 0 {
+		delete(b.windows, key)
+		b.mu.Unlock()
+		return
+	}
+	digest := Digest(window.pending, window.omitted)
+	window.pending = nil
+	window.omitted = 0
+	window.timer.Reset(b.window)
+	b.mu.Unlock()
+
+	b.deliver(digest)
+}
+
+// Flush delivers every batched event now, e.g. on shutdown.
+func (b *Batcher) Flush() {
+	b.mu.Lock()
+	var digests []Event
+	for key, window := range b.windows {
+		window.timer.Stop()
+		if len(window.pending) > 0 {
+			digests = append(digests, Digest(window.pending, window.omitted))
+		}
+		delete(b.windows, key)
+	}
+	b.mu.Unlock()
+
+	for _, digest := range digests {
+		b.deliver(digest)
+	}
+}
+
+func (b *Batcher) deliver(event Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if err := b.next.Notify(ctx, event); err != nil && b.onError != nil {
+		b.onError(err)
+	}
+}
+
+// BatcherGroup holds one Batcher per destination, so destinations are limited independently and
+// the limits hold across callers.
+type BatcherGroup struct {
+	window  time.Duration
+	onError func(error)
+
+	mu       sync.Mutex
+	batchers map[string]*Batcher
+}
+
+// NewBatcherGroup creates a group whose batchers use the window.
+func NewBatcherGroup(window time.Duration, onError func(error)) *BatcherGroup {
+	return &BatcherGroup{
+		window:   window,
+		onError:  onError,
+		batchers: map[string]*Batcher{},
+	}
+}
+
+// Get returns the batcher of the destination, wrapping the notifier built by newNotifier on first use.
+func (g *BatcherGroup) Get(destination string, newNotifier func() Notifier) *Batcher {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	batcher, ok := g.batchers[destination]
+	if !ok {
+		batcher = NewBatcher(newNotifier(), g.window, g.onError)
+		g.batchers[destination] = batcher
+	}
+	return batcher
+}
+
+// Flush flushes every batcher in the group.
+func (g *BatcherGroup) Flush() {
+	g.mu.Lock()
+	batchers := make([]*Batcher, 0, len(g.batchers))
+	for _, batcher := range g.batchers {
+		batchers = append(batchers, batcher)
+	}
+	g.mu.Unlock()
+
+	for _, batcher := range batchers {
+		batcher.Flush()
+	}
+}
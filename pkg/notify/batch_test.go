@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the delivered events.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return n.err
+}
+
+func (n *recordingNotifier) delivered() []Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Event(nil), n.events...)
+}
+
+func TestBatcherDigestsEventsInWindow(t *testing.T) {
+	next := &recordingNotifier{}
+	batcher := NewBatcher(next, time.Hour, nil)
+	ctx := context.Background()
+
+	batcher.Notify(ctx, Event{Type: "approval", Key: "octo/hello#7", Title: "first"})
+	batcher.Notify(ctx, Event{Type: "approval", Key: "octo/hello#7", Title: "second"})
+	batcher.Notify(ctx, Event{Type: "approval", Key: "octo/hello#7", Title: "third"})
+	batcher.Notify(ctx, Event{Type: "approval", Key: "octo/hello#8", Title: "other PR"})
+
+	delivered := next.delivered()
+	if len(delivered) != 2 || delivered[0].Title != "first" || delivered[1].Title != "other PR" {
+		t.Fatalf("delivered = %+v, want the first event of each key right away", delivered)
+	}
+
+	batcher.Flush()
+	delivered = next.delivered()
+	if len(delivered) != 3 || delivered[2].Count != 2 {
+		t.Fatalf("delivered after Flush = %+v, want one digest of the 2 batched events", delivered)
+	}
+
+	// Flush closed the windows, the next event goes out right away
+	batcher.Notify(ctx, Event{Type: "approval", Key: "octo/hello#7", Title: "fourth"})
+	if delivered = next.delivered(); len(delivered) != 4 || delivered[3].Title != "fourth" {
+		t.Errorf("delivered = %+v, want the event after Flush delivered", delivered)
+	}
+}
+
+func TestBatcherDeliversDigestWhenWindowCloses(t *testing.T) {
+	next := &recordingNotifier{err: errors.New("slack is down")}
+	errs := make(chan error, 1)
+	batcher := NewBatcher(next, 20*time.Millisecond, func(err error) { errs <- err })
+	ctx := context.Background()
+
+	if err := batcher.Notify(ctx, Event{Type: "retry", Key: "k"}); err == nil {
+		t.Error("Notify() of the first event didn't return the delivery error")
+	}
+	if err := batcher.Notify(ctx, Event{Type: "retry", Key: "k", Title: "batched"}); err != nil {
+		t.Errorf("Notify() of a batched event = %v, want nil", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err.Error() != "slack is down" {
+			t.Errorf("onError() got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the digest wasn't delivered when the window closed")
+	}
+	if delivered := next.delivered(); len(delivered) != 2 || delivered[1].Title != "batched" {
+		t.Errorf("delivered = %+v, want the batched event once the window closed", delivered)
+	}
+}
+
+func TestBatcherGroup(t *testing.T) {
+	group := NewBatcherGroup(time.Hour, nil)
+	built := 0
+	newNotifier := func() Notifier {
+		built++
+		return &recordingNotifier{}
+	}
+
+	if group.Get("slack:#deploys", newNotifier) != group.Get("slack:#deploys", newNotifier) {
+		t.Error("Get() returned two batchers for the same destination")
+	}
+	if group.Get("email:oncall", newNotifier) == group.Get("slack:#deploys", newNotifier) {
+		t.Error("Get() shared a batcher between destinations")
+	}
+	if built != 2 {
+		t.Errorf("built %d notifiers, want one per destination", built)
+	}
+}
@@ -0,0 +1,104 @@
+// Package notify delivers notifications about review events to sinks such as Slack, webhooks and
+// email, with batching so a burst of events becomes a single digest.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event is something worth telling people about.
+type Event struct {
+	// Type is used to route the event, e.g. "approval"
+	Type      string `json:"type"`
+	CompanyId uint   `json:"company_id"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	// Key groups events for batching, e.g. the pull request they are about
+	Key    string            `json:"key,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Time   time.Time         `json:"time"`
+	// Count is the number of events a digest stands for, 0 or 1 for a single event
+	Count int `json:"count,omitempty"`
+}
+
+// Text renders the event as plain text, for sinks without formatting.
+func (e Event) Text() string {
+	var text strings.Builder
+	if e.Title != "" {
+		text.WriteString(e.Title)
+	}
+	if e.Message != "" {
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		text.WriteString(e.Message)
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&text, "\n• %s: %s", key, e.Fields[key])
+	}
+	return text.String()
+}
+
+// Notifier delivers events to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(ctx context.Context, event Event) error
+
+// Notify implements Notifier.
+func (f NotifierFunc) Notify(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// maxDigestLines is the number of events listed in a digest, the rest are only counted.
+const maxDigestLines = 10
+
+// Digest combines events into one, counting omitted events that were not kept. The events are
+// expected to share their type and key.
+func Digest(events []Event, omitted int) Event {
+	if len(events) == 1 && omitted == 0 {
+		return events[0]
+	}
+
+	first := events[0]
+	total := len(events) + omitted
+	var lines []string
+	for _, event := range events {
+		if len(lines) == maxDigestLines {
+			break
+		}
+		line := event.Title
+		if line == "" {
+			line = event.Message
+		}
+		lines = append(lines, "• "+line)
+	}
+	if total > len(lines) {
+		lines = append(lines, fmt.Sprintf("• … and %d more", total-len(lines)))
+	}
+
+	title := fmt.Sprintf("%d %s notifications", total, first.Type)
+	if first.Key != "" {
+		title += " for " + first.Key
+	}
+	return Event{
+		Type:      first.Type,
+		CompanyId: first.CompanyId,
+		Title:     title,
+		Message:   strings.Join(lines, "\n"),
+		Key:       first.Key,
+		Time:      events[len(events)-1].Time,
+		Count:     total,
+	}
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventText(t *testing.T) {
+	event := Event{
+		Title:   "PR approved",
+		Message: "octo/hello#7 was approved",
+		Fields:  map[string]string{"reviewer": "bot", "model": "claude"},
+	}
+	want := "PR approved\nocto/hello#7 was approved\n• model: claude\n• reviewer: bot"
+	if got := event.Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if got := (Event{Message: "only a message"}).Text(); got != "only a message" {
+		t.Errorf("Text() = %q", got)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	single := Event{Type: "approval", Title: "PR approved"}
+	if got := Digest([]Event{single}, 0); got.Title != single.Title || got.Count != 0 {
+		t.Errorf("Digest() of one event = %+v, want the event itself", got)
+	}
+
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var events []Event
+	for i := 1; i <= maxDigestLines; i++ {
+		events = append(events, Event{Type: "token_mismatch", CompanyId: 3, Key: "octo/hello#7", Title: fmt.Sprintf("mismatch %d", i), Time: last})
+	}
+	digest := Digest(events, 5)
+
+	if digest.Title != "15 token_mismatch notifications for octo/hello#7" || digest.Count != 15 {
+		t.Errorf("Digest() title = %q, count = %d", digest.Title, digest.Count)
+	}
+	if digest.Type != "token_mismatch" || digest.CompanyId != 3 || digest.Key != "octo/hello#7" || !digest.Time.Equal(last) {
+		t.Errorf("Digest() = %+v, want the events' type, company, key and last time", digest)
+	}
+	lines := strings.Split(digest.Message, "\n")
+	if len(lines) != maxDigestLines+1 || lines[0] != "• mismatch 1" || lines[len(lines)-1] != "• … and 5 more" {
+		t.Errorf("Digest() message = %q", digest.Message)
+	}
+}
@@ -0,0 +1,206 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON posts the payload and treats any non-2xx response as an error.
+func postJSON(ctx context.Context, url string, payload []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("received status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// SlackNotifier posts events to a Slack incoming webhook, which is bound to a channel.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+// Notify implements Notifier.
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	text := event.Text()
+	if event.Title != "" {
+		text = "*" + event.Title + "*" + strings.TrimPrefix(text, event.Title)
+	}
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack message: %w", err)
+	}
+	if err := postJSON(ctx, n.WebhookURL, payload, nil); err != nil {
+		return fmt.Errorf("failed to post slack message: %w", err)
+	}
+	return nil
+}
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook's body, keyed with the webhook's secret.
+const SignatureHeader = "X-Signature-256"
+
+// WebhookNotifier posts events as JSON to any URL. With a secret, the body is signed in the
+// SignatureHeader as "sha256=<hex>", the format GitHub uses, so receivers can verify it.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+// Notify implements Notifier.
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+	headers := map[string]string{}
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(payload)
+		headers[SignatureHeader] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	if err := postJSON(ctx, n.URL, payload, headers); err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	return nil
+}
+
+// SMTPConfig is the mail server used by SMTPNotifier.
+type SMTPConfig struct {
+	// Addr is the server's host:port
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPNotifier emails events to a list of addresses.
+type SMTPNotifier struct {
+	Config SMTPConfig
+	To     []string
+}
+
+// Notify implements Notifier. The connection to the server is closed when ctx is done.
+func (n *SMTPNotifier) Notify(ctx context.Context, event Event) error {
+	if n.Config.Addr == "" || n.Config.From == "" {
+		return fmt.Errorf("smtp server is not configured")
+	}
+	if len(n.To) == 0 {
+		return fmt.Errorf("email notification has no recipients")
+	}
+
+	subject := event.Title
+	if subject == "" {
+		subject = "Code review notification"
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.Config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.To, ", "))
+	// Header values can't contain line breaks
+	fmt.Fprintf(&message, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(event.Text(), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.Config.Username != "" {
+		host, _, err := net.SplitHostPort(n.Config.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %q: %w", n.Config.Addr, err)
+		}
+		auth = smtp.PlainAuth("", n.Config.Username, n.Config.Password, host)
+	}
+
+	if err := sendMail(ctx, n.Config.Addr, auth, n.Config.From, n.To, message.Bytes()); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// sendMail is smtp.SendMail over a connection that is closed when ctx is done, so a hung server
+// can't keep the delivery running after the caller gave up.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address %q: %w", addr, err)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return contextError(ctx, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return contextError(ctx, err)
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(auth); err != nil {
+				return contextError(ctx, err)
+			}
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return contextError(ctx, err)
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return contextError(ctx, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := writer.Close(); err != nil {
+		return contextError(ctx, err)
+	}
+	return contextError(ctx, client.Quit())
+}
+
+// contextError reports ctx's error instead of the error of an operation its cancellation interrupted.
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	event := Event{Title: "PR approved", Message: "octo/hello#7"}
+	if err := (&SlackNotifier{WebhookURL: server.URL}).Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	if payload["text"] != "*PR approved*\nocto/hello#7" {
+		t.Errorf("text = %q, want the title in bold", payload["text"])
+	}
+}
+
+func TestWebhookNotifierSignsBody(t *testing.T) {
+	status := http.StatusOK
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Secret: "shared-secret"}
+	if err := notifier.Notify(context.Background(), Event{Type: "approval", Title: "PR approved"}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte("shared-secret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || event.Type != "approval" {
+		t.Errorf("body = %s, want the event as JSON", body)
+	}
+
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(context.Background(), Event{Type: "approval"}); err == nil {
+		t.Error("Notify() succeeded on a 500 response")
+	}
+}
+
+// smtpServer accepts one connection and answers it with a minimal SMTP dialogue, sending the
+// received message on the returned channel. A silent server never greets the client.
+func smtpServer(t *testing.T, silent bool) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			io.Copy(io.Discard, conn)
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				var message strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					message.WriteString(line)
+				}
+				messages <- message.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, messages := smtpServer(t, false)
+	notifier := &SMTPNotifier{
+		Config: SMTPConfig{Addr: addr, From: "review-bot@example.com"},
+		To:     []string{"oncall@example.com"},
+	}
+
+	event := Event{Title: "Token mismatch\nfor octo/hello", Message: "Retrying the review"}
+	if err := notifier.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	message := <-messages
+	if !strings.Contains(message, "To: oncall@example.com\r\n") || !strings.Contains(message, "Subject: Token mismatch for octo/hello\r\n") {
+		t.Errorf("message headers = %q, want the recipient and a single line subject", message)
+	}
+	if !strings.HasSuffix(message, "\r\nRetrying the review\r\n") {
+		t.Errorf("message = %q, want the event text as body", message)
+	}
+}
+
+func TestSMTPNotifierAbortsWhenContextDone(t *testing.T) {
+	addr, _ := smtpServer(t, true)
+	notifier := &SMTPNotifier{
+		Config: SMTPConfig{Addr: addr, From: "review-bot@example.com"},
+		To:     []string{"oncall@example.com"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- notifier.Notify(ctx, Event{Title: "PR approved"}) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Notify() = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify() kept waiting on the server after the deadline")
+	}
+}
+
+func TestSMTPNotifierValidatesConfig(t *testing.T) {
+	if err := (&SMTPNotifier{To: []string{"oncall@example.com"}}).Notify(context.Background(), Event{}); err == nil {
+		t.Error("Notify() without a server succeeded")
+	}
+	if err := (&SMTPNotifier{Config: SMTPConfig{Addr: "localhost:25", From: "bot@example.com"}}).Notify(context.Background(), Event{}); err == nil {
+		t.Error("Notify() without recipients succeeded")
+	}
+}
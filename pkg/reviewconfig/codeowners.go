@@ -0,0 +1,53 @@
+package reviewconfig
+
+import (
+	"bufio"
+	"strings"
+)
+
+// CodeOwnersPaths are the locations GitHub reads the CODEOWNERS file from, in order of precedence.
+var CodeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeOwnersRule assigns owners to the files matching a glob.
+type codeOwnersRule struct {
+	glob   string
+	owners []string
+}
+
+// CodeOwners maps files to their owners using the CODEOWNERS format.
+type CodeOwners struct {
+	rules []codeOwnersRule
+}
+
+// ParseCodeOwners parses a CODEOWNERS file. Like on GitHub, lines with an invalid glob are
+// skipped rather than failing the whole file.
+func ParseCodeOwners(content string) *CodeOwners {
+	codeOwners := &CodeOwners{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !ValidGlob(fields[0]) {
+			continue
+		}
+		codeOwners.rules = append(codeOwners.rules, codeOwnersRule{glob: fields[0], owners: fields[1:]})
+	}
+	return codeOwners
+}
+
+// Owners returns the owners of a file, e.g. "@octocat" or "@org/team". The last matching rule
+// wins, and a matching rule without owners leaves the file unowned.
+func (c *CodeOwners) Owners(filePath string) []string {
+	if c == nil {
+		return nil
+	}
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if Match(c.rules[i].glob, filePath) {
+			return c.rules[i].owners
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package reviewconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodeOwnersOwners(t *testing.T) {
+	codeOwners := ParseCodeOwners(`# Default owners
+*                   @octo/maintainers
+controllers/        @octo/api   @alice
+*.sql               @octo/dba # migrations too
+docs/generated/
+[invalid            @mallory
+`)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"main.go", []string{"@octo/maintainers"}},
+		{"controllers/review_controller.go", []string{"@octo/api", "@alice"}},
+		{"controllers/schema.sql", []string{"@octo/dba"}},
+		{"docs/generated/api.md", []string{}},
+	}
+	for _, tt := range tests {
+		if got := codeOwners.Owners(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Owners(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	var none *CodeOwners
+	if got := none.Owners("main.go"); got != nil {
+		t.Errorf("nil CodeOwners.Owners() = %v", got)
+	}
+}
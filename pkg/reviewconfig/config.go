@@ -0,0 +1,341 @@
+// Package reviewconfig parses and validates the .codereview.yml file that lets a repository
+// configure its reviews from version control.
+package reviewconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the repository file holding the review configuration.
+const FileName = ".codereview.yml"
+
+// CurrentVersion is the only supported config version.
+const CurrentVersion = 1
+
+// Severities in the order of decreasing importance. They match the comment severities used by
+// the review workflow.
+var Severities = []string{"blocker", "major", "minor", "nit"}
+
+// languageExtensions maps the languages accepted under "languages" to their file extensions.
+var languageExtensions = map[string][]string{
+	"go":         {".go"},
+	"typescript": {".ts", ".tsx"},
+	"javascript": {".js", ".jsx", ".mjs", ".cjs"},
+	"python":     {".py"},
+	"java":       {".java"},
+	"kotlin":     {".kt", ".kts"},
+	"ruby":       {".rb"},
+	"rust":       {".rs"},
+	"sql":        {".sql"},
+	"shell":      {".sh", ".bash"},
+	"yaml":       {".yml", ".yaml"},
+	"terraform":  {".tf"},
+}
+
+// Config is the parsed review configuration, for example:
+//
+//	version: 1
+//	paths:
+//	  include: ["**"]
+//	  exclude: ["vendor/", "**/*.pb.go"]
+//	path_instructions:
+//	  - path: "pkg/database/"
+//	    persona: "A database engineer who cares about transaction safety"
+//	    instructions: "Check that every multi-statement write runs in a transaction."
+//	severity:
+//	  minimum: minor
+//	  paths:
+//	    - path: "docs/"
+//	      minimum: major
+//	providers: [anthropic, openai]
+//	languages:
+//	  go: "Wrap returned errors with context using fmt.Errorf and %w."
+//	approval:
+//	  max_majors: 1
+//	  max_minors: -1
+type Config struct {
+	Version          int               `yaml:"version"`
+	Paths            PathFilter        `yaml:"paths"`
+	PathInstructions []PathInstruction `yaml:"path_instructions"`
+	Severity         SeverityConfig    `yaml:"severity"`
+	Providers        []string          `yaml:"providers"`
+	Languages        map[string]string `yaml:"languages"`
+	Approval         *ApprovalConfig   `yaml:"approval"`
+}
+
+// PathFilter selects the files that are reviewed. Exclusions win over inclusions, and an empty
+// include list includes every file.
+type PathFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// PathInstruction sets the reviewer persona and review instructions for files matching a glob.
+// Like CODEOWNERS, rules are listed from general to specific, but every matching rule applies.
+type PathInstruction struct {
+	Path         string `yaml:"path"`
+	Persona      string `yaml:"persona"`
+	Instructions string `yaml:"instructions"`
+}
+
+// SeverityConfig drops comments below a minimum severity. The last matching path override wins.
+type SeverityConfig struct {
+	Minimum string         `yaml:"minimum"`
+	Paths   []PathSeverity `yaml:"paths"`
+}
+
+// PathSeverity overrides the minimum severity for files matching a glob.
+type PathSeverity struct {
+	Path    string `yaml:"path"`
+	Minimum string `yaml:"minimum"`
+}
+
+// ApprovalConfig overrides the auto-approval limits. Unset limits keep the repository's existing
+// policy, and -1 means unlimited.
+type ApprovalConfig struct {
+	MaxBlockers         *int `yaml:"max_blockers"`
+	MaxMajors           *int `yaml:"max_majors"`
+	MaxMinors           *int `yaml:"max_minors"`
+	MaxNits             *int `yaml:"max_nits"`
+	TieBreakAboveMajors *int `yaml:"tie_break_above_majors"`
+}
+
+// Problem is a single schema violation.
+type Problem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// ValidationError lists everything wrong with a config so it can be fixed in one go.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		messages = append(messages, problem.String())
+	}
+	return fmt.Sprintf("invalid %s: %s", FileName, strings.Join(messages, "; "))
+}
+
+// Parse decodes and validates a config. Unknown fields are rejected so typos don't silently
+// disable a setting. When knownProviders is not empty, providers must be one of them. Invalid
+// configs return a *ValidationError.
+func Parse(content []byte, knownProviders []string) (*Config, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, &ValidationError{Problems: []Problem{{Message: "the file is empty"}}}
+		}
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			problems := make([]Problem, 0, len(typeErr.Errors))
+			for _, message := range typeErr.Errors {
+				problems = append(problems, Problem{Message: strings.TrimPrefix(message, "yaml: ")})
+			}
+			return nil, &ValidationError{Problems: problems}
+		}
+		return nil, &ValidationError{Problems: []Problem{{Message: strings.TrimPrefix(err.Error(), "yaml: ")}}}
+	}
+
+	if problems := config.Validate(knownProviders); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &config, nil
+}
+
+// Validate checks the config against the schema and returns every problem found.
+func (c *Config) Validate(knownProviders []string) []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch c.Version {
+	case 0:
+		add("version", "is required, set it to %d", CurrentVersion)
+	case CurrentVersion:
+	default:
+		add("version", "%d is not supported, the current version is %d", c.Version, CurrentVersion)
+	}
+
+	for i, glob := range c.Paths.Include {
+		if !ValidGlob(glob) {
+			add(fmt.Sprintf("paths.include[%d]", i), "%q is not a valid glob", glob)
+		}
+	}
+	for i, glob := range c.Paths.Exclude {
+		if !ValidGlob(glob) {
+			add(fmt.Sprintf("paths.exclude[%d]", i), "%q is not a valid glob", glob)
+		}
+	}
+
+	for i, rule := range c.PathInstructions {
+		field := fmt.Sprintf("path_instructions[%d]", i)
+		if !ValidGlob(rule.Path) {
+			add(field+".path", "%q is not a valid glob", rule.Path)
+		}
+		if strings.TrimSpace(rule.Persona) == "" && strings.TrimSpace(rule.Instructions) == "" {
+			add(field, "needs a persona or instructions")
+		}
+	}
+
+	if c.Severity.Minimum != "" && !validSeverity(c.Severity.Minimum) {
+		add("severity.minimum", "%q must be one of %s", c.Severity.Minimum, strings.Join(Severities, ", "))
+	}
+	for i, override := range c.Severity.Paths {
+		field := fmt.Sprintf("severity.paths[%d]", i)
+		if !ValidGlob(override.Path) {
+			add(field+".path", "%q is not a valid glob", override.Path)
+		}
+		if !validSeverity(override.Minimum) {
+			add(field+".minimum", "%q must be one of %s", override.Minimum, strings.Join(Severities, ", "))
+		}
+	}
+
+	seenProviders := map[string]bool{}
+	for i, provider := range c.Providers {
+		field := fmt.Sprintf("providers[%d]", i)
+		switch {
+		case seenProviders[provider]:
+			add(field, "%q is listed more than once", provider)
+		case len(knownProviders) > 0 && !contains(knownProviders, provider):
+			add(field, "%q is not a known provider, expected one of %s", provider, strings.Join(knownProviders, ", "))
+		}
+		seenProviders[provider] = true
+	}
+
+	for _, language := range sortedKeys(c.Languages) {
+		field := "languages." + language
+		if _, ok := languageExtensions[language]; !ok {
+			add(field, "is not a supported language, expected one of %s", strings.Join(sortedKeys(languageExtensions), ", "))
+		} else if strings.TrimSpace(c.Languages[language]) == "" {
+			add(field, "instructions are empty")
+		}
+	}
+
+	if c.Approval != nil {
+		limits := []struct {
+			field string
+			value *int
+		}{
+			{"approval.max_blockers", c.Approval.MaxBlockers},
+			{"approval.max_majors", c.Approval.MaxMajors},
+			{"approval.max_minors", c.Approval.MaxMinors},
+			{"approval.max_nits", c.Approval.MaxNits},
+			{"approval.tie_break_above_majors", c.Approval.TieBreakAboveMajors},
+		}
+		for _, limit := range limits {
+			if limit.value != nil && *limit.value < -1 {
+				add(limit.field, "must be -1 (unlimited) or more, got %d", *limit.value)
+			}
+		}
+	}
+
+	return problems
+}
+
+// Includes reports whether a file should be reviewed.
+func (c *Config) Includes(filePath string) bool {
+	for _, glob := range c.Paths.Exclude {
+		if Match(glob, filePath) {
+			return false
+		}
+	}
+	if len(c.Paths.Include) == 0 {
+		return true
+	}
+	for _, glob := range c.Paths.Include {
+		if Match(glob, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// InstructionsFor returns the path instructions that apply to a file, in config order.
+func (c *Config) InstructionsFor(filePath string) []PathInstruction {
+	var rules []PathInstruction
+	for _, rule := range c.PathInstructions {
+		if Match(rule.Path, filePath) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// MinimumSeverityFor returns the minimum severity of comments on a file, or "" if every severity
+// is kept.
+func (c *Config) MinimumSeverityFor(filePath string) string {
+	minimum := c.Severity.Minimum
+	for _, override := range c.Severity.Paths {
+		if Match(override.Path, filePath) {
+			minimum = override.Minimum
+		}
+	}
+	return strings.ToLower(minimum)
+}
+
+// LanguageFor returns the configured language of a file, or "" if it has none.
+func LanguageFor(filePath string) string {
+	extension := strings.ToLower(path.Ext(filePath))
+	for language, extensions := range languageExtensions {
+		if contains(extensions, extension) {
+			return language
+		}
+	}
+	return ""
+}
+
+// LanguageInstructions returns the instructions for the languages of the given files.
+func (c *Config) LanguageInstructions(filePaths []string) map[string]string {
+	instructions := map[string]string{}
+	for _, filePath := range filePaths {
+		language := LanguageFor(filePath)
+		if instruction, ok := c.Languages[language]; ok && language != "" {
+			instructions[language] = strings.TrimSpace(instruction)
+		}
+	}
+	return instructions
+}
+
+func validSeverity(severity string) bool {
+	return contains(Severities, strings.ToLower(severity))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
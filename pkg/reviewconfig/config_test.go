@@ -0,0 +1,130 @@
+package reviewconfig
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validConfig = `version: 1
+paths:
+  include: ["**/*.go", "*.sql"]
+  exclude: ["vendor/", "**/*_test.go"]
+path_instructions:
+  - path: "controllers/"
+    persona: "API reviewer"
+    instructions: "Check that every handler validates company_id."
+  - path: "controllers/admin/"
+    instructions: "Admin handlers must check the admin role."
+severity:
+  minimum: minor
+  paths:
+    - path: "docs/"
+      minimum: major
+providers: [anthropic, openai]
+languages:
+  go: "Wrap returned errors with context."
+approval:
+  max_majors: 1
+  max_minors: -1
+`
+
+func TestParseValidConfig(t *testing.T) {
+	config, err := Parse([]byte(validConfig), []string{"anthropic", "openai", "google"})
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	if !config.Includes("pkg/review/review.go") || config.Includes("vendor/lib/lib.go") || config.Includes("pkg/review/review_test.go") || config.Includes("README.md") {
+		t.Error("Includes() doesn't apply the include and exclude globs")
+	}
+	if rules := config.InstructionsFor("controllers/admin/users.go"); len(rules) != 2 {
+		t.Errorf("InstructionsFor() = %+v, want both controller rules", rules)
+	}
+	if got := config.MinimumSeverityFor("docs/setup.md"); got != "major" {
+		t.Errorf("MinimumSeverityFor(docs) = %q, want major", got)
+	}
+	if got := config.MinimumSeverityFor("main.go"); got != "minor" {
+		t.Errorf("MinimumSeverityFor(main.go) = %q, want minor", got)
+	}
+	want := map[string]string{"go": "Wrap returned errors with context."}
+	if got := config.LanguageInstructions([]string{"main.go", "schema.sql"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("LanguageInstructions() = %v, want %v", got, want)
+	}
+	if config.Approval == nil || *config.Approval.MaxMajors != 1 || config.Approval.MaxNits != nil {
+		t.Errorf("Approval = %+v, want only the set limits", config.Approval)
+	}
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		fields  []string
+	}{
+		{"empty", "", []string{""}},
+		{"missing version", "providers: [anthropic]", []string{"version"}},
+		{"unsupported version", "version: 2", []string{"version"}},
+		{"unknown field", "version: 1\nreviewers: [alice]", []string{""}},
+		{"wrong type", "version: 1\nproviders: anthropic", []string{""}},
+		{
+			"every problem reported",
+			`version: 1
+paths:
+  include: ["[a-z"]
+path_instructions:
+  - path: "api/"
+severity:
+  minimum: critical
+providers: [anthropic, anthropic, mistral]
+languages:
+  cobol: "Use uppercase."
+  go: " "
+approval:
+  max_majors: -2
+`,
+			[]string{
+				"paths.include[0]",
+				"path_instructions[0]",
+				"severity.minimum",
+				"providers[1]",
+				"providers[2]",
+				"languages.cobol",
+				"languages.go",
+				"approval.max_majors",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content), []string{"anthropic", "openai"})
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Parse() = %v, want a *ValidationError", err)
+			}
+			var fields []string
+			for _, problem := range validationErr.Problems {
+				fields = append(fields, problem.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("problem fields = %q, want %q (%v)", fields, tt.fields, err)
+			}
+			if !strings.HasPrefix(err.Error(), "invalid "+FileName+": ") {
+				t.Errorf("Error() = %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestExampleConfigIsValid(t *testing.T) {
+	content, err := os.ReadFile("../../docs/codereview.example.yml")
+	if err != nil {
+		t.Fatalf("reading the example: %v", err)
+	}
+	if _, err := Parse(content, nil); err != nil {
+		t.Errorf("Parse(example) = %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package reviewconfig
+
+import (
+	"path"
+	"strings"
+)
+
+// Match reports whether a repository path matches a glob. Globs use the CODEOWNERS and gitignore
+// conventions:
+//
+//	*.sql              files with the extension in any directory
+//	controllers/       everything below a directory
+//	pkg/**/db.go       "**" matches any number of directories
+//	/main.go           a leading slash anchors the glob at the repository root
+func Match(glob, filePath string) bool {
+	glob = strings.TrimSpace(glob)
+	filePath = strings.TrimPrefix(filePath, "/")
+	if glob == "" {
+		return false
+	}
+
+	anchored := strings.HasPrefix(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	directory := strings.HasSuffix(glob, "/")
+	glob = strings.TrimSuffix(glob, "/")
+	switch {
+	case !anchored && !strings.Contains(glob, "/"):
+		// Globs without a slash match the name of the file or of any of its directories
+		glob = "**/" + glob + "/**"
+	case directory:
+		glob += "/**"
+	}
+	return matchSegments(strings.Split(glob, "/"), strings.Split(filePath, "/"))
+}
+
+// ValidGlob reports whether the glob can be matched.
+func ValidGlob(glob string) bool {
+	if strings.TrimSpace(glob) == "" {
+		return false
+	}
+	for _, segment := range strings.Split(strings.Trim(glob, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func matchSegments(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			// Collapse repeated "**" and try every split of the remaining segments
+			for len(glob) > 0 && glob[0] == "**" {
+				glob = glob[1:]
+			}
+			if len(glob) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(glob, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(glob[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,45 @@
+package reviewconfig
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.sql", "db/migrations/001_init.sql", true},
+		{"*.sql", "db/schema.go", false},
+		{"controllers/", "controllers/review_controller.go", true},
+		{"controllers/", "pkg/controllers.go", false},
+		{"vendor", "third_party/vendor/lib/lib.go", true},
+		{"pkg/**/db.go", "pkg/db.go", true},
+		{"pkg/**/db.go", "pkg/database/postgres/db.go", true},
+		{"pkg/**/db.go", "cmd/pkg/db.go", false},
+		{"/main.go", "main.go", true},
+		{"/main.go", "cmd/main.go", false},
+		{"main.go", "cmd/main.go", true},
+		{"docs/*.md", "docs/guide/setup.md", false},
+		{"**", "anything/at/all.txt", true},
+		{"", "main.go", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.glob, tt.path); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValidGlob(t *testing.T) {
+	for glob, want := range map[string]bool{
+		"*.go":       true,
+		"pkg/**/":    true,
+		"/main.go":   true,
+		"[a-z]*.txt": true,
+		"[a-z.txt":   false,
+		"  ":         false,
+	} {
+		if got := ValidGlob(glob); got != want {
+			t.Errorf("ValidGlob(%q) = %v, want %v", glob, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package rules runs deterministic checks on Go source files, reporting findings on the lines a
+// pull request changed.
+package rules
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of findings. They match the comment severities used by the review workflow.
+const (
+	SeverityBlocker = "blocker"
+	SeverityMajor   = "major"
+	SeverityMinor   = "minor"
+	SeverityNit     = "nit"
+)
+
+// Finding is a rule violation at a line of a file.
+type Finding struct {
+	RuleID   string `json:"rule_id"`
+	Category string `json:"category"`
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Message  string `json:"message"`
+}
+
+// File is a parsed Go source file handed to the rules.
+type File struct {
+	Path string
+	Fset *token.FileSet
+	AST  *ast.File
+}
+
+// Line returns the line of a position in the file.
+func (f *File) Line(pos token.Pos) int {
+	return f.Fset.Position(pos).Line
+}
+
+// Rule is a single deterministic check.
+type Rule interface {
+	// ID identifies the rule in findings and configuration
+	ID() string
+	// Check returns the violations of the rule in the file
+	Check(file *File) []Finding
+}
+
+// Engine runs a set of rules.
+type Engine struct {
+	rules []Rule
+}
+
+// NewEngine creates an engine running the given rules.
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// NewDefaultEngine creates an engine running DefaultRules.
+func NewDefaultEngine() *Engine {
+	return NewEngine(DefaultRules()...)
+}
+
+// Run parses the Go source and returns the findings on the changed lines, ordered by line. A nil
+// changedLines reports findings on every line.
+func (e *Engine) Run(path string, src []byte, changedLines map[int]bool) ([]Finding, error) {
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	file := &File{Path: path, Fset: fset, AST: parsed}
+
+	var findings []Finding
+	for _, rule := range e.rules {
+		for _, finding := range rule.Check(file) {
+			if changedLines != nil && !changedLines[finding.Line] {
+				continue
+			}
+			finding.RuleID = rule.ID()
+			finding.Path = path
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+// ChangedLines returns the lines of the new file that a unified diff patch adds.
+func ChangedLines(patch string) map[int]bool {
+	changed := map[int]bool{}
+	line := 0
+	for _, diffLine := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(diffLine, "@@"):
+			// @@ -start,count +start,count @@
+			fields := strings.Fields(diffLine)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				continue
+			}
+			start, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[2], "+"), ",", 2)[0])
+			if err != nil {
+				continue
+			}
+			line = start
+		case line == 0:
+			// Before the first hunk
+		case strings.HasPrefix(diffLine, "+"):
+			changed[line] = true
+			line++
+		case strings.HasPrefix(diffLine, "-"), strings.HasPrefix(diffLine, `\`):
+			// Removed lines and "\ No newline at end of file" don't exist in the new file
+		default:
+			line++
+		}
+	}
+	return changed
+}
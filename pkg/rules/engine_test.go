@@ -0,0 +1,81 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+)
+
+const librarySource = `package store
+
+import (
+	"fmt"
+	"os"
+)
+
+const apiKey = "sk-live-1234"
+
+func Load(path string) []byte {
+	data, _ := os.ReadFile(path)
+	fmt.Println("loaded", path)
+	if len(data) == 0 {
+		panic("empty file")
+	}
+	_ = len(data)
+	return data
+}
+`
+
+func TestEngineRunReportsDefaultRules(t *testing.T) {
+	findings, err := NewDefaultEngine().Run("store/store.go", []byte(librarySource), nil)
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	var got []string
+	for _, finding := range findings {
+		got = append(got, finding.RuleID)
+		if finding.Path != "store/store.go" {
+			t.Errorf("finding %s path = %q", finding.RuleID, finding.Path)
+		}
+	}
+	want := []string{"hardcoded-secret", "ignored-error", "print-logging", "library-panic"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run() rules = %v, want %v ordered by line", got, want)
+	}
+}
+
+func TestEngineRunOnlyReportsChangedLines(t *testing.T) {
+	findings, err := NewDefaultEngine().Run("store/store.go", []byte(librarySource), map[int]bool{12: true})
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if len(findings) != 1 || findings[0].RuleID != "print-logging" || findings[0].Line != 12 {
+		t.Errorf("Run() = %+v, want only the print on line 12", findings)
+	}
+}
+
+func TestEngineRunSkipsMainAndTests(t *testing.T) {
+	main := "package main\n\nfunc main() {\n\tpanic(\"boom\")\n}\n"
+	if findings, _ := NewEngine(LibraryPanicRule{}).Run("main.go", []byte(main), nil); len(findings) != 0 {
+		t.Errorf("panic in package main reported: %+v", findings)
+	}
+
+	test := "package store\n\nconst token = \"fixture\"\n"
+	if findings, _ := NewEngine(HardcodedSecretRule{}).Run("store_test.go", []byte(test), nil); len(findings) != 0 {
+		t.Errorf("secret in a test file reported: %+v", findings)
+	}
+}
+
+func TestEngineRunInvalidSource(t *testing.T) {
+	if _, err := NewDefaultEngine().Run("broken.go", []byte("package"), nil); err == nil {
+		t.Error("Run() on invalid source succeeded")
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	patch := "@@ -1,3 +1,4 @@\n package store\n-var a = 1\n+var a = 2\n+var b = 3\n \n@@ -10,2 +11,2 @@ func Load() {\n \treturn\n+}\n\\ No newline at end of file"
+	want := map[int]bool{2: true, 3: true, 12: true}
+	if got := ChangedLines(patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedLines() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package rules
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultRules returns the rules from the repository's coding guidelines.
+func DefaultRules() []Rule {
+	return []Rule{
+		IgnoredErrorRule{},
+		PrintLoggingRule{},
+		HardcodedSecretRule{},
+		LibraryPanicRule{},
+	}
+}
+
+// importName returns the name a package is imported as in the file, or "" if it isn't imported.
+func importName(file *ast.File, importPath string) string {
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path != importPath {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return importPath[strings.LastIndex(importPath, "/")+1:]
+	}
+	return ""
+}
+
+// packageCall returns the function name if the call is pkg.Func for the given package name.
+func packageCall(call *ast.CallExpr, pkg string) (string, bool) {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || pkg == "" {
+		return "", false
+	}
+	ident, ok := selector.X.(*ast.Ident)
+	if !ok || ident.Name != pkg {
+		return "", false
+	}
+	return selector.Sel.Name, true
+}
+
+func isBlank(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
+
+func isTestFile(path string) bool {
+	return strings.HasSuffix(path, "_test.go")
+}
+
+// IgnoredErrorRule flags errors discarded with the blank identifier, e.g. "result, _ := f()",
+// "_ = f()" or "_ = err". Without type information the last result of a call is assumed to be the error.
+type IgnoredErrorRule struct{}
+
+// ID implements Rule.
+func (IgnoredErrorRule) ID() string { return "ignored-error" }
+
+// Check implements Rule.
+func (IgnoredErrorRule) Check(file *File) []Finding {
+	var findings []Finding
+	ast.Inspect(file.AST, func(node ast.Node) bool {
+		assign, ok := node.(*ast.AssignStmt)
+		if !ok || len(assign.Rhs) != 1 || !isBlank(assign.Lhs[len(assign.Lhs)-1]) {
+			return true
+		}
+		switch rhs := assign.Rhs[0].(type) {
+		case *ast.CallExpr:
+			// Builtins like len() don't return errors
+			if ident, ok := rhs.Fun.(*ast.Ident); ok && isBuiltin(ident.Name) {
+				return true
+			}
+		case *ast.Ident:
+			// "_ = err" silences the unused variable instead of handling it
+			if !strings.HasPrefix(strings.ToLower(rhs.Name), "err") {
+				return true
+			}
+		default:
+			return true
+		}
+		findings = append(findings, Finding{
+			Category: "error_handling",
+			Severity: SeverityMajor,
+			Line:     file.Line(assign.Pos()),
+			Message:  "The error returned here is discarded with `_`. Handle it explicitly, or wrap and return it with context.",
+		})
+		return true
+	})
+	return findings
+}
+
+func isBuiltin(name string) bool {
+	switch name {
+	case "len", "cap", "append", "copy", "delete", "new", "make", "min", "max", "recover", "print", "println":
+		return true
+	}
+	return false
+}
+
+// PrintLoggingRule flags fmt.Print* calls used for logging instead of the structured logger.
+type PrintLoggingRule struct{}
+
+// ID implements Rule.
+func (PrintLoggingRule) ID() string { return "print-logging" }
+
+// Check implements Rule.
+func (PrintLoggingRule) Check(file *File) []Finding {
+	if isTestFile(file.Path) {
+		return nil
+	}
+	fmtName := importName(file.AST, "fmt")
+
+	var findings []Finding
+	ast.Inspect(file.AST, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		name, ok := packageCall(call, fmtName)
+		if !ok || (name != "Println" && name != "Printf" && name != "Print") {
+			return true
+		}
+		findings = append(findings, Finding{
+			Category: "logging",
+			Severity: SeverityMinor,
+			Line:     file.Line(call.Pos()),
+			Message:  "Use the structured logger instead of `fmt." + name + "` for logging, so the output has levels and context fields.",
+		})
+		return true
+	})
+	return findings
+}
+
+// secretNamePattern matches identifiers and keys that usually hold credentials.
+var secretNamePattern = regexp.MustCompile(`(?i)(secret|passw(or)?d|pwd|api_?key|access_?key|private_?key|token|credential|dsn)`)
+
+// HardcodedSecretRule flags non-empty string literals assigned to credential-like names.
+type HardcodedSecretRule struct{}
+
+// ID implements Rule.
+func (HardcodedSecretRule) ID() string { return "hardcoded-secret" }
+
+// Check implements Rule.
+func (HardcodedSecretRule) Check(file *File) []Finding {
+	if isTestFile(file.Path) {
+		return nil
+	}
+
+	var findings []Finding
+	report := func(name string, value ast.Expr) {
+		literal, ok := value.(*ast.BasicLit)
+		if !ok || literal.Kind != token.STRING || !secretNamePattern.MatchString(name) {
+			return
+		}
+		if unquoted, err := strconv.Unquote(literal.Value); err != nil || strings.TrimSpace(unquoted) == "" {
+			return
+		}
+		findings = append(findings, Finding{
+			Category: "security",
+			Severity: SeverityBlocker,
+			Line:     file.Line(literal.Pos()),
+			Message:  "`" + name + "` is set to a hardcoded string. Load secrets from the environment or a secret manager instead of committing them.",
+		})
+	}
+
+	ast.Inspect(file.AST, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				if i < len(n.Values) {
+					report(name.Name, n.Values[i])
+				}
+			}
+		case *ast.AssignStmt:
+			if len(n.Lhs) != len(n.Rhs) {
+				return true
+			}
+			for i, lhs := range n.Lhs {
+				switch target := lhs.(type) {
+				case *ast.Ident:
+					report(target.Name, n.Rhs[i])
+				case *ast.SelectorExpr:
+					report(target.Sel.Name, n.Rhs[i])
+				}
+			}
+		case *ast.KeyValueExpr:
+			switch key := n.Key.(type) {
+			case *ast.Ident:
+				report(key.Name, n.Value)
+			case *ast.BasicLit:
+				if unquoted, err := strconv.Unquote(key.Value); err == nil {
+					report(unquoted, n.Value)
+				}
+			}
+		case *ast.CallExpr:
+			// os.Getenv("API_KEY", ...) style helpers with a hardcoded fallback
+			if len(n.Args) == 2 {
+				if key, ok := n.Args[0].(*ast.BasicLit); ok && key.Kind == token.STRING {
+					if unquoted, err := strconv.Unquote(key.Value); err == nil {
+						report(unquoted, n.Args[1])
+					}
+				}
+			}
+		}
+		return true
+	})
+	return findings
+}
+
+// LibraryPanicRule flags panic and log.Fatal calls outside package main.
+type LibraryPanicRule struct{}
+
+// ID implements Rule.
+func (LibraryPanicRule) ID() string { return "library-panic" }
+
+// Check implements Rule.
+func (LibraryPanicRule) Check(file *File) []Finding {
+	if file.AST.Name.Name == "main" || isTestFile(file.Path) {
+		return nil
+	}
+	logName := importName(file.AST, "log")
+
+	var findings []Finding
+	ast.Inspect(file.AST, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		message := ""
+		if ident, ok := call.Fun.(*ast.Ident); ok && ident.Name == "panic" {
+			message = "Don't panic in library code. Return an error and let the caller decide how to handle it."
+		} else if name, ok := packageCall(call, logName); ok && strings.HasPrefix(name, "Fatal") {
+			message = "`log." + name + "` exits the process from library code. Return an error instead."
+		}
+		if message == "" {
+			return true
+		}
+		findings = append(findings, Finding{
+			Category: "error_handling",
+			Severity: SeverityMajor,
+			Line:     file.Line(call.Pos()),
+			Message:  message,
+		})
+		return true
+	})
+	return findings
+}
@@ -0,0 +1,141 @@
+// Package secrets detects credentials added by a diff using patterns for well-known key formats,
+// DSNs and password assignments, and the entropy of string literals.
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Detector finds secrets in a line of code.
+type Detector interface {
+	// ID identifies the detector in findings and allowlists
+	ID() string
+	// Description is shown to the author when the detector finds a secret
+	Description() string
+	// Find returns the secret values found in the line
+	Find(line string) []string
+}
+
+// patternDetector finds secrets matching a regular expression. When the expression has a group
+// named "secret" only that part of the match is reported.
+type patternDetector struct {
+	id          string
+	description string
+	pattern     *regexp.Regexp
+}
+
+func (d *patternDetector) ID() string          { return d.id }
+func (d *patternDetector) Description() string { return d.description }
+
+func (d *patternDetector) Find(line string) []string {
+	group := d.pattern.SubexpIndex("secret")
+	var found []string
+	for _, match := range d.pattern.FindAllStringSubmatch(line, -1) {
+		if group > 0 {
+			found = append(found, match[group])
+		} else {
+			found = append(found, match[0])
+		}
+	}
+	return found
+}
+
+// placeholderPattern matches values that are obviously not real secrets.
+var placeholderPattern = regexp.MustCompile(`(?i)^(changeme|example|placeholder|dummy|test|xxx+|\*+|<.*>|\$\{.*\}|%s|\{\{.*\}\})$`)
+
+// passwordDetector finds non-placeholder string literals assigned to credential-like names.
+type passwordDetector struct {
+	patternDetector
+}
+
+func (d *passwordDetector) Find(line string) []string {
+	var found []string
+	for _, value := range d.patternDetector.Find(line) {
+		if !placeholderPattern.MatchString(value) {
+			found = append(found, value)
+		}
+	}
+	return found
+}
+
+// stringLiteralPattern matches quoted string literals.
+var stringLiteralPattern = regexp.MustCompile("\"([^\"\\\\]|\\\\.){16,}\"|'([^'\\\\]|\\\\.){16,}'|`[^`]{16,}`")
+
+// tokenCharsPattern matches strings made only of characters used in keys and tokens.
+var tokenCharsPattern = regexp.MustCompile(`^[A-Za-z0-9+/=_\-.]+$`)
+
+// entropyDetector finds string literals that look like random keys.
+type entropyDetector struct {
+	minLength  int
+	minEntropy float64
+}
+
+func (d *entropyDetector) ID() string { return "high-entropy-string" }
+
+func (d *entropyDetector) Description() string {
+	return "High entropy string literal that looks like a key or token"
+}
+
+func (d *entropyDetector) Find(line string) []string {
+	var found []string
+	for _, literal := range stringLiteralPattern.FindAllString(line, -1) {
+		value := literal[1 : len(literal)-1]
+		if len(value) < d.minLength || !tokenCharsPattern.MatchString(value) {
+			continue
+		}
+		// Identifiers, paths and words have letters of a single case or no digits
+		if !strings.ContainsAny(value, "0123456789") || strings.ToLower(value) == value || strings.ToUpper(value) == value {
+			continue
+		}
+		if ShannonEntropy(value) >= d.minEntropy {
+			found = append(found, value)
+		}
+	}
+	return found
+}
+
+// ShannonEntropy returns the entropy of the string in bits per character.
+func ShannonEntropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	for _, r := range value {
+		counts[r]++
+	}
+	length := float64(len([]rune(value)))
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// DefaultDetectors returns the detectors used by NewScanner.
+func DefaultDetectors() []Detector {
+	return []Detector{
+		&patternDetector{"aws-access-key", "AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		&patternDetector{"aws-secret-key", "AWS secret access key",
+			regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|key).{0,20}?['"](?P<secret>[A-Za-z0-9/+=]{40})['"]`)},
+		&patternDetector{"github-token", "GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+		&patternDetector{"slack-token", "Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+		&patternDetector{"slack-webhook", "Slack webhook URL", regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/]+`)},
+		&patternDetector{"stripe-key", "Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{16,}\b`)},
+		&patternDetector{"google-api-key", "Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+		&patternDetector{"openai-key", "OpenAI or Anthropic API key", regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_\-]{32,}\b`)},
+		&patternDetector{"private-key", "Private key", regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP )?PRIVATE KEY( BLOCK)?-----`)},
+		&patternDetector{"jwt", "JSON Web Token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\b`)},
+		&patternDetector{"url-credentials", "Connection string or URL with an embedded password",
+			regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^\s:/@'"]+:(?P<secret>[^\s@/'"]{3,})@[^\s'"]+`)},
+		&patternDetector{"mysql-dsn", "MySQL DSN with an embedded password",
+			regexp.MustCompile(`\b[^\s:/@'"(]+:(?P<secret>[^\s@/'"]{3,})@(?:tcp|unix)\(`)},
+		&patternDetector{"dsn-password", "Password in a connection string",
+			regexp.MustCompile(`(?i)\b(?:password|pwd)=(?P<secret>[^\s;&'"%]{3,})`)},
+		&passwordDetector{patternDetector{"password-literal", "Password or secret in a string literal",
+			regexp.MustCompile(`(?i)(?:passw(?:or)?d|secret|api[_-]?key|access[_-]?token|auth[_-]?token|credentials?)\w*['"]?\s*(?::=|=|:|,)\s*['"](?P<secret>[^'"\s]{4,})['"]`)}},
+		&entropyDetector{minLength: 20, minEntropy: 4.0},
+	}
+}
@@ -0,0 +1,213 @@
+package secrets
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AllowlistFile is the repository file listing accepted findings.
+const AllowlistFile = ".secretsignore"
+
+// Finding is a secret added by a patch.
+type Finding struct {
+	DetectorID  string `json:"detector_id"`
+	Description string `json:"description"`
+	Path        string `json:"path"`
+	Line        int    `json:"line"`
+	// Redacted shows enough of the value to locate it without leaking it
+	Redacted string `json:"redacted"`
+	// Fingerprint identifies the value in allowlists without committing it. It is empty when the
+	// scanner has no fingerprint key.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Fingerprint returns the allowlist fingerprint of a secret value. It is an HMAC keyed with a key
+// of the repository's owner, so the value can't be confirmed by hashing candidate secrets.
+func Fingerprint(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Redact hides all but the first characters of a secret value.
+func Redact(value string) string {
+	visible := 4
+	if len(value) <= 8 {
+		visible = 1
+	}
+	return value[:visible] + strings.Repeat("*", 8)
+}
+
+// Allowlist suppresses findings that were reviewed and accepted. Each line of the allowlist file is
+// one of:
+//
+//	path/glob/**          findings in matching files, e.g. testdata/** or *.example.env
+//	detector:<id>         findings of a detector
+//	fingerprint:<hash>    a specific value, by the fingerprint shown in the review comment
+//	match:<regexp>        values matching the expression, e.g. match:^sk_test_
+//
+// Blank lines and lines starting with # are ignored.
+type Allowlist struct {
+	paths        []string
+	detectors    map[string]bool
+	fingerprints map[string]bool
+	matches      []*regexp.Regexp
+}
+
+// ParseAllowlist parses the contents of an allowlist file.
+func ParseAllowlist(content string) (*Allowlist, error) {
+	allowlist := &Allowlist{
+		detectors:    map[string]bool{},
+		fingerprints: map[string]bool{},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, value, hasKind := strings.Cut(line, ":")
+		switch {
+		case hasKind && kind == "detector":
+			allowlist.detectors[strings.TrimSpace(value)] = true
+		case hasKind && kind == "fingerprint":
+			allowlist.fingerprints[strings.ToLower(strings.TrimSpace(value))] = true
+		case hasKind && kind == "match":
+			pattern, err := regexp.Compile(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern on line %d of %s: %w", lineNumber, AllowlistFile, err)
+			}
+			allowlist.matches = append(allowlist.matches, pattern)
+		default:
+			allowlist.paths = append(allowlist.paths, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", AllowlistFile, err)
+	}
+	return allowlist, nil
+}
+
+// AddPaths allowlists findings in files matching the globs.
+func (a *Allowlist) AddPaths(globs ...string) {
+	a.paths = append(a.paths, globs...)
+}
+
+// matchesPath reports whether the file matches a glob. A trailing /** matches everything below a
+// directory, and globs without a slash match the file name in any directory.
+func matchesPath(glob, filePath string) bool {
+	if strings.HasSuffix(glob, "/**") {
+		dir := strings.TrimSuffix(glob, "/**")
+		return filePath == dir || strings.HasPrefix(filePath, dir+"/")
+	}
+	if !strings.Contains(glob, "/") {
+		matched, _ := path.Match(glob, path.Base(filePath))
+		return matched
+	}
+	matched, _ := path.Match(glob, filePath)
+	return matched
+}
+
+func (a *Allowlist) allows(detectorID, filePath, fingerprint, value string) bool {
+	if a == nil {
+		return false
+	}
+	if a.detectors[detectorID] || (fingerprint != "" && a.fingerprints[fingerprint]) {
+		return true
+	}
+	for _, glob := range a.paths {
+		if matchesPath(glob, filePath) {
+			return true
+		}
+	}
+	for _, pattern := range a.matches {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scanner runs detectors over the lines added by patches.
+type Scanner struct {
+	detectors      []Detector
+	allowlist      *Allowlist
+	fingerprintKey []byte
+}
+
+// NewScanner creates a scanner with the default detectors. The allowlist may be nil. Findings only
+// have fingerprints when a fingerprint key is given.
+func NewScanner(allowlist *Allowlist, fingerprintKey []byte) *Scanner {
+	return &Scanner{
+		detectors:      DefaultDetectors(),
+		allowlist:      allowlist,
+		fingerprintKey: fingerprintKey,
+	}
+}
+
+// ScanPatch returns the secrets on the lines a unified diff patch adds to the file. Each value is
+// reported once per line, by the first detector that finds it.
+func (s *Scanner) ScanPatch(filePath, patch string) []Finding {
+	var findings []Finding
+	line := 0
+	for _, diffLine := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(diffLine, "@@"):
+			line = hunkStart(diffLine)
+			continue
+		case line == 0, strings.HasPrefix(diffLine, "-"), strings.HasPrefix(diffLine, `\`):
+			continue
+		case !strings.HasPrefix(diffLine, "+"):
+			line++
+			continue
+		}
+
+		reported := map[string]bool{}
+		for _, detector := range s.detectors {
+			for _, value := range detector.Find(diffLine[1:]) {
+				fingerprint := ""
+				if len(s.fingerprintKey) > 0 {
+					fingerprint = Fingerprint(s.fingerprintKey, value)
+				}
+				if reported[value] || s.allowlist.allows(detector.ID(), filePath, fingerprint, value) {
+					continue
+				}
+				reported[value] = true
+				findings = append(findings, Finding{
+					DetectorID:  detector.ID(),
+					Description: detector.Description(),
+					Path:        filePath,
+					Line:        line,
+					Redacted:    Redact(value),
+					Fingerprint: fingerprint,
+				})
+			}
+		}
+		line++
+	}
+	return findings
+}
+
+// hunkStart returns the first new-file line of a hunk header, or 0 if it can't be parsed.
+func hunkStart(header string) int {
+	// @@ -start,count +start,count @@
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 0
+	}
+	start, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[2], "+"), ",", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return start
+}
@@ -0,0 +1,299 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter sends ended spans somewhere they can be looked at.
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+}
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest. IDs are hex strings and 64 bit integers
+// are decimal strings, as the OTLP JSON mapping requires.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// OTLP span kinds and status codes used by the encoding.
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+// scopeName is the instrumentation scope reported with every span.
+const scopeName = "code-review-bot-test-repo/pkg/tracing"
+
+// EncodeOTLP encodes the spans as an OTLP/JSON ExportTraceServiceRequest.
+func EncodeOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		encoded = append(encoded, encodeSpan(span))
+	}
+	return json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{encodeAttribute("service.name", serviceName)},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: encoded,
+			}},
+		}},
+	})
+}
+
+func encodeSpan(span *Span) otlpSpan {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	encoded := otlpSpan{
+		TraceID:           span.sc.TraceID.String(),
+		SpanID:            span.sc.SpanID.String(),
+		Name:              span.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOk},
+	}
+	if span.parent.IsValid() {
+		encoded.ParentSpanID = span.parent.String()
+	}
+	if span.err != "" {
+		encoded.Status = otlpStatus{Code: otlpStatusError, Message: span.err}
+	}
+
+	keys := make([]string, 0, len(span.attributes))
+	for key := range span.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		encoded.Attributes = append(encoded.Attributes, encodeAttribute(key, span.attributes[key]))
+	}
+	return encoded
+}
+
+func encodeAttribute(key string, value interface{}) otlpKeyValue {
+	var encoded otlpAnyValue
+	switch v := value.(type) {
+	case string:
+		encoded.StringValue = &v
+	case bool:
+		encoded.BoolValue = &v
+	case time.Duration:
+		// Durations are exported in milliseconds, the unit used by the rest of the review logs
+		ms := strconv.FormatInt(v.Milliseconds(), 10)
+		encoded.IntValue = &ms
+	case float32, float64:
+		f := reflect.ValueOf(v).Float()
+		encoded.DoubleValue = &f
+	default:
+		rv := reflect.ValueOf(value)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := strconv.FormatInt(rv.Int(), 10)
+			encoded.IntValue = &i
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			i := strconv.FormatUint(rv.Uint(), 10)
+			encoded.IntValue = &i
+		default:
+			s := fmt.Sprint(value)
+			encoded.StringValue = &s
+		}
+	}
+	return otlpKeyValue{Key: key, Value: encoded}
+}
+
+// FileExporter appends each export as one line of OTLP/JSON to a file, the format read by the
+// OpenTelemetry Collector's otlpjsonfile receiver.
+type FileExporter struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileExporter creates an exporter appending to the file at path.
+func NewFileExporter(path string) *FileExporter {
+	return &FileExporter{path: path}
+}
+
+// Export implements Exporter.
+func (e *FileExporter) Export(serviceName string, spans []*Span) error {
+	payload, err := EncodeOTLP(serviceName, spans)
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	file, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(payload, '\n')); err != nil {
+		return fmt.Errorf("failed to write trace file: %w", err)
+	}
+	return nil
+}
+
+// HTTPExporter posts spans to an OTLP/HTTP collector using the JSON encoding.
+type HTTPExporter struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// NewHTTPExporter creates an exporter for the collector at endpoint, e.g. "http://localhost:4318".
+// The /v1/traces path is added unless the endpoint already ends with it.
+func NewHTTPExporter(endpoint string, headers map[string]string) *HTTPExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &HTTPExporter{
+		url:        url,
+		headers:    headers,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export implements Exporter.
+func (e *HTTPExporter) Export(serviceName string, spans []*Span) error {
+	payload, err := EncodeOTLP(serviceName, spans)
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create trace export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("trace collector returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+type multiExporter []Exporter
+
+func (m multiExporter) Export(serviceName string, spans []*Span) error {
+	var failures []string
+	for _, exporter := range m {
+		if err := exporter.Export(serviceName, spans); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to export spans: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// ExporterFromEnv creates the exporters configured in the environment, or returns nil when none is:
+//   - OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT posts to a collector, with
+//     the comma separated key=value pairs of OTEL_EXPORTER_OTLP_HEADERS as request headers
+//   - TRACE_EXPORT_FILE appends to a local file
+func ExporterFromEnv() Exporter {
+	var exporters multiExporter
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint != "" {
+		exporters = append(exporters, NewHTTPExporter(endpoint, parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))))
+	}
+	if path := os.Getenv("TRACE_EXPORT_FILE"); path != "" {
+		exporters = append(exporters, NewFileExporter(path))
+	}
+
+	switch len(exporters) {
+	case 0:
+		return nil
+	case 1:
+		return exporters[0]
+	}
+	return exporters
+}
+
+func parseHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers
+}
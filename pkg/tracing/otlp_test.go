@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// endedSpans returns a root span and a failed child span, both ended.
+func endedSpans() []*Span {
+	tracer := NewTracer("review-bot", nil)
+	start := time.Unix(1700000000, 0)
+	ctx, root := tracer.StartAt(context.Background(), "review", start)
+	root.SetAttributes(map[string]interface{}{
+		"pr_number":   42,
+		"incremental": true,
+		"duration":    1500 * time.Millisecond,
+		"cost_usd":    0.25,
+		"files":       []string{"main.go"},
+	})
+	_, child := tracer.StartAt(ctx, "model_call", start)
+	child.SetError(errors.New("rate limited"))
+	child.EndAt(start.Add(time.Second))
+	root.EndAt(start.Add(2 * time.Second))
+	return []*Span{root, child}
+}
+
+func TestEncodeOTLP(t *testing.T) {
+	spans := endedSpans()
+	payload, err := EncodeOTLP("review-bot", spans)
+	if err != nil {
+		t.Fatalf("EncodeOTLP() = %v", err)
+	}
+
+	var request otlpRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		t.Fatalf("payload isn't valid JSON: %v", err)
+	}
+	resource := request.ResourceSpans[0]
+	if service := resource.Resource.Attributes[0]; service.Key != "service.name" || *service.Value.StringValue != "review-bot" {
+		t.Errorf("resource attribute = %+v, want the service name", service)
+	}
+	encoded := resource.ScopeSpans[0].Spans
+	if len(encoded) != 2 {
+		t.Fatalf("encoded %d spans, want 2", len(encoded))
+	}
+
+	root, child := encoded[0], encoded[1]
+	if root.TraceID != spans[0].TraceID().String() || root.ParentSpanID != "" || root.Status.Code != otlpStatusOk {
+		t.Errorf("root span = %+v", root)
+	}
+	if root.StartTimeUnixNano != "1700000000000000000" || root.EndTimeUnixNano != "1700000002000000000" {
+		t.Errorf("root span times = %s to %s", root.StartTimeUnixNano, root.EndTimeUnixNano)
+	}
+	if child.ParentSpanID != root.SpanID || child.Status != (otlpStatus{Code: otlpStatusError, Message: "rate limited"}) {
+		t.Errorf("child span = %+v, want the root as parent and the error status", child)
+	}
+
+	attributes := map[string]interface{}{}
+	for _, attribute := range root.Attributes {
+		value := attribute.Value
+		switch {
+		case value.StringValue != nil:
+			attributes[attribute.Key] = *value.StringValue
+		case value.BoolValue != nil:
+			attributes[attribute.Key] = *value.BoolValue
+		case value.IntValue != nil:
+			attributes[attribute.Key] = "int:" + *value.IntValue
+		case value.DoubleValue != nil:
+			attributes[attribute.Key] = *value.DoubleValue
+		}
+	}
+	want := map[string]interface{}{
+		"pr_number":   "int:42",
+		"incremental": true,
+		"duration":    "int:1500",
+		"cost_usd":    0.25,
+		"files":       "[main.go]",
+	}
+	if !reflect.DeepEqual(attributes, want) {
+		t.Errorf("attributes = %v, want %v", attributes, want)
+	}
+}
+
+func TestFileExporterAppendsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter := NewFileExporter(path)
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export("review-bot", endedSpans()); err != nil {
+			t.Fatalf("Export() = %v", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var request otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Errorf("line %d isn't an OTLP request: %v", lines+1, err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("file has %d lines, want one per export", lines)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	status := http.StatusOK
+	var path, contentType, apiKey string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType, apiKey = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("X-Api-Key")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter := NewHTTPExporter(server.URL+"/", map[string]string{"X-Api-Key": "secret"})
+	if err := exporter.Export("review-bot", endedSpans()); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	if path != "/v1/traces" || contentType != "application/json" || apiKey != "secret" {
+		t.Errorf("request to %s with Content-Type %q and key %q", path, contentType, apiKey)
+	}
+	if !json.Valid(body) {
+		t.Error("request body isn't JSON")
+	}
+
+	status = http.StatusBadRequest
+	if err := exporter.Export("review-bot", endedSpans()); err == nil {
+		t.Error("Export() succeeded when the collector rejected the spans")
+	}
+}
+
+func TestExporterFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("TRACE_EXPORT_FILE", "")
+	if exporter := ExporterFromEnv(); exporter != nil {
+		t.Errorf("ExporterFromEnv() = %T without configuration, want nil", exporter)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key = secret, invalid ,tenant=review")
+	exporter, ok := ExporterFromEnv().(*HTTPExporter)
+	if !ok {
+		t.Fatalf("ExporterFromEnv() = %T, want *HTTPExporter", ExporterFromEnv())
+	}
+	if exporter.url != "http://collector:4318/v1/traces" {
+		t.Errorf("url = %q", exporter.url)
+	}
+	if want := map[string]string{"x-api-key": "secret", "tenant": "review"}; !reflect.DeepEqual(exporter.headers, want) {
+		t.Errorf("headers = %v, want %v", exporter.headers, want)
+	}
+
+	t.Setenv("TRACE_EXPORT_FILE", filepath.Join(t.TempDir(), "traces.jsonl"))
+	if exporters, ok := ExporterFromEnv().(multiExporter); !ok || len(exporters) != 2 {
+		t.Errorf("ExporterFromEnv() = %T, want both exporters", ExporterFromEnv())
+	}
+}
@@ -0,0 +1,294 @@
+// Package tracing records spans of a traced operation and exports them in the OTLP JSON format,
+// to a file or to a collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, all spans of one operation share it.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is set, the all-zero ID is invalid.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is set, the all-zero ID is invalid.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span that is propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// Traceparent formats the span context as a W3C traceparent header value, always sampled.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	var sc SpanContext
+	if n, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || n != len(sc.TraceID) || len(parts[1]) != 32 {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent %q", value)
+	}
+	if n, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || n != len(sc.SpanID) || len(parts[2]) != 16 {
+		return SpanContext{}, fmt.Errorf("invalid span id in traceparent %q", value)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	return sc, nil
+}
+
+// Span is a timed operation within a trace. Its methods are safe for concurrent use and do nothing
+// on a nil span.
+type Span struct {
+	tracer *Tracer
+	name   string
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	ended      bool
+	attributes map[string]interface{}
+	err        string
+}
+
+// SpanContext returns the IDs of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// TraceID returns the ID of the span's trace.
+func (s *Span) TraceID() TraceID {
+	return s.SpanContext().TraceID
+}
+
+// SetAttribute sets an attribute. Strings, booleans, integers, floats and durations are exported
+// as such, anything else as its string representation.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// SetAttributes sets several attributes.
+func (s *Span) SetAttributes(attributes map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range attributes {
+		s.attributes[key] = value
+	}
+}
+
+// SetError marks the span as failed. A nil error leaves the span unchanged.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End ends the span now.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at the given time. Only the first call has an effect.
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = end
+	s.mu.Unlock()
+	s.tracer.finish(s)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a context carrying the span, spans started from it become its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by the context, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+type remoteContextKey struct{}
+
+// ContextWithRemoteParent returns a context whose spans continue a trace started by another service.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// Inject sets the traceparent header to the span carried by the context, if any.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, span.sc.Traceparent())
+	}
+}
+
+// Extract returns a context continuing the trace of the request's traceparent header, if it has a valid one.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteParent(ctx, sc)
+}
+
+// Transport is an http.RoundTripper propagating the trace of the request's context to the server.
+type Transport struct {
+	// Base is the transport making the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if span := SpanFromContext(req.Context()); span != nil {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		Inject(req.Context(), req.Header)
+	}
+	return base.RoundTrip(req)
+}
+
+// defaultBatchSize is the number of ended spans that triggers an export before Flush is called.
+const defaultBatchSize = 512
+
+// Tracer creates spans and hands the ended ones to its exporter in batches.
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+
+	mu      sync.Mutex
+	pending []*Span
+}
+
+// NewTracer creates a tracer for the service. Without an exporter spans are still created, so IDs
+// can be used for correlation, but they are dropped when they end.
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{serviceName: serviceName, exporter: exporter}
+}
+
+// Start starts a span now, as a child of the span or remote parent carried by the context.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	return t.StartAt(ctx, name, time.Now())
+}
+
+// StartAt starts a span at the given time, for operations that are only recorded once they completed.
+func (t *Tracer) StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		start:      start,
+		attributes: map[string]interface{}{},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok && remote.IsValid() {
+		span.sc.TraceID = remote.TraceID
+		span.parent = remote.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+	}
+	span.sc.SpanID = newSpanID()
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) finish(span *Span) {
+	if t.exporter == nil {
+		return
+	}
+	t.mu.Lock()
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= defaultBatchSize
+	t.mu.Unlock()
+	if full {
+		// Flushing synchronously keeps memory bounded when a long operation ends many spans
+		_ = t.Flush()
+	}
+}
+
+// Flush exports the spans that ended since the last flush.
+func (t *Tracer) Flush() error {
+	if t.exporter == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.Export(t.serviceName, spans)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the exported spans in memory.
+type recordingExporter struct {
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(serviceName string, spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatalf("ParseTraceparent() = %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("ParseTraceparent() = %s %s", sc.TraceID, sc.SpanID)
+	}
+	if got := sc.Traceparent(); got != header {
+		t.Errorf("Traceparent() = %q, want %q", got, header)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestTracerStartsChildSpans(t *testing.T) {
+	tracer := NewTracer("review-bot", nil)
+	ctx, root := tracer.Start(context.Background(), "review")
+	_, child := tracer.Start(ctx, "model_call")
+
+	if !root.SpanContext().IsValid() || root.parent.IsValid() {
+		t.Errorf("root span = %+v, want new IDs without a parent", root.SpanContext())
+	}
+	if child.TraceID() != root.TraceID() || child.parent != root.SpanContext().SpanID {
+		t.Error("child span isn't in the root span's trace")
+	}
+	if child.SpanContext().SpanID == root.SpanContext().SpanID {
+		t.Error("child span reuses the root span's ID")
+	}
+	if SpanFromContext(ctx) != root {
+		t.Error("SpanFromContext() doesn't return the started span")
+	}
+}
+
+func TestTracerContinuesRemoteTrace(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	_, span := NewTracer("review-bot", nil).Start(Extract(context.Background(), header), "webhook")
+	if span.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("span = %s with parent %s, want it to continue the remote trace", span.TraceID(), span.parent)
+	}
+
+	header.Set(TraceparentHeader, "garbage")
+	_, span = NewTracer("review-bot", nil).Start(Extract(context.Background(), header), "webhook")
+	if span.parent.IsValid() {
+		t.Error("an invalid traceparent was used as parent")
+	}
+}
+
+func TestTransportInjectsTraceparent(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(TraceparentHeader)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	ctx, span := NewTracer("review-bot", nil).Start(context.Background(), "github")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	resp.Body.Close()
+	if received != span.SpanContext().Traceparent() {
+		t.Errorf("traceparent = %q, want %q", received, span.SpanContext().Traceparent())
+	}
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Error("Transport modified the caller's request")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if resp, err = client.Do(req); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	resp.Body.Close()
+	if received != "" {
+		t.Errorf("traceparent = %q without a span, want none", received)
+	}
+}
+
+func TestSpansAreExportedOnFlush(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer("review-bot", exporter)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, span := tracer.StartAt(context.Background(), "classify", start)
+	span.SetAttributes(map[string]interface{}{"pr_number": 7})
+	span.SetError(errors.New("model timed out"))
+	span.EndAt(start.Add(time.Second))
+	span.EndAt(start.Add(time.Minute))
+
+	if len(exporter.spans) != 0 {
+		t.Fatal("spans were exported before Flush")
+	}
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if len(exporter.spans) != 1 {
+		t.Fatalf("exported %d spans, want the span once", len(exporter.spans))
+	}
+	if got := exporter.spans[0]; got.end != start.Add(time.Second) || got.err != "model timed out" || got.attributes["pr_number"] != 7 {
+		t.Errorf("exported span = %+v, want the first end time, the error and the attribute", got)
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	var span *Span
+	span.SetAttribute("key", "value")
+	span.SetAttributes(map[string]interface{}{"key": "value"})
+	span.SetError(errors.New("ignored"))
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Error("nil span has a valid span context")
+	}
+}
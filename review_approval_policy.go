@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CommentSeverity is how strongly a review comment should hold back a pull request.
+type CommentSeverity string
+
+const (
+	SeverityBlocker CommentSeverity = "blocker"
+	SeverityMajor   CommentSeverity = "major"
+	SeverityMinor   CommentSeverity = "minor"
+	SeverityNit     CommentSeverity = "nit"
+)
+
+// commentSeverities lists the severities from most to least severe.
+var commentSeverities = []CommentSeverity{SeverityBlocker, SeverityMajor, SeverityMinor, SeverityNit}
+
+// ParseCommentSeverity normalizes a severity as written by a model or a user, e.g. "Blocker" or "nitpick".
+func ParseCommentSeverity(value string) (CommentSeverity, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "blocker", "critical":
+		return SeverityBlocker, true
+	case "major", "high":
+		return SeverityMajor, true
+	case "minor", "medium", "low":
+		return SeverityMinor, true
+	case "nit", "nitpick", "trivial":
+		return SeverityNit, true
+	}
+	return "", false
+}
+
+// Rank orders severities so that a higher rank is more severe. Unknown severities rank lowest.
+func (s CommentSeverity) Rank() int {
+	for i, severity := range commentSeverities {
+		if severity == s {
+			return len(commentSeverities) - i
+		}
+	}
+	return 0
+}
+
+// severityInstructions tells the models how to tag each comment.
+const severityInstructions = ` Tag every comment with a "severity" field set to one of: ` +
+	`"blocker" (bugs, security issues or data loss that must be fixed before merging), ` +
+	`"major" (likely defects or significant maintainability problems), ` +
+	`"minor" (improvements worth making) or "nit" (style and naming polish).`
+
+// defaultSeverityForType infers a severity from the classified comment type when a model didn't provide one.
+func defaultSeverityForType(commentType string) CommentSeverity {
+	commentType = strings.ToLower(commentType)
+	switch {
+	case strings.Contains(commentType, "security"), strings.Contains(commentType, "bug"):
+		return SeverityBlocker
+	case strings.Contains(commentType, "style"), strings.Contains(commentType, "nit"), strings.Contains(commentType, "naming"):
+		return SeverityNit
+	}
+	return SeverityMinor
+}
+
+// normalizeCommentSeverity makes sure a comment carries one of the known severities.
+func normalizeCommentSeverity(comment *InternalReviewComment) {
+	if severity, ok := ParseCommentSeverity(string(comment.Severity)); ok {
+		comment.Severity = severity
+		return
+	}
+	comment.Severity = defaultSeverityForType(comment.Type)
+}
+
+// ApprovalPolicy decides deterministically whether a pull request can be auto-approved based on the
+// severities of the posted comments. A negative limit means unlimited.
+type ApprovalPolicy struct {
+	MaxBlockers int `json:"max_blockers"`
+	MaxMajors   int `json:"max_majors"`
+	MaxMinors   int `json:"max_minors"`
+	MaxNits     int `json:"max_nits"`
+	// TieBreakAboveMajors hands the decision to a model when the number of majors is above this value
+	// but still within MaxMajors. A negative value disables the tie-breaker.
+	TieBreakAboveMajors int `json:"tie_break_above_majors"`
+}
+
+// DefaultApprovalPolicy only approves pull requests without any posted comments.
+var DefaultApprovalPolicy = ApprovalPolicy{
+	MaxBlockers:         0,
+	MaxMajors:           0,
+	MaxMinors:           0,
+	MaxNits:             0,
+	TieBreakAboveMajors: -1,
+}
+
+// RepoApprovalPolicy stores the approval policy of a single repository. It is unique per company,
+// owner and repo, see reviewCompanyIndexes.
+type RepoApprovalPolicy struct {
+	models.SingleCompanyModel
+	Owner  string         `json:"owner"`
+	Repo   string         `json:"repo"`
+	Policy ApprovalPolicy `gorm:"embedded;embeddedPrefix:policy_" json:"policy"`
+}
+
+// Outcomes of evaluating an ApprovalPolicy.
+const (
+	ApprovalOutcomeApprove  = "approve"
+	ApprovalOutcomeReject   = "reject"
+	ApprovalOutcomeTieBreak = "tie_break"
+)
+
+// ApprovalDecision is the result of an approval evaluation along with how it was reached.
+type ApprovalDecision struct {
+	Outcome        string                  `json:"outcome"`
+	Approve        bool                    `json:"approve"`
+	Reason         string                  `json:"reason"`
+	Counts         map[CommentSeverity]int `json:"counts"`
+	Policy         ApprovalPolicy          `json:"policy"`
+	UsedTieBreaker bool                    `json:"used_tie_breaker"`
+	Trail          []string                `json:"trail"`
+}
+
+// Evaluate applies the policy to the comments that were posted on the pull request.
+func (p ApprovalPolicy) Evaluate(comments []*InternalReviewComment) *ApprovalDecision {
+	decision := &ApprovalDecision{
+		Counts: map[CommentSeverity]int{},
+		Policy: p,
+	}
+	for _, severity := range commentSeverities {
+		decision.Counts[severity] = 0
+	}
+	for _, comment := range comments {
+		normalizeCommentSeverity(comment)
+		decision.Counts[comment.Severity]++
+	}
+	decision.Trail = append(decision.Trail, fmt.Sprintf("counted %d blockers, %d majors, %d minors, %d nits",
+		decision.Counts[SeverityBlocker], decision.Counts[SeverityMajor], decision.Counts[SeverityMinor], decision.Counts[SeverityNit]))
+
+	// Committed secrets need to be removed and rotated, no policy can approve them
+	if secretCount := countSecretFindings(comments); secretCount > 0 {
+		decision.Outcome = ApprovalOutcomeReject
+		decision.Reason = fmt.Sprintf("%d possible secret(s) were committed", secretCount)
+		decision.Trail = append(decision.Trail, fmt.Sprintf("secrets: %d found, reject", secretCount))
+		return decision
+	}
+
+	limits := []struct {
+		severity CommentSeverity
+		limit    int
+	}{
+		{SeverityBlocker, p.MaxBlockers},
+		{SeverityMajor, p.MaxMajors},
+		{SeverityMinor, p.MaxMinors},
+		{SeverityNit, p.MaxNits},
+	}
+	for _, l := range limits {
+		if l.limit < 0 {
+			decision.Trail = append(decision.Trail, fmt.Sprintf("%s: unlimited", l.severity))
+			continue
+		}
+		if count := decision.Counts[l.severity]; count > l.limit {
+			decision.Outcome = ApprovalOutcomeReject
+			decision.Reason = fmt.Sprintf("%d %s comment(s) exceed the limit of %d", count, l.severity, l.limit)
+			decision.Trail = append(decision.Trail, fmt.Sprintf("%s: %d > %d, reject", l.severity, count, l.limit))
+			return decision
+		}
+		decision.Trail = append(decision.Trail, fmt.Sprintf("%s: %d <= %d", l.severity, decision.Counts[l.severity], l.limit))
+	}
+
+	if p.TieBreakAboveMajors >= 0 && decision.Counts[SeverityMajor] > p.TieBreakAboveMajors {
+		decision.Outcome = ApprovalOutcomeTieBreak
+		decision.Trail = append(decision.Trail, fmt.Sprintf("majors %d above tie-break threshold %d, deferring to model", decision.Counts[SeverityMajor], p.TieBreakAboveMajors))
+		return decision
+	}
+
+	decision.Outcome = ApprovalOutcomeApprove
+	decision.Approve = true
+	if len(comments) == 0 {
+		decision.Reason = "AI analysis completed with no actionable comments or suggestions."
+	} else {
+		decision.Reason = fmt.Sprintf("No blocking issues found: %d blocker(s), %d major(s), %d minor(s) and %d nit(s) are within the repository's approval policy.",
+			decision.Counts[SeverityBlocker], decision.Counts[SeverityMajor], decision.Counts[SeverityMinor], decision.Counts[SeverityNit])
+	}
+	return decision
+}
+
+// loadApprovalPolicy returns the repository's approval policy, or DefaultApprovalPolicy if none is
+// configured, with the overrides from the repository's review config applied.
+func (w *CodeReviewWorkflow) loadApprovalPolicy() ApprovalPolicy {
+	policy := DefaultApprovalPolicy
+	var repoPolicy RepoApprovalPolicy
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo).
+		First(&repoPolicy).Error
+	if err == nil {
+		policy = repoPolicy.Policy
+	} else if err != gorm.ErrRecordNotFound {
+		logging.GetGlobalLogger().Warn("Failed to load approval policy, using default", zap.Error(err))
+	}
+
+	if w.repoConfig != nil {
+		policy = policy.withRepoConfig(w.repoConfig.Approval)
+	}
+	return policy
+}
+
+// logApprovalDecision stores the decision trail in the execution log.
+func (w *CodeReviewWorkflow) logApprovalDecision(prNumber int, decision *ApprovalDecision) {
+	decisionJSON, err := json.Marshal(decision)
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to marshal approval decision", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+	w.AddExecutionLog("Approval decision: " + string(decisionJSON))
+}
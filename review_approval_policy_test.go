@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+)
+
+func severityComments(severities ...CommentSeverity) []*InternalReviewComment {
+	comments := make([]*InternalReviewComment, len(severities))
+	for i, severity := range severities {
+		comments[i] = &InternalReviewComment{Severity: severity}
+	}
+	return comments
+}
+
+func TestApprovalPolicyEvaluate(t *testing.T) {
+	lenient := ApprovalPolicy{MaxBlockers: 0, MaxMajors: 2, MaxMinors: -1, MaxNits: -1, TieBreakAboveMajors: -1}
+	tieBreak := lenient
+	tieBreak.TieBreakAboveMajors = 0
+
+	tests := []struct {
+		name     string
+		policy   ApprovalPolicy
+		comments []*InternalReviewComment
+		want     string
+	}{
+		{"default approves no comments", DefaultApprovalPolicy, nil, ApprovalOutcomeApprove},
+		{"default rejects a nit", DefaultApprovalPolicy, severityComments(SeverityNit), ApprovalOutcomeReject},
+		{"blocker over limit", lenient, severityComments(SeverityBlocker), ApprovalOutcomeReject},
+		{"majors within limit", lenient, severityComments(SeverityMajor, SeverityMajor, SeverityMinor, SeverityNit), ApprovalOutcomeApprove},
+		{"majors over limit", lenient, severityComments(SeverityMajor, SeverityMajor, SeverityMajor), ApprovalOutcomeReject},
+		{"unlimited minors", lenient, severityComments(SeverityMinor, SeverityMinor, SeverityMinor, SeverityMinor), ApprovalOutcomeApprove},
+		{"majors in tie-break band", tieBreak, severityComments(SeverityMajor), ApprovalOutcomeTieBreak},
+		{"untagged bug is a blocker", lenient, []*InternalReviewComment{{Type: "bug"}}, ApprovalOutcomeReject},
+		{"untagged security issue is a blocker", lenient, []*InternalReviewComment{{Type: "security_vulnerability"}}, ApprovalOutcomeReject},
+		{"untagged style comment is a nit", lenient, []*InternalReviewComment{{Type: "style"}}, ApprovalOutcomeApprove},
+		{"secrets always reject", ApprovalPolicy{MaxBlockers: -1, MaxMajors: -1, MaxMinors: -1, MaxNits: -1, TieBreakAboveMajors: -1},
+			[]*InternalReviewComment{{Provider: secretScannerProviderName, Severity: SeverityBlocker}}, ApprovalOutcomeReject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := tt.policy.Evaluate(tt.comments)
+			if decision.Outcome != tt.want {
+				t.Errorf("Evaluate() outcome = %s (%s), want %s", decision.Outcome, decision.Reason, tt.want)
+			}
+			if decision.Approve != (tt.want == ApprovalOutcomeApprove) {
+				t.Errorf("Evaluate() approve = %v with outcome %s", decision.Approve, decision.Outcome)
+			}
+		})
+	}
+}
+
+func TestParseCommentSeverity(t *testing.T) {
+	tests := map[string]CommentSeverity{
+		"Blocker":  SeverityBlocker,
+		"critical": SeverityBlocker,
+		" high ":   SeverityMajor,
+		"medium":   SeverityMinor,
+		"nitpick":  SeverityNit,
+	}
+	for value, want := range tests {
+		if got, ok := ParseCommentSeverity(value); !ok || got != want {
+			t.Errorf("ParseCommentSeverity(%q) = %s, %v, want %s", value, got, ok, want)
+		}
+	}
+	if _, ok := ParseCommentSeverity("urgent"); ok {
+		t.Error("ParseCommentSeverity(urgent) succeeded")
+	}
+}
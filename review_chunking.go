@@ -0,0 +1,412 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// featureChunkedReview enables splitting diffs that exceed the token budget into batches.
+const featureChunkedReview = "chunked_review"
+
+// maxParallelReviewBatches bounds how many batches are sent to the providers at the same time.
+const maxParallelReviewBatches = 3
+
+// filePromptOverhead approximates the tokens the file metadata adds to the user message.
+const filePromptOverhead = 50
+
+// estimatePatchTokens returns the approximate number of tokens the diffs of the files take up.
+func estimatePatchTokens(files []clients.PullRequestFile) int {
+	total := 0
+	for _, file := range files {
+		total += estimateFileTokens(file)
+	}
+	return total
+}
+
+func estimateFileTokens(file clients.PullRequestFile) int {
+	return CountTokens(file.Patch) + filePromptOverhead
+}
+
+// splitPatchIntoHunks splits a unified diff patch at its "@@" hunk headers.
+func splitPatchIntoHunks(patch string) []string {
+	var hunks []string
+	var current strings.Builder
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if strings.HasPrefix(line, "@@") && current.Len() > 0 {
+			hunks = append(hunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		hunks = append(hunks, current.String())
+	}
+	return hunks
+}
+
+// splitFileByHunks breaks a file whose patch exceeds the budget into several partial files, each
+// holding as many consecutive hunks as fit. A single hunk larger than the budget is split into
+// line ranges first.
+func splitFileByHunks(file clients.PullRequestFile, tokenBudget int) []clients.PullRequestFile {
+	var parts []clients.PullRequestFile
+	var patch strings.Builder
+	patchTokens := 0
+
+	flush := func() {
+		if patch.Len() == 0 {
+			return
+		}
+		part := file
+		part.Patch = patch.String()
+		parts = append(parts, part)
+		patch.Reset()
+		patchTokens = 0
+	}
+
+	for _, hunk := range splitPatchIntoHunks(file.Patch) {
+		pieces := []string{hunk}
+		if CountTokens(hunk)+filePromptOverhead > tokenBudget {
+			pieces = splitHunkByLines(hunk, tokenBudget-filePromptOverhead)
+		}
+		for _, piece := range pieces {
+			pieceTokens := CountTokens(piece)
+			if patchTokens > 0 && patchTokens+pieceTokens+filePromptOverhead > tokenBudget {
+				flush()
+			}
+			patch.WriteString(piece)
+			patchTokens += pieceTokens
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitHunkByLines splits a hunk into consecutive line ranges of at most tokenBudget tokens. Each
+// range gets its own hunk header with the line numbers of its first lines, so comments on it still
+// point at the right lines. A hunk whose header can't be parsed is returned whole.
+func splitHunkByLines(hunk string, tokenBudget int) []string {
+	lines := strings.SplitAfter(hunk, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) < 2 {
+		return []string{hunk}
+	}
+
+	// @@ -start,count +start,count @@ section
+	header := strings.TrimRight(lines[0], "\n")
+	fields := strings.Fields(header)
+	if len(fields) < 4 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return []string{hunk}
+	}
+	oldLine, oldErr := parseHunkRange(strings.TrimPrefix(fields[1], "-"))
+	newLine, newErr := parseHunkRange(strings.TrimPrefix(fields[2], "+"))
+	if oldErr != nil || newErr != nil {
+		return []string{hunk}
+	}
+	section := ""
+	if _, after, ok := strings.Cut(strings.TrimPrefix(header, "@@"), "@@"); ok {
+		section = after
+	}
+
+	var pieces []string
+	var body strings.Builder
+	bodyTokens := 0
+	pieceOldStart, pieceNewStart := oldLine, newLine
+	pieceOldCount, pieceNewCount := 0, 0
+	flush := func() {
+		if body.Len() == 0 {
+			return
+		}
+		pieces = append(pieces, fmt.Sprintf("@@ -%s +%s @@%s\n%s",
+			hunkRange(pieceOldStart, pieceOldCount), hunkRange(pieceNewStart, pieceNewCount), section, body.String()))
+		body.Reset()
+		bodyTokens = 0
+		pieceOldStart, pieceNewStart = oldLine, newLine
+		pieceOldCount, pieceNewCount = 0, 0
+	}
+
+	// Counting line by line underestimates, leave room for the rounding and the new header
+	tokenBudget -= CountTokens(header)
+	for _, line := range lines[1:] {
+		lineTokens := CountTokens(line) + 1
+		// "\ No newline at end of file" belongs to the line before it
+		if bodyTokens > 0 && bodyTokens+lineTokens > tokenBudget && !strings.HasPrefix(line, `\`) {
+			flush()
+		}
+		body.WriteString(line)
+		bodyTokens += lineTokens
+		switch {
+		case strings.HasPrefix(line, "+"):
+			newLine++
+			pieceNewCount++
+		case strings.HasPrefix(line, "-"):
+			oldLine++
+			pieceOldCount++
+		case strings.HasPrefix(line, `\`):
+			// Not a line of either file
+		default:
+			oldLine++
+			newLine++
+			pieceOldCount++
+			pieceNewCount++
+		}
+	}
+	flush()
+	return pieces
+}
+
+// parseHunkRange returns the first line of one side of a hunk header, e.g. "12,4". An empty range
+// such as "12,0" names the line before it, so its first line is the one after.
+func parseHunkRange(value string) (int, error) {
+	startValue, countValue, hasCount := strings.Cut(value, ",")
+	start, err := strconv.Atoi(startValue)
+	if err != nil {
+		return 0, err
+	}
+	if hasCount && countValue == "0" {
+		return start + 1, nil
+	}
+	return start, nil
+}
+
+// hunkRange formats the start and length of one side of a hunk header. An empty range names the
+// line before it, as in diffs produced by git.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// relatedFileGroups groups files that should be reviewed together: files in the same directory,
+// a file and its test, and files linked by the dependency context.
+func relatedFileGroups(files []clients.PullRequestFile, additionalContext map[string]interface{}) [][]clients.PullRequestFile {
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) {
+		if rootA, rootB := find(a), find(b); rootA != rootB {
+			parent[rootB] = rootA
+		}
+	}
+
+	byPath := make(map[string]int, len(files))
+	byDir := map[string]int{}
+	byStem := map[string]int{}
+	for i, file := range files {
+		byPath[file.Filename] = i
+
+		dir := path.Dir(file.Filename)
+		if first, ok := byDir[dir]; ok {
+			union(first, i)
+		} else {
+			byDir[dir] = i
+		}
+
+		stem := strings.TrimSuffix(strings.TrimSuffix(file.Filename, path.Ext(file.Filename)), "_test")
+		stem = strings.TrimSuffix(strings.TrimSuffix(stem, ".test"), ".spec")
+		if first, ok := byStem[stem]; ok {
+			union(first, i)
+		} else {
+			byStem[stem] = i
+		}
+	}
+
+	for source, targets := range dependencyLinks(additionalContext) {
+		sourceIndex, ok := byPath[source]
+		if !ok {
+			continue
+		}
+		for _, target := range targets {
+			if targetIndex, ok := byPath[target]; ok {
+				union(sourceIndex, targetIndex)
+			}
+		}
+	}
+
+	groupsByRoot := map[int][]clients.PullRequestFile{}
+	var roots []int
+	for i, file := range files {
+		root := find(i)
+		if _, ok := groupsByRoot[root]; !ok {
+			roots = append(roots, root)
+		}
+		groupsByRoot[root] = append(groupsByRoot[root], file)
+	}
+
+	groups := make([][]clients.PullRequestFile, 0, len(roots))
+	for _, root := range roots {
+		groups = append(groups, groupsByRoot[root])
+	}
+	return groups
+}
+
+// dependencyLinks reads the file to dependency mapping from the dependency context, if present.
+func dependencyLinks(additionalContext map[string]interface{}) map[string][]string {
+	links := map[string][]string{}
+	switch dependencies := additionalContext["dependency_context"].(type) {
+	case map[string][]string:
+		return dependencies
+	case map[string]interface{}:
+		for source, value := range dependencies {
+			targets, ok := value.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, target := range targets {
+				if targetPath, ok := target.(string); ok {
+					links[source] = append(links[source], targetPath)
+				}
+			}
+		}
+	}
+	return links
+}
+
+// buildReviewBatches splits the files into batches whose diffs fit the token budget. Related files
+// are kept in the same batch where possible and oversized files are split at hunk boundaries.
+func buildReviewBatches(files []clients.PullRequestFile, additionalContext map[string]interface{}, tokenBudget int) [][]clients.PullRequestFile {
+	groups := relatedFileGroups(files, additionalContext)
+
+	// Place the largest groups first so smaller ones can fill the gaps
+	sort.SliceStable(groups, func(i, j int) bool {
+		return estimatePatchTokens(groups[i]) > estimatePatchTokens(groups[j])
+	})
+
+	var batches [][]clients.PullRequestFile
+	var batchTokens []int
+	place := func(items []clients.PullRequestFile, tokens int) {
+		for i := range batches {
+			if batchTokens[i]+tokens <= tokenBudget {
+				batches[i] = append(batches[i], items...)
+				batchTokens[i] += tokens
+				return
+			}
+		}
+		batches = append(batches, append([]clients.PullRequestFile{}, items...))
+		batchTokens = append(batchTokens, tokens)
+	}
+
+	for _, group := range groups {
+		if groupTokens := estimatePatchTokens(group); groupTokens <= tokenBudget {
+			place(group, groupTokens)
+			continue
+		}
+		// The group doesn't fit as a whole, fall back to placing its files, and their hunks, separately
+		for _, file := range group {
+			if fileTokens := estimateFileTokens(file); fileTokens <= tokenBudget {
+				place([]clients.PullRequestFile{file}, fileTokens)
+				continue
+			}
+			for _, part := range splitFileByHunks(file, tokenBudget) {
+				place([]clients.PullRequestFile{part}, estimateFileTokens(part))
+			}
+		}
+	}
+	return batches
+}
+
+// callMultipleAIModelsInBatches reviews each batch with all enabled providers, running several
+// batches in parallel, and combines their comments.
+func (w *CodeReviewWorkflow) callMultipleAIModelsInBatches(
+	ctx context.Context,
+	contextMessage string,
+	batches [][]clients.PullRequestFile,
+	tokenBudget int,
+	additionalContext map[string]interface{},
+	commit string,
+	prNumber int,
+	requestID string,
+	aiStart time.Time,
+) ([]*InternalReviewComment, error) {
+	type batchResult struct {
+		comments []*InternalReviewComment
+		err      error
+	}
+
+	results := make([]batchResult, len(batches))
+	semaphore := make(chan struct{}, maxParallelReviewBatches)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			// Batches still waiting for a slot when the review runs out of time are skipped
+			if err := ctx.Err(); err != nil {
+				results[i] = batchResult{nil, err}
+				return
+			}
+
+			userMessage := w.prepareUserMessage(batch, tokenBudget)
+			tokenCount := CountTokens(contextMessage) + CountTokens(userMessage)
+			comments, err := w.callMultipleAIModels(
+				ctx,
+				contextMessage,
+				userMessage,
+				tokenCount,
+				additionalContext,
+				commit,
+				batch,
+				prNumber,
+				fmt.Sprintf("%s-batch%d", requestID, i),
+				aiStart,
+			)
+			results[i] = batchResult{comments, err}
+		}()
+	}
+	wg.Wait()
+
+	var mergedComments []*InternalReviewComment
+	var failures []string
+	for i, result := range results {
+		if result.err != nil {
+			logging.GetGlobalLogger().Error("Failed to review batch",
+				zap.Error(result.err),
+				zap.Int("pr_number", prNumber),
+				zap.Int("batch", i),
+				zap.Int("file_count", len(batches[i])))
+			failures = append(failures, fmt.Sprintf("batch %d: %v", i, result.err))
+			continue
+		}
+		mergedComments = append(mergedComments, result.comments...)
+	}
+	if len(failures) == len(batches) {
+		return nil, fmt.Errorf("all review batches failed: %s", strings.Join(failures, "; "))
+	}
+
+	w.logWorkflowStep(
+		prNumber,
+		requestID,
+		"batched_review_complete",
+		map[string]interface{}{
+			"repository":     w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+			"duration":       time.Since(aiStart),
+			"batch_count":    len(batches),
+			"failed_batches": len(failures),
+			"merged_count":   len(mergedComments),
+		},
+	)
+	return mergedComments, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+// largeHunk returns a hunk of n changes, each removing one line and adding two.
+func largeHunk(n int) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("@@ -10,%d +10,%d @@ func process() {\n", n+1, 2*n+1))
+	sb.WriteString(" \tsetup()\n")
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf("-\told%d := compute(%d)\n", i, i))
+		sb.WriteString(fmt.Sprintf("+\tnew%d := compute(%d)\n", i, i))
+		sb.WriteString(fmt.Sprintf("+\tcheck(new%d)\n", i))
+	}
+	return sb.String()
+}
+
+func TestSplitFileByHunksSplitsOversizedHunk(t *testing.T) {
+	file := clients.PullRequestFile{Filename: "process.go", Patch: largeHunk(200)}
+	budget := 400
+
+	parts := splitFileByHunks(file, budget)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want the hunk split", len(parts))
+	}
+
+	var combined strings.Builder
+	for _, part := range parts {
+		if tokens := estimateFileTokens(part); tokens > budget {
+			t.Errorf("part of %d tokens exceeds the budget of %d", tokens, budget)
+		}
+		if !strings.HasPrefix(part.Patch, "@@ ") || !strings.Contains(strings.SplitN(part.Patch, "\n", 2)[0], "@@ func process() {") {
+			t.Errorf("part doesn't start with a hunk header: %q", strings.SplitN(part.Patch, "\n", 2)[0])
+		}
+		combined.WriteString(part.Patch)
+	}
+
+	// Every line keeps its line number in the old and the new file
+	wantLeft, wantRight := patchDiffLines(file.Patch)
+	gotLeft, gotRight := patchDiffLines(combined.String())
+	if !reflect.DeepEqual(gotLeft, wantLeft) || !reflect.DeepEqual(gotRight, wantRight) {
+		t.Error("split hunks don't map to the same old and new lines as the original")
+	}
+}
+
+func TestSplitHunkByLinesEmptyRanges(t *testing.T) {
+	header := "@@ -0,0 +1,4 @@"
+	line := "+value := 1\n"
+	hunk := header + "\n" + strings.Repeat(line, 4)
+
+	pieces := splitHunkByLines(hunk, CountTokens(header)+2*(CountTokens(line)+1))
+
+	want := []string{"@@ -0,0 +1,2 @@\n" + line + line, "@@ -0,0 +3,2 @@\n" + line + line}
+	if !reflect.DeepEqual(pieces, want) {
+		t.Errorf("splitHunkByLines() = %q, want %q", pieces, want)
+	}
+}
+
+func TestSplitFileByHunksKeepsHunksWhole(t *testing.T) {
+	patch := "@@ -1,2 +1,2 @@\n-a\n+b\n c\n@@ -20,2 +20,2 @@\n-d\n+e\n f\n"
+	file := clients.PullRequestFile{Filename: "small.go", Patch: patch}
+
+	parts := splitFileByHunks(file, filePromptOverhead+CountTokens("@@ -1,2 +1,2 @@\n-a\n+b\n c\n"))
+
+	if len(parts) != 2 || parts[0].Patch+parts[1].Patch != patch {
+		t.Errorf("splitFileByHunks() = %+v, want one part per hunk", parts)
+	}
+}
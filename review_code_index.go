@@ -0,0 +1,475 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code-review-bot-test-repo/pkg/codeindex"
+	jobs "code-review-bot-test-repo/services"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"github.com/tonyd3/propel-gtm/api/types"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Limits of the related code added to the review context.
+const (
+	relatedCodeTopK        = 20
+	relatedCodeTokenBudget = 6000
+	// relatedCodeMinScore drops chunks that only share common words with the changes
+	relatedCodeMinScore = 0.2
+	// relatedCodeQueryLines is the number of changed lines of a file used to query the index
+	relatedCodeQueryLines = 200
+)
+
+// Limits of the indexing and search of a repository.
+const (
+	// codeIndexMaxFiles caps the files indexed when a whole repository is indexed
+	codeIndexMaxFiles = 5000
+	// codeIndexScanBatch is the number of vectors a Postgres search loads at a time
+	codeIndexScanBatch = 1000
+)
+
+// codeIndexSkippedDirs hold third-party code, which would crowd the repository's own code out of
+// the index.
+var codeIndexSkippedDirs = []string{"vendor", "node_modules", "third_party"}
+
+// CodeIndexChunk is a chunk of a repository file in the code index, see pkg/codeindex.
+type CodeIndexChunk struct {
+	models.SingleCompanyModel
+	Repository string `gorm:"index:idx_code_index_chunk_file" json:"repository"`
+	Path       string `gorm:"index:idx_code_index_chunk_file" json:"path"`
+	Symbol     string `json:"symbol"`
+	Kind       string `json:"kind"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Content    string `gorm:"type:text" json:"content"`
+	Embedder   string `gorm:"index" json:"embedder"`
+	// Vector holds the embedding as little-endian float32s
+	Vector []byte `gorm:"type:bytea" json:"-"`
+}
+
+// relatedCodeSnippet is a chunk of the code index passed to the models as related_code.
+type relatedCodeSnippet struct {
+	Path      string `json:"path"`
+	Symbol    string `json:"symbol"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Code      string `json:"code"`
+}
+
+// codeIndex returns the index of the workflow's company. It's stored in Postgres, or in
+// CODE_INDEX_DIR on local disk when that's set.
+func (w *CodeReviewWorkflow) codeIndex() *codeindex.Index {
+	var store codeindex.Store
+	if dir := os.Getenv("CODE_INDEX_DIR"); dir != "" {
+		store = fileCodeIndexStore(dir)
+	} else {
+		store = &postgresCodeIndexStore{db: w.db, companyId: w.repoWorkflowSetting.CompanyId}
+	}
+	return codeindex.New(store, w.recordEmbedder(codeindex.EmbedderFromEnv()))
+}
+
+// consensusEmbedder returns the embedder clustering the comments of a consensus merge, or nil when
+// no embeddings API is configured. The HashEmbedder only measures shared words, which the merge
+// compares already.
+func consensusEmbedder() codeindex.Embedder {
+	if os.Getenv("EMBEDDINGS_BASE_URL") == "" {
+		return nil
+	}
+	return codeindex.EmbedderFromEnv()
+}
+
+var (
+	fileCodeIndexStoresMu sync.Mutex
+	fileCodeIndexStores   = map[string]*codeindex.FileStore{}
+)
+
+// fileCodeIndexStore shares one FileStore per directory, it caches the index in memory.
+func fileCodeIndexStore(dir string) *codeindex.FileStore {
+	fileCodeIndexStoresMu.Lock()
+	defer fileCodeIndexStoresMu.Unlock()
+	store, ok := fileCodeIndexStores[dir]
+	if !ok {
+		store = codeindex.NewFileStore(dir)
+		fileCodeIndexStores[dir] = store
+	}
+	return store
+}
+
+// retrieveRelatedCode looks up the code related to the pull request's changes in the repository's
+// index and keeps the best matches that fit the token budget. The changed files themselves are
+// left out, the diff already covers them.
+func (w *CodeReviewWorkflow) retrieveRelatedCode(ctx context.Context, prNumber int, requestID string, files []clients.PullRequestFile) []relatedCodeSnippet {
+	start := time.Now()
+	queries := buildRelatedCodeQueries(files)
+	if len(queries) == 0 {
+		return nil
+	}
+	changedPaths := make(map[string]bool, len(files))
+	for _, file := range files {
+		changedPaths[file.Filename] = true
+	}
+
+	results, err := w.codeIndex().Search(ctx, w.githubConfig.Owner+"/"+w.githubConfig.Repo, queries, relatedCodeTopK, changedPaths)
+	if err != nil {
+		w.logWorkflowError(prNumber, requestID, err, map[string]interface{}{
+			"step":       "retrieve_related_code",
+			"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+			"duration":   time.Since(start),
+		})
+		return nil
+	}
+
+	var snippets []relatedCodeSnippet
+	tokens := 0
+	for _, result := range results {
+		if result.Score < relatedCodeMinScore {
+			break
+		}
+		// Skip the chunks that don't fit, a smaller one further down may still
+		chunkTokens := CountTokens(result.Content)
+		if tokens+chunkTokens > relatedCodeTokenBudget {
+			continue
+		}
+		tokens += chunkTokens
+		snippets = append(snippets, relatedCodeSnippet{
+			Path:      result.Path,
+			Symbol:    result.Symbol,
+			StartLine: result.StartLine,
+			EndLine:   result.EndLine,
+			Code:      result.Content,
+		})
+	}
+
+	w.logWorkflowStep(prNumber, requestID, "retrieve_related_code", map[string]interface{}{
+		"repository":    w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+		"duration":      time.Since(start),
+		"query_count":   len(queries),
+		"result_count":  len(results),
+		"snippet_count": len(snippets),
+		"token_count":   tokens,
+	})
+	return snippets
+}
+
+// buildRelatedCodeQueries builds one query per changed file from its path, the enclosing symbols
+// named in its hunk headers and its changed lines.
+func buildRelatedCodeQueries(files []clients.PullRequestFile) []string {
+	var queries []string
+	for _, file := range files {
+		if file.Patch == "" {
+			continue
+		}
+		query := []string{file.Filename}
+		changedLines := 0
+		for _, line := range strings.Split(file.Patch, "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				// The text after the range is the enclosing function or type, e.g. "@@ -1,2 +1,3 @@ func foo()"
+				if _, header, ok := strings.Cut(strings.TrimPrefix(line, "@@"), "@@"); ok && strings.TrimSpace(header) != "" {
+					query = append(query, strings.TrimSpace(header))
+				}
+			case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"):
+				if changedLines < relatedCodeQueryLines {
+					query = append(query, line[1:])
+					changedLines++
+				}
+			}
+		}
+		queries = append(queries, strings.Join(query, "\n"))
+	}
+	return queries
+}
+
+// IndexMergedPullRequest re-indexes the files a merged pull request changed, as of its merge commit.
+// Renamed files are indexed under their new path, their old path stays in the index.
+func (w *CodeReviewWorkflow) IndexMergedPullRequest(ctx context.Context, prNumber int, commitSHA string) error {
+	repository := w.githubConfig.Owner + "/" + w.githubConfig.Repo
+	files, err := w.github(ctx).GetPullRequestFiles(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get files of PR #%d: %w", prNumber, err)
+	}
+
+	index := w.codeIndex()
+	indexed, removed, chunks := 0, 0, 0
+	for _, file := range files {
+		if !codeindex.Supported(file.Filename) {
+			continue
+		}
+		if file.Status == "removed" {
+			if err := index.RemoveFile(ctx, repository, file.Filename); err != nil {
+				return err
+			}
+			removed++
+			continue
+		}
+
+		content, err := w.github(ctx).GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, file.Filename, commitSHA)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.GetGlobalLogger().Warn("Failed to fetch file for the code index",
+				zap.Error(err),
+				zap.String("path", file.Filename),
+				zap.String("repository", repository))
+			continue
+		}
+		count, err := index.IndexFile(ctx, repository, file.Filename, []byte(content))
+		if err != nil {
+			return err
+		}
+		indexed++
+		chunks += count
+	}
+
+	logging.GetGlobalLogger().Info("Updated code index from merged pull request",
+		zap.String("repository", repository),
+		zap.Int("pr_number", prNumber),
+		zap.Int("indexed_files", indexed),
+		zap.Int("removed_files", removed),
+		zap.Int("chunks", chunks))
+	return nil
+}
+
+// IndexRepository indexes every supported file of the repository as of ref, skipping vendored
+// code. It bootstraps the index of a repository, which merged pull requests then keep up to date.
+// Files no longer in the repository aren't removed from an existing index.
+func (w *CodeReviewWorkflow) IndexRepository(ctx context.Context, ref string) error {
+	repository := w.githubConfig.Owner + "/" + w.githubConfig.Repo
+	paths, err := w.github(ctx).ListRepositoryFiles(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, ref)
+	if err != nil {
+		return fmt.Errorf("failed to list files of %s at %s: %w", repository, ref, err)
+	}
+
+	var supported []string
+	for _, path := range paths {
+		if codeindex.Supported(path) && !inSkippedDir(path) {
+			supported = append(supported, path)
+		}
+	}
+	if len(supported) > codeIndexMaxFiles {
+		logging.GetGlobalLogger().Warn("Repository has too many files, indexing part of them",
+			zap.String("repository", repository),
+			zap.Int("file_count", len(supported)),
+			zap.Int("max_files", codeIndexMaxFiles))
+		supported = supported[:codeIndexMaxFiles]
+	}
+
+	index := w.codeIndex()
+	indexed, chunks := 0, 0
+	for _, path := range supported {
+		content, err := w.github(ctx).GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, path, ref)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.GetGlobalLogger().Warn("Failed to fetch file for the code index",
+				zap.Error(err),
+				zap.String("path", path),
+				zap.String("repository", repository))
+			continue
+		}
+		count, err := index.IndexFile(ctx, repository, path, []byte(content))
+		if err != nil {
+			return err
+		}
+		indexed++
+		chunks += count
+	}
+
+	logging.GetGlobalLogger().Info("Indexed repository",
+		zap.String("repository", repository),
+		zap.String("ref", ref),
+		zap.Int("indexed_files", indexed),
+		zap.Int("chunks", chunks))
+	return nil
+}
+
+// inSkippedDir reports whether the path is inside one of codeIndexSkippedDirs, at any depth.
+func inSkippedDir(path string) bool {
+	dirs := strings.Split(path, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		for _, skipped := range codeIndexSkippedDirs {
+			if dir == skipped {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HandleIndexJob re-indexes the files of a merged pull request. A repository that isn't indexed
+// yet, or was indexed with another embedder, is indexed in full as of the merge commit instead,
+// and a retry of that job indexes it in full again rather than trusting the partial index.
+// It can be registered as the handler for jobs.ReviewJobKindIndex jobs.
+func (w *CodeReviewWorkflow) HandleIndexJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+	if job.Step == jobs.ReviewJobStepPosted {
+		return nil
+	}
+	if !models.IsFeatureEnabledForCompany(w.db, string(types.AgenticWorkflow), w.repoWorkflowSetting.CompanyId) {
+		return nil
+	}
+	indexed, err := w.codeIndex().Indexed(ctx, w.githubConfig.Owner+"/"+w.githubConfig.Repo)
+	if err != nil {
+		return err
+	}
+	if indexed && job.Step != jobs.ReviewJobStepStarted {
+		if err := w.IndexMergedPullRequest(ctx, job.PRNumber, job.HeadSHA); err != nil {
+			return err
+		}
+	} else {
+		if err := queue.Checkpoint(ctx, job, jobs.ReviewJobStepStarted); err != nil {
+			return err
+		}
+		if err := w.IndexRepository(ctx, job.HeadSHA); err != nil {
+			return err
+		}
+	}
+	return queue.Checkpoint(ctx, job, jobs.ReviewJobStepPosted)
+}
+
+// postgresCodeIndexStore implements codeindex.Store with CodeIndexChunk rows. Searches scan the
+// vectors of the repository in batches of codeIndexScanBatch, keeping the best k in process, and
+// only load the content of those chunks.
+type postgresCodeIndexStore struct {
+	db        *gorm.DB
+	companyId uint
+}
+
+func (s *postgresCodeIndexStore) ReplaceFile(ctx context.Context, repo, path string, chunks []codeindex.Chunk) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("company_id = ? AND repository = ? AND path = ?", s.companyId, repo, path).Delete(&CodeIndexChunk{}).Error; err != nil {
+			return fmt.Errorf("failed to delete chunks: %w", err)
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		rows := make([]CodeIndexChunk, len(chunks))
+		for i, chunk := range chunks {
+			rows[i] = CodeIndexChunk{
+				SingleCompanyModel: models.SingleCompanyModel{
+					CompanyId: s.companyId,
+				},
+				Repository: repo,
+				Path:       chunk.Path,
+				Symbol:     chunk.Symbol,
+				Kind:       chunk.Kind,
+				StartLine:  chunk.StartLine,
+				EndLine:    chunk.EndLine,
+				Content:    chunk.Content,
+				Embedder:   chunk.Embedder,
+				Vector:     encodeVector(chunk.Vector),
+			}
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to insert chunks: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *postgresCodeIndexStore) Search(ctx context.Context, repo, embedder string, vector []float32, k int, excludePaths map[string]bool) ([]codeindex.Result, error) {
+	query := s.db.WithContext(ctx).Select("id", "path", "start_line", "end_line", "vector").
+		Where("company_id = ? AND repository = ? AND embedder = ?", s.companyId, repo, embedder)
+	if len(excludePaths) > 0 {
+		paths := make([]string, 0, len(excludePaths))
+		for path := range excludePaths {
+			paths = append(paths, path)
+		}
+		query = query.Where("path NOT IN ?", paths)
+	}
+
+	var results []codeindex.Result
+	ids := map[string]uint{}
+	var batch []CodeIndexChunk
+	err := query.FindInBatches(&batch, codeIndexScanBatch, func(tx *gorm.DB, _ int) error {
+		for _, candidate := range batch {
+			result := codeindex.Result{Chunk: codeindex.Chunk{
+				Path:      candidate.Path,
+				StartLine: candidate.StartLine,
+				EndLine:   candidate.EndLine,
+				Vector:    decodeVector(candidate.Vector),
+			}}
+			ids[result.ID()] = candidate.ID
+			results = append(results, result)
+		}
+		codeindex.Score(results, vector)
+		results = codeindex.TopK(results, k)
+		// Keep the IDs and vectors of the best chunks only, so memory doesn't grow with the repository
+		best := make(map[string]uint, len(results))
+		for i := range results {
+			best[results[i].ID()] = ids[results[i].ID()]
+		}
+		ids = best
+		return nil
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vectors: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	bestIds := make([]uint, len(results))
+	for i, result := range results {
+		bestIds[i] = ids[result.ID()]
+	}
+	var rows []CodeIndexChunk
+	if err := s.db.WithContext(ctx).Where("id IN ?", bestIds).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load chunks: %w", err)
+	}
+	byId := make(map[uint]CodeIndexChunk, len(rows))
+	for _, row := range rows {
+		byId[row.ID] = row
+	}
+	for i := range results {
+		row := byId[bestIds[i]]
+		results[i].Chunk = codeindex.Chunk{
+			Path:      row.Path,
+			Symbol:    row.Symbol,
+			Kind:      row.Kind,
+			StartLine: row.StartLine,
+			EndLine:   row.EndLine,
+			Content:   row.Content,
+			Embedder:  row.Embedder,
+		}
+	}
+	return results, nil
+}
+
+func (s *postgresCodeIndexStore) Indexed(ctx context.Context, repo, embedder string) (bool, error) {
+	var chunks []CodeIndexChunk
+	err := s.db.WithContext(ctx).Select("id").
+		Where("company_id = ? AND repository = ? AND embedder = ?", s.companyId, repo, embedder).
+		Limit(1).Find(&chunks).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check chunks: %w", err)
+	}
+	return len(chunks) > 0, nil
+}
+
+func encodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(v))
+	}
+	return encoded
+}
+
+func decodeVector(encoded []byte) []float32 {
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"code-review-bot-test-repo/pkg/notify"
+	jobs "code-review-bot-test-repo/services"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Slash commands understood in PR comments.
+const (
+	CommandReview          = "review"
+	CommandExplain         = "explain"
+	CommandIgnore          = "ignore"
+	CommandApproveOverride = "approve-override"
+)
+
+// GitHub collaborator permission levels, from least to most privileged.
+var collaboratorPermissionLevels = []string{"none", "read", "triage", "write", "maintain", "admin"}
+
+// commandPermissions is the collaborator permission each command requires.
+var commandPermissions = map[string]string{
+	CommandReview:          "write",
+	CommandExplain:         "read",
+	CommandIgnore:          "write",
+	CommandApproveOverride: "maintain",
+}
+
+// ReviewCommand is a slash command parsed from a PR comment.
+type ReviewCommand struct {
+	Name      string
+	Args      []string
+	Line      string
+	Author    string
+	CommentID int64
+	PRNumber  int
+}
+
+// ParseReviewCommand returns the first known slash command in the comment body. Commands must
+// start a line, text quoted from an earlier comment is ignored.
+func ParseReviewCommand(body string) (*ReviewCommand, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "/") {
+			continue
+		}
+		fields := strings.Fields(line)
+		name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+		if _, known := commandPermissions[name]; !known {
+			continue
+		}
+		return &ReviewCommand{
+			Name: name,
+			Args: fields[1:],
+			Line: line,
+		}, true
+	}
+	return nil, false
+}
+
+// hasPermission reports whether the granted collaborator permission satisfies the required one.
+func hasPermission(granted, required string) bool {
+	rank := func(permission string) int {
+		for i, level := range collaboratorPermissionLevels {
+			if level == permission {
+				return i
+			}
+		}
+		return 0
+	}
+	return rank(strings.ToLower(granted)) >= rank(required)
+}
+
+// ReviewIgnoreRule is a rule a maintainer asked the reviewer to stop flagging in a repository.
+type ReviewIgnoreRule struct {
+	models.SingleCompanyModel
+	Owner     string `gorm:"index:idx_review_ignore_rule" json:"owner"`
+	Repo      string `gorm:"index:idx_review_ignore_rule" json:"repo"`
+	Rule      string `json:"rule"`
+	CreatedBy string `json:"created_by"`
+	PRNumber  int    `json:"pr_number"`
+}
+
+// loadIgnoreRules returns the rules the repository's maintainers asked the reviewer to skip.
+func (w *CodeReviewWorkflow) loadIgnoreRules() []string {
+	var rules []ReviewIgnoreRule
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo).
+		Order("id").
+		Find(&rules).Error
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load ignore rules", zap.Error(err))
+		return nil
+	}
+	ignored := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		ignored = append(ignored, rule.Rule)
+	}
+	return ignored
+}
+
+// filterFilesByPath keeps the files matching one of the paths. A path matches a file exactly, as
+// a directory prefix, or as a glob pattern.
+func filterFilesByPath(files []clients.PullRequestFile, paths []string) []clients.PullRequestFile {
+	var filtered []clients.PullRequestFile
+	for _, file := range files {
+		for _, p := range paths {
+			p = strings.TrimPrefix(p, "./")
+			matched, _ := path.Match(p, file.Filename)
+			if matched || file.Filename == p || strings.HasPrefix(file.Filename, strings.TrimSuffix(p, "/")+"/") {
+				filtered = append(filtered, file)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// CommandHandler runs the slash commands left in PR comments using the review workflow.
+type CommandHandler struct {
+	workflow       *CodeReviewWorkflow
+	contextBuilder *ContextBuilder
+}
+
+// NewCommandHandler creates a handler that runs commands with the given workflow.
+func NewCommandHandler(workflow *CodeReviewWorkflow, contextBuilder *ContextBuilder) *CommandHandler {
+	return &CommandHandler{
+		workflow:       workflow,
+		contextBuilder: contextBuilder,
+	}
+}
+
+// HandleJob runs the command in a comment job. It can be registered as the handler for
+// jobs.ReviewJobKindComment jobs.
+func (h *CommandHandler) HandleJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+	command, ok := ParseReviewCommand(job.CommentBody)
+	if !ok {
+		return nil
+	}
+	command.Author = job.Sender
+	command.CommentID = job.CommentID
+	command.PRNumber = job.PRNumber
+
+	if job.Step == jobs.ReviewJobStepPosted {
+		return nil
+	}
+	if err := h.Handle(ctx, command); err != nil {
+		return err
+	}
+	return queue.Checkpoint(ctx, job, jobs.ReviewJobStepPosted)
+}
+
+// Handle checks the author's permission, runs the command and replies with the result. The GitHub
+// and model requests are aborted when ctx is done.
+func (h *CommandHandler) Handle(ctx context.Context, command *ReviewCommand) error {
+	w := h.workflow
+	defer w.bindGitHubClient(ctx)()
+	logger := logging.GetGlobalLogger()
+
+	permission, err := w.githubConfig.Client.GetCollaboratorPermission(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, command.Author)
+	if err != nil {
+		return fmt.Errorf("failed to get permission of %s: %w", command.Author, err)
+	}
+	required := commandPermissions[command.Name]
+	if !hasPermission(permission, required) {
+		logger.Info("Rejected command from user without permission",
+			zap.String("command", command.Name),
+			zap.String("author", command.Author),
+			zap.String("permission", permission),
+			zap.Int("pr_number", command.PRNumber))
+		return h.reply(command, fmt.Sprintf("Sorry, `/%s` requires %s access to this repository.", command.Name, required))
+	}
+
+	var reply string
+	switch command.Name {
+	case CommandReview:
+		reply, err = h.review(ctx, command)
+	case CommandExplain:
+		reply, err = h.explain(ctx, command)
+	case CommandIgnore:
+		reply, err = h.ignore(command)
+	case CommandApproveOverride:
+		reply, err = h.approveOverride(command)
+	}
+	if err != nil {
+		logger.Error("Failed to run command",
+			zap.Error(err),
+			zap.String("command", command.Name),
+			zap.Int("pr_number", command.PRNumber),
+			zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+		reply = fmt.Sprintf("Sorry, `/%s` failed: %v", command.Name, err)
+	}
+
+	w.AddExecutionLog(fmt.Sprintf("Ran /%s for %s", command.Name, command.Author))
+	return h.reply(command, reply)
+}
+
+// reply posts the result as a reply quoting the command.
+func (h *CommandHandler) reply(command *ReviewCommand, body string) error {
+	w := h.workflow
+	message := fmt.Sprintf("> %s\n\n@%s %s", command.Line, command.Author, body)
+	if _, err := w.githubConfig.Client.PostIssueComment(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, command.PRNumber, message); err != nil {
+		return fmt.Errorf("failed to reply to /%s: %w", command.Name, err)
+	}
+	return nil
+}
+
+// review reviews the whole pull request again, or only the given paths.
+func (h *CommandHandler) review(ctx context.Context, command *ReviewCommand) (string, error) {
+	w := h.workflow
+	w.pathFilter = command.Args
+	defer func() { w.pathFilter = nil }()
+
+	comments, files, reviewErr := w.ReviewPullRequestContext(ctx, command.PRNumber, h.contextBuilder, false, true)
+	if reviewErr != nil && (!w.reviewScope.ModelsFailed || len(comments) == 0) {
+		return "", reviewErr
+	}
+	if files == nil {
+		return "There is nothing new to review.", nil
+	}
+	posted, _, err := w.PostReviewCommentsContext(ctx, command.PRNumber, w.repoWorkflowSetting.CompanyId, comments, files)
+	if err != nil {
+		return "", err
+	}
+
+	scope := "the pull request"
+	if len(command.Args) > 0 {
+		scope = "`" + strings.Join(command.Args, "`, `") + "`"
+	}
+	if reviewErr != nil {
+		// Only the deterministic findings were posted
+		return fmt.Sprintf("The review of %s failed, but the deterministic checks left %d comment(s). Try `/review` again later.", scope, len(posted)), nil
+	}
+	return fmt.Sprintf("Reviewed %s and left %d comment(s).", scope, len(posted)), nil
+}
+
+// explain asks the model to expand on one of the workflow's review comments.
+func (h *CommandHandler) explain(ctx context.Context, command *ReviewCommand) (string, error) {
+	w := h.workflow
+	if len(command.Args) == 0 {
+		return "Usage: `/explain <comment-id>`", nil
+	}
+	commentID, err := strconv.ParseInt(strings.TrimPrefix(command.Args[0], "#"), 10, 64)
+	if err != nil {
+		return fmt.Sprintf("`%s` is not a comment id.", command.Args[0]), nil
+	}
+
+	var prComment models.PRComment
+	err = w.db.Where("owner = ? AND repo = ? AND pr_number = ? AND comment_id = ? AND is_by_workflow = ?",
+		w.githubConfig.Owner, w.githubConfig.Repo, command.PRNumber, commentID, true).
+		First(&prComment).Error
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Sprintf("I couldn't find a review comment of mine with id %d on this pull request.", commentID), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load comment %d: %w", commentID, err)
+	}
+
+	// Include the diff of the commented file so the explanation can refer to the code
+	patch := ""
+	reviewComments, err := w.githubConfig.Client.GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, command.PRNumber)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch review comments: %w", err)
+	}
+	for _, reviewComment := range reviewComments {
+		if reviewComment.ID != commentID {
+			continue
+		}
+		files, err := w.githubConfig.Client.GetPullRequestFiles(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, command.PRNumber)
+		if err != nil {
+			return "", fmt.Errorf("failed to get PR files: %w", err)
+		}
+		for _, file := range files {
+			if file.Filename == reviewComment.Path {
+				patch = fmt.Sprintf("File: %s (comment on line %d)\n%s", file.Filename, reviewComment.Line, file.Patch)
+			}
+		}
+	}
+
+	providers := w.resolveReviewProviders()
+	if len(providers) == 0 {
+		return "", fmt.Errorf("no AI providers enabled")
+	}
+	provider := providers[0]
+
+	systemMessage := "You are the code reviewer who wrote the review comment below. A developer asked you to explain it. " +
+		"Explain what the problem is, why it matters and how to fix it, with a short code example if it helps. " +
+		"Be concise and respond in Markdown."
+	userMessage := fmt.Sprintf("Review comment:\n%s\n\n%s", prComment.Body, patch)
+
+	explainFn := w.meteredModelCall(command.PRNumber, provider.Name(), provider.Model(), "explain_comment", providerModelCall(ctx, provider))
+	explanation, err := explainFn(systemMessage, userMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to explain comment %d: %w", commentID, err)
+	}
+	return strings.TrimSpace(explanation), nil
+}
+
+// ignore stores a rule the reviewer should stop flagging in this repository.
+func (h *CommandHandler) ignore(command *ReviewCommand) (string, error) {
+	w := h.workflow
+	rule := strings.TrimSpace(strings.Join(command.Args, " "))
+	if rule == "" {
+		return "Usage: `/ignore <rule>`", nil
+	}
+
+	ignoreRule := ReviewIgnoreRule{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.repoWorkflowSetting.CompanyId,
+		},
+		Owner:     w.githubConfig.Owner,
+		Repo:      w.githubConfig.Repo,
+		Rule:      rule,
+		CreatedBy: command.Author,
+		PRNumber:  command.PRNumber,
+	}
+	if err := w.db.Create(&ignoreRule).Error; err != nil {
+		return "", fmt.Errorf("failed to save ignore rule: %w", err)
+	}
+	return fmt.Sprintf("Got it, I'll stop flagging \"%s\" in this repository.", rule), nil
+}
+
+// approveOverride approves the pull request regardless of the approval policy.
+func (h *CommandHandler) approveOverride(command *ReviewCommand) (string, error) {
+	w := h.workflow
+	prDetails, err := w.GetPullRequestDetails(command.PRNumber)
+	if err != nil {
+		return "", err
+	}
+	w.githubConfig.CommitSHA = prDetails.Head.SHA
+
+	reason := fmt.Sprintf("Approval policy overridden by @%s.", command.Author)
+	if len(command.Args) > 0 {
+		reason += " Reason: " + strings.Join(command.Args, " ")
+	}
+	body := buildReviewSummary(ReviewEventApprove, nil, prDetails.Head.SHA, reason)
+	if _, _, err := w.submitPullRequestReview(command.PRNumber, w.repoWorkflowSetting.CompanyId, ReviewEventApprove, body, nil); err != nil {
+		return "", err
+	}
+
+	w.notify(command.PRNumber, notify.Event{
+		Type:    NotificationApproval,
+		Title:   fmt.Sprintf("✅ PR %d approved by override from %s", command.PRNumber, command.Author),
+		Message: reason,
+	})
+	return "Approved the pull request.", nil
+}
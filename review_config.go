@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"code-review-bot-test-repo/pkg/reviewconfig"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// repoConfigMarker identifies the PR comment reporting problems with the repository's review config.
+const repoConfigMarker = "<!-- code-review-bot:repo-config -->"
+
+// loadRepoConfig reads the review config from the base branch, so a pull request can't loosen the
+// review of its own changes. Invalid configs are reported on the pull request and ignored, which
+// leaves the company's settings in effect.
+func (w *CodeReviewWorkflow) loadRepoConfig(prNumber int, baseSHA string) *reviewconfig.Config {
+	content, err := w.githubConfig.Client.GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, reviewconfig.FileName, baseSHA)
+	if err != nil {
+		// Most repositories don't have a config
+		return nil
+	}
+
+	var providerNames []string
+	for _, registration := range DefaultProviderRegistry.Registrations() {
+		providerNames = append(providerNames, registration.Name)
+	}
+
+	config, err := reviewconfig.Parse([]byte(content), providerNames)
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Ignoring invalid review config",
+			zap.Error(err),
+			zap.Int("pr_number", prNumber),
+			zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+
+		var validationErr *reviewconfig.ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil
+		}
+		if err := w.upsertStickyIssueComment(prNumber, repoConfigMarker, renderRepoConfigProblems(validationErr.Problems, baseSHA)); err != nil {
+			logging.GetGlobalLogger().Warn("Failed to report invalid review config", zap.Error(err), zap.Int("pr_number", prNumber))
+		}
+		return nil
+	}
+
+	// Clear an earlier report once the config on the base branch is fixed
+	body := fmt.Sprintf("`%s` is valid as of %s.", reviewconfig.FileName, baseSHA)
+	if _, err := w.updateStickyIssueComment(prNumber, repoConfigMarker, body); err != nil {
+		logging.GetGlobalLogger().Warn("Failed to update review config report", zap.Error(err), zap.Int("pr_number", prNumber))
+	}
+	return config
+}
+
+// renderRepoConfigProblems builds the PR comment listing what is wrong with the config.
+func renderRepoConfigProblems(problems []reviewconfig.Problem, baseSHA string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("### :warning: Invalid `%s`\n\n", reviewconfig.FileName))
+	sb.WriteString(fmt.Sprintf("The review config on the base branch (%s) was ignored and the default review settings were used:\n\n", baseSHA))
+	for _, problem := range problems {
+		if problem.Field != "" {
+			sb.WriteString(fmt.Sprintf("- `%s`: %s\n", problem.Field, problem.Message))
+		} else {
+			sb.WriteString(fmt.Sprintf("- %s\n", problem.Message))
+		}
+	}
+	return sb.String()
+}
+
+// filterFilesByRepoConfig keeps the files the config includes.
+func filterFilesByRepoConfig(files []clients.PullRequestFile, config *reviewconfig.Config) []clients.PullRequestFile {
+	var filtered []clients.PullRequestFile
+	for _, file := range files {
+		if config.Includes(file.Filename) {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
+
+// filterCommentsBySeverity rejects the comments below the minimum severity the config sets for
+// their file.
+func filterCommentsBySeverity(comments []*InternalReviewComment, config *reviewconfig.Config) (kept []*InternalReviewComment, rejected []*InternalReviewComment) {
+	for _, comment := range comments {
+		normalizeCommentSeverity(comment)
+		minimum := CommentSeverity(config.MinimumSeverityFor(comment.Path))
+		if minimum != "" && comment.Severity.Rank() < minimum.Rank() {
+			comment.RejectionReason = fmt.Sprintf("Severity %s is below the minimum of %s set in %s", comment.Severity, minimum, reviewconfig.FileName)
+			rejected = append(rejected, comment)
+			continue
+		}
+		kept = append(kept, comment)
+	}
+	return kept, rejected
+}
+
+// withRepoConfig overrides the limits set in the repository's config.
+func (p ApprovalPolicy) withRepoConfig(approval *reviewconfig.ApprovalConfig) ApprovalPolicy {
+	if approval == nil {
+		return p
+	}
+	overrides := []struct {
+		value *int
+		limit *int
+	}{
+		{approval.MaxBlockers, &p.MaxBlockers},
+		{approval.MaxMajors, &p.MaxMajors},
+		{approval.MaxMinors, &p.MaxMinors},
+		{approval.MaxNits, &p.MaxNits},
+		{approval.TieBreakAboveMajors, &p.TieBreakAboveMajors},
+	}
+	for _, override := range overrides {
+		if override.value != nil {
+			*override.limit = *override.value
+		}
+	}
+	return p
+}
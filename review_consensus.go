@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Merge modes for combining comments from several review providers.
+const (
+	ReviewMergeModePriority  = "priority"
+	ReviewMergeModeConsensus = "consensus"
+)
+
+const (
+	defaultConsensusQuorum              = 2
+	defaultConsensusSimilarityThreshold = 0.35
+	defaultConsensusMinConfidence       = 0.6
+	// consensusSemanticThreshold is the minimum cosine similarity of two comment embeddings for the
+	// comments to be clustered.
+	consensusSemanticThreshold = 0.8
+	// consensusLineDistance is how far apart two comments on the same file may be and still be clustered.
+	consensusLineDistance = 3
+)
+
+// ReviewMergeSetting controls how comments from several providers are merged for a single company.
+type ReviewMergeSetting struct {
+	models.SingleCompanyModel
+	Mode string `json:"mode"`
+	// Quorum is the number of distinct providers that must agree before a comment is posted.
+	Quorum int `json:"quorum"`
+	// SimilarityThreshold is the minimum word overlap (0-1) for two comments to be clustered when
+	// they are compared without embeddings.
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	// MinConfidence is the share of the panel's weight (0-1) that must agree with a comment when
+	// too few providers responded to reach the quorum.
+	MinConfidence float64 `json:"min_confidence"`
+}
+
+// loadReviewMergeSetting returns the company's merge setting, defaulting to priority merging.
+func (w *CodeReviewWorkflow) loadReviewMergeSetting() ReviewMergeSetting {
+	setting := ReviewMergeSetting{Mode: ReviewMergeModePriority}
+	err := w.db.Where("company_id = ?", w.repoWorkflowSetting.CompanyId).First(&setting).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logging.GetGlobalLogger().Warn("Failed to load review merge setting, using priority merge",
+			zap.Error(err),
+			zap.Uint("company_id", w.repoWorkflowSetting.CompanyId))
+	}
+
+	if setting.Mode == "" {
+		setting.Mode = ReviewMergeModePriority
+	}
+	if setting.Quorum <= 0 {
+		setting.Quorum = defaultConsensusQuorum
+	}
+	if setting.SimilarityThreshold <= 0 {
+		setting.SimilarityThreshold = defaultConsensusSimilarityThreshold
+	}
+	if setting.MinConfidence <= 0 {
+		setting.MinConfidence = defaultConsensusMinConfidence
+	}
+	return setting
+}
+
+// commentCluster groups comments from different providers that describe the same issue.
+type commentCluster struct {
+	comments  []*InternalReviewComment
+	features  []commentFeatures
+	providers map[string]float64
+}
+
+// commentEmbedder turns comment bodies into vectors whose cosine similarity reflects how close
+// their meaning is, one vector per body. The embedders of the code index implement it.
+type commentEmbedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// commentFeatures are what two comments are compared on: their stemmed words, and their embedding
+// when an embedder is available.
+type commentFeatures struct {
+	tokens map[string]struct{}
+	vector []float32
+}
+
+// mergeCommentsByConsensus clusters similar comments across providers and keeps one comment per
+// cluster. Each kept comment gets a confidence equal to the share of the panel's weight that agreed
+// with it, where the panel is every configured provider, padded to the quorum when fewer are
+// configured. Clusters backed by fewer providers than the quorum are rejected. When fewer providers
+// responded than the quorum, agreement can't reach it, and clusters below the minimum confidence are
+// rejected instead. A nil embedder clusters by word overlap only.
+func mergeCommentsByConsensus(ctx context.Context, providers []activeProvider, providerComments [][]*InternalReviewComment, providerErrors []error, setting ReviewMergeSetting, embedder commentEmbedder) []*InternalReviewComment {
+	features := consensusFeatures(ctx, providerComments, providerErrors, embedder)
+
+	var clusters []*commentCluster
+	respondedProviders := 0
+	panelWeight := 0.0
+
+	// Providers are ordered by priority, so the first comment in each cluster comes from the
+	// highest priority provider and is the one we keep
+	for i, provider := range providers {
+		panelWeight += provider.weight
+		if providerErrors[i] != nil {
+			continue
+		}
+		respondedProviders++
+
+		for j, comment := range providerComments[i] {
+			if comment.Body == "" {
+				continue
+			}
+			if cluster := findMatchingCluster(clusters, comment, features[i][j], provider.Name(), setting.SimilarityThreshold); cluster != nil {
+				cluster.comments = append(cluster.comments, comment)
+				cluster.features = append(cluster.features, features[i][j])
+				cluster.providers[provider.Name()] = provider.weight
+				continue
+			}
+			clusters = append(clusters, &commentCluster{
+				comments:  []*InternalReviewComment{comment},
+				features:  []commentFeatures{features[i][j]},
+				providers: map[string]float64{provider.Name(): provider.weight},
+			})
+		}
+	}
+
+	// A panel smaller than the quorum counts the missing providers at the default weight, so a
+	// single provider can't confirm its own comments
+	quorum := setting.Quorum
+	if missing := quorum - len(providers); missing > 0 {
+		panelWeight += float64(missing)
+	}
+	quorumReachable := respondedProviders >= quorum
+	if !quorumReachable {
+		logging.GetGlobalLogger().Warn("Too few providers responded to reach the consensus quorum, keeping only confident comments",
+			zap.Int("configured_providers", len(providers)),
+			zap.Int("responded_providers", respondedProviders),
+			zap.Int("quorum", quorum),
+			zap.Float64("min_confidence", setting.MinConfidence))
+	}
+
+	merged := make([]*InternalReviewComment, 0, len(clusters))
+	for _, cluster := range clusters {
+		representative := cluster.comments[0]
+
+		agreedWeight := 0.0
+		for _, weight := range cluster.providers {
+			agreedWeight += weight
+		}
+		if panelWeight > 0 {
+			representative.Confidence = agreedWeight / panelWeight
+		}
+
+		rejectionReason := ""
+		if quorumReachable && len(cluster.providers) < quorum {
+			rejectionReason = fmt.Sprintf("Below consensus quorum: %d of %d required models agreed", len(cluster.providers), quorum)
+		} else if !quorumReachable && representative.Confidence < setting.MinConfidence {
+			rejectionReason = fmt.Sprintf("Unconfirmed: %d of %d required models responded and %.0f%% of the panel agreed, below the %.0f%% minimum",
+				respondedProviders, quorum, representative.Confidence*100, setting.MinConfidence*100)
+		}
+		if rejectionReason != "" {
+			representative.RejectionReason = rejectionReason
+			rejectionModel := "consensus"
+			representative.RejectionModel = &rejectionModel
+		}
+		merged = append(merged, representative)
+	}
+
+	logging.GetGlobalLogger().Info("Merged comments by consensus",
+		zap.Int("clusters", len(clusters)),
+		zap.Int("responded_providers", respondedProviders),
+		zap.Int("quorum", quorum),
+		zap.Bool("quorum_reachable", quorumReachable),
+		zap.Bool("semantic", embedder != nil))
+
+	return merged
+}
+
+// consensusFeatures computes the features of every comment, indexed like providerComments. The
+// bodies are embedded in a single request; if that fails the comments are compared by words only.
+func consensusFeatures(ctx context.Context, providerComments [][]*InternalReviewComment, providerErrors []error, embedder commentEmbedder) [][]commentFeatures {
+	features := make([][]commentFeatures, len(providerComments))
+	var bodies []string
+	for i, comments := range providerComments {
+		features[i] = make([]commentFeatures, len(comments))
+		for j, comment := range comments {
+			features[i][j].tokens = commentTokens(comment.Body)
+			if providerErrors[i] == nil && comment.Body != "" {
+				bodies = append(bodies, comment.Body)
+			}
+		}
+	}
+	if embedder == nil || len(bodies) == 0 {
+		return features
+	}
+
+	vectors, err := embedder.Embed(ctx, bodies)
+	if err != nil || len(vectors) != len(bodies) {
+		logging.GetGlobalLogger().Warn("Failed to embed comments for consensus, clustering by word overlap", zap.Error(err))
+		return features
+	}
+	next := 0
+	for i, comments := range providerComments {
+		for j, comment := range comments {
+			if providerErrors[i] == nil && comment.Body != "" {
+				features[i][j].vector = vectors[next]
+				next++
+			}
+		}
+	}
+	return features
+}
+
+// findMatchingCluster returns the cluster a comment belongs to, or nil if it starts a new one. A
+// cluster that already has a comment from the provider is skipped, so distinct comments of one
+// model are never merged into each other.
+func findMatchingCluster(clusters []*commentCluster, comment *InternalReviewComment, features commentFeatures, provider string, threshold float64) *commentCluster {
+	var best *commentCluster
+	bestScore := 0.0
+	for _, cluster := range clusters {
+		if _, ok := cluster.providers[provider]; ok {
+			continue
+		}
+		representative := cluster.comments[0]
+		if representative.Path != comment.Path {
+			continue
+		}
+		if !commentsOverlap(comment.PullRequestComment, representative.PullRequestComment) &&
+			absInt(representative.Line-comment.Line) > consensusLineDistance {
+			continue
+		}
+		for _, member := range cluster.features {
+			score, memberThreshold := commentSimilarity(member, features, threshold)
+			if score >= memberThreshold && score > bestScore {
+				best = cluster
+				bestScore = score
+			}
+		}
+	}
+	return best
+}
+
+// commentSimilarity returns how alike two comments are and the threshold that score must reach.
+// Embeddings are compared by cosine similarity, otherwise by the word overlap threshold.
+func commentSimilarity(a, b commentFeatures, wordThreshold float64) (float64, float64) {
+	if a.vector != nil && b.vector != nil {
+		return cosineSimilarity(a.vector, b.vector), consensusSemanticThreshold
+	}
+	return jaccardSimilarity(a.tokens, b.tokens), wordThreshold
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// consensusStopWords are common words that carry no signal when comparing review comments.
+var consensusStopWords = map[string]struct{}{
+	"the": {}, "and": {}, "this": {}, "that": {}, "with": {}, "for": {}, "are": {}, "not": {},
+	"you": {}, "should": {}, "could": {}, "would": {}, "consider": {}, "here": {}, "from": {},
+	"can": {}, "will": {}, "use": {}, "instead": {}, "suggestion": {}, "when": {}, "which": {},
+}
+
+// commentTokens normalizes a comment body into a set of meaningful lowercase word stems.
+func commentTokens(body string) map[string]struct{} {
+	tokens := map[string]struct{}{}
+	words := strings.FieldsFunc(strings.ToLower(body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range words {
+		if len(word) < 3 {
+			continue
+		}
+		if _, stop := consensusStopWords[word]; stop {
+			continue
+		}
+		tokens[stemWord(word)] = struct{}{}
+	}
+	return tokens
+}
+
+// stemWord strips common English suffixes so that e.g. "leaks", "leaked" and "leaking" match.
+func stemWord(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word)-len(suffix) >= 4 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// jaccardSimilarity returns the size of the intersection over the size of the union of two sets.
+func jaccardSimilarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	intersection := 0
+	for token := range a {
+		if _, ok := b[token]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
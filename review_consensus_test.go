@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+func consensusComment(path string, line int, body string) *InternalReviewComment {
+	return &InternalReviewComment{PullRequestComment: clients.PullRequestComment{Path: path, Line: line, Body: body}}
+}
+
+func consensusPanel(names ...string) []activeProvider {
+	providers := make([]activeProvider, len(names))
+	for i, name := range names {
+		providers[i] = activeProvider{ReviewProvider: &stubProvider{name: name}, priority: i, weight: 1}
+	}
+	return providers
+}
+
+func consensusSetting() ReviewMergeSetting {
+	return ReviewMergeSetting{
+		Mode:                ReviewMergeModeConsensus,
+		Quorum:              2,
+		SimilarityThreshold: defaultConsensusSimilarityThreshold,
+		MinConfidence:       defaultConsensusMinConfidence,
+	}
+}
+
+// fixedEmbedder embeds each text as the vector it is mapped to.
+type fixedEmbedder map[string][]float32
+
+func (e fixedEmbedder) Name() string { return "fixed" }
+
+func (e fixedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e[text]
+	}
+	return vectors, nil
+}
+
+func TestMergeCommentsByConsensusClustersAcrossProviders(t *testing.T) {
+	providers := consensusPanel("anthropic", "openai")
+	comments := [][]*InternalReviewComment{
+		{
+			consensusComment("main.go", 10, "The file handle leaks when the read fails"),
+			consensusComment("main.go", 40, "This loop never terminates"),
+		},
+		{
+			consensusComment("main.go", 11, "File handle is leaked if reading fails"),
+		},
+	}
+
+	merged := mergeCommentsByConsensus(context.Background(), providers, comments, []error{nil, nil}, consensusSetting(), nil)
+
+	if len(merged) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(merged))
+	}
+	if merged[0] != comments[0][0] || merged[0].RejectionReason != "" || merged[0].Confidence != 1 {
+		t.Errorf("agreed comment = %+v, want the anthropic comment kept with confidence 1", merged[0])
+	}
+	if merged[1].RejectionReason == "" || merged[1].Confidence != 0.5 {
+		t.Errorf("unconfirmed comment = %+v, want it rejected with confidence 0.5", merged[1])
+	}
+}
+
+func TestMergeCommentsByConsensusDoesNotMergeSameProvider(t *testing.T) {
+	providers := consensusPanel("anthropic", "openai")
+	comments := [][]*InternalReviewComment{
+		{
+			consensusComment("main.go", 10, "The error returned by Close is ignored"),
+			consensusComment("main.go", 11, "The error returned by Flush is ignored"),
+		},
+		{
+			consensusComment("main.go", 10, "The error returned by Close is ignored here"),
+		},
+	}
+
+	merged := mergeCommentsByConsensus(context.Background(), providers, comments, []error{nil, nil}, consensusSetting(), nil)
+
+	if len(merged) != 2 {
+		t.Fatalf("got %d clusters, want the two anthropic comments kept apart", len(merged))
+	}
+	if merged[0].RejectionReason != "" {
+		t.Errorf("Close comment rejected: %s", merged[0].RejectionReason)
+	}
+	if merged[1] != comments[0][1] || merged[1].RejectionReason == "" {
+		t.Errorf("Flush comment = %+v, want it kept separate and rejected", merged[1])
+	}
+}
+
+func TestMergeCommentsByConsensusUsesEmbeddings(t *testing.T) {
+	providers := consensusPanel("anthropic", "openai")
+	first := "Possible SQL injection through the name parameter"
+	second := "User input is concatenated into the query string"
+	comments := [][]*InternalReviewComment{
+		{consensusComment("db.go", 5, first)},
+		{consensusComment("db.go", 6, second)},
+	}
+	embedder := fixedEmbedder{first: {1, 0.1}, second: {0.95, 0.15}}
+
+	merged := mergeCommentsByConsensus(context.Background(), providers, comments, []error{nil, nil}, consensusSetting(), embedder)
+
+	if len(merged) != 1 || merged[0].RejectionReason != "" {
+		t.Fatalf("got %+v, want the two comments merged by meaning", merged)
+	}
+}
+
+func TestMergeCommentsByConsensusQuorum(t *testing.T) {
+	agreed := func() [][]*InternalReviewComment {
+		return [][]*InternalReviewComment{
+			{consensusComment("main.go", 10, "The mutex is never unlocked on the error path")},
+			{consensusComment("main.go", 10, "Mutex is not unlocked on the error path")},
+			{},
+		}
+	}
+	unconfirmed := func() [][]*InternalReviewComment {
+		return [][]*InternalReviewComment{
+			{consensusComment("main.go", 10, "The mutex is never unlocked on the error path")},
+			{},
+			{},
+		}
+	}
+	failed := errors.New("timeout")
+
+	tests := []struct {
+		name         string
+		providers    []string
+		comments     [][]*InternalReviewComment
+		errs         []error
+		quorum       int
+		wantRejected bool
+	}{
+		{"quorum met", []string{"a", "b", "c"}, agreed(), []error{nil, nil, nil}, 2, false},
+		{"below quorum", []string{"a", "b", "c"}, unconfirmed(), []error{nil, nil, nil}, 2, true},
+		{"single provider cannot confirm itself", []string{"a"}, unconfirmed()[:1], []error{nil}, 2, true},
+		{"too few responders and unconfirmed", []string{"a", "b", "c"}, unconfirmed(), []error{nil, failed, failed}, 2, true},
+		{"too few responders but confident", []string{"a", "b"}, agreed()[:2], []error{nil, nil}, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setting := consensusSetting()
+			setting.Quorum = tt.quorum
+
+			merged := mergeCommentsByConsensus(context.Background(), consensusPanel(tt.providers...), tt.comments, tt.errs, setting, nil)
+
+			if len(merged) != 1 {
+				t.Fatalf("got %d clusters, want 1", len(merged))
+			}
+			if rejected := merged[0].RejectionReason != ""; rejected != tt.wantRejected {
+				t.Errorf("rejected = %v (%q), want %v", rejected, merged[0].RejectionReason, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestConsensusEmbedderNeedsEmbeddingsAPI(t *testing.T) {
+	t.Setenv("EMBEDDINGS_BASE_URL", "")
+	if embedder := consensusEmbedder(); embedder != nil {
+		t.Errorf("consensusEmbedder() = %T without an embeddings API, want nil", embedder)
+	}
+
+	t.Setenv("EMBEDDINGS_BASE_URL", "https://api.example.com/v1")
+	var embedder commentEmbedder = consensusEmbedder()
+	if embedder == nil {
+		t.Error("consensusEmbedder() = nil with an embeddings API")
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// Default deadlines of a review run. REVIEW_TIMEOUT and REVIEW_PROVIDER_TIMEOUT override them with
+// a Go duration, e.g. "15m".
+const (
+	defaultReviewTimeout   = 15 * time.Minute
+	defaultProviderTimeout = 5 * time.Minute
+)
+
+// reviewDeadlineRejection is the rejection reason of comments the review ran out of time to validate.
+const reviewDeadlineRejection = "Review deadline exceeded before the comment was validated"
+
+// envDuration reads a duration from the environment, falling back to the default when it's unset or invalid.
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		logging.GetGlobalLogger().Warn("Ignoring invalid duration", zap.String("key", key), zap.String("value", value))
+		return defaultValue
+	}
+	return duration
+}
+
+// reviewTimeout is the deadline of a whole review run.
+func reviewTimeout() time.Duration {
+	return envDuration("REVIEW_TIMEOUT", defaultReviewTimeout)
+}
+
+// providerTimeout is the deadline of a single provider's review, including its retry.
+func providerTimeout() time.Duration {
+	return envDuration("REVIEW_PROVIDER_TIMEOUT", defaultProviderTimeout)
+}
+
+// ContextReviewProvider is implemented by providers that abort their request when the context is
+// cancelled.
+type ContextReviewProvider interface {
+	CallContext(ctx context.Context, contextMessage, userMessage string) (string, error)
+}
+
+// ContextStreamingReviewProvider is implemented by streaming providers that abort their request
+// when the context is cancelled.
+type ContextStreamingReviewProvider interface {
+	StreamContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error)
+}
+
+// callProviderContext calls the provider in a span of the review's trace, aborting the request
+// when ctx is done. A provider that doesn't take a context can't be interrupted, it is only called
+// if ctx isn't done yet.
+func callProviderContext(ctx context.Context, provider ReviewProvider, contextMessage, userMessage string) (string, error) {
+	ctx, span := startModelCallSpan(ctx, provider)
+	defer span.End()
+
+	var message string
+	err := ctx.Err()
+	if err == nil {
+		if contextProvider, ok := provider.(ContextReviewProvider); ok {
+			message, err = contextProvider.CallContext(ctx, contextMessage, userMessage)
+		} else {
+			message, err = provider.Call(contextMessage, userMessage)
+		}
+	}
+	span.SetError(err)
+	return message, err
+}
+
+// contextModelCall binds a context-aware model call to ctx, for the validators and other code
+// taking a raw model call function.
+func contextModelCall(ctx context.Context, callFn func(context.Context, string, string) (string, error)) func(string, string) (string, error) {
+	return func(contextMessage, userMessage string) (string, error) {
+		return callFn(ctx, contextMessage, userMessage)
+	}
+}
+
+// providerModelCall binds a call to the provider to ctx, see callProviderContext.
+func providerModelCall(ctx context.Context, provider ReviewProvider) func(string, string) (string, error) {
+	return func(contextMessage, userMessage string) (string, error) {
+		return callProviderContext(ctx, provider, contextMessage, userMessage)
+	}
+}
+
+// bindGitHubClient binds the workflow's GitHub client to ctx until the returned function is called.
+// Meanwhile every GitHub request of the workflow is aborted when ctx is done, including those made
+// by the context builders and other code sharing its GitHub configuration.
+func (w *CodeReviewWorkflow) bindGitHubClient(ctx context.Context) (restore func()) {
+	client := w.githubConfig.Client
+	w.githubConfig.Client = client.WithContext(ctx)
+	return func() { w.githubConfig.Client = client }
+}
+
+// github returns the workflow's GitHub client with its requests bound to ctx, for calls made in a
+// span so that the span's context reaches the client.
+func (w *CodeReviewWorkflow) github(ctx context.Context) clients.GitHubClient {
+	return w.githubConfig.Client.WithContext(ctx)
+}
+
+// rejectUnvalidatedComments rejects the comments that haven't been validated yet when the review
+// runs out of time, so they are reported as filtered instead of being posted unchecked.
+func rejectUnvalidatedComments(comments []*InternalReviewComment) int {
+	rejected := 0
+	for _, comment := range comments {
+		if len(comment.RejectionReason) != 0 || comment.Provider == staticProviderName || comment.Provider == secretScannerProviderName {
+			continue
+		}
+		comment.RejectionReason = reviewDeadlineRejection
+		rejectionModel := "review_deadline"
+		comment.RejectionModel = &rejectionModel
+		rejected++
+	}
+	return rejected
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+// The review features extend types that are defined outside this tree. The declarations below
+// list those extensions in one place, so that building against a version of the API without them
+// fails here instead of at scattered call sites.
+
+// reviewGitHubClient is the part of clients.GitHubClient added for the review features.
+type reviewGitHubClient interface {
+	// CompareCommits is used by incremental reviews
+	CompareCommits(token, owner, repo, base, head string) (*clients.CommitComparison, error)
+	// CreatePullRequestReview and DismissPullRequestReview submit and withdraw batched reviews
+	CreatePullRequestReview(token, owner, repo string, prNumber int, review *clients.PullRequestReviewRequest) (*clients.PullRequestReview, error)
+	DismissPullRequestReview(token, owner, repo string, prNumber int, reviewID int64, message string) error
+	// GetIssueComments, PostIssueComment and UpdateIssueComment maintain the sticky summary and command replies
+	GetIssueComments(token, owner, repo string, prNumber int) ([]clients.IssueComment, error)
+	PostIssueComment(token, owner, repo string, prNumber int, body string) (*clients.IssueComment, error)
+	UpdateIssueComment(token, owner, repo string, commentID int64, body string) error
+	// GetFileContent reads the review config, CODEOWNERS, allowlists and files to index
+	GetFileContent(token, owner, repo, path, ref string) (string, error)
+	// ListRepositoryFiles lists the paths of the repository's files at ref, to index all of them
+	ListRepositoryFiles(token, owner, repo, ref string) ([]string, error)
+	// GetCollaboratorPermission authorizes slash commands
+	GetCollaboratorPermission(token, owner, repo, username string) (string, error)
+	// GetReviewThreads and GetPullRequestReviewCommentReactions collect feedback
+	GetReviewThreads(token, owner, repo string, prNumber int) ([]clients.ReviewThread, error)
+	GetPullRequestReviewCommentReactions(token, owner, repo string, commentID int64) ([]clients.Reaction, error)
+	// ReplyToPullRequestReviewComment and ResolveReviewThread answer replies on review threads
+	ReplyToPullRequestReviewComment(token, owner, repo string, prNumber int, commentID int64, body string) (*clients.PullRequestComment, error)
+	ResolveReviewThread(token, owner, repo string, prNumber int, commentID int64) error
+}
+
+var _ reviewGitHubClient = clients.GitHubClient(nil)
+
+// contextGitHubClient binds the client to a context. The requests of the returned client are made
+// with ctx, so they are aborted when the review's deadline passes or its job is cancelled.
+type contextGitHubClient interface {
+	WithContext(ctx context.Context) clients.GitHubClient
+}
+
+var _ contextGitHubClient = clients.GitHubClient(nil)
+
+// Fields added to clients.PullRequestComment and InternalReviewComment.
+var _ = InternalReviewComment{
+	PullRequestComment: clients.PullRequestComment{
+		PullRequestReviewID: 0,
+		InReplyToID:         0,
+		DiffHunk:            "",
+	},
+	Severity:   SeverityMinor,
+	Confidence: 0,
+}
+
+// reviewAIConfig is the part of the AI configuration added for the review features. Each call
+// passes ctx to the vendor SDK's request, so a cancelled review stops waiting on the model.
+type reviewAIConfig interface {
+	CallAnthropicContext(ctx context.Context, contextMessage, userMessage string) (string, error)
+	CallAnthropicWithModelContext(ctx context.Context, contextMessage, userMessage string, model anthropic.Model) (string, error)
+	CallOpenAIContext(ctx context.Context, contextMessage, userMessage string) (string, error)
+	CallGeminiContext(ctx context.Context, contextMessage, userMessage string) (string, error)
+	// The Stream* calls return the full completion and pass each text delta to onDelta
+	StreamAnthropicContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error)
+	StreamOpenAIContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error)
+	StreamGeminiContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error)
+}
+
+// Fields added to CodeReviewWorkflow, and the calls added to its AI configuration.
+var _ = func(w *CodeReviewWorkflow) {
+	_ = w.recorder
+	_ = w.costLedger
+	_ = w.pathFilter
+	_ = w.repoConfig
+	_ = w.codeOwners
+	_ = w.reviewRun
+	_ = w.reviewSpan
+	_ = w.reviewScope
+	var _ reviewAIConfig = w.aiConfig
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code-review-bot-test-repo/pkg/breaker"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultProviderBreakers holds a circuit breaker per review provider, shared by all review runs
+// in the process so an outage seen by one run protects the others.
+var DefaultProviderBreakers = breaker.NewGroup(breaker.DefaultSettings(), func(name string, from, to breaker.State) {
+	logging.GetGlobalLogger().Warn("Review provider circuit breaker changed state",
+		zap.String("provider", name),
+		zap.String("from", from.String()),
+		zap.String("to", to.String()))
+})
+
+// parseProviderFallbacks parses the comma separated fallbacks of a ReviewProviderSetting.
+func parseProviderFallbacks(value string) []string {
+	if strings.TrimSpace(value) == "none" {
+		return []string{}
+	}
+	var fallbacks []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fallbacks = append(fallbacks, name)
+		}
+	}
+	return fallbacks
+}
+
+// callAIModelWithFallback reviews with the provider, and when it fails or its circuit breaker is
+// open, with its fallbacks in order until one succeeds. Fallbacks that already review in this run
+// are skipped, their comments would only be duplicates.
+func (w *CodeReviewWorkflow) callAIModelWithFallback(
+	ctx context.Context,
+	primary activeProvider,
+	activeNames map[string]bool,
+	contextMessage string,
+	userMessage string,
+	tokenCount int,
+	additionalContext map[string]interface{},
+	commit string,
+	files []clients.PullRequestFile,
+	prNumber int,
+	requestID string,
+	aiStart time.Time,
+) ([]*InternalReviewComment, error) {
+	chain := []ReviewProvider{primary.ReviewProvider}
+	for _, name := range primary.fallbacks {
+		if activeNames[name] || name == primary.Name() {
+			continue
+		}
+		registration, ok := DefaultProviderRegistry.Registration(name)
+		if !ok {
+			logging.GetGlobalLogger().Warn("Unknown fallback review provider", zap.String("provider", primary.Name()), zap.String("fallback", name))
+			continue
+		}
+		if provider := w.bindReviewProvider(registration); provider != nil {
+			chain = append(chain, provider)
+		}
+	}
+
+	var failures []error
+	for i, provider := range chain {
+		if ctx.Err() != nil {
+			failures = append(failures, ctx.Err())
+			break
+		}
+
+		providerBreaker := DefaultProviderBreakers.Get(provider.Name())
+		if err := providerBreaker.Allow(); err != nil {
+			w.logWorkflowStep(prNumber, requestID, "provider_circuit_open", map[string]interface{}{
+				"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+				"provider":   provider.Name(),
+			})
+			failures = append(failures, err)
+			continue
+		}
+
+		if i > 0 {
+			w.logWorkflowStep(prNumber, requestID, "provider_fallback", map[string]interface{}{
+				"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+				"provider":   primary.Name(),
+				"fallback":   provider.Name(),
+			})
+		}
+
+		// Each attempt gets its own deadline so a hung provider leaves time for its fallbacks
+		providerCtx, cancel := context.WithTimeout(ctx, providerTimeout())
+		providerCtx, span := DefaultReviewTracer.Start(providerCtx, "review_provider")
+		span.SetAttributes(map[string]interface{}{
+			"provider":    provider.Name(),
+			"primary":     primary.Name(),
+			"is_fallback": i > 0,
+		})
+		callStart := time.Now()
+		comments, err := w.callAIModel(
+			providerCtx,
+			contextMessage,
+			userMessage,
+			tokenCount,
+			additionalContext,
+			commit,
+			files,
+			prNumber,
+			requestID,
+			aiStart,
+			provider,
+		)
+		span.SetError(err)
+		span.End()
+		cancel()
+
+		if err != nil && ctx.Err() != nil {
+			// The whole review ran out of time, that says nothing about the provider
+			providerBreaker.Release()
+			failures = append(failures, err)
+			break
+		}
+		providerBreaker.Record(err, time.Since(callStart))
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		if i > 0 {
+			w.AddExecutionLog(fmt.Sprintf("%s review failed, fell back to %s", primary.DisplayName(), provider.DisplayName()))
+		}
+		return comments, nil
+	}
+	return nil, errors.Join(failures...)
+}
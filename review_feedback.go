@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jobs "code-review-bot-test-repo/services"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+// featureFeedbackLearning enables collecting feedback on review comments and suppressing the
+// kinds of comments a repository's team keeps dismissing.
+const featureFeedbackLearning = "review_feedback_learning"
+
+// Feedback signals collected from GitHub, in addition to the follow-up outcomes.
+const (
+	FeedbackOutcomeThumbsUp              = "thumbs_up"
+	FeedbackOutcomeThumbsDown            = "thumbs_down"
+	FeedbackOutcomeResolvedWithoutChange = "resolved_without_change"
+	FeedbackOutcomeAddressed             = "addressed"
+)
+
+// Thresholds for deriving a suppression rule from the feedback on a kind of comment.
+const (
+	minSuppressionEvidence   = 3
+	minSuppressionDismissals = 0.7
+)
+
+// ReviewSuppressionRule is a kind of comment the workflow stops raising in a repository because the
+// team keeps dismissing it. Rules are derived from ReviewCommentFeedback; maintainers can disable one.
+// It is unique per company, repository, comment type and path scope, see reviewCompanyIndexes.
+type ReviewSuppressionRule struct {
+	models.SingleCompanyModel
+	Owner       string `json:"owner"`
+	Repo        string `json:"repo"`
+	CommentType string `json:"comment_type"`
+	PathScope   string `json:"path_scope"`
+	Rule        string `json:"rule"`
+	Dismissed   int    `json:"dismissed"`
+	Total       int    `json:"total"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// Path scopes of suppression rules.
+const (
+	suppressionScopeAll   = ""
+	suppressionScopeTests = "tests"
+)
+
+// isTestPath reports whether the file is a test by its name or directory.
+func isTestPath(filePath string) bool {
+	lower := strings.ToLower(filePath)
+	for _, marker := range []string{"_test.", ".test.", ".spec.", "/test/", "/tests/", "__tests__/"} {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return strings.HasPrefix(lower, "test/") || strings.HasPrefix(lower, "tests/")
+}
+
+// patchTouchesLine reports whether a hunk of the patch changes the given line of the original file.
+func patchTouchesLine(patch string, line int) bool {
+	for _, header := range strings.Split(patch, "\n") {
+		if !strings.HasPrefix(header, "@@ -") {
+			continue
+		}
+		// @@ -start,count +start,count @@
+		oldRange := strings.Fields(strings.TrimPrefix(header, "@@ -"))[0]
+		parts := strings.SplitN(oldRange, ",", 2)
+		start, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		count := 1
+		if len(parts) == 2 {
+			if count, err = strconv.Atoi(parts[1]); err != nil {
+				continue
+			}
+		}
+		if line >= start && line < start+max(count, 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleReviewEventJob collects the feedback on the workflow's comments when a review is submitted
+// or dismissed on the pull request. It can be registered as the handler for
+// jobs.ReviewJobKindReviewEvent jobs.
+func (w *CodeReviewWorkflow) HandleReviewEventJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+	if job.Step == jobs.ReviewJobStepPosted {
+		return nil
+	}
+	if !models.IsFeatureEnabledForCompany(w.db, featureFeedbackLearning, w.repoWorkflowSetting.CompanyId) {
+		return nil
+	}
+
+	files, err := w.githubConfig.Client.GetPullRequestFiles(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, job.PRNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get PR files: %w", err)
+	}
+	w.prFiles = files
+	w.collectReviewFeedback(job.PRNumber, job.HeadSHA)
+	return queue.Checkpoint(ctx, job, jobs.ReviewJobStepPosted)
+}
+
+// collectReviewFeedback records reactions, resolutions and follow-up commits on the workflow's
+// earlier comments on the pull request, then refreshes the repository's suppression rules.
+func (w *CodeReviewWorkflow) collectReviewFeedback(prNumber int, headSHA string) {
+	logger := logging.GetGlobalLogger()
+
+	var botComments []models.PRComment
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND pr_number = ? AND is_by_workflow = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, true).
+		Find(&botComments).Error
+	if err != nil {
+		logger.Warn("Failed to load previous review comments", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+	if len(botComments) == 0 {
+		return
+	}
+
+	reviewComments, err := w.githubConfig.Client.GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		logger.Warn("Failed to fetch review comments for feedback", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+	reviewCommentsByID := make(map[int64]clients.PullRequestComment, len(reviewComments))
+	for _, comment := range reviewComments {
+		reviewCommentsByID[comment.ID] = comment
+	}
+
+	resolved := map[int64]bool{}
+	threads, err := w.githubConfig.Client.GetReviewThreads(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		// Reactions and follow-up commits are still worth recording
+		logger.Warn("Failed to fetch review threads for feedback", zap.Error(err), zap.Int("pr_number", prNumber))
+	}
+	for _, thread := range threads {
+		resolved[thread.RootCommentID] = thread.IsResolved
+	}
+
+	// The patches pushed since the last review tell which commented lines were changed
+	changedPatches := map[string]string{}
+	if changedFiles, ok := w.filesSinceLastReview(prNumber, w.prFiles, w.lastReviewedCommit(prNumber), headSHA); ok {
+		for _, file := range changedFiles {
+			changedPatches[file.Filename] = file.Patch
+		}
+	}
+
+	commentIDs := make([]int64, 0, len(botComments))
+	for _, comment := range botComments {
+		commentIDs = append(commentIDs, comment.CommentID)
+	}
+	var existing []ReviewCommentFeedback
+	if err := w.db.Where("company_id = ? AND comment_id IN ?", w.repoWorkflowSetting.CompanyId, commentIDs).Find(&existing).Error; err != nil {
+		logger.Warn("Failed to load existing feedback", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+	recorded := map[int64]map[string]bool{}
+	for _, feedback := range existing {
+		if recorded[feedback.CommentID] == nil {
+			recorded[feedback.CommentID] = map[string]bool{}
+		}
+		recorded[feedback.CommentID][feedback.Outcome] = true
+	}
+
+	newSignals := 0
+	for _, botComment := range botComments {
+		reviewComment, ok := reviewCommentsByID[botComment.CommentID]
+		if !ok {
+			continue
+		}
+
+		var outcomes []string
+		reactions, err := w.githubConfig.Client.GetPullRequestReviewCommentReactions(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, botComment.CommentID)
+		if err != nil {
+			logger.Warn("Failed to fetch comment reactions", zap.Error(err), zap.Int64("comment_id", botComment.CommentID))
+		}
+		for _, reaction := range reactions {
+			switch reaction.Content {
+			case "+1":
+				outcomes = append(outcomes, FeedbackOutcomeThumbsUp)
+			case "-1":
+				outcomes = append(outcomes, FeedbackOutcomeThumbsDown)
+			}
+		}
+
+		addressed := recorded[botComment.CommentID][FeedbackOutcomeAddressed]
+		if patch, changed := changedPatches[reviewComment.Path]; changed && patchTouchesLine(patch, reviewComment.Line) {
+			outcomes = append(outcomes, FeedbackOutcomeAddressed)
+			addressed = true
+		}
+		if resolved[botComment.CommentID] && !addressed {
+			outcomes = append(outcomes, FeedbackOutcomeResolvedWithoutChange)
+		}
+
+		for _, outcome := range outcomes {
+			if recorded[botComment.CommentID][outcome] {
+				continue
+			}
+			if recorded[botComment.CommentID] == nil {
+				recorded[botComment.CommentID] = map[string]bool{}
+			}
+			recorded[botComment.CommentID][outcome] = true
+
+			feedback := ReviewCommentFeedback{
+				SingleCompanyModel: models.SingleCompanyModel{
+					CompanyId: w.repoWorkflowSetting.CompanyId,
+				},
+				Owner:       w.githubConfig.Owner,
+				Repo:        w.githubConfig.Repo,
+				PRNumber:    prNumber,
+				CommentID:   botComment.CommentID,
+				CommentBody: botComment.Body,
+				CommentType: string(botComment.CommentType),
+				Path:        reviewComment.Path,
+				Line:        reviewComment.Line,
+				Outcome:     outcome,
+			}
+			if err := w.db.Create(&feedback).Error; err != nil {
+				logger.Error("Failed to record review comment feedback", zap.Error(err), zap.Int64("comment_id", botComment.CommentID))
+				continue
+			}
+			newSignals++
+		}
+	}
+
+	if newSignals > 0 {
+		w.AddExecutionLog(fmt.Sprintf("Recorded %d feedback signal(s) on earlier review comments", newSignals))
+		w.refreshSuppressionRules()
+	}
+}
+
+// feedbackVerdict collapses the signals on a comment: 1 if it was useful, -1 if the team dismissed
+// it, 0 if there is no clear verdict.
+func feedbackVerdict(outcomes map[string]bool) int {
+	if outcomes[FeedbackOutcomeAddressed] || outcomes[FeedbackOutcomeThumbsUp] {
+		return 1
+	}
+	if outcomes[FeedbackOutcomeThumbsDown] || outcomes[FeedbackOutcomeResolvedWithoutChange] || outcomes[FollowUpOutcomeConceded] {
+		return -1
+	}
+	return 0
+}
+
+// suppressionExempt reports whether comments of the type are never suppressed, however often the
+// team dismisses them. Bugs and security issues are blockers and are always raised.
+func suppressionExempt(commentType string) bool {
+	return defaultSeverityForType(commentType) == SeverityBlocker
+}
+
+// describeSuppressionRule phrases a rule the way it is shown to the models.
+func describeSuppressionRule(commentType, pathScope string, dismissed, total int) string {
+	scope := ""
+	if pathScope == suppressionScopeTests {
+		scope = " in test files"
+	}
+	return fmt.Sprintf("Don't flag %s issues%s: the team dismissed %d of the last %d such comments.",
+		strings.ReplaceAll(commentType, "_", " "), scope, dismissed, total)
+}
+
+// refreshSuppressionRules derives the repository's suppression rules from the recorded feedback.
+func (w *CodeReviewWorkflow) refreshSuppressionRules() {
+	logger := logging.GetGlobalLogger()
+
+	var feedback []ReviewCommentFeedback
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo).
+		Find(&feedback).Error
+	if err != nil {
+		logger.Warn("Failed to load review comment feedback", zap.Error(err))
+		return
+	}
+
+	type commentFeedback struct {
+		commentType string
+		path        string
+		outcomes    map[string]bool
+	}
+	comments := map[int64]*commentFeedback{}
+	for _, f := range feedback {
+		if f.CommentType == "" || suppressionExempt(f.CommentType) {
+			continue
+		}
+		if comments[f.CommentID] == nil {
+			comments[f.CommentID] = &commentFeedback{commentType: f.CommentType, path: f.Path, outcomes: map[string]bool{}}
+		}
+		comments[f.CommentID].outcomes[f.Outcome] = true
+	}
+
+	type ruleKey struct{ commentType, pathScope string }
+	type ruleStats struct{ dismissed, total int }
+	stats := map[ruleKey]*ruleStats{}
+	for _, comment := range comments {
+		verdict := feedbackVerdict(comment.outcomes)
+		if verdict == 0 {
+			continue
+		}
+		scopes := []string{suppressionScopeAll}
+		if isTestPath(comment.path) {
+			scopes = append(scopes, suppressionScopeTests)
+		}
+		for _, scope := range scopes {
+			key := ruleKey{comment.commentType, scope}
+			if stats[key] == nil {
+				stats[key] = &ruleStats{}
+			}
+			stats[key].total++
+			if verdict < 0 {
+				stats[key].dismissed++
+			}
+		}
+	}
+
+	var keep []uint
+	for key, s := range stats {
+		if s.dismissed < minSuppressionEvidence || float64(s.dismissed)/float64(s.total) < minSuppressionDismissals {
+			continue
+		}
+		rule := ReviewSuppressionRule{
+			SingleCompanyModel: models.SingleCompanyModel{
+				CompanyId: w.repoWorkflowSetting.CompanyId,
+			},
+			Owner:       w.githubConfig.Owner,
+			Repo:        w.githubConfig.Repo,
+			CommentType: key.commentType,
+			PathScope:   key.pathScope,
+			Rule:        describeSuppressionRule(key.commentType, key.pathScope, s.dismissed, s.total),
+			Dismissed:   s.dismissed,
+			Total:       s.total,
+		}
+		err := w.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "company_id"}, {Name: "owner"}, {Name: "repo"}, {Name: "comment_type"}, {Name: "path_scope"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rule", "dismissed", "total", "updated_at"}),
+		}).Create(&rule).Error
+		if err != nil {
+			logger.Error("Failed to save suppression rule", zap.Error(err), zap.String("comment_type", key.commentType))
+			continue
+		}
+		keep = append(keep, rule.ID)
+	}
+
+	// Drop derived rules the feedback no longer supports, disabled rules are kept so they stay disabled
+	stale := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND disabled = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, false)
+	if len(keep) > 0 {
+		stale = stale.Where("id NOT IN ?", keep)
+	}
+	if err := stale.Delete(&ReviewSuppressionRule{}).Error; err != nil {
+		logger.Warn("Failed to remove stale suppression rules", zap.Error(err))
+	}
+}
+
+// loadSuppressionRules returns the active suppression rules of the repository for the prompt.
+func (w *CodeReviewWorkflow) loadSuppressionRules() []string {
+	var rules []ReviewSuppressionRule
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND disabled = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, false).
+		Order("comment_type, path_scope").
+		Find(&rules).Error
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load suppression rules", zap.Error(err))
+		return nil
+	}
+	descriptions := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		// Rules derived before the type was exempted are removed on the next refresh
+		if suppressionExempt(rule.CommentType) {
+			continue
+		}
+		descriptions = append(descriptions, rule.Rule)
+	}
+	return descriptions
+}
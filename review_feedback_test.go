@@ -0,0 +1,44 @@
+package services
+
+import "testing"
+
+func TestSuppressionExempt(t *testing.T) {
+	tests := map[string]bool{
+		"bug":                    true,
+		"security_vulnerability": true,
+		"logic_bug":              true,
+		"style":                  false,
+		"naming":                 false,
+		"performance":            false,
+	}
+	for commentType, want := range tests {
+		if got := suppressionExempt(commentType); got != want {
+			t.Errorf("suppressionExempt(%q) = %v, want %v", commentType, got, want)
+		}
+	}
+}
+
+func TestFeedbackVerdict(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []string
+		want     int
+	}{
+		{"addressed", []string{FeedbackOutcomeAddressed}, 1},
+		{"thumbs down", []string{FeedbackOutcomeThumbsDown}, -1},
+		{"conceded", []string{FollowUpOutcomeConceded}, -1},
+		{"addressed despite thumbs down", []string{FeedbackOutcomeThumbsDown, FeedbackOutcomeAddressed}, 1},
+		{"no signal", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcomes := map[string]bool{}
+			for _, outcome := range tt.outcomes {
+				outcomes[outcome] = true
+			}
+			if got := feedbackVerdict(outcomes); got != tt.want {
+				t.Errorf("feedbackVerdict(%v) = %d, want %d", tt.outcomes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	jobs "code-review-bot-test-repo/services"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Outcomes of a follow-up on a review thread.
+const (
+	FollowUpOutcomeConceded  = "conceded"
+	FollowUpOutcomeExplained = "explained"
+)
+
+// maxFollowUpRepliesPerThread stops the bot from arguing indefinitely on one thread.
+const maxFollowUpRepliesPerThread = 3
+
+// ReviewCommentFeedback records how an author responded to one of the workflow's review comments.
+type ReviewCommentFeedback struct {
+	models.SingleCompanyModel
+	Owner          string `gorm:"index:idx_review_comment_feedback" json:"owner"`
+	Repo           string `gorm:"index:idx_review_comment_feedback" json:"repo"`
+	PRNumber       int    `json:"pr_number"`
+	CommentID      int64  `gorm:"index" json:"comment_id"`
+	CommentBody    string `json:"comment_body"`
+	CommentType    string `json:"comment_type"`
+	Path           string `json:"path"`
+	Line           int    `json:"line"`
+	ReplyCommentID int64  `json:"reply_comment_id"`
+	ReplyAuthor    string `json:"reply_author"`
+	ReplyBody      string `json:"reply_body"`
+	Outcome        string `json:"outcome"`
+	Response       string `json:"response"`
+}
+
+// threadFollowUp is the model's decision on an author's reply.
+type threadFollowUp struct {
+	Decision string `json:"decision"`
+	Reply    string `json:"reply"`
+}
+
+// threadFollowUpSystemMessage instructs the model how to respond to a reply on its review comment.
+const threadFollowUpSystemMessage = `You are a world class software engineer who left the first comment of the review thread below. ` +
+	`The pull request author replied to it. Decide, based on the thread and the diff hunk, whether the author's reply is convincing. ` +
+	`If the code is intentional and the reasoning holds, or the concern doesn't apply, concede briefly and thank the author. ` +
+	`Otherwise explain the concern further, concretely and politely, with a short example if it helps. Never repeat the original comment verbatim. ` +
+	`Respond with ONLY a JSON object: {"decision": "concede"|"explain", "reply": string}`
+
+// HandleThreadReplyJob runs the follow-up for a thread reply job. It can be registered as the
+// handler for jobs.ReviewJobKindThreadReply jobs.
+func (w *CodeReviewWorkflow) HandleThreadReplyJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+	if job.Step == jobs.ReviewJobStepPosted {
+		return nil
+	}
+	if err := w.HandleThreadReply(ctx, job.PRNumber, job.InReplyToID, job.CommentID, job.Sender, job.CommentBody); err != nil {
+		return err
+	}
+	return queue.Checkpoint(ctx, job, jobs.ReviewJobStepPosted)
+}
+
+// HandleThreadReply responds to a reply on one of the workflow's review threads. The model either
+// concedes and the thread is resolved, or explains the comment further. Replies on threads the
+// workflow didn't start are ignored. The GitHub and model requests are aborted when ctx is done.
+func (w *CodeReviewWorkflow) HandleThreadReply(ctx context.Context, prNumber int, rootCommentID, replyCommentID int64, replyAuthor, replyBody string) error {
+	defer w.bindGitHubClient(ctx)()
+	logger := logging.GetGlobalLogger()
+
+	var rootComment models.PRComment
+	err := w.db.Where("owner = ? AND repo = ? AND pr_number = ? AND comment_id = ? AND is_by_workflow = ?",
+		w.githubConfig.Owner, w.githubConfig.Repo, prNumber, rootCommentID, true).
+		First(&rootComment).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load review comment %d: %w", rootCommentID, err)
+	}
+
+	reviewComments, err := w.githubConfig.Client.GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		return fmt.Errorf("failed to fetch review comments: %w", err)
+	}
+	thread := reviewThread(reviewComments, rootCommentID)
+	if len(thread) == 0 {
+		return fmt.Errorf("review comment %d no longer exists", rootCommentID)
+	}
+
+	botLogin := thread[0].User.Login
+	botReplies := 0
+	for _, comment := range thread[1:] {
+		if comment.User.Login == botLogin {
+			botReplies++
+		}
+	}
+	if botReplies >= maxFollowUpRepliesPerThread {
+		logger.Info("Not replying again on review thread",
+			zap.Int("pr_number", prNumber),
+			zap.Int64("comment_id", rootCommentID),
+			zap.Int("bot_replies", botReplies))
+		return nil
+	}
+
+	providers := w.resolveReviewProviders()
+	if len(providers) == 0 {
+		return fmt.Errorf("no AI providers enabled")
+	}
+	provider := providers[0]
+
+	followUpFn := w.meteredModelCall(prNumber, provider.Name(), provider.Model(), "thread_followup", providerModelCall(ctx, provider))
+	response, err := followUpFn(threadFollowUpSystemMessage, formatReviewThread(thread))
+	if err != nil {
+		return fmt.Errorf("failed to generate follow-up: %w", err)
+	}
+
+	var followUp threadFollowUp
+	if err := SanitizeAndParseJSON(response, &followUp); err != nil {
+		return fmt.Errorf("failed to parse follow-up: %w", err)
+	}
+	if strings.TrimSpace(followUp.Reply) == "" {
+		return fmt.Errorf("model returned an empty follow-up")
+	}
+
+	if _, err := w.githubConfig.Client.ReplyToPullRequestReviewComment(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, rootCommentID, followUp.Reply); err != nil {
+		return fmt.Errorf("failed to reply on review thread %d: %w", rootCommentID, err)
+	}
+
+	outcome := FollowUpOutcomeExplained
+	if strings.EqualFold(followUp.Decision, "concede") {
+		outcome = FollowUpOutcomeConceded
+		if err := w.githubConfig.Client.ResolveReviewThread(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, rootCommentID); err != nil {
+			// Log the error, the concession was posted
+			logger.Error("Failed to resolve review thread", zap.Error(err), zap.Int("pr_number", prNumber), zap.Int64("comment_id", rootCommentID))
+		}
+	}
+
+	feedback := ReviewCommentFeedback{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.repoWorkflowSetting.CompanyId,
+		},
+		Owner:          w.githubConfig.Owner,
+		Repo:           w.githubConfig.Repo,
+		PRNumber:       prNumber,
+		CommentID:      rootCommentID,
+		CommentBody:    rootComment.Body,
+		CommentType:    string(rootComment.CommentType),
+		Path:           thread[0].Path,
+		Line:           thread[0].Line,
+		ReplyCommentID: replyCommentID,
+		ReplyAuthor:    replyAuthor,
+		ReplyBody:      replyBody,
+		Outcome:        outcome,
+		Response:       followUp.Reply,
+	}
+	if err := w.db.Create(&feedback).Error; err != nil {
+		// Log the error, the reply was posted
+		logger.Error("Failed to record review comment feedback", zap.Error(err), zap.Int64("comment_id", rootCommentID))
+	}
+
+	w.AddExecutionLog(fmt.Sprintf("Followed up on review thread %d: %s", rootCommentID, outcome))
+	return nil
+}
+
+// reviewThread returns the root comment followed by its replies in the order they were posted.
+func reviewThread(comments []clients.PullRequestComment, rootCommentID int64) []clients.PullRequestComment {
+	var root []clients.PullRequestComment
+	var replies []clients.PullRequestComment
+	for _, comment := range comments {
+		switch {
+		case comment.ID == rootCommentID:
+			root = append(root, comment)
+		case comment.InReplyToID == rootCommentID:
+			replies = append(replies, comment)
+		}
+	}
+	if len(root) == 0 {
+		return nil
+	}
+	sort.SliceStable(replies, func(i, j int) bool {
+		return replies[i].CreatedAt < replies[j].CreatedAt
+	})
+	return append(root, replies...)
+}
+
+// formatReviewThread renders the thread and the diff hunk it is attached to for the model.
+func formatReviewThread(thread []clients.PullRequestComment) string {
+	var sb strings.Builder
+	root := thread[0]
+	sb.WriteString(fmt.Sprintf("File: %s, line %d\n", root.Path, root.Line))
+	sb.WriteString("Diff hunk:\n```diff\n" + root.DiffHunk + "\n```\n\nThread:\n")
+	for i, comment := range thread {
+		role := "author"
+		if i == 0 || comment.User.Login == root.User.Login {
+			role = "you"
+		}
+		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n\n", role, comment.User.Login, comment.Body))
+	}
+	return sb.String()
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// featureIncrementalReview enables reviewing only the commits pushed since the last review.
+const featureIncrementalReview = "incremental_review"
+
+// ReviewedPullRequestCommit remembers the last head commit the workflow reviewed for a pull request.
+// It is unique per company, owner, repo and pull request, see reviewCompanyIndexes.
+type ReviewedPullRequestCommit struct {
+	models.SingleCompanyModel
+	Owner           string    `json:"owner"`
+	Repo            string    `json:"repo"`
+	PRNumber        int       `json:"pr_number"`
+	LastReviewedSHA string    `json:"last_reviewed_sha"`
+	ReviewedAt      time.Time `json:"reviewed_at"`
+}
+
+// reviewScope is what the last ReviewPullRequest run covered. PostReviewComments uses it to
+// decide what the posted review settles.
+type reviewScope struct {
+	// HeadSHA is the head commit of the pull request when it was reviewed
+	HeadSHA string
+	// Incremental is set when only the commits since the previous review were reviewed
+	Incremental bool
+	// PathFiltered is set when the review was limited to the paths given to "/review"
+	PathFiltered bool
+	// ModelsFailed is set when the models failed and only the deterministic findings were returned
+	ModelsFailed bool
+}
+
+// fullDiff reports whether the run reviewed every change of the pull request.
+func (s reviewScope) fullDiff() bool {
+	return s.HeadSHA != "" && !s.Incremental && !s.PathFiltered && !s.ModelsFailed
+}
+
+// previousReviewComment is the summary of an earlier bot comment shown to the models.
+type previousReviewComment struct {
+	Body        string    `json:"body"`
+	CommentType string    `json:"comment_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// lastReviewedCommit returns the head SHA reviewed by the previous run, or "" if there was none.
+func (w *CodeReviewWorkflow) lastReviewedCommit(prNumber int) string {
+	var reviewed ReviewedPullRequestCommit
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND pr_number = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, prNumber).
+		First(&reviewed).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			logging.GetGlobalLogger().Warn("Failed to load last reviewed commit", zap.Error(err), zap.Int("pr_number", prNumber))
+		}
+		return ""
+	}
+	return reviewed.LastReviewedSHA
+}
+
+// recordReviewedCommit stores the head SHA of a completed review so the next run can be incremental.
+func (w *CodeReviewWorkflow) recordReviewedCommit(prNumber int, commitSHA string) {
+	if commitSHA == "" {
+		return
+	}
+	reviewed := ReviewedPullRequestCommit{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.repoWorkflowSetting.CompanyId,
+		},
+		Owner:           w.githubConfig.Owner,
+		Repo:            w.githubConfig.Repo,
+		PRNumber:        prNumber,
+		LastReviewedSHA: commitSHA,
+		ReviewedAt:      time.Now(),
+	}
+	err := w.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}, {Name: "owner"}, {Name: "repo"}, {Name: "pr_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_reviewed_sha", "reviewed_at", "updated_at"}),
+	}).Create(&reviewed).Error
+	if err != nil {
+		logging.GetGlobalLogger().Error("Failed to record reviewed commit", zap.Error(err), zap.Int("pr_number", prNumber))
+	}
+}
+
+// filesSinceLastReview narrows the PR files to the hunks changed since the last reviewed commit.
+// It returns false when a full review is needed, e.g. on the first run or after a force push.
+func (w *CodeReviewWorkflow) filesSinceLastReview(prNumber int, files []clients.PullRequestFile, lastSHA, headSHA string) ([]clients.PullRequestFile, bool) {
+	if lastSHA == "" {
+		return files, false
+	}
+	if lastSHA == headSHA {
+		return []clients.PullRequestFile{}, true
+	}
+
+	comparison, err := w.githubConfig.Client.CompareCommits(
+		w.githubConfig.Token,
+		w.githubConfig.Owner,
+		w.githubConfig.Repo,
+		lastSHA,
+		headSHA)
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to compare commits, falling back to full review",
+			zap.Error(err),
+			zap.Int("pr_number", prNumber),
+			zap.String("base", lastSHA),
+			zap.String("head", headSHA))
+		return files, false
+	}
+
+	// A rebase or force push rewrites history, the old SHA is no longer an ancestor of head
+	if comparison.Status != "ahead" {
+		logging.GetGlobalLogger().Info("Last reviewed commit is not an ancestor of head, falling back to full review",
+			zap.Int("pr_number", prNumber),
+			zap.String("comparison_status", comparison.Status))
+		return files, false
+	}
+
+	// Only keep files that are part of the PR, commits merged in from the base branch are not ours to review
+	prFiles := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		prFiles[file.Filename] = struct{}{}
+	}
+	changed := make([]clients.PullRequestFile, 0, len(comparison.Files))
+	for _, file := range comparison.Files {
+		if _, ok := prFiles[file.Filename]; ok && file.Patch != "" {
+			changed = append(changed, file)
+		}
+	}
+	return changed, true
+}
+
+// previousReviewComments returns the comments the workflow already posted on the pull request.
+func (w *CodeReviewWorkflow) previousReviewComments(prNumber int) []previousReviewComment {
+	var prComments []models.PRComment
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND pr_number = ? AND is_by_workflow = ?",
+		w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, true).
+		Order("comment_created_at").
+		Find(&prComments).Error
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load previous review comments", zap.Error(err), zap.Int("pr_number", prNumber))
+		return nil
+	}
+
+	comments := make([]previousReviewComment, 0, len(prComments))
+	for _, prComment := range prComments {
+		comments = append(comments, previousReviewComment{
+			Body:        prComment.Body,
+			CommentType: string(prComment.CommentType),
+			CreatedAt:   prComment.CommentCreatedAt,
+		})
+	}
+	return comments
+}
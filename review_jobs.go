@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	jobs "code-review-bot-test-repo/services"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ReviewWorkflowFactory creates the workflow and context builder for the repository of a review
+// job, with the repository's settings, installation token and AI configuration.
+type ReviewWorkflowFactory func(ctx context.Context, job *jobs.ReviewJob) (*CodeReviewWorkflow, *ContextBuilder, error)
+
+// StartReviewWorkflow prepares the service hosting the workflow to run review jobs: it migrates the
+// review models and registers the job handlers. It fails when a kind of job would be left without a
+// handler, since such jobs would be retried until they are dead-lettered. The service calls it at
+// startup, before starting the review workers.
+func StartReviewWorkflow(db *gorm.DB, factory ReviewWorkflowFactory) error {
+	if err := MigrateReviewModels(db); err != nil {
+		return err
+	}
+	RegisterReviewJobHandlers(factory)
+	if missing := jobs.MissingReviewJobHandlers(); len(missing) > 0 {
+		return fmt.Errorf("no handlers registered for review jobs of kind %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RegisterReviewJobHandlers registers a handler for every kind of review job. Each job gets a
+// workflow of its own from the factory, since jobs of different repositories run concurrently.
+func RegisterReviewJobHandlers(factory ReviewWorkflowFactory) {
+	register := func(kind string, run func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error) {
+		jobs.RegisterReviewJobHandler(kind, func(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+			w, contextBuilder, err := factory(ctx, job)
+			if err != nil {
+				return fmt.Errorf("failed to create review workflow for %s: %w", job.Key(), err)
+			}
+			defer w.bindGitHubClient(ctx)()
+			return run(ctx, w, contextBuilder, queue, job)
+		})
+	}
+
+	register(jobs.ReviewJobKindReview, func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+		return w.RunReviewJob(ctx, queue, job, contextBuilder)
+	})
+	register(jobs.ReviewJobKindComment, func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+		return NewCommandHandler(w, contextBuilder).HandleJob(ctx, queue, job)
+	})
+	register(jobs.ReviewJobKindReviewEvent, func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+		return w.HandleReviewEventJob(ctx, queue, job)
+	})
+	register(jobs.ReviewJobKindThreadReply, func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+		return w.HandleThreadReplyJob(ctx, queue, job)
+	})
+	register(jobs.ReviewJobKindIndex, func(ctx context.Context, w *CodeReviewWorkflow, contextBuilder *ContextBuilder, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+		return w.HandleIndexJob(ctx, queue, job)
+	})
+}
+
+// RunReviewJob reviews the pull request of a queued review job and posts the comments. The steps
+// are checkpointed on the job so that a retry after a crash resumes after posting instead of
+// posting the comments a second time.
+func (w *CodeReviewWorkflow) RunReviewJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob, contextBuilder *ContextBuilder) error {
+	if job.Step == jobs.ReviewJobStepPosted {
+		// A previous attempt posted the review and failed afterwards, there's nothing left to do
+		return nil
+	}
+
+	if err := w.ensureCurrentJob(ctx, queue, job); err != nil {
+		return err
+	}
+	w.githubConfig.CommitSHA = job.HeadSHA
+	if err := queue.Checkpoint(ctx, job, jobs.ReviewJobStepStarted); err != nil {
+		return err
+	}
+
+	comments, files, err := w.ReviewPullRequestContext(ctx, job.PRNumber, contextBuilder, true, true)
+	if err != nil {
+		if !w.reviewScope.ModelsFailed || len(comments) == 0 {
+			return fmt.Errorf("failed to review PR #%d: %w", job.PRNumber, err)
+		}
+		// The models failed but the deterministic findings are there. A retry couldn't post its
+		// review once these are posted, so the job finishes with them, and the commit isn't recorded
+		// as reviewed so the next push is reviewed in full.
+		logging.GetGlobalLogger().Warn("Models failed, posting the deterministic findings only",
+			zap.Error(err),
+			zap.Int("pr_number", job.PRNumber),
+			zap.Int("finding_count", len(comments)),
+			zap.Int64("job_id", job.ID))
+		w.AddExecutionLog(fmt.Sprintf("The models failed, posting %d deterministic finding(s) only", len(comments)))
+	}
+	if err := queue.Checkpoint(ctx, job, jobs.ReviewJobStepReviewed); err != nil {
+		return err
+	}
+
+	// A push during the review makes the comments stale, the newer job reviews it instead
+	if err := w.ensureCurrentJob(ctx, queue, job); err != nil {
+		return err
+	}
+
+	// The previous attempt may have submitted the review but crashed before checkpointing it
+	alreadySubmitted, err := w.reviewSubmittedForCommit(job.PRNumber, job.HeadSHA)
+	if err != nil {
+		return err
+	}
+	if alreadySubmitted {
+		logging.GetGlobalLogger().Info("Review already submitted for commit, skipping posting",
+			zap.Int("pr_number", job.PRNumber),
+			zap.String("commit", job.HeadSHA),
+			zap.Int64("job_id", job.ID))
+	} else if files != nil {
+		if _, _, err := w.PostReviewCommentsContext(ctx, job.PRNumber, w.repoWorkflowSetting.CompanyId, comments, files); err != nil {
+			return fmt.Errorf("failed to post review comments on PR #%d: %w", job.PRNumber, err)
+		}
+	}
+
+	return queue.Checkpoint(ctx, job, jobs.ReviewJobStepPosted)
+}
+
+// ensureCurrentJob returns jobs.ErrReviewJobSuperseded when a newer head SHA was pushed.
+func (w *CodeReviewWorkflow) ensureCurrentJob(ctx context.Context, queue jobs.ReviewJobQueue, job *jobs.ReviewJob) error {
+	current, err := queue.IsCurrent(ctx, job)
+	if err != nil {
+		return err
+	}
+	if !current {
+		w.AddExecutionLog(fmt.Sprintf("Skipping review of %s, a newer commit was pushed", shortSHA(job.HeadSHA)))
+		return jobs.ErrReviewJobSuperseded
+	}
+	return nil
+}
+
+// reviewSubmittedForCommit reports whether the workflow already submitted a review for the commit.
+func (w *CodeReviewWorkflow) reviewSubmittedForCommit(prNumber int, commitSHA string) (bool, error) {
+	var count int64
+	err := w.db.Model(&BotPullRequestReview{}).
+		Where("company_id = ? AND owner = ? AND repo = ? AND pr_number = ? AND commit_sha = ?",
+			w.repoWorkflowSetting.CompanyId, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, commitSHA).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check submitted reviews: %w", err)
+	}
+	return count > 0, nil
+}
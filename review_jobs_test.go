@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	jobs "code-review-bot-test-repo/services"
+)
+
+func TestRegisterReviewJobHandlersCoversEveryKind(t *testing.T) {
+	factoryErr := errors.New("no installation")
+	var created []string
+	RegisterReviewJobHandlers(func(ctx context.Context, job *jobs.ReviewJob) (*CodeReviewWorkflow, *ContextBuilder, error) {
+		created = append(created, job.Kind)
+		return nil, nil, factoryErr
+	})
+
+	if missing := jobs.MissingReviewJobHandlers(); len(missing) > 0 {
+		t.Fatalf("no handler registered for review jobs of kind %v", missing)
+	}
+	for _, kind := range jobs.ReviewJobKinds {
+		job := &jobs.ReviewJob{Kind: kind, Owner: "octo", Repo: "hello", PRNumber: 7}
+		if err := jobs.DispatchReviewJob(context.Background(), jobs.NewMemoryReviewJobQueue(), job); !errors.Is(err, factoryErr) {
+			t.Errorf("DispatchReviewJob(%s) = %v, want the factory's error", kind, err)
+		}
+	}
+	if len(created) != len(jobs.ReviewJobKinds) {
+		t.Errorf("factory called for %v, want every kind of %v", created, jobs.ReviewJobKinds)
+	}
+}
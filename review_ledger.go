@@ -0,0 +1,297 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ReviewLedgerEntry records the token usage and cost of a single model call made during a review.
+type ReviewLedgerEntry struct {
+	models.SingleCompanyModel
+	CodeWorkflowExecutionId uint    `gorm:"index" json:"code_workflow_execution_id"`
+	ReviewRunId             uint    `gorm:"index" json:"review_run_id"`
+	Owner                   string  `gorm:"index:idx_review_ledger_repo" json:"owner"`
+	Repo                    string  `gorm:"index:idx_review_ledger_repo" json:"repo"`
+	PRNumber                int     `json:"pr_number"`
+	CommitSHA               string  `json:"commit_sha"`
+	Provider                string  `json:"provider"`
+	Model                   string  `json:"model"`
+	Purpose                 string  `json:"purpose"`
+	PromptTokens            int     `json:"prompt_tokens"`
+	CompletionTokens        int     `json:"completion_tokens"`
+	Retries                 int     `json:"retries"`
+	Failed                  bool    `json:"failed"`
+	CostUSD                 float64 `json:"cost_usd"`
+}
+
+// CompanyReviewBudget caps how much a company spends on model calls. A zero limit means unlimited.
+type CompanyReviewBudget struct {
+	models.SingleCompanyModel
+	PerReviewUSD float64 `json:"per_review_usd"`
+	MonthlyUSD   float64 `json:"monthly_usd"`
+}
+
+// modelPrice is the list price of a model in US dollars per million tokens.
+type modelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// modelPrices maps model name prefixes to prices. The longest matching prefix wins.
+var modelPrices = map[string]modelPrice{
+	"claude-opus-4":     {InputPerMillion: 15, OutputPerMillion: 75},
+	"claude-sonnet-4":   {InputPerMillion: 3, OutputPerMillion: 15},
+	"claude-3-7-sonnet": {InputPerMillion: 3, OutputPerMillion: 15},
+	"claude-3-5-sonnet": {InputPerMillion: 3, OutputPerMillion: 15},
+	"claude-3-5-haiku":  {InputPerMillion: 0.8, OutputPerMillion: 4},
+	"gpt-4.1-mini":      {InputPerMillion: 0.4, OutputPerMillion: 1.6},
+	"gpt-4.1":           {InputPerMillion: 2, OutputPerMillion: 8},
+	"gpt-4o-mini":       {InputPerMillion: 0.15, OutputPerMillion: 0.6},
+	"gpt-4o":            {InputPerMillion: 2.5, OutputPerMillion: 10},
+	"o3":                {InputPerMillion: 2, OutputPerMillion: 8},
+	"o4-mini":           {InputPerMillion: 1.1, OutputPerMillion: 4.4},
+	"gemini-2.5-pro":    {InputPerMillion: 1.25, OutputPerMillion: 10},
+	"gemini-2.5-flash":  {InputPerMillion: 0.3, OutputPerMillion: 2.5},
+}
+
+// localReviewProvider is the provider name of self-hosted models, their calls cost nothing.
+const localReviewProvider = "local"
+
+// computeModelCost returns the dollar cost of a call. A model without a known price is charged
+// at the highest known price, so that budgets still stop a review that uses it.
+func computeModelCost(provider, model string, promptTokens, completionTokens int) float64 {
+	if provider == localReviewProvider {
+		return 0
+	}
+
+	var price modelPrice
+	matched := ""
+	for prefix, candidate := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			price = candidate
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		price = highestModelPrice()
+		logging.GetGlobalLogger().Warn("No price for model, charging the highest known price",
+			zap.String("provider", provider),
+			zap.String("model", model))
+	}
+	return (float64(promptTokens)*price.InputPerMillion + float64(completionTokens)*price.OutputPerMillion) / 1_000_000
+}
+
+// highestModelPrice returns the highest input and output prices of any known model.
+func highestModelPrice() modelPrice {
+	var highest modelPrice
+	for _, price := range modelPrices {
+		highest.InputPerMillion = math.Max(highest.InputPerMillion, price.InputPerMillion)
+		highest.OutputPerMillion = math.Max(highest.OutputPerMillion, price.OutputPerMillion)
+	}
+	return highest
+}
+
+// ReviewCostLedger accumulates the model usage of one review run. It is safe for concurrent use.
+type ReviewCostLedger struct {
+	mu        sync.Mutex
+	entries   []ReviewLedgerEntry
+	persisted int
+}
+
+// NewReviewCostLedger creates an empty ledger.
+func NewReviewCostLedger() *ReviewCostLedger {
+	return &ReviewCostLedger{}
+}
+
+// Record adds an entry, computing its cost from the model's price.
+func (l *ReviewCostLedger) Record(entry ReviewLedgerEntry) {
+	entry.CostUSD = computeModelCost(entry.Provider, entry.Model, entry.PromptTokens, entry.CompletionTokens)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+// TotalCost returns the dollar cost of every entry recorded so far.
+func (l *ReviewCostLedger) TotalCost() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total := 0.0
+	for _, entry := range l.entries {
+		total += entry.CostUSD
+	}
+	return total
+}
+
+// Entries returns a copy of the recorded entries.
+func (l *ReviewCostLedger) Entries() []ReviewLedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ReviewLedgerEntry(nil), l.entries...)
+}
+
+// Persist writes the entries recorded since the last call to Persist.
+func (l *ReviewCostLedger) Persist(db *gorm.DB) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pending := l.entries[l.persisted:]
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := db.Create(&pending).Error; err != nil {
+		return fmt.Errorf("failed to persist review ledger entries: %w", err)
+	}
+	l.persisted = len(l.entries)
+	return nil
+}
+
+// recordModelUsage adds a model call to the workflow's cost ledger.
+func (w *CodeReviewWorkflow) recordModelUsage(prNumber int, provider, model, purpose string, promptTokens, completionTokens, retries int, failed bool) {
+	if w.costLedger == nil {
+		return
+	}
+
+	var executionId uint
+	if w.codeWorkflowExecution != nil {
+		executionId = w.codeWorkflowExecution.ID
+	}
+	var runId uint
+	if w.reviewRun != nil {
+		runId = w.reviewRun.ID
+	}
+	w.costLedger.Record(ReviewLedgerEntry{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.repoWorkflowSetting.CompanyId,
+		},
+		CodeWorkflowExecutionId: executionId,
+		ReviewRunId:             runId,
+		Owner:                   w.githubConfig.Owner,
+		Repo:                    w.githubConfig.Repo,
+		PRNumber:                prNumber,
+		CommitSHA:               w.githubConfig.CommitSHA,
+		Provider:                provider,
+		Model:                   model,
+		Purpose:                 purpose,
+		PromptTokens:            promptTokens,
+		CompletionTokens:        completionTokens,
+		Retries:                 retries,
+		Failed:                  failed,
+	})
+}
+
+// meteredModelCall wraps a raw model call function so its usage is recorded in the cost ledger.
+func (w *CodeReviewWorkflow) meteredModelCall(prNumber int, provider, model, purpose string, callFn func(string, string) (string, error)) func(string, string) (string, error) {
+	return func(contextMessage, userMessage string) (string, error) {
+		response, err := callFn(contextMessage, userMessage)
+		w.recordModelUsage(
+			prNumber,
+			provider,
+			model,
+			purpose,
+			CountTokens(contextMessage)+CountTokens(userMessage),
+			logging.EstimateTokenCount(response),
+			0,
+			err != nil,
+		)
+		return response, err
+	}
+}
+
+// meteredStep runs a model call made by code outside this package, such as comment
+// classification, and records its usage in the cost ledger. That code doesn't report its
+// tokens, so usage is estimated from the prompt and the marshaled result.
+func meteredStep[T any](w *CodeReviewWorkflow, prNumber int, provider, model, purpose, prompt string, call func() (T, error)) (T, error) {
+	result, err := call()
+	completion, _ := json.Marshal(result)
+	w.recordModelUsage(
+		prNumber,
+		provider,
+		model,
+		purpose,
+		CountTokens(prompt),
+		logging.EstimateTokenCount(string(completion)),
+		0,
+		err != nil,
+	)
+	return result, err
+}
+
+// persistCostLedger stores the run's ledger entries next to the workflow execution.
+func (w *CodeReviewWorkflow) persistCostLedger(prNumber int) {
+	if w.costLedger == nil {
+		return
+	}
+	if err := w.costLedger.Persist(w.db); err != nil {
+		logging.GetGlobalLogger().Error("Failed to persist review cost ledger",
+			zap.Error(err),
+			zap.Int("pr_number", prNumber),
+			zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+	}
+}
+
+// reviewBudgetExceeded reports whether the company has used up its per-review or monthly budget.
+func (w *CodeReviewWorkflow) reviewBudgetExceeded() (bool, string) {
+	if w.costLedger == nil {
+		return false, ""
+	}
+
+	var budget CompanyReviewBudget
+	err := w.db.Where("company_id = ?", w.repoWorkflowSetting.CompanyId).First(&budget).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, ""
+	}
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load review budget", zap.Error(err), zap.Uint("company_id", w.repoWorkflowSetting.CompanyId))
+		return false, ""
+	}
+
+	reviewCost := w.costLedger.TotalCost()
+	if budget.PerReviewUSD > 0 && reviewCost >= budget.PerReviewUSD {
+		return true, fmt.Sprintf("review cost $%.4f reached the per-review budget of $%.2f", reviewCost, budget.PerReviewUSD)
+	}
+
+	if budget.MonthlyUSD > 0 {
+		now := time.Now()
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		var monthCost float64
+		err := w.db.Model(&ReviewLedgerEntry{}).
+			Where("company_id = ? AND created_at >= ?", w.repoWorkflowSetting.CompanyId, monthStart).
+			Select("COALESCE(SUM(cost_usd), 0)").
+			Scan(&monthCost).Error
+		if err != nil {
+			logging.GetGlobalLogger().Warn("Failed to load monthly review spend", zap.Error(err), zap.Uint("company_id", w.repoWorkflowSetting.CompanyId))
+			return false, ""
+		}
+		// Entries of the current run are only persisted at the end, so add them explicitly
+		if total := monthCost + reviewCost; total >= budget.MonthlyUSD {
+			return true, fmt.Sprintf("monthly spend $%.2f reached the monthly budget of $%.2f", total, budget.MonthlyUSD)
+		}
+	}
+
+	return false, ""
+}
+
+// skipOptionalPass reports whether an optional review pass should be skipped because the budget is exhausted.
+func (w *CodeReviewWorkflow) skipOptionalPass(prNumber int, pass string) bool {
+	exceeded, reason := w.reviewBudgetExceeded()
+	if !exceeded {
+		return false
+	}
+	logging.GetGlobalLogger().Info("Skipping optional review pass, budget exceeded",
+		zap.Int("pr_number", prNumber),
+		zap.String("pass", pass),
+		zap.String("reason", reason))
+	w.AddExecutionLog(fmt.Sprintf("Skipped %s: %s", pass, reason))
+	return true
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeModelCost(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		want     float64
+	}{
+		{"longest prefix wins", "openai", "gpt-4.1-mini-2025-04-14", 0.4 + 1.6},
+		{"dated model", "anthropic", "claude-sonnet-4-20250514", 3 + 15},
+		{"local models are free", "local", "llama3.1:70b", 0},
+		{"unknown models are charged the highest price", "openai", "gpt-9", 15 + 75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeModelCost(tt.provider, tt.model, 1_000_000, 1_000_000)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("computeModelCost(%q, %q) = %v, want %v", tt.provider, tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReviewCostLedgerTotalCost(t *testing.T) {
+	ledger := NewReviewCostLedger()
+	ledger.Record(ReviewLedgerEntry{Provider: "google", Model: "gemini-2.5-flash", PromptTokens: 2_000_000, CompletionTokens: 100_000})
+	ledger.Record(ReviewLedgerEntry{Provider: "local", Model: "qwen2.5-coder", PromptTokens: 5_000_000})
+
+	if got, want := ledger.TotalCost(), 0.6+0.25; math.Abs(got-want) > 1e-9 {
+		t.Errorf("TotalCost() = %v, want %v", got, want)
+	}
+	if entries := ledger.Entries(); len(entries) != 2 || entries[1].CostUSD != 0 {
+		t.Errorf("Entries() = %+v, want two entries with a free local call", entries)
+	}
+}
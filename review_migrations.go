@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// reviewModels are the tables owned by the review workflow's features. Each feature adds its
+// models here so that MigrateReviewModels creates them.
+var reviewModels = []interface{}{
+	&ReviewProviderSetting{},
+	&ReviewMergeSetting{},
+	&ReviewLedgerEntry{},
+	&CompanyReviewBudget{},
+	&ReviewedPullRequestCommit{},
+	&RepoApprovalPolicy{},
+	&BotPullRequestReview{},
+	&ReviewIgnoreRule{},
+	&ReviewCommentFeedback{},
+	&ReviewSuppressionRule{},
+	&SecretFingerprintKey{},
+	&ReviewRun{},
+	&ReviewRunStep{},
+	&ReviewRunComment{},
+	&NotificationRoute{},
+	&Notification{},
+	&CodeIndexChunk{},
+}
+
+// companyIndex is a unique index that starts with company_id. The column comes from the embedded
+// models.SingleCompanyModel, where a struct tag can't add it to a composite index, so these
+// indexes are created by MigrateReviewModels instead of by a tag.
+type companyIndex struct {
+	model   interface{}
+	name    string
+	columns []string
+}
+
+var reviewCompanyIndexes = []companyIndex{
+	{&ReviewedPullRequestCommit{}, "idx_reviewed_pr_commit", []string{"owner", "repo", "pr_number"}},
+	{&RepoApprovalPolicy{}, "idx_repo_approval_policy", []string{"owner", "repo"}},
+	{&ReviewSuppressionRule{}, "idx_review_suppression_rule", []string{"owner", "repo", "comment_type", "path_scope"}},
+	{&SecretFingerprintKey{}, "idx_secret_fingerprint_key", nil},
+}
+
+// MigrateReviewModels creates or updates the tables of the review workflow's features. The
+// service hosting the workflow runs it at startup through StartReviewWorkflow and stops on error.
+// Nothing else creates these tables, the review and notification API of the webhook server reads
+// the ones it writes.
+func MigrateReviewModels(db *gorm.DB) error {
+	for _, model := range reviewModels {
+		if err := db.AutoMigrate(model); err != nil {
+			return fmt.Errorf("failed to migrate %T: %w", model, err)
+		}
+	}
+
+	for _, index := range reviewCompanyIndexes {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(index.model); err != nil {
+			return fmt.Errorf("failed to parse %T: %w", index.model, err)
+		}
+		columns := append([]string{"company_id"}, index.columns...)
+		sql := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", index.name, stmt.Schema.Table, strings.Join(columns, ", "))
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"code-review-bot-test-repo/pkg/notify"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Event types of review notifications, used by NotificationRoute.EventType.
+const (
+	NotificationTokenMismatch = "token_mismatch"
+	NotificationModelRetry    = "model_retry"
+	NotificationModelError    = "model_error"
+	NotificationApproval      = "approval"
+)
+
+// Sinks a NotificationRoute can deliver to.
+const (
+	// NotificationSinkSlack posts to the Slack incoming webhook in Target, or to the default
+	// workspace channel when Target is empty
+	NotificationSinkSlack = "slack"
+	// NotificationSinkWebhook posts the event as JSON to the URL in Target, signed with Secret
+	NotificationSinkWebhook = "webhook"
+	// NotificationSinkEmail emails the comma separated addresses in Target through the SMTP_* server
+	NotificationSinkEmail = "email"
+	// NotificationSinkInApp stores the event as a Notification for the dashboard
+	NotificationSinkInApp = "in_app"
+)
+
+// NotificationRouteAllEvents is the EventType of a route receiving every event type.
+const NotificationRouteAllEvents = "*"
+
+// defaultNotificationBatchWindow is how often one pull request can notify a destination about the
+// same event type, NOTIFICATION_BATCH_WINDOW overrides it. Events in between are sent as a digest.
+const defaultNotificationBatchWindow = 5 * time.Minute
+
+// NotificationRoute sends a company's events of one type to a sink. Routes for a specific event
+// type take precedence over NotificationRouteAllEvents routes, so "token mismatches to on-call
+// only" is a token_mismatch route next to a "*" route. Companies without routes get the default
+// Slack channel for everything.
+type NotificationRoute struct {
+	models.SingleCompanyModel
+	EventType string `gorm:"index" json:"event_type"`
+	Sink      string `json:"sink"`
+	Target    string `json:"target"`
+	Secret    string `json:"-"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// Notification is an event delivered to the in-app sink.
+type Notification struct {
+	models.SingleCompanyModel
+	Type    string     `gorm:"index" json:"type"`
+	Title   string     `json:"title"`
+	Message string     `json:"message"`
+	Key     string     `json:"key"`
+	Fields  string     `gorm:"type:jsonb" json:"fields"`
+	Count   int        `json:"count"`
+	ReadAt  *time.Time `json:"read_at"`
+}
+
+// DefaultNotificationBatchers rate limits every notification destination. It is shared by all
+// review runs in the process, so the limits hold across runs of the same pull request.
+var DefaultNotificationBatchers = notify.NewBatcherGroup(
+	envDuration("NOTIFICATION_BATCH_WINDOW", defaultNotificationBatchWindow),
+	func(err error) {
+		logging.GetGlobalLogger().Error("Failed to deliver batched notification", zap.Error(err))
+	},
+)
+
+// notify sends the event to the destinations routed for the workflow's company. Delivery happens
+// in the background so a slow sink can't hold up the review.
+func (w *CodeReviewWorkflow) notify(prNumber int, event notify.Event) {
+	event.CompanyId = w.repoWorkflowSetting.CompanyId
+	event.Key = fmt.Sprintf("%s/%s#%d", w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	event.Time = time.Now()
+
+	for _, route := range w.notificationRoutes(event.Type) {
+		notifier, err := notificationNotifier(w.db, route)
+		if err != nil {
+			logging.GetGlobalLogger().Warn("Skipping invalid notification route",
+				zap.Error(err),
+				zap.Uint("route_id", route.ID),
+				zap.Uint("company_id", event.CompanyId))
+			continue
+		}
+		destination := fmt.Sprintf("%d:%s:%s:%s", route.ID, route.Sink, route.Target, route.Secret)
+		batcher := DefaultNotificationBatchers.Get(destination, func() notify.Notifier { return notifier })
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := batcher.Notify(ctx, event); err != nil {
+				logging.GetGlobalLogger().Error("Failed to deliver notification",
+					zap.Error(err),
+					zap.String("type", event.Type),
+					zap.String("sink", route.Sink),
+					zap.Int("pr_number", prNumber))
+			}
+		}()
+	}
+}
+
+// notificationRoutes returns the company's enabled routes for the event type.
+func (w *CodeReviewWorkflow) notificationRoutes(eventType string) []NotificationRoute {
+	var routes []NotificationRoute
+	if err := w.db.Where("company_id = ? AND enabled = ?", w.repoWorkflowSetting.CompanyId, true).Find(&routes).Error; err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load notification routes, using the default channel",
+			zap.Error(err),
+			zap.Uint("company_id", w.repoWorkflowSetting.CompanyId))
+		routes = nil
+	}
+	if len(routes) == 0 {
+		return []NotificationRoute{{EventType: NotificationRouteAllEvents, Sink: NotificationSinkSlack, Enabled: true}}
+	}
+
+	var specific, catchAll []NotificationRoute
+	for _, route := range routes {
+		switch route.EventType {
+		case eventType:
+			specific = append(specific, route)
+		case NotificationRouteAllEvents:
+			catchAll = append(catchAll, route)
+		}
+	}
+	if len(specific) > 0 {
+		return specific
+	}
+	return catchAll
+}
+
+// notificationNotifier creates the notifier delivering to the route's sink.
+func notificationNotifier(db *gorm.DB, route NotificationRoute) (notify.Notifier, error) {
+	switch route.Sink {
+	case NotificationSinkSlack:
+		if route.Target == "" {
+			return notify.NotifierFunc(func(ctx context.Context, event notify.Event) error {
+				SendSlackNotification(1, event.Text())
+				return nil
+			}), nil
+		}
+		return &notify.SlackNotifier{WebhookURL: route.Target}, nil
+	case NotificationSinkWebhook:
+		if route.Target == "" {
+			return nil, fmt.Errorf("webhook route has no url")
+		}
+		return &notify.WebhookNotifier{URL: route.Target, Secret: route.Secret}, nil
+	case NotificationSinkEmail:
+		var recipients []string
+		for _, address := range strings.Split(route.Target, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				recipients = append(recipients, address)
+			}
+		}
+		if len(recipients) == 0 {
+			return nil, fmt.Errorf("email route has no recipients")
+		}
+		return &notify.SMTPNotifier{
+			Config: notify.SMTPConfig{
+				Addr:     os.Getenv("SMTP_ADDR"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     os.Getenv("SMTP_FROM"),
+			},
+			To: recipients,
+		}, nil
+	case NotificationSinkInApp:
+		return notify.NotifierFunc(func(ctx context.Context, event notify.Event) error {
+			return storeNotification(db, event)
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown notification sink %q", route.Sink)
+}
+
+// storeNotification records the event for the in-app sink.
+func storeNotification(db *gorm.DB, event notify.Event) error {
+	fields, err := json.Marshal(event.Fields)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification fields: %w", err)
+	}
+	count := event.Count
+	if count == 0 {
+		count = 1
+	}
+	notification := &Notification{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: event.CompanyId,
+		},
+		Type:    event.Type,
+		Title:   event.Title,
+		Message: event.Message,
+		Key:     event.Key,
+		Fields:  string(fields),
+		Count:   count,
+	}
+	if err := db.Create(notification).Error; err != nil {
+		return fmt.Errorf("failed to store notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"strings"
+
+	"code-review-bot-test-repo/pkg/reviewconfig"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// featureOwnerMentions enables mentioning the CODEOWNERS of a file on blocker comments.
+const featureOwnerMentions = "owner_mentions"
+
+// fileInstruction is what the repository asks reviewers to focus on for one file.
+type fileInstruction struct {
+	Personas     []string `json:"personas,omitempty"`
+	Instructions []string `json:"instructions,omitempty"`
+}
+
+// fileInstructions returns the path instructions from the repository's review config for each
+// file that matches any.
+func (w *CodeReviewWorkflow) fileInstructions(files []clients.PullRequestFile) map[string]fileInstruction {
+	instructions := map[string]fileInstruction{}
+	if w.repoConfig == nil {
+		return instructions
+	}
+	for _, file := range files {
+		var instruction fileInstruction
+		for _, rule := range w.repoConfig.InstructionsFor(file.Filename) {
+			if persona := strings.TrimSpace(rule.Persona); persona != "" {
+				instruction.Personas = append(instruction.Personas, persona)
+			}
+			if text := strings.TrimSpace(rule.Instructions); text != "" {
+				instruction.Instructions = append(instruction.Instructions, text)
+			}
+		}
+		if len(instruction.Personas) > 0 || len(instruction.Instructions) > 0 {
+			instructions[file.Filename] = instruction
+		}
+	}
+	return instructions
+}
+
+// loadCodeOwners reads the CODEOWNERS file from the base branch, or returns nil if the repository
+// doesn't have one.
+func (w *CodeReviewWorkflow) loadCodeOwners(baseSHA string) *reviewconfig.CodeOwners {
+	for _, path := range reviewconfig.CodeOwnersPaths {
+		content, err := w.githubConfig.Client.GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, path, baseSHA)
+		if err != nil {
+			continue
+		}
+		logging.GetGlobalLogger().Debug("Loaded code owners",
+			zap.String("path", path),
+			zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+		return reviewconfig.ParseCodeOwners(content)
+	}
+	return nil
+}
+
+// mentionOwnersOnBlockers mentions the owners of the file on each blocker comment so the people
+// who know the code see the problem.
+func mentionOwnersOnBlockers(comments []*InternalReviewComment, codeOwners *reviewconfig.CodeOwners) {
+	for _, comment := range comments {
+		if comment.Severity != SeverityBlocker {
+			continue
+		}
+		var mentions []string
+		for _, owner := range codeOwners.Owners(comment.Path) {
+			// Owners can also be email addresses, which can't be mentioned
+			if strings.HasPrefix(owner, "@") && !strings.Contains(comment.Body, owner) {
+				mentions = append(mentions, owner)
+			}
+		}
+		if len(mentions) > 0 {
+			comment.Body += "\n\n<sub>cc " + strings.Join(mentions, " ") + " (code owners)</sub>"
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code-review-bot-test-repo/pkg/reviewconfig"
+	"code-review-bot-test-repo/pkg/tracing"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"github.com/tonyd3/propel-gtm/api/types"
+	"go.uber.org/zap"
+)
+
+// ReviewProvider is a model backend that can review a pull request.
+type ReviewProvider interface {
+	// Name is the stable key stored on comments and used in configuration, e.g. "anthropic".
+	Name() string
+	// DisplayName is the human readable name used in logs and errors, e.g. "Anthropic".
+	DisplayName() string
+	// Model is the model identifier recorded on the generated comments.
+	Model() string
+	// Call sends the system and user messages and returns the raw completion.
+	Call(contextMessage, userMessage string) (string, error)
+}
+
+// ProviderRegistration describes a provider and its defaults in a ProviderRegistry.
+type ProviderRegistration struct {
+	Name            string
+	DefaultPriority int
+	DefaultWeight   float64
+	// DefaultEnabled decides whether the provider participates when the company has no override.
+	DefaultEnabled func(w *CodeReviewWorkflow) bool
+	// Factory binds the provider to the workflow's AI configuration. It returns nil when the
+	// provider cannot be used, e.g. because it is missing credentials.
+	Factory func(w *CodeReviewWorkflow) ReviewProvider
+	// DefaultFallbacks are the providers tried, in order, when this one fails or its circuit
+	// breaker is open.
+	DefaultFallbacks []string
+}
+
+// ReviewProviderSetting overrides a registered provider's defaults for a single company.
+type ReviewProviderSetting struct {
+	models.SingleCompanyModel
+	Provider string  `gorm:"index" json:"provider"`
+	Enabled  bool    `json:"enabled"`
+	Priority int     `json:"priority"`
+	Weight   float64 `json:"weight"`
+	// Fallbacks is a comma separated list of providers replacing the default fallback chain,
+	// "none" disables falling back.
+	Fallbacks string `json:"fallbacks"`
+}
+
+// activeProvider is a provider resolved for one review run along with its effective settings.
+type activeProvider struct {
+	ReviewProvider
+	priority  int
+	weight    float64
+	fallbacks []string
+}
+
+// ProviderRegistry holds the review providers available to the code review workflow.
+type ProviderRegistry struct {
+	mu            sync.RWMutex
+	registrations map[string]ProviderRegistration
+}
+
+// NewProviderRegistry creates an empty ProviderRegistry.
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{registrations: map[string]ProviderRegistration{}}
+}
+
+// Register adds a provider to the registry. Registering the same name twice is an error.
+func (r *ProviderRegistry) Register(registration ProviderRegistration) error {
+	if registration.Name == "" {
+		return fmt.Errorf("provider name is required")
+	}
+	if registration.Factory == nil {
+		return fmt.Errorf("provider %s has no factory", registration.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.registrations[registration.Name]; exists {
+		return fmt.Errorf("provider %s is already registered", registration.Name)
+	}
+	r.registrations[registration.Name] = registration
+	return nil
+}
+
+// Registrations returns all registered providers sorted by name.
+func (r *ProviderRegistry) Registrations() []ProviderRegistration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	registrations := make([]ProviderRegistration, 0, len(r.registrations))
+	for _, registration := range r.registrations {
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations
+}
+
+// Registration returns the registered provider with the name.
+func (r *ProviderRegistry) Registration(name string) (ProviderRegistration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	registration, ok := r.registrations[name]
+	return registration, ok
+}
+
+// DefaultProviderRegistry is the registry used by callMultipleAIModels.
+var DefaultProviderRegistry = newDefaultProviderRegistry()
+
+// RegisterReviewProvider adds a provider to the DefaultProviderRegistry.
+func RegisterReviewProvider(registration ProviderRegistration) error {
+	return DefaultProviderRegistry.Register(registration)
+}
+
+func newDefaultProviderRegistry() *ProviderRegistry {
+	registry := NewProviderRegistry()
+	for _, registration := range []ProviderRegistration{
+		{
+			Name:            "anthropic",
+			DefaultPriority: 0,
+			DefaultWeight:   1.0,
+			DefaultEnabled:  func(w *CodeReviewWorkflow) bool { return true },
+			Factory: func(w *CodeReviewWorkflow) ReviewProvider {
+				return &modelCallProvider{
+					name:        "anthropic",
+					displayName: "Anthropic",
+					model:       string(w.aiConfig.GetAnthropicModel()),
+					callFn:      w.aiConfig.CallAnthropicContext,
+					streamFn:    w.aiConfig.StreamAnthropicContext,
+				}
+			},
+			DefaultFallbacks: []string{"openai", "local"},
+		},
+		{
+			Name:            "openai",
+			DefaultPriority: 10,
+			DefaultWeight:   1.0,
+			DefaultEnabled: func(w *CodeReviewWorkflow) bool {
+				return models.IsFeatureEnabledForCompany(w.db, string(types.AddOpenAIResults), w.repoWorkflowSetting.CompanyId)
+			},
+			Factory: func(w *CodeReviewWorkflow) ReviewProvider {
+				return &modelCallProvider{
+					name:        "openai",
+					displayName: "OpenAI",
+					model:       w.aiConfig.GetOpenAIModel(),
+					callFn:      w.aiConfig.CallOpenAIContext,
+					streamFn:    w.aiConfig.StreamOpenAIContext,
+				}
+			},
+			DefaultFallbacks: []string{"anthropic", "local"},
+		},
+		{
+			Name:            "google",
+			DefaultPriority: 20,
+			DefaultWeight:   1.0,
+			DefaultEnabled: func(w *CodeReviewWorkflow) bool {
+				return models.IsFeatureEnabledForCompany(w.db, string(types.AddGeminiResults), w.repoWorkflowSetting.CompanyId)
+			},
+			Factory: func(w *CodeReviewWorkflow) ReviewProvider {
+				return &modelCallProvider{
+					name:        "google",
+					displayName: "Gemini",
+					model:       w.aiConfig.GetGeminiModel(),
+					callFn:      w.aiConfig.CallGeminiContext,
+					streamFn:    w.aiConfig.StreamGeminiContext,
+				}
+			},
+			DefaultFallbacks: []string{"anthropic", "openai"},
+		},
+		{
+			Name:            "local",
+			DefaultPriority: 30,
+			DefaultWeight:   0.5,
+			DefaultEnabled:  func(w *CodeReviewWorkflow) bool { return os.Getenv("LOCAL_LLM_BASE_URL") != "" },
+			Factory: func(w *CodeReviewWorkflow) ReviewProvider {
+				if provider := newLocalOpenAICompatibleProvider(); provider != nil {
+					return provider
+				}
+				return nil
+			},
+		},
+	} {
+		// The registrations above are fixed, an error here is a programming mistake
+		if err := registry.Register(registration); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// resolveReviewProviders returns the providers enabled for the workflow's company, ordered by merge priority.
+func (w *CodeReviewWorkflow) resolveReviewProviders() []activeProvider {
+	companyId := w.repoWorkflowSetting.CompanyId
+
+	overrides := map[string]ReviewProviderSetting{}
+	var settings []ReviewProviderSetting
+	if err := w.db.Where("company_id = ?", companyId).Find(&settings).Error; err != nil {
+		logging.GetGlobalLogger().Warn("Failed to load review provider settings, using defaults",
+			zap.Error(err),
+			zap.Uint("company_id", companyId))
+	}
+	for _, setting := range settings {
+		overrides[setting.Provider] = setting
+	}
+
+	var providers []activeProvider
+	for _, registration := range DefaultProviderRegistry.Registrations() {
+		enabled := registration.DefaultEnabled != nil && registration.DefaultEnabled(w)
+		priority := registration.DefaultPriority
+		weight := registration.DefaultWeight
+		fallbacks := registration.DefaultFallbacks
+		if setting, ok := overrides[registration.Name]; ok {
+			enabled = setting.Enabled
+			priority = setting.Priority
+			if setting.Weight > 0 {
+				weight = setting.Weight
+			}
+			if setting.Fallbacks != "" {
+				fallbacks = parseProviderFallbacks(setting.Fallbacks)
+			}
+		}
+		if !enabled || !repoConfigAllowsProvider(w.repoConfig, registration.Name) {
+			continue
+		}
+
+		provider := w.bindReviewProvider(registration)
+		if provider == nil {
+			logging.GetGlobalLogger().Warn("Review provider is enabled but unavailable",
+				zap.String("provider", registration.Name),
+				zap.Uint("company_id", companyId))
+			continue
+		}
+		providers = append(providers, activeProvider{ReviewProvider: provider, priority: priority, weight: weight, fallbacks: fallbacks})
+	}
+
+	sort.SliceStable(providers, func(i, j int) bool {
+		return providers[i].priority < providers[j].priority
+	})
+	return providers
+}
+
+// repoConfigAllowsProvider reports whether the repository's review config keeps the provider. A
+// config listing providers narrows the ones the company enabled, it can't enable the others.
+func repoConfigAllowsProvider(config *reviewconfig.Config, name string) bool {
+	if config == nil || len(config.Providers) == 0 {
+		return true
+	}
+	for _, provider := range config.Providers {
+		if provider == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bindReviewProvider creates the registered provider for the workflow, or returns nil when it's unavailable.
+func (w *CodeReviewWorkflow) bindReviewProvider(registration ProviderRegistration) ReviewProvider {
+	provider := registration.Factory(w)
+	if provider == nil {
+		return nil
+	}
+	if w.recorder != nil {
+		provider = recordProvider(provider, w.recorder)
+	}
+	return provider
+}
+
+// modelCallProvider adapts one of the aiConfig Call*Context functions, and optionally its
+// Stream*Context counterpart, to the ReviewProvider interface.
+type modelCallProvider struct {
+	name        string
+	displayName string
+	model       string
+	callFn      func(context.Context, string, string) (string, error)
+	streamFn    func(context.Context, string, string, func(string)) (string, error)
+}
+
+func (p *modelCallProvider) Name() string        { return p.name }
+func (p *modelCallProvider) DisplayName() string { return p.displayName }
+func (p *modelCallProvider) Model() string       { return p.model }
+
+func (p *modelCallProvider) Call(contextMessage, userMessage string) (string, error) {
+	return p.CallContext(context.Background(), contextMessage, userMessage)
+}
+
+// CallContext implements ContextReviewProvider, ctx is passed to the vendor SDK's request.
+func (p *modelCallProvider) CallContext(ctx context.Context, contextMessage, userMessage string) (string, error) {
+	return p.callFn(ctx, contextMessage, userMessage)
+}
+
+func (p *modelCallProvider) Stream(contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	return p.StreamContext(context.Background(), contextMessage, userMessage, onDelta)
+}
+
+// StreamContext implements ContextStreamingReviewProvider, ctx is passed to the vendor SDK's request.
+func (p *modelCallProvider) StreamContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	if p.streamFn == nil {
+		// Without a streaming endpoint the whole completion arrives as a single chunk
+		message, err := p.callFn(ctx, contextMessage, userMessage)
+		if err == nil {
+			onDelta(message)
+		}
+		return message, err
+	}
+	return p.streamFn(ctx, contextMessage, userMessage, onDelta)
+}
+
+// localOpenAICompatibleProvider talks to any server exposing the OpenAI chat completions API,
+// such as a local model runner used as a stand-in during development.
+type localOpenAICompatibleProvider struct {
+	baseURL    string
+	model      string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newLocalOpenAICompatibleProvider() *localOpenAICompatibleProvider {
+	baseURL := strings.TrimRight(os.Getenv("LOCAL_LLM_BASE_URL"), "/")
+	if baseURL == "" {
+		return nil
+	}
+	model := os.Getenv("LOCAL_LLM_MODEL")
+	if model == "" {
+		model = "local-model"
+	}
+	return &localOpenAICompatibleProvider{
+		baseURL:    baseURL,
+		model:      model,
+		apiKey:     os.Getenv("LOCAL_LLM_API_KEY"),
+		httpClient: &http.Client{Timeout: 10 * time.Minute, Transport: &tracing.Transport{}},
+	}
+}
+
+func (p *localOpenAICompatibleProvider) Name() string        { return "local" }
+func (p *localOpenAICompatibleProvider) DisplayName() string { return "Local" }
+func (p *localOpenAICompatibleProvider) Model() string       { return p.model }
+
+type chatCompletionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	Model       string                  `json:"model"`
+	Messages    []chatCompletionMessage `json:"messages"`
+	Temperature float64                 `json:"temperature"`
+	Stream      bool                    `json:"stream,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatCompletionMessage `json:"message"`
+	} `json:"choices"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta chatCompletionMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// newRequest builds a chat completions request for the given messages.
+func (p *localOpenAICompatibleProvider) newRequest(ctx context.Context, contextMessage, userMessage string, stream bool) (*http.Request, error) {
+	payload, err := json.Marshal(chatCompletionRequest{
+		Model: p.model,
+		Messages: []chatCompletionMessage{
+			{Role: "system", Content: contextMessage},
+			{Role: "user", Content: userMessage},
+		},
+		Stream: stream,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chat completion request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat completion request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	return req, nil
+}
+
+func (p *localOpenAICompatibleProvider) Call(contextMessage, userMessage string) (string, error) {
+	return p.CallContext(context.Background(), contextMessage, userMessage)
+}
+
+// CallContext implements ContextReviewProvider, the request is aborted when ctx is done.
+func (p *localOpenAICompatibleProvider) CallContext(ctx context.Context, contextMessage, userMessage string) (string, error) {
+	req, err := p.newRequest(ctx, contextMessage, userMessage, false)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call local model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read local model response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("local model returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return "", fmt.Errorf("failed to decode local model response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("local model returned no choices")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// Stream reads the server-sent events of a streaming chat completion.
+func (p *localOpenAICompatibleProvider) Stream(contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	return p.StreamContext(context.Background(), contextMessage, userMessage, onDelta)
+}
+
+// StreamContext implements ContextStreamingReviewProvider, the stream is aborted when ctx is done.
+func (p *localOpenAICompatibleProvider) StreamContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	req, err := p.newRequest(ctx, contextMessage, userMessage, true)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call local model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("local model returned status %d", resp.StatusCode)
+		}
+		return "", fmt.Errorf("local model returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var message strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			return message.String(), nil
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return message.String(), fmt.Errorf("failed to decode local model stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		message.WriteString(chunk.Choices[0].Delta.Content)
+		onDelta(chunk.Choices[0].Delta.Content)
+	}
+	if err := scanner.Err(); err != nil {
+		return message.String(), fmt.Errorf("local model stream interrupted: %w", err)
+	}
+	return message.String(), nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code-review-bot-test-repo/pkg/reviewconfig"
+)
+
+// stubProvider is a ReviewProvider returning a fixed completion.
+type stubProvider struct {
+	name     string
+	response string
+	err      error
+	calls    int
+}
+
+func (p *stubProvider) Name() string        { return p.name }
+func (p *stubProvider) DisplayName() string { return p.name }
+func (p *stubProvider) Model() string       { return p.name + "-model" }
+
+func (p *stubProvider) Call(contextMessage, userMessage string) (string, error) {
+	p.calls++
+	return p.response, p.err
+}
+
+func stubRegistration(name string) ProviderRegistration {
+	return ProviderRegistration{
+		Name:    name,
+		Factory: func(w *CodeReviewWorkflow) ReviewProvider { return &stubProvider{name: name} },
+	}
+}
+
+func TestProviderRegistryRegister(t *testing.T) {
+	registry := NewProviderRegistry()
+	if err := registry.Register(stubRegistration("beta")); err != nil {
+		t.Fatalf("Register(beta) = %v", err)
+	}
+	if err := registry.Register(stubRegistration("alpha")); err != nil {
+		t.Fatalf("Register(alpha) = %v", err)
+	}
+
+	if err := registry.Register(stubRegistration("alpha")); err == nil {
+		t.Error("registering alpha twice succeeded")
+	}
+	if err := registry.Register(ProviderRegistration{Factory: stubRegistration("x").Factory}); err == nil {
+		t.Error("registering a provider without a name succeeded")
+	}
+	if err := registry.Register(ProviderRegistration{Name: "nofactory"}); err == nil {
+		t.Error("registering a provider without a factory succeeded")
+	}
+
+	registrations := registry.Registrations()
+	if len(registrations) != 2 || registrations[0].Name != "alpha" || registrations[1].Name != "beta" {
+		t.Fatalf("Registrations() = %v, want alpha and beta sorted by name", registrations)
+	}
+	if _, ok := registry.Registration("beta"); !ok {
+		t.Error("Registration(beta) not found")
+	}
+	if _, ok := registry.Registration("gamma"); ok {
+		t.Error("Registration(gamma) found")
+	}
+}
+
+func TestDefaultProviderRegistryHasVendorProviders(t *testing.T) {
+	for _, name := range []string{"anthropic", "openai", "google", "local"} {
+		if _, ok := DefaultProviderRegistry.Registration(name); !ok {
+			t.Errorf("default registry is missing %s", name)
+		}
+	}
+}
+
+func TestRepoConfigNarrowsProviders(t *testing.T) {
+	if !repoConfigAllowsProvider(nil, "openai") || !repoConfigAllowsProvider(&reviewconfig.Config{}, "openai") {
+		t.Error("a repository without providers in its config disabled a provider")
+	}
+	config := &reviewconfig.Config{Providers: []string{"anthropic"}}
+	if !repoConfigAllowsProvider(config, "anthropic") {
+		t.Error("the provider listed by the repository was dropped")
+	}
+	if repoConfigAllowsProvider(config, "openai") {
+		t.Error("a provider the repository doesn't list was kept")
+	}
+}
+
+type contextKey struct{}
+
+func TestModelCallProviderPassesContext(t *testing.T) {
+	var got context.Context
+	provider := &modelCallProvider{
+		name: "anthropic",
+		callFn: func(ctx context.Context, contextMessage, userMessage string) (string, error) {
+			got = ctx
+			return "[]", nil
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "review")
+	var deltas []string
+	if message, err := provider.StreamContext(ctx, "system", "diff", func(delta string) { deltas = append(deltas, delta) }); err != nil || message != "[]" {
+		t.Fatalf("StreamContext() = %q, %v", message, err)
+	}
+	if got == nil || got.Value(contextKey{}) != "review" {
+		t.Error("StreamContext() didn't pass its context to the model call")
+	}
+	if len(deltas) != 1 || deltas[0] != "[]" {
+		t.Errorf("deltas = %q, want the completion as a single delta", deltas)
+	}
+}
+
+func TestCallProviderContextWhenDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	provider := &stubProvider{name: "legacy", response: "[]"}
+	if _, err := callProviderContext(ctx, provider, "system", "diff"); !errors.Is(err, context.Canceled) {
+		t.Errorf("callProviderContext() error = %v, want context.Canceled", err)
+	}
+	if provider.calls != 0 {
+		t.Errorf("provider called %d times after the context was done", provider.calls)
+	}
+}
+
+func TestLocalProviderAbortsRequest(t *testing.T) {
+	// The model never answers, until the test is over
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	t.Setenv("LOCAL_LLM_BASE_URL", server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := newLocalOpenAICompatibleProvider().CallContext(ctx, "system", "diff"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CallContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CallContext() returned after %v, want it aborted at the deadline", elapsed)
+	}
+}
@@ -0,0 +1,586 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code-review-bot-test-repo/pkg/codeindex"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// ReplayMode selects whether a ReviewRecorder captures live traffic or serves it from fixtures.
+type ReplayMode string
+
+const (
+	ReplayModeRecord ReplayMode = "record"
+	ReplayModeReplay ReplayMode = "replay"
+)
+
+// replayManifestFile holds the arguments of the recorded ReviewPullRequest run.
+const replayManifestFile = "run.json"
+
+// ErrReplayFixtureMissing is returned in replay mode when a call has no recorded interaction.
+var ErrReplayFixtureMissing = errors.New("no recorded interaction for call")
+
+// recordedInteraction is a single captured request/response pair stored in the fixture directory.
+type recordedInteraction struct {
+	Kind     string          `json:"kind"`
+	Name     string          `json:"name"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// replayManifest describes a recorded run so it can be repeated with the same arguments.
+type replayManifest struct {
+	Owner                  string    `json:"owner"`
+	Repo                   string    `json:"repo"`
+	PRNumber               int       `json:"pr_number"`
+	CheckIfAlreadyApproved bool      `json:"check_if_already_approved"`
+	CheckExistingComments  bool      `json:"check_existing_comments"`
+	RecordedAt             time.Time `json:"recorded_at"`
+}
+
+// ReviewRecorder captures GitHub and model interactions of a review run into a fixture
+// directory, or serves them back from it so the run can be repeated offline.
+//
+// Interactions are keyed by their kind, name and request payload plus an occurrence counter,
+// so replay does not depend on the order in which concurrent calls are made.
+type ReviewRecorder struct {
+	mode     ReplayMode
+	dir      string
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+// NewReviewRecorder creates a recorder for the given mode and fixture directory.
+func NewReviewRecorder(mode ReplayMode, dir string) (*ReviewRecorder, error) {
+	switch mode {
+	case ReplayModeRecord:
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create fixture directory %s: %w", dir, err)
+		}
+	case ReplayModeReplay:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("fixture directory %s is not readable: %w", dir, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown replay mode %q", mode)
+	}
+	return &ReviewRecorder{mode: mode, dir: dir, counters: map[string]int{}}, nil
+}
+
+// fixturePath returns the file for the next occurrence of a call with the given request.
+func (r *ReviewRecorder) fixturePath(kind, name string, request []byte) string {
+	sum := sha256.Sum256(append([]byte(kind+"\x00"+name+"\x00"), request...))
+	key := fmt.Sprintf("%s.%s.%s", kind, name, hex.EncodeToString(sum[:])[:12])
+
+	r.mu.Lock()
+	occurrence := r.counters[key]
+	r.counters[key]++
+	r.mu.Unlock()
+
+	return filepath.Join(r.dir, fmt.Sprintf("%s.%d.json", key, occurrence))
+}
+
+// recordCall runs call in record mode and stores its result, or loads the stored result in replay mode.
+func recordCall[T any](r *ReviewRecorder, kind, name string, request interface{}, call func() (T, error)) (T, error) {
+	var result T
+
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return result, fmt.Errorf("failed to marshal %s.%s request: %w", kind, name, err)
+	}
+	path := r.fixturePath(kind, name, requestJSON)
+
+	if r.mode == ReplayModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return result, fmt.Errorf("%w %s.%s (%s): %v", ErrReplayFixtureMissing, kind, name, filepath.Base(path), err)
+		}
+		var interaction recordedInteraction
+		if err := json.Unmarshal(data, &interaction); err != nil {
+			return result, fmt.Errorf("failed to decode fixture %s: %w", path, err)
+		}
+		if len(interaction.Response) > 0 {
+			if err := json.Unmarshal(interaction.Response, &result); err != nil {
+				return result, fmt.Errorf("failed to decode recorded response in %s: %w", path, err)
+			}
+		}
+		if interaction.Error != "" {
+			return result, errors.New(interaction.Error)
+		}
+		return result, nil
+	}
+
+	result, callErr := call()
+
+	interaction := recordedInteraction{Kind: kind, Name: name, Request: requestJSON}
+	if responseJSON, err := json.Marshal(result); err != nil {
+		logging.GetGlobalLogger().Warn("Failed to marshal response for recording",
+			zap.Error(err),
+			zap.String("call", kind+"."+name))
+	} else {
+		interaction.Response = responseJSON
+	}
+	if callErr != nil {
+		interaction.Error = callErr.Error()
+	}
+
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to marshal recorded interaction", zap.Error(err), zap.String("call", kind+"."+name))
+	} else if err := os.WriteFile(path, data, 0644); err != nil {
+		logging.GetGlobalLogger().Warn("Failed to write recorded interaction", zap.Error(err), zap.String("path", path))
+	}
+
+	return result, callErr
+}
+
+// recordedProvider records or replays the completions of a ReviewProvider.
+type recordedProvider struct {
+	ReviewProvider
+	recorder *ReviewRecorder
+}
+
+// recordProvider wraps the provider so its completions are captured by the recorder. Streaming
+// providers stay streaming so that replay runs the same code path as production.
+func recordProvider(provider ReviewProvider, recorder *ReviewRecorder) ReviewProvider {
+	recorded := &recordedProvider{ReviewProvider: provider, recorder: recorder}
+	if streamer, ok := provider.(StreamingReviewProvider); ok {
+		return &recordedStreamingProvider{recordedProvider: recorded, streamer: streamer}
+	}
+	return recorded
+}
+
+func (p *recordedProvider) Call(contextMessage, userMessage string) (string, error) {
+	return p.CallContext(context.Background(), contextMessage, userMessage)
+}
+
+// CallContext implements ContextReviewProvider, aborting the live call when ctx is done.
+func (p *recordedProvider) CallContext(ctx context.Context, contextMessage, userMessage string) (string, error) {
+	request := map[string]string{"model": p.Model(), "context_message": contextMessage, "user_message": userMessage}
+	return recordCall(p.recorder, "model", p.Name(), request, func() (string, error) {
+		if contextProvider, ok := p.ReviewProvider.(ContextReviewProvider); ok {
+			return contextProvider.CallContext(ctx, contextMessage, userMessage)
+		}
+		return p.ReviewProvider.Call(contextMessage, userMessage)
+	})
+}
+
+// recordedStreamingProvider records or replays the completions of a StreamingReviewProvider. The
+// recorded completion is replayed as a single delta.
+type recordedStreamingProvider struct {
+	*recordedProvider
+	streamer StreamingReviewProvider
+}
+
+func (p *recordedStreamingProvider) Stream(contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	return p.StreamContext(context.Background(), contextMessage, userMessage, onDelta)
+}
+
+// StreamContext implements ContextStreamingReviewProvider, aborting the live stream when ctx is done.
+func (p *recordedStreamingProvider) StreamContext(ctx context.Context, contextMessage, userMessage string, onDelta func(string)) (string, error) {
+	request := map[string]string{"model": p.Model(), "context_message": contextMessage, "user_message": userMessage}
+	replaying := p.recorder.mode == ReplayModeReplay
+	message, err := recordCall(p.recorder, "model_stream", p.Name(), request, func() (string, error) {
+		if contextStreamer, ok := p.streamer.(ContextStreamingReviewProvider); ok {
+			return contextStreamer.StreamContext(ctx, contextMessage, userMessage, onDelta)
+		}
+		return p.streamer.Stream(contextMessage, userMessage, onDelta)
+	})
+	if replaying && message != "" {
+		onDelta(message)
+	}
+	return message, err
+}
+
+// recordStep runs a call made through code outside this package that talks to GitHub or a model
+// itself, such as the context builders, so that replay serves its result instead of running it.
+// Without a recorder the call runs unchanged.
+func recordStep[T any](w *CodeReviewWorkflow, kind, name string, request interface{}, call func() (T, error)) (T, error) {
+	if w.recorder == nil {
+		return call()
+	}
+	return recordCall(w.recorder, kind, name, request, call)
+}
+
+// extractAllFileChanges runs ExtractAllFileChanges through the recorder, it reads file contents
+// from GitHub when the token budget allows.
+func (w *CodeReviewWorkflow) extractAllFileChanges(files []clients.PullRequestFile, tokenBudget int) []FileChange {
+	filenames := make([]string, len(files))
+	for i, file := range files {
+		filenames[i] = file.Filename
+	}
+	request := map[string]interface{}{"owner": w.githubConfig.Owner, "repo": w.githubConfig.Repo, "commit": w.githubConfig.CommitSHA, "files": filenames, "token_budget": tokenBudget}
+	fileChanges, err := recordStep(w, "github", "ExtractAllFileChanges", request, func() ([]FileChange, error) {
+		return ExtractAllFileChanges(w.githubConfig, files, tokenBudget), nil
+	})
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Failed to replay file changes", zap.Error(err))
+	}
+	return fileChanges
+}
+
+// recordedEmbedder records or replays the embeddings of a codeindex.Embedder.
+type recordedEmbedder struct {
+	codeindex.Embedder
+	recorder *ReviewRecorder
+}
+
+func (e *recordedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return recordCall(e.recorder, "embedding", e.Name(), texts, func() ([][]float32, error) {
+		return e.Embedder.Embed(ctx, texts)
+	})
+}
+
+// recordEmbedder wraps the embedder so its embeddings are captured by the workflow's recorder.
+// Without a recorder, or without an embedder, it is returned unchanged.
+func (w *CodeReviewWorkflow) recordEmbedder(embedder codeindex.Embedder) codeindex.Embedder {
+	if w.recorder == nil || embedder == nil {
+		return embedder
+	}
+	return &recordedEmbedder{Embedder: embedder, recorder: w.recorder}
+}
+
+// recordModelCall wraps a raw model call function so it is captured by the workflow's recorder.
+// Without a recorder the function is returned unchanged.
+func (w *CodeReviewWorkflow) recordModelCall(name string, callFn func(string, string) (string, error)) func(string, string) (string, error) {
+	if w.recorder == nil {
+		return callFn
+	}
+	return func(contextMessage, userMessage string) (string, error) {
+		request := map[string]string{"context_message": contextMessage, "user_message": userMessage}
+		return recordCall(w.recorder, "model", name, request, func() (string, error) {
+			return callFn(contextMessage, userMessage)
+		})
+	}
+}
+
+// recordedGitHubClient records or replays the GitHub calls made by the workflow. Credentials are
+// never written to the fixtures.
+//
+// In replay mode the embedded client is an offlineGitHubClient, so a call to a method without a
+// wrapper below returns an error instead of reaching GitHub. New client calls in the workflow need
+// a wrapper here.
+type recordedGitHubClient struct {
+	clients.GitHubClient
+	// live is the client that was wrapped, kept so that EnableReplay doesn't wrap twice
+	live     clients.GitHubClient
+	recorder *ReviewRecorder
+}
+
+type pullRequestRef struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	PRNumber int    `json:"pr_number"`
+}
+
+func (c *recordedGitHubClient) GetPullRequestDetails(token, owner, repo string, prNumber int) (*clients.PullRequestDetails, error) {
+	return recordCall(c.recorder, "github", "GetPullRequestDetails", pullRequestRef{owner, repo, prNumber}, func() (*clients.PullRequestDetails, error) {
+		return c.live.GetPullRequestDetails(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) GetPullRequestFiles(token, owner, repo string, prNumber int) ([]clients.PullRequestFile, error) {
+	return recordCall(c.recorder, "github", "GetPullRequestFiles", pullRequestRef{owner, repo, prNumber}, func() ([]clients.PullRequestFile, error) {
+		return c.live.GetPullRequestFiles(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) GetPullRequestReviews(token, owner, repo string, prNumber int) ([]clients.PullRequestReview, error) {
+	return recordCall(c.recorder, "github", "GetPullRequestReviews", pullRequestRef{owner, repo, prNumber}, func() ([]clients.PullRequestReview, error) {
+		return c.live.GetPullRequestReviews(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) GetPullRequestReviewComments(token, owner, repo string, prNumber int) ([]clients.PullRequestComment, error) {
+	return recordCall(c.recorder, "github", "GetPullRequestReviewComments", pullRequestRef{owner, repo, prNumber}, func() ([]clients.PullRequestComment, error) {
+		return c.live.GetPullRequestReviewComments(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) CompareCommits(token, owner, repo, base, head string) (*clients.CommitComparison, error) {
+	request := map[string]string{"owner": owner, "repo": repo, "base": base, "head": head}
+	return recordCall(c.recorder, "github", "CompareCommits", request, func() (*clients.CommitComparison, error) {
+		return c.live.CompareCommits(token, owner, repo, base, head)
+	})
+}
+
+func (c *recordedGitHubClient) PostPullRequestComment(token, owner, repo string, prNumber int, comment *clients.PullRequestComment) (*clients.PullRequestComment, error) {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "comment": comment}
+	return recordCall(c.recorder, "github", "PostPullRequestComment", request, func() (*clients.PullRequestComment, error) {
+		return c.live.PostPullRequestComment(token, owner, repo, prNumber, comment)
+	})
+}
+
+func (c *recordedGitHubClient) CreatePullRequestReview(token, owner, repo string, prNumber int, review *clients.PullRequestReviewRequest) (*clients.PullRequestReview, error) {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "review": review}
+	return recordCall(c.recorder, "github", "CreatePullRequestReview", request, func() (*clients.PullRequestReview, error) {
+		return c.live.CreatePullRequestReview(token, owner, repo, prNumber, review)
+	})
+}
+
+func (c *recordedGitHubClient) DismissPullRequestReview(token, owner, repo string, prNumber int, reviewID int64, message string) error {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "review_id": reviewID, "message": message}
+	_, err := recordCall(c.recorder, "github", "DismissPullRequestReview", request, func() (struct{}, error) {
+		return struct{}{}, c.live.DismissPullRequestReview(token, owner, repo, prNumber, reviewID, message)
+	})
+	return err
+}
+
+func (c *recordedGitHubClient) GetIssueComments(token, owner, repo string, prNumber int) ([]clients.IssueComment, error) {
+	return recordCall(c.recorder, "github", "GetIssueComments", pullRequestRef{owner, repo, prNumber}, func() ([]clients.IssueComment, error) {
+		return c.live.GetIssueComments(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) PostIssueComment(token, owner, repo string, prNumber int, body string) (*clients.IssueComment, error) {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "body": body}
+	return recordCall(c.recorder, "github", "PostIssueComment", request, func() (*clients.IssueComment, error) {
+		return c.live.PostIssueComment(token, owner, repo, prNumber, body)
+	})
+}
+
+func (c *recordedGitHubClient) UpdateIssueComment(token, owner, repo string, commentID int64, body string) error {
+	request := map[string]interface{}{"owner": owner, "repo": repo, "comment_id": commentID, "body": body}
+	_, err := recordCall(c.recorder, "github", "UpdateIssueComment", request, func() (struct{}, error) {
+		return struct{}{}, c.live.UpdateIssueComment(token, owner, repo, commentID, body)
+	})
+	return err
+}
+
+func (c *recordedGitHubClient) ApprovePullRequest(token, owner, repo string, prNumber int, body string) error {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "body": body}
+	_, err := recordCall(c.recorder, "github", "ApprovePullRequest", request, func() (struct{}, error) {
+		return struct{}{}, c.live.ApprovePullRequest(token, owner, repo, prNumber, body)
+	})
+	return err
+}
+
+func (c *recordedGitHubClient) GetFileContent(token, owner, repo, path, ref string) (string, error) {
+	request := map[string]string{"owner": owner, "repo": repo, "path": path, "ref": ref}
+	return recordCall(c.recorder, "github", "GetFileContent", request, func() (string, error) {
+		return c.live.GetFileContent(token, owner, repo, path, ref)
+	})
+}
+
+func (c *recordedGitHubClient) ListRepositoryFiles(token, owner, repo, ref string) ([]string, error) {
+	request := map[string]string{"owner": owner, "repo": repo, "ref": ref}
+	return recordCall(c.recorder, "github", "ListRepositoryFiles", request, func() ([]string, error) {
+		return c.live.ListRepositoryFiles(token, owner, repo, ref)
+	})
+}
+
+func (c *recordedGitHubClient) GetCollaboratorPermission(token, owner, repo, username string) (string, error) {
+	request := map[string]string{"owner": owner, "repo": repo, "username": username}
+	return recordCall(c.recorder, "github", "GetCollaboratorPermission", request, func() (string, error) {
+		return c.live.GetCollaboratorPermission(token, owner, repo, username)
+	})
+}
+
+func (c *recordedGitHubClient) GetReviewThreads(token, owner, repo string, prNumber int) ([]clients.ReviewThread, error) {
+	return recordCall(c.recorder, "github", "GetReviewThreads", pullRequestRef{owner, repo, prNumber}, func() ([]clients.ReviewThread, error) {
+		return c.live.GetReviewThreads(token, owner, repo, prNumber)
+	})
+}
+
+func (c *recordedGitHubClient) GetPullRequestReviewCommentReactions(token, owner, repo string, commentID int64) ([]clients.Reaction, error) {
+	request := map[string]interface{}{"owner": owner, "repo": repo, "comment_id": commentID}
+	return recordCall(c.recorder, "github", "GetPullRequestReviewCommentReactions", request, func() ([]clients.Reaction, error) {
+		return c.live.GetPullRequestReviewCommentReactions(token, owner, repo, commentID)
+	})
+}
+
+func (c *recordedGitHubClient) ReplyToPullRequestReviewComment(token, owner, repo string, prNumber int, commentID int64, body string) (*clients.PullRequestComment, error) {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "comment_id": commentID, "body": body}
+	return recordCall(c.recorder, "github", "ReplyToPullRequestReviewComment", request, func() (*clients.PullRequestComment, error) {
+		return c.live.ReplyToPullRequestReviewComment(token, owner, repo, prNumber, commentID, body)
+	})
+}
+
+func (c *recordedGitHubClient) ResolveReviewThread(token, owner, repo string, prNumber int, commentID int64) error {
+	request := map[string]interface{}{"pull_request": pullRequestRef{owner, repo, prNumber}, "comment_id": commentID}
+	_, err := recordCall(c.recorder, "github", "ResolveReviewThread", request, func() (struct{}, error) {
+		return struct{}{}, c.live.ResolveReviewThread(token, owner, repo, prNumber, commentID)
+	})
+	return err
+}
+
+// WithContext binds the live client to ctx. A replaying client never reaches the live one, so
+// it's left unbound.
+func (c *recordedGitHubClient) WithContext(ctx context.Context) clients.GitHubClient {
+	bound := *c
+	bound.GitHubClient = c.GitHubClient.WithContext(ctx)
+	if c.recorder.mode != ReplayModeReplay {
+		bound.live = c.live.WithContext(ctx)
+	}
+	return &bound
+}
+
+// offlineGitHubClient is the client behind a replaying recordedGitHubClient. Every call fails
+// with ErrReplayFixtureMissing.
+type offlineGitHubClient struct{}
+
+var _ clients.GitHubClient = offlineGitHubClient{}
+
+func (c offlineGitHubClient) WithContext(ctx context.Context) clients.GitHubClient { return c }
+
+func notRecorded(method string) error {
+	return fmt.Errorf("%w github.%s: the call has no replay wrapper", ErrReplayFixtureMissing, method)
+}
+
+func (offlineGitHubClient) GetPullRequestDetails(token, owner, repo string, prNumber int) (*clients.PullRequestDetails, error) {
+	return nil, notRecorded("GetPullRequestDetails")
+}
+
+func (offlineGitHubClient) GetPullRequestFiles(token, owner, repo string, prNumber int) ([]clients.PullRequestFile, error) {
+	return nil, notRecorded("GetPullRequestFiles")
+}
+
+func (offlineGitHubClient) GetPullRequestReviews(token, owner, repo string, prNumber int) ([]clients.PullRequestReview, error) {
+	return nil, notRecorded("GetPullRequestReviews")
+}
+
+func (offlineGitHubClient) GetPullRequestReviewComments(token, owner, repo string, prNumber int) ([]clients.PullRequestComment, error) {
+	return nil, notRecorded("GetPullRequestReviewComments")
+}
+
+func (offlineGitHubClient) CompareCommits(token, owner, repo, base, head string) (*clients.CommitComparison, error) {
+	return nil, notRecorded("CompareCommits")
+}
+
+func (offlineGitHubClient) PostPullRequestComment(token, owner, repo string, prNumber int, comment *clients.PullRequestComment) (*clients.PullRequestComment, error) {
+	return nil, notRecorded("PostPullRequestComment")
+}
+
+func (offlineGitHubClient) CreatePullRequestReview(token, owner, repo string, prNumber int, review *clients.PullRequestReviewRequest) (*clients.PullRequestReview, error) {
+	return nil, notRecorded("CreatePullRequestReview")
+}
+
+func (offlineGitHubClient) DismissPullRequestReview(token, owner, repo string, prNumber int, reviewID int64, message string) error {
+	return notRecorded("DismissPullRequestReview")
+}
+
+func (offlineGitHubClient) GetIssueComments(token, owner, repo string, prNumber int) ([]clients.IssueComment, error) {
+	return nil, notRecorded("GetIssueComments")
+}
+
+func (offlineGitHubClient) PostIssueComment(token, owner, repo string, prNumber int, body string) (*clients.IssueComment, error) {
+	return nil, notRecorded("PostIssueComment")
+}
+
+func (offlineGitHubClient) UpdateIssueComment(token, owner, repo string, commentID int64, body string) error {
+	return notRecorded("UpdateIssueComment")
+}
+
+func (offlineGitHubClient) ApprovePullRequest(token, owner, repo string, prNumber int, body string) error {
+	return notRecorded("ApprovePullRequest")
+}
+
+func (offlineGitHubClient) GetFileContent(token, owner, repo, path, ref string) (string, error) {
+	return "", notRecorded("GetFileContent")
+}
+
+func (offlineGitHubClient) ListRepositoryFiles(token, owner, repo, ref string) ([]string, error) {
+	return nil, notRecorded("ListRepositoryFiles")
+}
+
+func (offlineGitHubClient) GetCollaboratorPermission(token, owner, repo, username string) (string, error) {
+	return "", notRecorded("GetCollaboratorPermission")
+}
+
+func (offlineGitHubClient) GetReviewThreads(token, owner, repo string, prNumber int) ([]clients.ReviewThread, error) {
+	return nil, notRecorded("GetReviewThreads")
+}
+
+func (offlineGitHubClient) GetPullRequestReviewCommentReactions(token, owner, repo string, commentID int64) ([]clients.Reaction, error) {
+	return nil, notRecorded("GetPullRequestReviewCommentReactions")
+}
+
+func (offlineGitHubClient) ReplyToPullRequestReviewComment(token, owner, repo string, prNumber int, commentID int64, body string) (*clients.PullRequestComment, error) {
+	return nil, notRecorded("ReplyToPullRequestReviewComment")
+}
+
+func (offlineGitHubClient) ResolveReviewThread(token, owner, repo string, prNumber int, commentID int64) error {
+	return notRecorded("ResolveReviewThread")
+}
+
+// EnableReplay routes the workflow's GitHub client, model and embedding calls through a
+// ReviewRecorder. In replay mode no GitHub, model or embeddings vendor is contacted. Calling it
+// again replaces the recorder instead of wrapping the clients twice.
+func (w *CodeReviewWorkflow) EnableReplay(mode ReplayMode, dir string) error {
+	recorder, err := NewReviewRecorder(mode, dir)
+	if err != nil {
+		return err
+	}
+	w.recorder = recorder
+
+	live := w.githubConfig.Client
+	if recorded, ok := live.(*recordedGitHubClient); ok {
+		live = recorded.live
+	}
+	client := &recordedGitHubClient{GitHubClient: live, live: live, recorder: recorder}
+	if mode == ReplayModeReplay {
+		client.GitHubClient = offlineGitHubClient{}
+	}
+	w.githubConfig.Client = client
+	return nil
+}
+
+// RecordPullRequestReview runs ReviewPullRequest against live services and captures every
+// GitHub and model interaction into dir.
+func (w *CodeReviewWorkflow) RecordPullRequestReview(dir string, prNumber int, contextBuilder *ContextBuilder, checkIfAlreadyApproved bool, checkExistingComments bool) ([]*InternalReviewComment, []clients.PullRequestFile, error) {
+	if err := w.EnableReplay(ReplayModeRecord, dir); err != nil {
+		return nil, nil, err
+	}
+
+	manifest := replayManifest{
+		Owner:                  w.githubConfig.Owner,
+		Repo:                   w.githubConfig.Repo,
+		PRNumber:               prNumber,
+		CheckIfAlreadyApproved: checkIfAlreadyApproved,
+		CheckExistingComments:  checkExistingComments,
+		RecordedAt:             time.Now(),
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal replay manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, replayManifestFile), data, 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write replay manifest: %w", err)
+	}
+
+	return w.ReviewPullRequest(prNumber, contextBuilder, checkIfAlreadyApproved, checkExistingComments)
+}
+
+// ReplayPullRequestReview reruns a review recorded with RecordPullRequestReview entirely from
+// the fixtures in dir, using the same arguments as the original run.
+func (w *CodeReviewWorkflow) ReplayPullRequestReview(dir string, contextBuilder *ContextBuilder) ([]*InternalReviewComment, []clients.PullRequestFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, replayManifestFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read replay manifest: %w", err)
+	}
+	var manifest replayManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode replay manifest: %w", err)
+	}
+
+	if err := w.EnableReplay(ReplayModeReplay, dir); err != nil {
+		return nil, nil, err
+	}
+	w.githubConfig.Owner = manifest.Owner
+	w.githubConfig.Repo = manifest.Repo
+
+	return w.ReviewPullRequest(manifest.PRNumber, contextBuilder, manifest.CheckIfAlreadyApproved, manifest.CheckExistingComments)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+// liveGitHubClient fails the test when a replayed workflow reaches GitHub.
+type liveGitHubClient struct {
+	clients.GitHubClient
+	t *testing.T
+}
+
+func (c liveGitHubClient) GetFileContent(token, owner, repo, path, ref string) (string, error) {
+	c.t.Error("replay called GitHub for GetFileContent")
+	return "", nil
+}
+
+func TestReplayServesRecordedFixtures(t *testing.T) {
+	w := &CodeReviewWorkflow{githubConfig: &GitHubConfig{Client: liveGitHubClient{t: t}}}
+	if err := w.EnableReplay(ReplayModeReplay, "testdata/replay"); err != nil {
+		t.Fatalf("EnableReplay() = %v", err)
+	}
+	client := w.githubConfig.Client
+
+	content, err := client.GetFileContent("secret-token", "octo", "hello", ".codereview.yml", "abc123")
+	if err != nil || content != "review:\n  min_severity: minor\n" {
+		t.Errorf("GetFileContent() = %q, %v, want the recorded config", content, err)
+	}
+
+	if _, err := client.GetCollaboratorPermission("secret-token", "octo", "hello", "mallory"); err == nil || err.Error() != "GET /repos/octo/hello/collaborators/mallory/permission: 404 Not Found" {
+		t.Errorf("GetCollaboratorPermission() error = %v, want the recorded error", err)
+	}
+
+	if _, err := client.GetFileContent("secret-token", "octo", "hello", "README.md", "abc123"); !errors.Is(err, ErrReplayFixtureMissing) {
+		t.Errorf("unrecorded GetFileContent() error = %v, want ErrReplayFixtureMissing", err)
+	}
+
+	provider := recordProvider(&stubProvider{name: "anthropic", err: errors.New("live model called")}, w.recorder)
+	response, err := provider.Call("You are a code reviewer", "Review this diff")
+	if err != nil || response != `[{"path":"main.go","line":3,"body":"Check the error returned by Close"}]` {
+		t.Errorf("Call() = %q, %v, want the recorded completion", response, err)
+	}
+
+	vectors, err := w.recordEmbedder(fixedEmbedder{}).Embed(context.Background(), []string{"Check the error returned by Close"})
+	if err != nil || len(vectors) != 1 || vectors[0][0] != 0.6 || vectors[0][1] != 0.8 {
+		t.Errorf("Embed() = %v, %v, want the recorded embedding", vectors, err)
+	}
+}
+
+func TestReplayWithoutWrapperReturnsError(t *testing.T) {
+	if _, err := (offlineGitHubClient{}).GetReviewThreads("token", "octo", "hello", 7); !errors.Is(err, ErrReplayFixtureMissing) {
+		t.Errorf("GetReviewThreads() error = %v, want ErrReplayFixtureMissing", err)
+	}
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewReviewRecorder(ReplayModeRecord, dir)
+	if err != nil {
+		t.Fatalf("NewReviewRecorder() = %v", err)
+	}
+	live := &stubProvider{name: "openai", response: "[]"}
+	if _, err := recordProvider(live, recorder).Call("system", "diff"); err != nil {
+		t.Fatalf("recording Call() = %v", err)
+	}
+
+	replayer, err := NewReviewRecorder(ReplayModeReplay, dir)
+	if err != nil {
+		t.Fatalf("NewReviewRecorder() = %v", err)
+	}
+	live.response, live.err = "", errors.New("live model called")
+	provider := recordProvider(live, replayer)
+	if response, err := provider.Call("system", "diff"); err != nil || response != "[]" {
+		t.Errorf("replayed Call() = %q, %v, want the recorded completion", response, err)
+	}
+	// Each occurrence of a call is recorded separately
+	if _, err := provider.Call("system", "diff"); !errors.Is(err, ErrReplayFixtureMissing) {
+		t.Errorf("second replayed Call() error = %v, want ErrReplayFixtureMissing", err)
+	}
+}
+
+// repositoryGitHubClient serves the files of a repository.
+type repositoryGitHubClient struct {
+	clients.GitHubClient
+	files map[string]string
+}
+
+func (c repositoryGitHubClient) WithContext(ctx context.Context) clients.GitHubClient { return c }
+
+func (c repositoryGitHubClient) ListRepositoryFiles(token, owner, repo, ref string) ([]string, error) {
+	var paths []string
+	for path := range c.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (c repositoryGitHubClient) GetFileContent(token, owner, repo, path, ref string) (string, error) {
+	return c.files[path], nil
+}
+
+func TestRecordedIndexingReplaysWithoutLiveClient(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	t.Setenv("EMBEDDINGS_BASE_URL", "")
+
+	t.Setenv("CODE_INDEX_DIR", t.TempDir())
+	live := repositoryGitHubClient{files: map[string]string{
+		"review/post.go": "package review\n\nfunc postComment(body string) error {\n\treturn nil\n}\n",
+		"README.md":      "# hello",
+	}}
+	recording := &CodeReviewWorkflow{githubConfig: &GitHubConfig{Client: live, Token: "secret-token", Owner: "octo", Repo: "hello"}}
+	if err := recording.EnableReplay(ReplayModeRecord, dir); err != nil {
+		t.Fatalf("EnableReplay(record) = %v", err)
+	}
+	if err := recording.IndexRepository(ctx, "abc123"); err != nil {
+		t.Fatalf("recording IndexRepository() = %v", err)
+	}
+	if _, err := recording.codeIndex().Search(ctx, "octo/hello", []string{"postComment"}, 5, nil); err != nil {
+		t.Fatalf("recording Search() = %v", err)
+	}
+
+	// A fresh index, filled from the recording alone
+	t.Setenv("CODE_INDEX_DIR", t.TempDir())
+	replaying := &CodeReviewWorkflow{githubConfig: &GitHubConfig{Token: "secret-token", Owner: "octo", Repo: "hello"}}
+	if err := replaying.EnableReplay(ReplayModeReplay, dir); err != nil {
+		t.Fatalf("EnableReplay(replay) = %v", err)
+	}
+	if err := replaying.IndexRepository(ctx, "abc123"); err != nil {
+		t.Fatalf("replayed IndexRepository() = %v", err)
+	}
+	results, err := replaying.codeIndex().Search(ctx, "octo/hello", []string{"postComment"}, 5, nil)
+	if err != nil || len(results) != 1 || results[0].Symbol != "postComment" {
+		t.Errorf("Search() after replay = %+v, %v, want the recorded file indexed", results, err)
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+)
+
+// Statuses of a ReviewRun.
+const (
+	ReviewRunStatusRunning = "running"
+	// ReviewRunStatusReviewed means the comments were generated but not posted (yet)
+	ReviewRunStatusReviewed  = "reviewed"
+	ReviewRunStatusCompleted = "completed"
+	ReviewRunStatusSkipped   = "skipped"
+	ReviewRunStatusFailed    = "failed"
+)
+
+// ReviewRun records one run of ReviewPullRequest and its outcome, for the review dashboard.
+type ReviewRun struct {
+	models.SingleCompanyModel
+	CodeWorkflowExecutionId uint       `gorm:"index" json:"code_workflow_execution_id"`
+	Owner                   string     `gorm:"index:idx_review_run_repo" json:"owner"`
+	Repo                    string     `gorm:"index:idx_review_run_repo" json:"repo"`
+	PRNumber                int        `gorm:"index:idx_review_run_repo" json:"pr_number"`
+	RequestID               string     `json:"request_id"`
+	CommitSHA               string     `json:"commit_sha"`
+	Status                  string     `gorm:"index" json:"status"`
+	Error                   string     `json:"error"`
+	StartedAt               time.Time  `gorm:"index" json:"started_at"`
+	FinishedAt              *time.Time `json:"finished_at"`
+	DurationMs              int64      `json:"duration_ms"`
+	PostedCount             int        `json:"posted_count"`
+	FilteredCount           int        `json:"filtered_count"`
+}
+
+// ReviewRunStep is a workflow step logged during a review run.
+type ReviewRunStep struct {
+	models.SingleCompanyModel
+	ReviewRunId uint      `gorm:"index" json:"review_run_id"`
+	Step        string    `json:"step"`
+	Failed      bool      `json:"failed"`
+	Error       string    `json:"error"`
+	DurationMs  int64     `json:"duration_ms"`
+	Fields      string    `gorm:"type:jsonb" json:"fields"`
+	LoggedAt    time.Time `json:"logged_at"`
+}
+
+// ReviewRunComment is a comment generated during a review run, posted or filtered out.
+type ReviewRunComment struct {
+	models.SingleCompanyModel
+	ReviewRunId      uint   `gorm:"index" json:"review_run_id"`
+	Path             string `json:"path"`
+	Line             int    `json:"line"`
+	Type             string `json:"type"`
+	Severity         string `json:"severity"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	Body             string `json:"body"`
+	Posted           bool   `json:"posted"`
+	AcceptanceReason string `json:"acceptance_reason"`
+	RejectionReason  string `json:"rejection_reason"`
+	RejectionModel   string `json:"rejection_model"`
+}
+
+// startReviewRun records the start of a review run. Steps logged afterwards are attached to it.
+func (w *CodeReviewWorkflow) startReviewRun(prNumber int, requestID string) {
+	var executionId uint
+	if w.codeWorkflowExecution != nil {
+		executionId = w.codeWorkflowExecution.ID
+	}
+	run := &ReviewRun{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.repoWorkflowSetting.CompanyId,
+		},
+		CodeWorkflowExecutionId: executionId,
+		Owner:                   w.githubConfig.Owner,
+		Repo:                    w.githubConfig.Repo,
+		PRNumber:                prNumber,
+		RequestID:               requestID,
+		CommitSHA:               w.githubConfig.CommitSHA,
+		Status:                  ReviewRunStatusRunning,
+		StartedAt:               time.Now(),
+	}
+	w.reviewRun = nil
+	if err := w.db.Create(run).Error; err != nil {
+		logging.GetGlobalLogger().Error("Failed to record review run", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+	w.reviewRun = run
+}
+
+// finishReviewRun records the outcome of ReviewPullRequest. Runs that produced comments stay in
+// ReviewRunStatusReviewed until PostReviewComments completes them.
+func (w *CodeReviewWorkflow) finishReviewRun(files []clients.PullRequestFile, err error) {
+	switch {
+	case err != nil:
+		w.updateReviewRun(ReviewRunStatusFailed, err)
+	case files == nil:
+		// ReviewPullRequest returns no files when it exits early, e.g. for an approved PR
+		w.updateReviewRun(ReviewRunStatusSkipped, nil)
+	default:
+		w.updateReviewRun(ReviewRunStatusReviewed, nil)
+	}
+}
+
+// completeReviewRun records the comments handled by PostReviewComments and completes the run.
+func (w *CodeReviewWorkflow) completeReviewRun(postedComments, filteredComments []*InternalReviewComment, err error) {
+	if w.reviewRun == nil {
+		return
+	}
+
+	runComments := make([]ReviewRunComment, 0, len(postedComments)+len(filteredComments))
+	for _, comment := range postedComments {
+		runComments = append(runComments, w.reviewRunComment(comment, true))
+	}
+	for _, comment := range filteredComments {
+		runComments = append(runComments, w.reviewRunComment(comment, false))
+	}
+	if len(runComments) > 0 {
+		if createErr := w.db.Create(&runComments).Error; createErr != nil {
+			logging.GetGlobalLogger().Error("Failed to record review run comments", zap.Error(createErr), zap.Uint("review_run_id", w.reviewRun.ID))
+		}
+	}
+
+	w.reviewRun.PostedCount = len(postedComments)
+	w.reviewRun.FilteredCount = len(filteredComments)
+	if err != nil {
+		w.updateReviewRun(ReviewRunStatusFailed, err)
+	} else {
+		w.updateReviewRun(ReviewRunStatusCompleted, nil)
+	}
+}
+
+func (w *CodeReviewWorkflow) reviewRunComment(comment *InternalReviewComment, posted bool) ReviewRunComment {
+	runComment := ReviewRunComment{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.reviewRun.CompanyId,
+		},
+		ReviewRunId:      w.reviewRun.ID,
+		Path:             comment.Path,
+		Line:             comment.Line,
+		Type:             comment.Type,
+		Severity:         string(comment.Severity),
+		Provider:         comment.Provider,
+		Model:            comment.Model,
+		Body:             comment.Body,
+		Posted:           posted,
+		AcceptanceReason: comment.AcceptanceReason,
+		RejectionReason:  comment.RejectionReason,
+	}
+	if comment.RejectionModel != nil {
+		runComment.RejectionModel = *comment.RejectionModel
+	}
+	return runComment
+}
+
+func (w *CodeReviewWorkflow) updateReviewRun(status string, err error) {
+	if w.reviewRun == nil {
+		return
+	}
+	now := time.Now()
+	w.reviewRun.Status = status
+	w.reviewRun.FinishedAt = &now
+	w.reviewRun.DurationMs = now.Sub(w.reviewRun.StartedAt).Milliseconds()
+	w.reviewRun.CommitSHA = w.githubConfig.CommitSHA
+	if err != nil {
+		w.reviewRun.Error = err.Error()
+	}
+	if saveErr := w.db.Save(w.reviewRun).Error; saveErr != nil {
+		logging.GetGlobalLogger().Error("Failed to update review run", zap.Error(saveErr), zap.Uint("review_run_id", w.reviewRun.ID))
+	}
+}
+
+// logWorkflowStep logs a step of the code review workflow and records it on the current review run
+// and its trace.
+func (w *CodeReviewWorkflow) logWorkflowStep(prNumber int, requestID string, step string, fields map[string]interface{}) {
+	logging.LogWorkflowStep("CODE_REVIEW", prNumber, requestID, step, fields)
+	w.recordReviewRunStep(step, nil, fields)
+	w.recordStepSpan(prNumber, step, nil, fields)
+}
+
+// logWorkflowError logs a failed step of the code review workflow and records it on the current
+// review run and its trace. The step is taken from the "step" field.
+func (w *CodeReviewWorkflow) logWorkflowError(prNumber int, requestID string, err error, fields map[string]interface{}) {
+	logging.LogWorkflowError("CODE_REVIEW", prNumber, requestID, err, fields)
+	step, _ := fields["step"].(string)
+	w.recordReviewRunStep(step, err, fields)
+	w.recordStepSpan(prNumber, step, err, fields)
+}
+
+func (w *CodeReviewWorkflow) recordReviewRunStep(step string, err error, fields map[string]interface{}) {
+	if w.reviewRun == nil {
+		return
+	}
+
+	runStep := ReviewRunStep{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: w.reviewRun.CompanyId,
+		},
+		ReviewRunId: w.reviewRun.ID,
+		Step:        step,
+		Failed:      err != nil,
+		LoggedAt:    time.Now(),
+	}
+	if err != nil {
+		runStep.Error = err.Error()
+	}
+	if duration, ok := fields["duration"].(time.Duration); ok {
+		runStep.DurationMs = duration.Milliseconds()
+	}
+
+	serializable := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+		serializable[key] = value
+	}
+	fieldsJSON, jsonErr := json.Marshal(serializable)
+	if jsonErr != nil {
+		fieldsJSON = []byte(fmt.Sprintf(`{"error": %q}`, jsonErr.Error()))
+	}
+	runStep.Fields = string(fieldsJSON)
+
+	if createErr := w.db.Create(&runStep).Error; createErr != nil {
+		logging.GetGlobalLogger().Warn("Failed to record review run step", zap.Error(createErr), zap.String("step", step))
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"code-review-bot-test-repo/pkg/secrets"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// featureSecretScanning enables scanning the lines added by a pull request for secrets.
+const featureSecretScanning = "secret_scanning"
+
+// secretScannerProviderName is the provider of comments reporting secrets. These comments always
+// block auto-approval, whatever the repository's approval policy allows.
+const secretScannerProviderName = "secret-scanner"
+
+// SecretFingerprintKey is the key a company's secret fingerprints are computed with. It is created
+// on the first scan and unique per company, see reviewCompanyIndexes.
+type SecretFingerprintKey struct {
+	models.SingleCompanyModel
+	Key string `json:"-"`
+}
+
+// loadFingerprintKey returns the company's fingerprint key, creating it on first use. Findings have
+// no fingerprint when the key can't be loaded.
+func (w *CodeReviewWorkflow) loadFingerprintKey() []byte {
+	companyId := w.repoWorkflowSetting.CompanyId
+	var stored SecretFingerprintKey
+	err := w.db.Where("company_id = ?", companyId).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			logging.GetGlobalLogger().Error("Failed to generate secret fingerprint key", zap.Error(err))
+			return nil
+		}
+		stored = SecretFingerprintKey{
+			SingleCompanyModel: models.SingleCompanyModel{
+				CompanyId: companyId,
+			},
+			Key: hex.EncodeToString(key),
+		}
+		// A concurrent review may create the key first, the unique index keeps a single one
+		err = w.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&stored).Error
+		if err == nil {
+			err = w.db.Where("company_id = ?", companyId).First(&stored).Error
+		}
+	}
+	if err != nil {
+		logging.GetGlobalLogger().Error("Failed to load secret fingerprint key", zap.Error(err), zap.Uint("company_id", companyId))
+		return nil
+	}
+
+	key, err := hex.DecodeString(stored.Key)
+	if err != nil {
+		logging.GetGlobalLogger().Error("Invalid secret fingerprint key", zap.Error(err), zap.Uint("company_id", companyId))
+		return nil
+	}
+	return key
+}
+
+// loadSecretsAllowlist reads the allowlist from the base branch so a pull request can't allowlist
+// the secrets it adds.
+func (w *CodeReviewWorkflow) loadSecretsAllowlist(baseSHA string) *secrets.Allowlist {
+	content, err := w.githubConfig.Client.GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, secrets.AllowlistFile, baseSHA)
+	if err != nil {
+		// Most repositories don't have an allowlist
+		return nil
+	}
+	allowlist, err := secrets.ParseAllowlist(content)
+	if err != nil {
+		logging.GetGlobalLogger().Warn("Ignoring invalid secrets allowlist", zap.Error(err),
+			zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+		return nil
+	}
+	return allowlist
+}
+
+// scanForSecrets scans the lines added by the pull request and returns a blocker comment per secret.
+func (w *CodeReviewWorkflow) scanForSecrets(prNumber int, requestID string, files []clients.PullRequestFile, allowlist *secrets.Allowlist) []*InternalReviewComment {
+	scanStart := time.Now()
+	scanner := secrets.NewScanner(allowlist, w.loadFingerprintKey())
+
+	var comments []*InternalReviewComment
+	for _, file := range files {
+		if file.Status == "removed" || file.Patch == "" {
+			continue
+		}
+		for _, finding := range scanner.ScanPatch(file.Filename, file.Patch) {
+			comments = append(comments, secretFindingComment(finding))
+		}
+	}
+
+	w.logWorkflowStep(
+		prNumber,
+		requestID,
+		"secret_scanning",
+		map[string]interface{}{
+			"duration":      time.Since(scanStart),
+			"repository":    w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+			"finding_count": len(comments),
+		},
+	)
+	if len(comments) > 0 {
+		w.AddExecutionLog(fmt.Sprintf("Secret scanning found %d potential secret(s)", len(comments)))
+	}
+	return comments
+}
+
+// secretFindingComment converts a secret finding to a blocker review comment. The value is redacted
+// so the comment doesn't spread the secret further.
+func secretFindingComment(finding secrets.Finding) *InternalReviewComment {
+	allowlistEntry := "fingerprint:" + finding.Fingerprint
+	if finding.Fingerprint == "" {
+		allowlistEntry = finding.Path
+	}
+
+	comment := &InternalReviewComment{}
+	comment.Path = finding.Path
+	comment.Line = finding.Line
+	comment.Body = fmt.Sprintf(`**Possible secret committed:** %s (`+"`%s`"+`).
+
+Remove it from the code and load it from the environment or a secret manager instead. If it was ever pushed, treat it as leaked and rotate it, removing it in a later commit doesn't remove it from the git history.
+
+<sub>If this is a false positive, add `+"`%s`"+` to `+"`%s`"+` on the default branch.</sub>`,
+		finding.Description, finding.Redacted, allowlistEntry, secrets.AllowlistFile)
+	comment.Type = "security"
+	comment.Severity = SeverityBlocker
+	comment.Provider = secretScannerProviderName
+	comment.Model = finding.DetectorID
+	comment.Confidence = 1
+	comment.AcceptanceReason = "Reported by secret detector " + finding.DetectorID
+	return comment
+}
+
+// countSecretFindings returns the number of comments reporting secrets.
+func countSecretFindings(comments []*InternalReviewComment) int {
+	count := 0
+	for _, comment := range comments {
+		if comment.Provider == secretScannerProviderName {
+			count++
+		}
+	}
+	return count
+}
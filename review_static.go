@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"code-review-bot-test-repo/pkg/rules"
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// featureStaticRules enables the deterministic rule checks that run before the model review.
+const featureStaticRules = "static_rules"
+
+// staticProviderName is the provider of comments produced by the rule engine.
+const staticProviderName = "static"
+
+// staticFindingSummary describes an existing finding to the models so they don't repeat it.
+type staticFindingSummary struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// runStaticRules runs the rule engine on the changed lines of the changed Go files and returns the
+// findings as review comments.
+func (w *CodeReviewWorkflow) runStaticRules(prNumber int, requestID string, files []clients.PullRequestFile, commit string) []*InternalReviewComment {
+	staticStart := time.Now()
+	engine := rules.NewDefaultEngine()
+
+	var comments []*InternalReviewComment
+	for _, file := range files {
+		if !strings.HasSuffix(file.Filename, ".go") || file.Status == "removed" || file.Patch == "" {
+			continue
+		}
+
+		content, err := w.githubConfig.Client.GetFileContent(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, file.Filename, commit)
+		if err != nil {
+			logging.GetGlobalLogger().Warn("Failed to fetch file for static checks",
+				zap.Error(err),
+				zap.Int("pr_number", prNumber),
+				zap.String("path", file.Filename))
+			continue
+		}
+
+		findings, err := engine.Run(file.Filename, []byte(content), rules.ChangedLines(file.Patch))
+		if err != nil {
+			// Files that don't parse are left to the models
+			logging.GetGlobalLogger().Debug("Skipping static checks", zap.Error(err), zap.String("path", file.Filename))
+			continue
+		}
+		for _, finding := range findings {
+			comments = append(comments, staticFindingComment(finding))
+		}
+	}
+
+	w.logWorkflowStep(
+		prNumber,
+		requestID,
+		"static_rules",
+		map[string]interface{}{
+			"duration":      time.Since(staticStart),
+			"repository":    w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+			"finding_count": len(comments),
+		},
+	)
+	return comments
+}
+
+// staticFindingComment converts a rule finding to a review comment.
+func staticFindingComment(finding rules.Finding) *InternalReviewComment {
+	comment := &InternalReviewComment{}
+	comment.Path = finding.Path
+	comment.Line = finding.Line
+	comment.Body = fmt.Sprintf("%s\n\n<sub>Rule: `%s`</sub>", finding.Message, finding.RuleID)
+	comment.Type = finding.Category
+	comment.Severity = CommentSeverity(finding.Severity)
+	comment.Provider = staticProviderName
+	comment.Model = finding.RuleID
+	comment.Confidence = 1
+	comment.AcceptanceReason = "Reported by deterministic rule " + finding.RuleID
+	return comment
+}
+
+// summarizeStaticFindings lists the findings for the prompt.
+func summarizeStaticFindings(comments []*InternalReviewComment) []staticFindingSummary {
+	summaries := make([]staticFindingSummary, 0, len(comments))
+	for _, comment := range comments {
+		summaries = append(summaries, staticFindingSummary{
+			Path:    comment.Path,
+			Line:    comment.Line,
+			Rule:    comment.Model,
+			Message: strings.SplitN(comment.Body, "\n", 2)[0],
+		})
+	}
+	return summaries
+}
+
+// withoutPostedFindings drops the findings an earlier review already posted on the same line. The
+// findings skip duplicate detection, and their bodies are the same from one run to the next.
+func withoutPostedFindings(comments []*InternalReviewComment, existing []clients.PullRequestComment) []*InternalReviewComment {
+	type location struct {
+		path string
+		line int
+		body string
+	}
+	posted := make(map[location]bool, len(existing))
+	for _, comment := range existing {
+		posted[location{comment.Path, comment.Line, comment.Body}] = true
+	}
+
+	var fresh []*InternalReviewComment
+	for _, comment := range comments {
+		if !posted[location{comment.Path, comment.Line, comment.Body}] {
+			fresh = append(fresh, comment)
+		}
+	}
+	return fresh
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+
+	"code-review-bot-test-repo/pkg/rules"
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+func TestWithoutPostedFindings(t *testing.T) {
+	posted := staticFindingComment(rules.Finding{RuleID: "print-logging", Path: "main.go", Line: 4, Message: "Use the logger"})
+	moved := staticFindingComment(rules.Finding{RuleID: "print-logging", Path: "main.go", Line: 9, Message: "Use the logger"})
+	existing := []clients.PullRequestComment{{Path: "main.go", Line: 4, Body: posted.Body}}
+
+	fresh := withoutPostedFindings([]*InternalReviewComment{posted, moved}, existing)
+
+	if len(fresh) != 1 || fresh[0] != moved {
+		t.Errorf("withoutPostedFindings() = %+v, want only the finding on line 9", fresh)
+	}
+}
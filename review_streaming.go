@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"go.uber.org/zap"
+)
+
+// featureStreamingReview enables streaming model responses with incremental comment parsing.
+const featureStreamingReview = "streaming_review"
+
+// StreamingReviewProvider is implemented by providers that can stream their completion.
+type StreamingReviewProvider interface {
+	ReviewProvider
+	// Stream sends the messages and calls onDelta sequentially with each chunk of the completion as
+	// it arrives. It returns the text received so far even when the stream fails part way.
+	Stream(contextMessage, userMessage string, onDelta func(string)) (string, error)
+}
+
+// commentStreamParser extracts complete comment objects from a JSON array that arrives in chunks.
+// Text before the opening bracket, such as a markdown code fence, is ignored.
+type commentStreamParser struct {
+	started  bool
+	depth    int
+	inString bool
+	escaped  bool
+	current  []byte
+}
+
+func newCommentStreamParser() *commentStreamParser {
+	return &commentStreamParser{}
+}
+
+// Feed consumes the next chunk and returns every top-level array element object it completed.
+func (p *commentStreamParser) Feed(chunk string) []json.RawMessage {
+	var objects []json.RawMessage
+	for i := 0; i < len(chunk); i++ {
+		c := chunk[i]
+
+		if !p.started {
+			if c == '[' {
+				p.started = true
+				p.depth = 1
+			}
+			continue
+		}
+		if p.depth == 0 {
+			// The array is closed, anything after it is trailing text
+			break
+		}
+
+		if p.depth > 1 {
+			p.current = append(p.current, c)
+		}
+
+		if p.inString {
+			switch {
+			case p.escaped:
+				p.escaped = false
+			case c == '\\':
+				p.escaped = true
+			case c == '"':
+				p.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			p.inString = true
+		case '{', '[':
+			if p.depth == 1 {
+				p.current = append(p.current[:0], c)
+			}
+			p.depth++
+		case '}', ']':
+			p.depth--
+			if p.depth == 1 && c == '}' {
+				object := make(json.RawMessage, len(p.current))
+				copy(object, p.current)
+				objects = append(objects, object)
+				p.current = p.current[:0]
+			}
+		}
+	}
+	return objects
+}
+
+// ReviewProgressEvent is published while a review is running so long reviews can be followed live.
+type ReviewProgressEvent struct {
+	CompanyId uint                   `json:"company_id"`
+	Owner     string                 `json:"owner"`
+	Repo      string                 `json:"repo"`
+	PRNumber  int                    `json:"pr_number"`
+	Provider  string                 `json:"provider"`
+	Type      string                 `json:"type"`
+	Comment   *InternalReviewComment `json:"comment,omitempty"`
+	Count     int                    `json:"count,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Time      time.Time              `json:"time"`
+}
+
+// Progress event types.
+const (
+	ProgressEventProviderStarted  = "provider_started"
+	ProgressEventComment          = "comment"
+	ProgressEventProviderFinished = "provider_finished"
+	ProgressEventProviderFailed   = "provider_failed"
+)
+
+// ReviewProgressFeed fans out progress events to subscribers of a pull request. Pull requests are
+// keyed by company as well, so a subscriber only sees the reviews of its own company. Slow
+// subscribers miss events rather than blocking the review.
+type ReviewProgressFeed struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[string]map[int]chan ReviewProgressEvent
+}
+
+// NewReviewProgressFeed creates an empty feed.
+func NewReviewProgressFeed() *ReviewProgressFeed {
+	return &ReviewProgressFeed{subscribers: map[string]map[int]chan ReviewProgressEvent{}}
+}
+
+// DefaultReviewProgressFeed receives the progress of every review run in this process.
+var DefaultReviewProgressFeed = NewReviewProgressFeed()
+
+func progressFeedKey(companyId uint, owner, repo string, prNumber int) string {
+	return fmt.Sprintf("%d:%s/%s#%d", companyId, owner, repo, prNumber)
+}
+
+// Subscribe returns a channel of events for the pull request and a function to stop listening.
+func (f *ReviewProgressFeed) Subscribe(companyId uint, owner, repo string, prNumber int) (<-chan ReviewProgressEvent, func()) {
+	key := progressFeedKey(companyId, owner, repo, prNumber)
+	events := make(chan ReviewProgressEvent, 64)
+
+	f.mu.Lock()
+	id := f.nextID
+	f.nextID++
+	if f.subscribers[key] == nil {
+		f.subscribers[key] = map[int]chan ReviewProgressEvent{}
+	}
+	f.subscribers[key][id] = events
+	f.mu.Unlock()
+
+	unsubscribe := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[key][id]; ok {
+			delete(f.subscribers[key], id)
+			close(events)
+		}
+		if len(f.subscribers[key]) == 0 {
+			delete(f.subscribers, key)
+		}
+	}
+	return events, unsubscribe
+}
+
+// Publish delivers an event to the subscribers of its pull request.
+func (f *ReviewProgressFeed) Publish(event ReviewProgressEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, events := range f.subscribers[progressFeedKey(event.CompanyId, event.Owner, event.Repo, event.PRNumber)] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// progressKeepAlive is how often an idle progress stream sends a comment, so that proxies don't
+// close the connection while a provider is thinking.
+const progressKeepAlive = 15 * time.Second
+
+// ReviewProgressHandler serves GET /reviews/:owner/:repo/:pr/events, streaming the progress of the
+// pull request's reviews as server-sent events until the client disconnects. Each event is named
+// after its type. The hosting API mounts it behind its authentication; companyScope returns the
+// company of the authenticated caller, and requests without one are rejected.
+func ReviewProgressHandler(feed *ReviewProgressFeed, companyScope func(*gin.Context) (uint, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyId, ok := companyScope(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		prNumber, err := strconv.Atoi(c.Param("pr"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pull request number"})
+			return
+		}
+
+		events, unsubscribe := feed.Subscribe(companyId, c.Param("owner"), c.Param("repo"), prNumber)
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		keepAlive := time.NewTicker(progressKeepAlive)
+		defer keepAlive.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				c.SSEvent(event.Type, event)
+				return true
+			case <-keepAlive.C:
+				_, err := io.WriteString(w, ": keep-alive\n\n")
+				return err == nil
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// publishProgress publishes an event for the pull request under review.
+func (w *CodeReviewWorkflow) publishProgress(prNumber int, event ReviewProgressEvent) {
+	event.CompanyId = w.repoWorkflowSetting.CompanyId
+	event.Owner = w.githubConfig.Owner
+	event.Repo = w.githubConfig.Repo
+	event.PRNumber = prNumber
+	DefaultReviewProgressFeed.Publish(event)
+}
+
+// callReviewProvider calls the provider, streaming the completion when the provider supports it.
+// Comments parsed from the stream are returned even when the stream fails part way so that a
+// truncated or timed out response doesn't lose everything.
+func (w *CodeReviewWorkflow) callReviewProvider(ctx context.Context, prNumber int, provider ReviewProvider, contextMessage, userMessage string) (string, []*InternalReviewComment, error) {
+	streamer, ok := provider.(StreamingReviewProvider)
+	if !ok || !models.IsFeatureEnabledForCompany(w.db, featureStreamingReview, w.repoWorkflowSetting.CompanyId) {
+		message, err := callProviderContext(ctx, provider, contextMessage, userMessage)
+		return message, nil, err
+	}
+
+	w.publishProgress(prNumber, ReviewProgressEvent{Provider: provider.Name(), Type: ProgressEventProviderStarted})
+
+	// A stream that can't be cancelled keeps calling onDelta after the deadline, so the comments are
+	// guarded and deltas arriving after ctx is done are dropped
+	var mu sync.Mutex
+	parser := newCommentStreamParser()
+	var comments []*InternalReviewComment
+	onDelta := func(delta string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		for _, object := range parser.Feed(delta) {
+			var comment InternalReviewComment
+			if err := json.Unmarshal(object, &comment); err != nil {
+				logging.GetGlobalLogger().Debug("Skipping unparsable streamed comment",
+					zap.Error(err),
+					zap.String("provider", provider.Name()))
+				continue
+			}
+			comment.Provider = provider.Name()
+			comment.Model = provider.Model()
+			comments = append(comments, &comment)
+			w.publishProgress(prNumber, ReviewProgressEvent{Provider: provider.Name(), Type: ProgressEventComment, Comment: &comment})
+		}
+	}
+
+	spanCtx, span := startModelCallSpan(ctx, provider)
+	span.SetAttribute("streaming", true)
+	var message string
+	err := spanCtx.Err()
+	if err == nil {
+		if contextStreamer, ok := provider.(ContextStreamingReviewProvider); ok {
+			message, err = contextStreamer.StreamContext(spanCtx, contextMessage, userMessage, onDelta)
+		} else {
+			// The provider can't be interrupted, the deadline only applies before the call
+			message, err = streamer.Stream(contextMessage, userMessage, onDelta)
+		}
+	}
+	span.SetError(err)
+	span.End()
+
+	mu.Lock()
+	streamed := append([]*InternalReviewComment(nil), comments...)
+	mu.Unlock()
+
+	if err != nil {
+		w.publishProgress(prNumber, ReviewProgressEvent{Provider: provider.Name(), Type: ProgressEventProviderFailed, Count: len(streamed), Error: err.Error()})
+	} else {
+		w.publishProgress(prNumber, ReviewProgressEvent{Provider: provider.Name(), Type: ProgressEventProviderFinished, Count: len(streamed)})
+	}
+	return message, streamed, err
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func feedAll(parser *commentStreamParser, chunks ...string) []string {
+	var objects []string
+	for _, chunk := range chunks {
+		for _, object := range parser.Feed(chunk) {
+			objects = append(objects, string(object))
+		}
+	}
+	return objects
+}
+
+func TestCommentStreamParserFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name:   "whole array",
+			chunks: []string{`[{"line":1},{"line":2}]`},
+			want:   []string{`{"line":1}`, `{"line":2}`},
+		},
+		{
+			name:   "code fence and trailing text",
+			chunks: []string{"Here is my review:\n```json\n[{\"line\":1}]\n```\nLet me know {if} you need more."},
+			want:   []string{`{"line":1}`},
+		},
+		{
+			name:   "escaped quotes and brackets in strings",
+			chunks: []string{`[{"body":"use \"x[0]\" not {y}","line":3},{"body":"ends with a backslash \\"}]`},
+			want:   []string{`{"body":"use \"x[0]\" not {y}","line":3}`, `{"body":"ends with a backslash \\"}`},
+		},
+		{
+			name:   "nested arrays and objects",
+			chunks: []string{`[{"lines":[1,[2,3]],"meta":{"tags":["a","b"]}},{"line":4}]`},
+			want:   []string{`{"lines":[1,[2,3]],"meta":{"tags":["a","b"]}}`, `{"line":4}`},
+		},
+		{
+			name:   "split chunks",
+			chunks: []string{"```json\n[", `{"bo`, `dy":"a \`, `"quote\" }`, `"}`, `,{"line"`, `:5}]`},
+			want:   []string{`{"body":"a \"quote\" }"}`, `{"line":5}`},
+		},
+		{
+			name:   "truncated object is not emitted",
+			chunks: []string{`[{"line":1},{"line":`},
+			want:   []string{`{"line":1}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feedAll(newCommentStreamParser(), tt.chunks...)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Feed() = %q, want %q", got, tt.want)
+			}
+			for _, object := range got {
+				if !json.Valid([]byte(object)) {
+					t.Errorf("Feed() returned invalid JSON %q", object)
+				}
+			}
+		})
+	}
+}
+
+func TestReviewProgressHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	feed := NewReviewProgressFeed()
+	router := gin.New()
+	router.GET("/reviews/:owner/:repo/:pr/events", ReviewProgressHandler(feed, func(c *gin.Context) (uint, bool) {
+		return 7, c.GetHeader("Authorization") != ""
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/reviews/octo/hello/3/events")
+	if err != nil {
+		t.Fatalf("GET without credentials: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without credentials = %d, want 401", response.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/reviews/octo/hello/3/events", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer response.Body.Close()
+	if got := response.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/event-stream") {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	// The headers are flushed once the handler has subscribed
+	feed.Publish(ReviewProgressEvent{CompanyId: 8, Owner: "octo", Repo: "hello", PRNumber: 3, Type: ProgressEventProviderStarted, Provider: "other-company"})
+	feed.Publish(ReviewProgressEvent{CompanyId: 7, Owner: "octo", Repo: "hello", PRNumber: 3, Type: ProgressEventProviderStarted, Provider: "anthropic"})
+
+	reader := bufio.NewReader(response.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "event:"+ProgressEventProviderStarted {
+		t.Errorf("event line = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], `"provider":"anthropic"`) {
+		t.Errorf("data line = %q, want the event of company 7", lines[1])
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"github.com/tonyd3/propel-gtm/api/models"
+	"github.com/tonyd3/propel-gtm/api/utils"
+	"go.uber.org/zap"
+)
+
+// GitHub review events used when submitting a batched review.
+const (
+	ReviewEventComment        = "COMMENT"
+	ReviewEventApprove        = "APPROVE"
+	ReviewEventRequestChanges = "REQUEST_CHANGES"
+)
+
+// featureRequestChanges lets the workflow submit REQUEST_CHANGES reviews when blockers are found.
+const featureRequestChanges = "request_changes_reviews"
+
+// BotPullRequestReview records a review the workflow submitted so it can later be dismissed.
+type BotPullRequestReview struct {
+	models.SingleCompanyModel
+	Owner     string `gorm:"index:idx_bot_pr_review" json:"owner"`
+	Repo      string `gorm:"index:idx_bot_pr_review" json:"repo"`
+	PRNumber  int    `gorm:"index:idx_bot_pr_review" json:"pr_number"`
+	ReviewID  int64  `json:"review_id"`
+	Event     string `json:"event"`
+	CommitSHA string `json:"commit_sha"`
+	Dismissed bool   `json:"dismissed"`
+}
+
+// hasBlockingComments reports whether any of the comments is a blocker.
+func hasBlockingComments(comments []*InternalReviewComment) bool {
+	for _, comment := range comments {
+		if comment.Severity == SeverityBlocker {
+			return true
+		}
+	}
+	return false
+}
+
+// buildReviewSummary writes the top-level body of a batched review.
+func buildReviewSummary(event string, comments []*InternalReviewComment, commit, approvalReason string) string {
+	if event == ReviewEventApprove {
+		return fmt.Sprintf(`LGTM, ship it! :ship:
+<details>
+<summary>Why was this auto-approved?</summary>
+%s
+</details>`, approvalReason)
+	}
+
+	counts := map[CommentSeverity]int{}
+	for _, comment := range comments {
+		counts[comment.Severity]++
+	}
+
+	var sb strings.Builder
+	if event == ReviewEventRequestChanges {
+		sb.WriteString(fmt.Sprintf("**Changes requested** for `%s`: %d blocking issue(s) need to be fixed before merging.\n\n", shortSHA(commit), counts[SeverityBlocker]))
+	} else {
+		sb.WriteString(fmt.Sprintf("Reviewed `%s` and left %d comment(s).\n\n", shortSHA(commit), len(comments)))
+	}
+	sb.WriteString("| Severity | Count |\n|---|---|\n")
+	for _, severity := range commentSeverities {
+		if counts[severity] > 0 {
+			sb.WriteString(fmt.Sprintf("| %s | %d |\n", severity, counts[severity]))
+		}
+	}
+	return sb.String()
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// submitPullRequestReview posts the inline comments and the summary as a single GitHub review. It
+// returns the comments that were posted inline.
+//
+// GitHub rejects the whole review with a 422 when a single comment isn't on a line of the diff. The
+// review is then retried with the comments outside the diff moved into the summary body, and if
+// GitHub still rejects it, with every comment moved into the body.
+func (w *CodeReviewWorkflow) submitPullRequestReview(prNumber int, companyId uint, event string, body string, comments []*InternalReviewComment) (*clients.PullRequestReview, []*InternalReviewComment, error) {
+	inline := comments
+	review, err := w.createPullRequestReview(prNumber, event, body, inline)
+	if err != nil && isUnprocessableEntity(err) && len(inline) > 0 {
+		var outside []*InternalReviewComment
+		inline, outside = splitCommentsByDiff(inline, w.prFiles)
+		if len(outside) > 0 {
+			logging.GetGlobalLogger().Warn("Review rejected, moving comments outside the diff into the summary",
+				zap.Error(err),
+				zap.Int("pr_number", prNumber),
+				zap.Int("moved_count", len(outside)))
+			review, err = w.createPullRequestReview(prNumber, event, body+outsideDiffSection(outside), inline)
+		}
+		if err != nil && isUnprocessableEntity(err) && len(inline) > 0 {
+			logging.GetGlobalLogger().Warn("Review rejected, moving every comment into the summary",
+				zap.Error(err),
+				zap.Int("pr_number", prNumber),
+				zap.Int("moved_count", len(comments)))
+			inline = nil
+			review, err = w.createPullRequestReview(prNumber, event, body+outsideDiffSection(comments), nil)
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to submit %s review: %w", event, err)
+	}
+
+	botReview := BotPullRequestReview{
+		SingleCompanyModel: models.SingleCompanyModel{
+			CompanyId: companyId,
+		},
+		Owner:     w.githubConfig.Owner,
+		Repo:      w.githubConfig.Repo,
+		PRNumber:  prNumber,
+		ReviewID:  review.ID,
+		Event:     event,
+		CommitSHA: w.githubConfig.CommitSHA,
+	}
+	if err := w.db.Create(&botReview).Error; err != nil {
+		// Log the error, the review itself was submitted
+		logging.GetGlobalLogger().Error("Failed to record bot review", zap.Error(err), zap.Int("pr_number", prNumber))
+	}
+
+	return review, inline, nil
+}
+
+// createPullRequestReview makes a single attempt at submitting a review.
+func (w *CodeReviewWorkflow) createPullRequestReview(prNumber int, event string, body string, comments []*InternalReviewComment) (*clients.PullRequestReview, error) {
+	reviewComments := make([]clients.PullRequestComment, 0, len(comments))
+	for _, comment := range comments {
+		reviewComments = append(reviewComments, comment.PullRequestComment)
+	}
+
+	return w.githubConfig.Client.CreatePullRequestReview(
+		w.githubConfig.Token,
+		w.githubConfig.Owner,
+		w.githubConfig.Repo,
+		prNumber,
+		&clients.PullRequestReviewRequest{
+			CommitID: w.githubConfig.CommitSHA,
+			Body:     body,
+			Event:    event,
+			Comments: reviewComments,
+		})
+}
+
+// isUnprocessableEntity reports whether GitHub rejected a request as invalid. The client doesn't
+// expose the status code, so this relies on the status being part of the error message.
+func isUnprocessableEntity(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "422") || strings.Contains(message, "unprocessable")
+}
+
+// splitCommentsByDiff separates the comments on lines shown in the diff of the files from those
+// that GitHub won't accept as inline comments.
+func splitCommentsByDiff(comments []*InternalReviewComment, files []clients.PullRequestFile) (inside, outside []*InternalReviewComment) {
+	type diffLines struct{ left, right map[int]bool }
+	lines := make(map[string]diffLines, len(files))
+	for _, file := range files {
+		left, right := patchDiffLines(file.Patch)
+		lines[file.Filename] = diffLines{left, right}
+	}
+
+	for _, comment := range comments {
+		fileLines, ok := lines[comment.Path]
+		side := fileLines.right
+		if strings.EqualFold(comment.Side, "LEFT") {
+			side = fileLines.left
+		}
+		if ok && side[comment.Line] && (comment.StartLine == 0 || side[comment.StartLine]) {
+			inside = append(inside, comment)
+		} else {
+			outside = append(outside, comment)
+		}
+	}
+	return inside, outside
+}
+
+// patchDiffLines returns the lines of the old and of the new file that a unified diff patch shows,
+// including context lines.
+func patchDiffLines(patch string) (left, right map[int]bool) {
+	left, right = map[int]bool{}, map[int]bool{}
+	oldLine, newLine := 0, 0
+	for _, diffLine := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(diffLine, "@@"):
+			// @@ -start,count +start,count @@
+			fields := strings.Fields(diffLine)
+			if len(fields) < 3 {
+				oldLine, newLine = 0, 0
+				continue
+			}
+			oldLine, _ = strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[1], "-"), ",", 2)[0])
+			newLine, _ = strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[2], "+"), ",", 2)[0])
+		case oldLine == 0 && newLine == 0, strings.HasPrefix(diffLine, `\`):
+			// Before the first hunk, or "\ No newline at end of file"
+		case strings.HasPrefix(diffLine, "+"):
+			right[newLine] = true
+			newLine++
+		case strings.HasPrefix(diffLine, "-"):
+			left[oldLine] = true
+			oldLine++
+		default:
+			left[oldLine] = true
+			right[newLine] = true
+			oldLine++
+			newLine++
+		}
+	}
+	return left, right
+}
+
+// outsideDiffSection renders comments that couldn't be posted inline for the summary body.
+func outsideDiffSection(comments []*InternalReviewComment) string {
+	var sb strings.Builder
+	sb.WriteString("\n<details>\n<summary>Comments on lines outside the diff</summary>\n\n")
+	for _, comment := range comments {
+		sb.WriteString(fmt.Sprintf("**%s:%d**\n\n%s\n\n", comment.Path, comment.Line, comment.Body))
+	}
+	sb.WriteString("</details>\n")
+	return sb.String()
+}
+
+// recordPostedReviewComments stores the inline comments of a submitted review as PRComment rows.
+func (w *CodeReviewWorkflow) recordPostedReviewComments(prNumber int, companyId uint, reviewID int64, comments []*InternalReviewComment) {
+	postedComments, err := w.githubConfig.Client.GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		logging.GetGlobalLogger().Error("Failed to fetch comments of submitted review", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+
+	// GitHub doesn't return the ids of the inline comments when a review is created, so match them up by position
+	unmatched := map[string][]clients.PullRequestComment{}
+	for _, posted := range postedComments {
+		if posted.PullRequestReviewID != reviewID {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", posted.Path, posted.Line)
+		unmatched[key] = append(unmatched[key], posted)
+	}
+
+	for _, comment := range comments {
+		key := fmt.Sprintf("%s:%d", comment.Path, comment.Line)
+		candidates := unmatched[key]
+		if len(candidates) == 0 {
+			logging.GetGlobalLogger().Warn("Could not find posted review comment",
+				zap.Int("pr_number", prNumber),
+				zap.String("path", comment.Path),
+				zap.Int("line", comment.Line))
+			continue
+		}
+		posted := candidates[0]
+		unmatched[key] = candidates[1:]
+
+		prComment := models.PRComment{
+			SingleCompanyModel: models.SingleCompanyModel{
+				CompanyId: companyId,
+			},
+			PRNumber:         prNumber,
+			Owner:            w.githubConfig.Owner,
+			Repo:             w.githubConfig.Repo,
+			CommentID:        posted.ID,
+			Author:           posted.User.Login,
+			Body:             comment.Body,
+			CommentCreatedAt: utils.ParseTimeOrDefault(posted.CreatedAt, time.Now()),
+			CommentUpdatedAt: utils.ParseTimeOrDefault(posted.UpdatedAt, time.Now()),
+			IsByWorkflow:     true,
+			CommentType:      models.ReviewCommentType(comment.Type),
+		}
+		if err := w.db.Create(&prComment).Error; err != nil {
+			// Log the error but continue with the next comment
+			logging.GetGlobalLogger().Error("Failed to record PRComment", zap.Error(err))
+		}
+	}
+}
+
+// dismissResolvedChangeRequests dismisses the workflow's earlier REQUEST_CHANGES reviews once
+// a run no longer finds any blockers.
+func (w *CodeReviewWorkflow) dismissResolvedChangeRequests(prNumber int, companyId uint) {
+	var openReviews []BotPullRequestReview
+	err := w.db.Where("company_id = ? AND owner = ? AND repo = ? AND pr_number = ? AND event = ? AND dismissed = ?",
+		companyId, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, ReviewEventRequestChanges, false).
+		Find(&openReviews).Error
+	if err != nil {
+		logging.GetGlobalLogger().Error("Failed to load previous change requests", zap.Error(err), zap.Int("pr_number", prNumber))
+		return
+	}
+
+	for _, review := range openReviews {
+		message := fmt.Sprintf("The blocking issues from this review were addressed as of %s.", shortSHA(w.githubConfig.CommitSHA))
+		err := w.githubConfig.Client.DismissPullRequestReview(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, review.ReviewID, message)
+		if err != nil {
+			logging.GetGlobalLogger().Error("Failed to dismiss previous change request",
+				zap.Error(err),
+				zap.Int("pr_number", prNumber),
+				zap.Int64("review_id", review.ReviewID))
+			continue
+		}
+		if err := w.db.Model(&review).Update("dismissed", true).Error; err != nil {
+			logging.GetGlobalLogger().Error("Failed to mark review as dismissed", zap.Error(err), zap.Int64("review_id", review.ReviewID))
+		}
+		w.AddExecutionLog(fmt.Sprintf("Dismissed previous change request review %d", review.ReviewID))
+	}
+}
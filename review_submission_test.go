@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+)
+
+func TestSplitCommentsByDiff(t *testing.T) {
+	files := []clients.PullRequestFile{{
+		Filename: "main.go",
+		Patch:    "@@ -10,4 +10,5 @@ func main() {\n \tctx := context.Background()\n-\trun(ctx)\n+\tif err := run(ctx); err != nil {\n+\t\tlog.Fatal(err)\n+\t}\n \tdone()",
+	}}
+	comment := func(path string, line int, side string) *InternalReviewComment {
+		return &InternalReviewComment{PullRequestComment: clients.PullRequestComment{Path: path, Line: line, Side: side}}
+	}
+	tests := []struct {
+		name    string
+		comment *InternalReviewComment
+		inside  bool
+	}{
+		{"added line", comment("main.go", 12, ""), true},
+		{"context line", comment("main.go", 10, "RIGHT"), true},
+		{"trailing context line", comment("main.go", 14, ""), true},
+		{"removed line", comment("main.go", 11, "LEFT"), true},
+		{"line after the hunk", comment("main.go", 20, ""), false},
+		{"removed line on the new side", comment("main.go", 15, ""), false},
+		{"file outside the pull request", comment("util.go", 12, ""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inside, outside := splitCommentsByDiff([]*InternalReviewComment{tt.comment}, files)
+			if got := len(inside) == 1; got != tt.inside || len(inside)+len(outside) != 1 {
+				t.Errorf("inside = %d, outside = %d, want inside %v", len(inside), len(outside), tt.inside)
+			}
+		})
+	}
+}
+
+func TestIsUnprocessableEntity(t *testing.T) {
+	if !isUnprocessableEntity(errors.New("POST https://api.github.com/repos/o/r/pulls/1/reviews: 422 Unprocessable Entity")) {
+		t.Error("422 response not detected")
+	}
+	if isUnprocessableEntity(errors.New("POST https://api.github.com/repos/o/r/pulls/1/reviews: 502 Bad Gateway")) {
+		t.Error("502 response detected as 422")
+	}
+}
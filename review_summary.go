@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tonyd3/propel-gtm/api/clients"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// featurePullRequestSummary enables the PR summary and walkthrough pass.
+const featurePullRequestSummary = "pull_request_summary"
+
+// pullRequestSummaryMarker identifies the sticky summary comment so it can be edited in place.
+const pullRequestSummaryMarker = "<!-- code-review-bot:pr-summary -->"
+
+// FileWalkthrough describes the changes made to one file.
+type FileWalkthrough struct {
+	Path    string `json:"path"`
+	Summary string `json:"summary"`
+}
+
+// PullRequestRisk is the model's assessment of how risky a pull request is to merge.
+type PullRequestRisk struct {
+	Level   string   `json:"level"`
+	Reasons []string `json:"reasons"`
+}
+
+// PullRequestSummary is the top-level summary generated for a pull request.
+type PullRequestSummary struct {
+	Overview       string            `json:"overview"`
+	Walkthrough    []FileWalkthrough `json:"walkthrough"`
+	Risk           PullRequestRisk   `json:"risk"`
+	ChangeSequence []string          `json:"change_sequence"`
+}
+
+// buildSummarySystemMessage builds the system message for the summary pass from the review context.
+func buildSummarySystemMessage(additionalContext map[string]interface{}, commit string, tokenBudget int) string {
+	message := map[string]interface{}{
+		"role":      "You are a world class software engineer writing the description reviewers read before looking at a pull request.",
+		"objective": "Summarize the pull request so a reviewer understands what changed, why, and where the risk is.",
+		"commit":    commit,
+		"guidelines": "Base the summary only on the file changes and context provided. Describe intent using the author context when available. " +
+			"Walk through every changed file in one or two sentences. Assess the risk as low, medium or high and explain why. " +
+			"Order the change sequence so a reviewer can read the changes in a logical order.",
+		"output_format": `Respond with ONLY a JSON object: {"overview": string, "walkthrough": [{"path": string, "summary": string}], ` +
+			`"risk": {"level": "low"|"medium"|"high", "reasons": [string]}, "change_sequence": [string]}`,
+		"context": additionalContext,
+	}
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil || CountTokens(string(messageJSON)) > tokenBudget {
+		// The context is the only unbounded part, drop it rather than failing the summary
+		delete(message, "context")
+		if authorContext, ok := additionalContext["author_context"]; ok {
+			message["author_context"] = authorContext
+		}
+		messageJSON, err = json.Marshal(message)
+		if err != nil {
+			logging.GetGlobalLogger().Error("Error marshaling summary system message", zap.Error(err))
+			return ""
+		}
+	}
+	return string(messageJSON)
+}
+
+// renderPullRequestSummary formats the summary as the markdown body of the sticky comment.
+func renderPullRequestSummary(summary *PullRequestSummary, commit string) string {
+	var sb strings.Builder
+	sb.WriteString(pullRequestSummaryMarker + "\n")
+	sb.WriteString("## Summary\n\n")
+	sb.WriteString(summary.Overview + "\n\n")
+
+	if len(summary.ChangeSequence) > 0 {
+		sb.WriteString("### Sequence of changes\n\n")
+		for i, step := range summary.ChangeSequence {
+			sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, step))
+		}
+		sb.WriteString("\n")
+	}
+
+	if summary.Risk.Level != "" {
+		sb.WriteString(fmt.Sprintf("### Risk assessment: %s\n\n", strings.ToLower(summary.Risk.Level)))
+		for _, reason := range summary.Risk.Reasons {
+			sb.WriteString("- " + reason + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(summary.Walkthrough) > 0 {
+		sb.WriteString("<details>\n<summary>Walkthrough</summary>\n\n")
+		sb.WriteString("| File | Changes |\n|---|---|\n")
+		for _, file := range summary.Walkthrough {
+			sb.WriteString(fmt.Sprintf("| `%s` | %s |\n", file.Path, strings.ReplaceAll(file.Summary, "\n", " ")))
+		}
+		sb.WriteString("\n</details>\n\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("<sub>Updated for %s</sub>\n", shortSHA(commit)))
+	return sb.String()
+}
+
+// generatePullRequestSummary asks the primary review provider for a summary of the pull request
+// and posts or updates the sticky summary comment.
+func (w *CodeReviewWorkflow) generatePullRequestSummary(ctx context.Context, prNumber int, requestID string, files []clients.PullRequestFile, additionalContext map[string]interface{}, commit string) error {
+	summaryStart := time.Now()
+
+	providers := w.resolveReviewProviders()
+	if len(providers) == 0 {
+		return fmt.Errorf("no AI providers enabled for the summary")
+	}
+	provider := providers[0]
+
+	systemMessage := buildSummarySystemMessage(additionalContext, commit, MaxAllowedTokens/4)
+	if systemMessage == "" {
+		return fmt.Errorf("failed to build summary system message")
+	}
+	userMessage := w.prepareUserMessage(files, (MaxAllowedTokens-CountTokens(systemMessage))*75/100)
+
+	summaryFn := w.meteredModelCall(prNumber, provider.Name(), provider.Model(), "pr_summary", func(contextMessage, userMessage string) (string, error) {
+		return callProviderContext(ctx, provider, contextMessage, userMessage)
+	})
+	response, err := summaryFn(systemMessage, userMessage)
+	if err != nil {
+		return fmt.Errorf("failed to generate PR summary: %w", err)
+	}
+
+	var summary PullRequestSummary
+	if err := SanitizeAndParseJSON(response, &summary); err != nil {
+		return fmt.Errorf("failed to parse PR summary: %w", err)
+	}
+	if summary.Overview == "" {
+		return fmt.Errorf("model returned an empty PR summary")
+	}
+
+	if err := w.upsertStickyIssueComment(prNumber, pullRequestSummaryMarker, renderPullRequestSummary(&summary, commit)); err != nil {
+		return err
+	}
+
+	w.logWorkflowStep(
+		prNumber,
+		requestID,
+		"pr_summary",
+		map[string]interface{}{
+			"duration":   time.Since(summaryStart),
+			"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+			"provider":   provider.Name(),
+			"file_count": len(summary.Walkthrough),
+			"risk_level": summary.Risk.Level,
+		},
+	)
+	return nil
+}
+
+// upsertStickyIssueComment edits the PR conversation comment containing marker, or creates it if
+// it doesn't exist yet, so repeated runs update one comment instead of adding new ones.
+func (w *CodeReviewWorkflow) upsertStickyIssueComment(prNumber int, marker string, body string) error {
+	if !strings.Contains(body, marker) {
+		body = marker + "\n" + body
+	}
+
+	updated, err := w.updateStickyIssueComment(prNumber, marker, body)
+	if err != nil || updated {
+		return err
+	}
+
+	if _, err := w.githubConfig.Client.PostIssueComment(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, body); err != nil {
+		return fmt.Errorf("failed to post comment: %w", err)
+	}
+	return nil
+}
+
+// updateStickyIssueComment edits the PR conversation comment containing marker and reports
+// whether it exists.
+func (w *CodeReviewWorkflow) updateStickyIssueComment(prNumber int, marker string, body string) (bool, error) {
+	if !strings.Contains(body, marker) {
+		body = marker + "\n" + body
+	}
+
+	issueComments, err := w.githubConfig.Client.GetIssueComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	if err != nil {
+		return false, fmt.Errorf("failed to list PR comments: %w", err)
+	}
+
+	for _, issueComment := range issueComments {
+		if strings.Contains(issueComment.Body, marker) {
+			if err := w.githubConfig.Client.UpdateIssueComment(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, issueComment.ID, body); err != nil {
+				return false, fmt.Errorf("failed to update comment %d: %w", issueComment.ID, err)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"code-review-bot-test-repo/pkg/tracing"
+	"github.com/tonyd3/propel-gtm/api/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultReviewTracer records the spans of review runs. It exports to the collector or file
+// configured with OTEL_EXPORTER_OTLP_ENDPOINT or TRACE_EXPORT_FILE, see tracing.ExporterFromEnv.
+var DefaultReviewTracer = tracing.NewTracer("code-review-bot", tracing.ExporterFromEnv())
+
+// startReviewTrace starts the root span of a review run, or continues the trace carried by ctx.
+// The trace ID is returned as the run's request ID so its log lines, dashboard entry and spans
+// can be correlated.
+func (w *CodeReviewWorkflow) startReviewTrace(ctx context.Context, prNumber int) (context.Context, string) {
+	ctx, span := DefaultReviewTracer.Start(ctx, "review_pull_request")
+	span.SetAttributes(map[string]interface{}{
+		"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+		"pr_number":  prNumber,
+		"commit_sha": w.githubConfig.CommitSHA,
+		"company_id": w.repoWorkflowSetting.CompanyId,
+	})
+	w.reviewSpan = span
+	return ctx, span.TraceID().String()
+}
+
+// endReviewTrace ends the root span of the review run and exports the run's spans.
+func (w *CodeReviewWorkflow) endReviewTrace(err error) {
+	span := w.reviewSpan
+	if span == nil {
+		return
+	}
+	span.SetError(err)
+	span.End()
+	if flushErr := DefaultReviewTracer.Flush(); flushErr != nil {
+		logging.GetGlobalLogger().Warn("Failed to export review trace", zap.Error(flushErr), zap.String("trace_id", span.TraceID().String()))
+	}
+}
+
+// recordStepSpan adds a logged workflow step to the review run's trace. Steps are logged once they
+// completed, so a step with a "duration" field is backdated to cover it.
+func (w *CodeReviewWorkflow) recordStepSpan(prNumber int, step string, err error, fields map[string]interface{}) {
+	if w.reviewSpan == nil {
+		return
+	}
+
+	end := time.Now()
+	start := end
+	if duration, ok := fields["duration"].(time.Duration); ok {
+		start = end.Add(-duration)
+	}
+	_, span := DefaultReviewTracer.StartAt(tracing.ContextWithSpan(context.Background(), w.reviewSpan), step, start)
+	span.SetAttributes(fields)
+	span.SetAttribute("pr_number", prNumber)
+	span.SetError(err)
+	span.EndAt(end)
+}
+
+// tracedCall runs fn in a span of the review run's trace. fn gets the span's context, so the
+// requests it makes with it are aborted when ctx is done and carry the trace.
+func tracedCall[T any](ctx context.Context, name string, fn func(ctx context.Context) (T, error)) (T, error) {
+	ctx, span := DefaultReviewTracer.Start(ctx, name)
+	defer span.End()
+	var value T
+	err := ctx.Err()
+	if err == nil {
+		value, err = fn(ctx)
+	}
+	span.SetError(err)
+	return value, err
+}
+
+// startModelCallSpan starts the span of a call to a review provider. Providers making their own
+// HTTP requests with the returned context forward the trace to the model server.
+func startModelCallSpan(ctx context.Context, provider ReviewProvider) (context.Context, *tracing.Span) {
+	ctx, span := DefaultReviewTracer.Start(ctx, "model_call")
+	span.SetAttributes(map[string]interface{}{
+		"provider": provider.Name(),
+		"model":    provider.Model(),
+	})
+	return ctx, span
+}
@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Statuses of a review job row
+const (
+	ReviewJobStatusQueued     = "queued"
+	ReviewJobStatusRunning    = "running"
+	ReviewJobStatusDone       = "done"
+	ReviewJobStatusSuperseded = "superseded"
+	ReviewJobStatusDead       = "dead"
+)
+
+// reviewJobsSchema creates the review_jobs table used by PostgresReviewJobQueue
+const reviewJobsSchema = `
+CREATE TABLE IF NOT EXISTS review_jobs (
+	id           BIGSERIAL PRIMARY KEY,
+	kind         TEXT NOT NULL,
+	delivery_id  TEXT,
+	event        TEXT NOT NULL DEFAULT '',
+	action       TEXT NOT NULL DEFAULT '',
+	owner        TEXT NOT NULL,
+	repo         TEXT NOT NULL,
+	pr_number    INTEGER NOT NULL,
+	head_sha     TEXT NOT NULL DEFAULT '',
+	comment_id   BIGINT NOT NULL DEFAULT 0,
+	in_reply_to_id BIGINT NOT NULL DEFAULT 0,
+	comment_body TEXT NOT NULL DEFAULT '',
+	sender       TEXT NOT NULL DEFAULT '',
+	status       TEXT NOT NULL DEFAULT 'queued',
+	step         TEXT NOT NULL DEFAULT '',
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	last_error   TEXT NOT NULL DEFAULT '',
+	run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	leased_until TIMESTAMPTZ,
+	lease_owner  TEXT NOT NULL DEFAULT '',
+	enqueued_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+ALTER TABLE review_jobs ADD COLUMN IF NOT EXISTS in_reply_to_id BIGINT NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_review_jobs_delivery ON review_jobs (delivery_id) WHERE delivery_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_review_jobs_ready ON review_jobs (status, run_at);
+CREATE INDEX IF NOT EXISTS idx_review_jobs_pr ON review_jobs (owner, repo, pr_number, status);
+`
+
+// reviewJobColumns is the column list scanned by scanReviewJob
+const reviewJobColumns = `id, kind, COALESCE(delivery_id, ''), event, action, owner, repo, pr_number, head_sha,
+	comment_id, in_reply_to_id, comment_body, sender, step, attempts, enqueued_at`
+
+// PostgresReviewJobQueueOptions configures leasing and retries of the Postgres queue
+type PostgresReviewJobQueueOptions struct {
+	// WorkerID identifies the process holding a lease
+	WorkerID string
+	// LeaseDuration is how long a job stays leased without being extended
+	LeaseDuration time.Duration
+	// PollInterval is how often Dequeue looks for new jobs when the queue is empty
+	PollInterval time.Duration
+	// MaxAttempts is the number of attempts after which a failing job is dead-lettered
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, doubling with every attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultPostgresReviewJobQueueOptions returns the options used for unset fields
+func DefaultPostgresReviewJobQueueOptions() PostgresReviewJobQueueOptions {
+	return PostgresReviewJobQueueOptions{
+		LeaseDuration: 10 * time.Minute,
+		PollInterval:  2 * time.Second,
+		MaxAttempts:   5,
+		BaseBackoff:   30 * time.Second,
+		MaxBackoff:    30 * time.Minute,
+	}
+}
+
+// PostgresReviewJobQueue is a durable ReviewJobQueue. Workers lease jobs with SELECT ... FOR UPDATE
+// SKIP LOCKED, failed jobs are retried with exponential backoff and dead-lettered after
+// MaxAttempts, and a job whose lease expires, e.g. because its worker crashed, is picked up again.
+type PostgresReviewJobQueue struct {
+	db   *sql.DB
+	opts PostgresReviewJobQueueOptions
+}
+
+// NewPostgresReviewJobQueue creates a queue on the given connection pool
+func NewPostgresReviewJobQueue(db *sql.DB, opts PostgresReviewJobQueueOptions) *PostgresReviewJobQueue {
+	defaults := DefaultPostgresReviewJobQueueOptions()
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = defaults.LeaseDuration
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaults.PollInterval
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaults.MaxAttempts
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = defaults.BaseBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	return &PostgresReviewJobQueue{db: db, opts: opts}
+}
+
+// EnsureSchema creates the review_jobs table if it doesn't exist
+func (q *PostgresReviewJobQueue) EnsureSchema(ctx context.Context) error {
+	if _, err := q.db.ExecContext(ctx, reviewJobsSchema); err != nil {
+		return fmt.Errorf("failed to create review_jobs table: %w", err)
+	}
+	return nil
+}
+
+// Enqueue inserts the job. Redelivered webhooks and review jobs for a head SHA that is already
+// queued or running are dropped, and a queued review job for an older head SHA is superseded.
+func (q *PostgresReviewJobQueue) Enqueue(ctx context.Context, job ReviewJob) (string, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Error().Err(err).Msg("Error rolling back review job transaction")
+		}
+	}()
+
+	// Serialize enqueues for the same pull request so two deliveries can't both win the checks below
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", job.Key()); err != nil {
+		return "", fmt.Errorf("failed to lock pull request %s: %w", job.Key(), err)
+	}
+
+	var deliveryID sql.NullString
+	if job.DeliveryID != "" {
+		deliveryID = sql.NullString{String: job.DeliveryID, Valid: true}
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM review_jobs WHERE delivery_id = $1)", job.DeliveryID).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("failed to check delivery %s: %w", job.DeliveryID, err)
+		}
+		if exists {
+			return EnqueueResultDuplicate, nil
+		}
+	}
+
+	result := EnqueueResultEnqueued
+	if job.Kind == ReviewJobKindReview {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (
+			SELECT 1 FROM review_jobs
+			WHERE kind = $1 AND owner = $2 AND repo = $3 AND pr_number = $4 AND head_sha = $5 AND status IN ($6, $7))`,
+			ReviewJobKindReview, job.Owner, job.Repo, job.PRNumber, job.HeadSHA, ReviewJobStatusQueued, ReviewJobStatusRunning).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("failed to check pending reviews for %s: %w", job.Key(), err)
+		}
+		if exists {
+			return EnqueueResultDuplicate, nil
+		}
+
+		superseded, err := tx.ExecContext(ctx, `UPDATE review_jobs SET status = $1, updated_at = now()
+			WHERE kind = $2 AND owner = $3 AND repo = $4 AND pr_number = $5 AND status = $6`,
+			ReviewJobStatusSuperseded, ReviewJobKindReview, job.Owner, job.Repo, job.PRNumber, ReviewJobStatusQueued)
+		if err != nil {
+			return "", fmt.Errorf("failed to supersede pending reviews for %s: %w", job.Key(), err)
+		}
+		if count, err := superseded.RowsAffected(); err == nil && count > 0 {
+			result = EnqueueResultSuperseded
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO review_jobs
+		(kind, delivery_id, event, action, owner, repo, pr_number, head_sha, comment_id, in_reply_to_id, comment_body, sender, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		job.Kind, deliveryID, job.Event, job.Action, job.Owner, job.Repo, job.PRNumber, job.HeadSHA,
+		job.CommentID, job.InReplyToID, job.CommentBody, job.Sender, ReviewJobStatusQueued)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert review job for %s: %w", job.Key(), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit review job for %s: %w", job.Key(), err)
+	}
+	return result, nil
+}
+
+// Dequeue leases the oldest ready job whose pull request has no other job running, polling until
+// one is available or the context is done.
+func (q *PostgresReviewJobQueue) Dequeue(ctx context.Context) (*ReviewJob, error) {
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := q.lease(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			if job.Attempts > q.opts.MaxAttempts {
+				// The job's worker kept dying before it could report back
+				if err := q.deadLetter(ctx, job, "lease expired too many times"); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *PostgresReviewJobQueue) lease(ctx context.Context) (*ReviewJob, error) {
+	row := q.db.QueryRowContext(ctx, `UPDATE review_jobs SET
+			status = $1, attempts = attempts + 1, leased_until = now() + make_interval(secs => $2), lease_owner = $3, updated_at = now()
+		WHERE id = (
+			SELECT j.id FROM review_jobs j
+			WHERE ((j.status = $4 AND j.run_at <= now()) OR (j.status = $1 AND j.leased_until < now()))
+			AND NOT EXISTS (
+				SELECT 1 FROM review_jobs r
+				WHERE r.owner = j.owner AND r.repo = j.repo AND r.pr_number = j.pr_number
+				AND r.id <> j.id AND r.status = $1 AND r.leased_until >= now())
+			ORDER BY j.run_at, j.id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1)
+		RETURNING `+reviewJobColumns,
+		ReviewJobStatusRunning, q.opts.LeaseDuration.Seconds(), q.opts.WorkerID, ReviewJobStatusQueued)
+
+	job, err := scanReviewJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease review job: %w", err)
+	}
+	return job, nil
+}
+
+func scanReviewJob(row *sql.Row) (*ReviewJob, error) {
+	var job ReviewJob
+	err := row.Scan(&job.ID, &job.Kind, &job.DeliveryID, &job.Event, &job.Action, &job.Owner, &job.Repo, &job.PRNumber,
+		&job.HeadSHA, &job.CommentID, &job.InReplyToID, &job.CommentBody, &job.Sender, &job.Step, &job.Attempts, &job.EnqueuedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtendLease keeps a running job leased while its worker is still working on it
+func (q *PostgresReviewJobQueue) ExtendLease(ctx context.Context, job *ReviewJob) error {
+	result, err := q.db.ExecContext(ctx, `UPDATE review_jobs SET leased_until = now() + make_interval(secs => $1), updated_at = now()
+		WHERE id = $2 AND lease_owner = $3 AND status = $4`,
+		q.opts.LeaseDuration.Seconds(), job.ID, q.opts.WorkerID, ReviewJobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to extend lease of review job %d: %w", job.ID, err)
+	}
+	return leaseHeld(result, job)
+}
+
+// Complete marks the job done, or schedules a retry with exponential backoff when it failed.
+// Jobs that keep failing are dead-lettered with their last error. It returns ErrReviewJobLeaseLost
+// when the lease expired and the job was leased again, by this or another worker.
+func (q *PostgresReviewJobQueue) Complete(ctx context.Context, job *ReviewJob, jobErr error) error {
+	switch {
+	case jobErr == nil:
+		return q.setStatus(ctx, job, ReviewJobStatusDone, "")
+	case errors.Is(jobErr, ErrReviewJobSuperseded):
+		return q.setStatus(ctx, job, ReviewJobStatusSuperseded, jobErr.Error())
+	case job.Attempts >= q.opts.MaxAttempts:
+		return q.deadLetter(ctx, job, jobErr.Error())
+	}
+
+	result, err := q.db.ExecContext(ctx, `UPDATE review_jobs SET
+			status = $1, last_error = $2, run_at = now() + make_interval(secs => $3), leased_until = NULL, updated_at = now()
+		WHERE id = $4 AND lease_owner = $5 AND status = $6`,
+		ReviewJobStatusQueued, jobErr.Error(), q.backoff(job.Attempts).Seconds(), job.ID, q.opts.WorkerID, ReviewJobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to schedule retry of review job %d: %w", job.ID, err)
+	}
+	return leaseHeld(result, job)
+}
+
+// leaseHeld returns ErrReviewJobLeaseLost when an update guarded by the job's lease matched no row
+func leaseHeld(result sql.Result, job *ReviewJob) error {
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check lease of review job %d: %w", job.ID, err)
+	}
+	if count == 0 {
+		return fmt.Errorf("review job %d: %w", job.ID, ErrReviewJobLeaseLost)
+	}
+	return nil
+}
+
+// backoff returns the delay before the next attempt after the given number of attempts
+func (q *PostgresReviewJobQueue) backoff(attempts int) time.Duration {
+	delay := float64(q.opts.BaseBackoff) * math.Pow(2, float64(attempts-1))
+	if delay > float64(q.opts.MaxBackoff) {
+		return q.opts.MaxBackoff
+	}
+	return time.Duration(delay)
+}
+
+func (q *PostgresReviewJobQueue) deadLetter(ctx context.Context, job *ReviewJob, reason string) error {
+	log.Warn().
+		Int64("job_id", job.ID).
+		Str("kind", job.Kind).
+		Str("pull_request", job.Key()).
+		Int("attempts", job.Attempts).
+		Str("reason", reason).
+		Msg("Dead-lettering review job")
+	return q.setStatus(ctx, job, ReviewJobStatusDead, reason)
+}
+
+func (q *PostgresReviewJobQueue) setStatus(ctx context.Context, job *ReviewJob, status, lastError string) error {
+	result, err := q.db.ExecContext(ctx, `UPDATE review_jobs SET status = $1, last_error = $2, leased_until = NULL, updated_at = now()
+		WHERE id = $3 AND lease_owner = $4 AND status = $5`,
+		status, lastError, job.ID, q.opts.WorkerID, ReviewJobStatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to mark review job %d as %s: %w", job.ID, status, err)
+	}
+	return leaseHeld(result, job)
+}
+
+// IsCurrent reports whether the job's head SHA is the one of the newest review job for its pull
+// request. Other kinds of jobs carry a head SHA too, e.g. the merge commit of an index job, but
+// don't make a review stale.
+func (q *PostgresReviewJobQueue) IsCurrent(ctx context.Context, job *ReviewJob) (bool, error) {
+	if job.HeadSHA == "" {
+		return true, nil
+	}
+	var latest string
+	err := q.db.QueryRowContext(ctx, `SELECT head_sha FROM review_jobs
+		WHERE kind = $1 AND owner = $2 AND repo = $3 AND pr_number = $4 AND head_sha <> ''
+		ORDER BY id DESC LIMIT 1`,
+		ReviewJobKindReview, job.Owner, job.Repo, job.PRNumber).Scan(&latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load latest head of %s: %w", job.Key(), err)
+	}
+	return latest == job.HeadSHA, nil
+}
+
+// Checkpoint records the last step the job completed so a retry can resume after it
+func (q *PostgresReviewJobQueue) Checkpoint(ctx context.Context, job *ReviewJob, step string) error {
+	_, err := q.db.ExecContext(ctx, "UPDATE review_jobs SET step = $1, updated_at = now() WHERE id = $2", step, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint review job %d at %s: %w", job.ID, step, err)
+	}
+	job.Step = step
+	return nil
+}
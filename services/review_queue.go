@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of review jobs
+const (
+	// ReviewJobKindReview reviews the pull request at HeadSHA
+	ReviewJobKindReview = "review"
+	// ReviewJobKindComment handles a comment left on the pull request
+	ReviewJobKindComment = "comment"
+	// ReviewJobKindReviewEvent handles a review submitted or dismissed on the pull request
+	ReviewJobKindReviewEvent = "review_event"
+	// ReviewJobKindThreadReply handles a reply on a review comment thread
+	ReviewJobKindThreadReply = "thread_reply"
+	// ReviewJobKindIndex updates the code index with the files of the pull request merged at HeadSHA
+	ReviewJobKindIndex = "index"
+)
+
+// ReviewJobKinds are the kinds of jobs the webhook receiver enqueues. A worker needs a handler
+// registered for each of them.
+var ReviewJobKinds = []string{
+	ReviewJobKindReview,
+	ReviewJobKindComment,
+	ReviewJobKindReviewEvent,
+	ReviewJobKindThreadReply,
+	ReviewJobKindIndex,
+}
+
+// Outcomes of enqueueing a review job
+const (
+	EnqueueResultEnqueued   = "enqueued"
+	EnqueueResultDuplicate  = "duplicate"
+	EnqueueResultSuperseded = "superseded"
+)
+
+// Steps of a review job that are checkpointed so a retried job can resume
+const (
+	ReviewJobStepStarted  = "started"
+	ReviewJobStepReviewed = "reviewed"
+	ReviewJobStepPosted   = "posted"
+)
+
+// ErrReviewJobSuperseded is returned by a job handler when a newer head SHA was pushed while the
+// job was running. The job is finished without being retried.
+var ErrReviewJobSuperseded = errors.New("review job superseded by a newer commit")
+
+// ErrReviewJobLeaseLost is returned when a worker reports on a job whose lease expired and was
+// taken by another worker. The outcome is dropped, the job belongs to the new lease holder.
+var ErrReviewJobLeaseLost = errors.New("review job lease lost")
+
+// deliveryRetention is how long delivery IDs are remembered to drop redelivered webhooks
+const deliveryRetention = time.Hour
+
+// ReviewJob is a unit of work for the review workers, created from a GitHub webhook delivery
+type ReviewJob struct {
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	DeliveryID  string    `json:"delivery_id"`
+	Event       string    `json:"event"`
+	Action      string    `json:"action"`
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	PRNumber    int       `json:"pr_number"`
+	HeadSHA     string    `json:"head_sha"`
+	CommentID   int64     `json:"comment_id,omitempty"`
+	InReplyToID int64     `json:"in_reply_to_id,omitempty"`
+	CommentBody string    `json:"comment_body,omitempty"`
+	Sender      string    `json:"sender"`
+	Step        string    `json:"step,omitempty"`
+	Attempts    int       `json:"attempts"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+}
+
+// Key identifies the pull request the job belongs to
+func (j *ReviewJob) Key() string {
+	return fmt.Sprintf("%s/%s#%d", j.Owner, j.Repo, j.PRNumber)
+}
+
+// ReviewJobQueue hands review jobs from the webhook receiver to the review workers
+type ReviewJobQueue interface {
+	// Enqueue adds the job, coalescing it with pending work for the same pull request
+	Enqueue(ctx context.Context, job ReviewJob) (string, error)
+	// Dequeue blocks until a job is available or the context is done
+	Dequeue(ctx context.Context) (*ReviewJob, error)
+	// Complete reports the outcome of a job returned by Dequeue
+	Complete(ctx context.Context, job *ReviewJob, jobErr error) error
+	// IsCurrent reports whether the job still targets the latest known head of its pull request
+	IsCurrent(ctx context.Context, job *ReviewJob) (bool, error)
+	// Checkpoint records the last step the job completed
+	Checkpoint(ctx context.Context, job *ReviewJob, step string) error
+}
+
+// MemoryReviewJobQueue is an in-process ReviewJobQueue. Review jobs for the same pull request are
+// coalesced so that only the latest head SHA is reviewed, and redelivered webhooks are dropped.
+type MemoryReviewJobQueue struct {
+	mu         sync.Mutex
+	nextID     int64
+	jobs       []*ReviewJob
+	pending    map[string]*ReviewJob
+	inFlight   map[string]*ReviewJob
+	latestSHA  map[string]string
+	deliveries map[string]time.Time
+	ready      chan struct{}
+}
+
+// NewMemoryReviewJobQueue creates an empty queue
+func NewMemoryReviewJobQueue() *MemoryReviewJobQueue {
+	return &MemoryReviewJobQueue{
+		pending:    map[string]*ReviewJob{},
+		inFlight:   map[string]*ReviewJob{},
+		latestSHA:  map[string]string{},
+		deliveries: map[string]time.Time{},
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds the job to the queue. A review job for a head SHA that is already pending or being
+// reviewed is a duplicate, and a review job for a new head SHA replaces the pending one.
+func (q *MemoryReviewJobQueue) Enqueue(ctx context.Context, job ReviewJob) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for deliveryID, seenAt := range q.deliveries {
+		if now.Sub(seenAt) > deliveryRetention {
+			delete(q.deliveries, deliveryID)
+		}
+	}
+	if job.DeliveryID != "" {
+		if _, seen := q.deliveries[job.DeliveryID]; seen {
+			return EnqueueResultDuplicate, nil
+		}
+		q.deliveries[job.DeliveryID] = now
+	}
+
+	key := job.Key()
+	if job.Kind == ReviewJobKindReview && job.HeadSHA != "" {
+		q.latestSHA[key] = job.HeadSHA
+	}
+
+	result := EnqueueResultEnqueued
+	if job.Kind == ReviewJobKindReview {
+		if running, ok := q.inFlight[key]; ok && running.HeadSHA == job.HeadSHA {
+			return EnqueueResultDuplicate, nil
+		}
+		if pending, ok := q.pending[key]; ok {
+			if pending.HeadSHA == job.HeadSHA {
+				return EnqueueResultDuplicate, nil
+			}
+			q.removeJob(pending.ID)
+			result = EnqueueResultSuperseded
+		}
+	}
+
+	q.nextID++
+	job.ID = q.nextID
+	job.EnqueuedAt = now
+	q.jobs = append(q.jobs, &job)
+	if job.Kind == ReviewJobKindReview {
+		q.pending[key] = &job
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return result, nil
+}
+
+func (q *MemoryReviewJobQueue) removeJob(id int64) {
+	for i, queued := range q.jobs {
+		if queued.ID == id {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Dequeue returns the oldest job whose pull request isn't already being reviewed.
+func (q *MemoryReviewJobQueue) Dequeue(ctx context.Context) (*ReviewJob, error) {
+	for {
+		if job := q.take(); job != nil {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.ready:
+		}
+	}
+}
+
+func (q *MemoryReviewJobQueue) take() *ReviewJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.jobs {
+		// Jobs for a pull request run one at a time so comments and reviews are handled in order
+		if _, busy := q.inFlight[job.Key()]; busy {
+			continue
+		}
+		q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+		if pending, ok := q.pending[job.Key()]; ok && pending.ID == job.ID {
+			delete(q.pending, job.Key())
+		}
+		q.inFlight[job.Key()] = job
+		job.Attempts++
+
+		// Wake up another worker if there is more to do
+		if len(q.jobs) > 0 {
+			select {
+			case q.ready <- struct{}{}:
+			default:
+			}
+		}
+		return job
+	}
+	return nil
+}
+
+// Complete releases the job's pull request so its next job can run. The in-memory queue doesn't
+// retry failed jobs.
+func (q *MemoryReviewJobQueue) Complete(ctx context.Context, job *ReviewJob, jobErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if running, ok := q.inFlight[job.Key()]; ok && running.ID == job.ID {
+		delete(q.inFlight, job.Key())
+	}
+	if len(q.jobs) > 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// IsCurrent reports whether no review job for a newer head SHA has been seen for the job's pull
+// request.
+func (q *MemoryReviewJobQueue) IsCurrent(ctx context.Context, job *ReviewJob) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	latest, ok := q.latestSHA[job.Key()]
+	return !ok || job.HeadSHA == "" || latest == job.HeadSHA, nil
+}
+
+// Checkpoint records the step on the job. The in-memory queue doesn't survive restarts so there is
+// nothing to persist.
+func (q *MemoryReviewJobQueue) Checkpoint(ctx context.Context, job *ReviewJob, step string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job.Step = step
+	return nil
+}
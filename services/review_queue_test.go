@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryReviewJobQueueEnqueue(t *testing.T) {
+	review := func(deliveryID, headSHA string) ReviewJob {
+		return ReviewJob{Kind: ReviewJobKindReview, DeliveryID: deliveryID, Owner: "octo", Repo: "hello", PRNumber: 7, HeadSHA: headSHA}
+	}
+	tests := []struct {
+		name        string
+		jobs        []ReviewJob
+		wantResults []string
+		wantQueued  []string
+	}{
+		{
+			name:        "redelivered webhook",
+			jobs:        []ReviewJob{review("d1", "abc"), review("d1", "abc")},
+			wantResults: []string{EnqueueResultEnqueued, EnqueueResultDuplicate},
+			wantQueued:  []string{"abc"},
+		},
+		{
+			name:        "same head from another delivery",
+			jobs:        []ReviewJob{review("d1", "abc"), review("d2", "abc")},
+			wantResults: []string{EnqueueResultEnqueued, EnqueueResultDuplicate},
+			wantQueued:  []string{"abc"},
+		},
+		{
+			name:        "newer head",
+			jobs:        []ReviewJob{review("d1", "abc"), review("d2", "def")},
+			wantResults: []string{EnqueueResultEnqueued, EnqueueResultSuperseded},
+			wantQueued:  []string{"def"},
+		},
+		{
+			name: "comments aren't coalesced",
+			jobs: []ReviewJob{
+				{Kind: ReviewJobKindComment, DeliveryID: "d1", Owner: "octo", Repo: "hello", PRNumber: 7, CommentID: 1},
+				{Kind: ReviewJobKindComment, DeliveryID: "d2", Owner: "octo", Repo: "hello", PRNumber: 7, CommentID: 2},
+			},
+			wantResults: []string{EnqueueResultEnqueued, EnqueueResultEnqueued},
+			wantQueued:  []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := NewMemoryReviewJobQueue()
+			ctx := context.Background()
+			for i, job := range tt.jobs {
+				if result, err := queue.Enqueue(ctx, job); err != nil || result != tt.wantResults[i] {
+					t.Errorf("Enqueue(#%d) = %s, %v, want %s", i+1, result, err, tt.wantResults[i])
+				}
+			}
+
+			var queued []string
+			for _, job := range queue.jobs {
+				queued = append(queued, job.HeadSHA)
+			}
+			if len(queued) != len(tt.wantQueued) {
+				t.Fatalf("queued heads = %q, want %q", queued, tt.wantQueued)
+			}
+			for i := range queued {
+				if queued[i] != tt.wantQueued[i] {
+					t.Errorf("queued heads = %q, want %q", queued, tt.wantQueued)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryReviewJobQueueDuplicateOfRunningReview(t *testing.T) {
+	queue := NewMemoryReviewJobQueue()
+	ctx := context.Background()
+	job := ReviewJob{Kind: ReviewJobKindReview, DeliveryID: "d1", Owner: "octo", Repo: "hello", PRNumber: 7, HeadSHA: "abc"}
+	queue.Enqueue(ctx, job)
+	running, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job.DeliveryID = "d2"
+	if result, _ := queue.Enqueue(ctx, job); result != EnqueueResultDuplicate {
+		t.Errorf("Enqueue() of the running head = %s, want duplicate", result)
+	}
+	job.DeliveryID, job.HeadSHA = "d3", "def"
+	if result, _ := queue.Enqueue(ctx, job); result != EnqueueResultEnqueued {
+		t.Errorf("Enqueue() of a newer head = %s, want enqueued", result)
+	}
+	if current, _ := queue.IsCurrent(ctx, running); current {
+		t.Error("IsCurrent() = true for the review of a superseded head")
+	}
+}
+
+func TestMemoryReviewJobQueueIndexJobDoesNotSupersedeReview(t *testing.T) {
+	queue := NewMemoryReviewJobQueue()
+	ctx := context.Background()
+	queue.Enqueue(ctx, ReviewJob{Kind: ReviewJobKindReview, DeliveryID: "d1", Owner: "octo", Repo: "hello", PRNumber: 7, HeadSHA: "abc"})
+	review, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue.Enqueue(ctx, ReviewJob{Kind: ReviewJobKindIndex, DeliveryID: "d2", Owner: "octo", Repo: "hello", PRNumber: 7, HeadSHA: "merge"})
+	if current, _ := queue.IsCurrent(ctx, review); !current {
+		t.Error("IsCurrent() = false after an index job for the merge commit")
+	}
+}
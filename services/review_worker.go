@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReviewJobHandler runs a review job. Handlers should checkpoint the steps they complete so a
+// retried job doesn't repeat work with side effects, such as posting comments.
+type ReviewJobHandler func(ctx context.Context, queue ReviewJobQueue, job *ReviewJob) error
+
+var (
+	reviewJobHandlersMu sync.RWMutex
+	reviewJobHandlers   = map[string]ReviewJobHandler{}
+)
+
+// RegisterReviewJobHandler sets the handler for jobs of the given kind
+func RegisterReviewJobHandler(kind string, handler ReviewJobHandler) {
+	reviewJobHandlersMu.Lock()
+	defer reviewJobHandlersMu.Unlock()
+	reviewJobHandlers[kind] = handler
+}
+
+// DispatchReviewJob runs the handler registered for the job's kind
+func DispatchReviewJob(ctx context.Context, queue ReviewJobQueue, job *ReviewJob) error {
+	reviewJobHandlersMu.RLock()
+	handler, ok := reviewJobHandlers[job.Kind]
+	reviewJobHandlersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler registered for %s jobs", job.Kind)
+	}
+	return handler(ctx, queue, job)
+}
+
+// MissingReviewJobHandlers returns the kinds of ReviewJobKinds that have no registered handler
+func MissingReviewJobHandlers() []string {
+	reviewJobHandlersMu.RLock()
+	defer reviewJobHandlersMu.RUnlock()
+
+	var missing []string
+	for _, kind := range ReviewJobKinds {
+		if _, ok := reviewJobHandlers[kind]; !ok {
+			missing = append(missing, kind)
+		}
+	}
+	return missing
+}
+
+// leaseExtender is implemented by queues whose leases expire while a job is running
+type leaseExtender interface {
+	ExtendLease(ctx context.Context, job *ReviewJob) error
+}
+
+// ReviewWorkerPool runs review jobs from a queue with a fixed number of workers
+type ReviewWorkerPool struct {
+	queue         ReviewJobQueue
+	handler       ReviewJobHandler
+	size          int
+	leaseInterval time.Duration
+}
+
+// NewReviewWorkerPool creates a pool of size workers. Leases are extended every leaseInterval
+// while a job runs, for queues that support it.
+func NewReviewWorkerPool(queue ReviewJobQueue, handler ReviewJobHandler, size int, leaseInterval time.Duration) *ReviewWorkerPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &ReviewWorkerPool{
+		queue:         queue,
+		handler:       handler,
+		size:          size,
+		leaseInterval: leaseInterval,
+	}
+}
+
+// Run starts the workers and blocks until the context is cancelled and running jobs have finished
+func (p *ReviewWorkerPool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.size; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			p.work(ctx, worker)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (p *ReviewWorkerPool) work(ctx context.Context, worker int) {
+	for {
+		job, err := p.queue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error().Err(err).Int("worker", worker).Msg("Review worker failed to dequeue")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		jobErr := p.run(ctx, job)
+		if jobErr != nil && !errors.Is(jobErr, ErrReviewJobSuperseded) {
+			log.Error().Err(jobErr).
+				Int64("job_id", job.ID).
+				Str("kind", job.Kind).
+				Str("pull_request", job.Key()).
+				Int("attempt", job.Attempts).
+				Msg("Review job failed")
+		}
+
+		// Report the outcome even when shutting down so the job isn't left leased
+		completeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := p.queue.Complete(completeCtx, job, jobErr); err != nil {
+			log.Error().Err(err).Int("worker", worker).Int64("job_id", job.ID).Msg("Review worker failed to complete job")
+		}
+		cancel()
+	}
+}
+
+// run calls the handler, extending the job's lease while it runs and recovering from panics
+func (p *ReviewWorkerPool) run(ctx context.Context, job *ReviewJob) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	if extender, ok := p.queue.(leaseExtender); ok && p.leaseInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(p.leaseInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := extender.ExtendLease(ctx, job); err != nil {
+						log.Warn().Err(err).Int64("job_id", job.ID).Msg("Failed to extend lease of review job")
+					}
+				}
+			}
+		}()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("review job panicked: %v", r)
+		}
+	}()
+	return p.handler(ctx, p.queue, job)
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withReviewJobHandlers replaces the registered handlers for the duration of the test
+func withReviewJobHandlers(t *testing.T, handlers map[string]ReviewJobHandler) {
+	reviewJobHandlersMu.Lock()
+	previous := reviewJobHandlers
+	reviewJobHandlers = handlers
+	reviewJobHandlersMu.Unlock()
+
+	t.Cleanup(func() {
+		reviewJobHandlersMu.Lock()
+		reviewJobHandlers = previous
+		reviewJobHandlersMu.Unlock()
+	})
+}
+
+func TestReviewWorkerPoolDispatchesJobs(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string]string{}
+	done := make(chan struct{}, 2)
+	record := func(ctx context.Context, queue ReviewJobQueue, job *ReviewJob) error {
+		if err := queue.Checkpoint(ctx, job, ReviewJobStepPosted); err != nil {
+			return err
+		}
+		mu.Lock()
+		handled[job.Kind] = job.Key()
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}
+	withReviewJobHandlers(t, map[string]ReviewJobHandler{
+		ReviewJobKindReview:  record,
+		ReviewJobKindComment: record,
+	})
+
+	queue := NewMemoryReviewJobQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		NewReviewWorkerPool(queue, DispatchReviewJob, 2, time.Minute).Run(ctx)
+		close(stopped)
+	}()
+
+	jobs := []ReviewJob{
+		{Kind: ReviewJobKindReview, DeliveryID: "1", Owner: "octo", Repo: "hello", PRNumber: 3, HeadSHA: "abc123"},
+		{Kind: ReviewJobKindComment, DeliveryID: "2", Owner: "octo", Repo: "hello", PRNumber: 4, CommentBody: "/review"},
+	}
+	for _, job := range jobs {
+		if result, err := queue.Enqueue(ctx, job); err != nil || result != EnqueueResultEnqueued {
+			t.Fatalf("Enqueue() = %s, %v", result, err)
+		}
+	}
+
+	for range jobs {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the workers")
+		}
+	}
+	cancel()
+	<-stopped
+
+	if handled[ReviewJobKindReview] != "octo/hello#3" || handled[ReviewJobKindComment] != "octo/hello#4" {
+		t.Errorf("handled = %v, want both jobs dispatched to their handlers", handled)
+	}
+}
+
+func TestReviewWorkerPoolRecoversFromPanics(t *testing.T) {
+	pool := NewReviewWorkerPool(NewMemoryReviewJobQueue(), func(ctx context.Context, queue ReviewJobQueue, job *ReviewJob) error {
+		panic("nil workflow")
+	}, 1, 0)
+
+	err := pool.run(context.Background(), &ReviewJob{Kind: ReviewJobKindReview})
+	if err == nil || err.Error() != "review job panicked: nil workflow" {
+		t.Errorf("run() = %v, want the panic returned as an error", err)
+	}
+}
+
+func TestDispatchReviewJobWithoutHandler(t *testing.T) {
+	withReviewJobHandlers(t, map[string]ReviewJobHandler{
+		ReviewJobKindReview: func(ctx context.Context, queue ReviewJobQueue, job *ReviewJob) error {
+			return errors.New("not called")
+		},
+	})
+
+	err := DispatchReviewJob(context.Background(), NewMemoryReviewJobQueue(), &ReviewJob{Kind: ReviewJobKindIndex})
+	if err == nil || err.Error() != "no handler registered for index jobs" {
+		t.Errorf("DispatchReviewJob() = %v, want a missing handler error", err)
+	}
+
+	missing := MissingReviewJobHandlers()
+	if len(missing) != len(ReviewJobKinds)-1 || missing[0] != ReviewJobKindComment {
+		t.Errorf("MissingReviewJobHandlers() = %v, want every kind but review", missing)
+	}
+}
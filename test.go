@@ -1,6 +1,9 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,12 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"code-review-bot-test-repo/pkg/notify"
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/tonyd3/propel-gtm/api/clients"
 	"github.com/tonyd3/propel-gtm/api/logging"
 	"github.com/tonyd3/propel-gtm/api/models"
 	"github.com/tonyd3/propel-gtm/api/types"
-	"github.com/tonyd3/propel-gtm/api/utils"
 	"go.uber.org/zap"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -23,12 +26,43 @@ import (
 
 // ReviewPullRequest performs a code review on a specific pull request
 func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *ContextBuilder, checkIfAlreadyApproved bool, checkExistingComments bool) ([]*InternalReviewComment, []clients.PullRequestFile, error) {
+	return w.ReviewPullRequestContext(context.Background(), prNumber, contextBuilder, checkIfAlreadyApproved, checkExistingComments)
+}
+
+// ReviewPullRequestContext performs a code review on a specific pull request. The review stops
+// when ctx is cancelled or the review deadline passes, returning the comments of the providers
+// that finished in time. When the models fail, the findings of the deterministic checks are
+// returned along with the error.
+func (w *CodeReviewWorkflow) ReviewPullRequestContext(ctx context.Context, prNumber int, contextBuilder *ContextBuilder, checkIfAlreadyApproved bool, checkExistingComments bool) (reviewComments []*InternalReviewComment, reviewFiles []clients.PullRequestFile, reviewErr error) {
 	logger := logging.GetGlobalLogger()
-	requestID := "" // Initialize with empty string since we don't have requestID in this context
+
+	ctx, cancel := context.WithTimeout(ctx, reviewTimeout())
+	defer cancel()
+
+	// Each run is traced, the trace ID doubles as the request ID correlating the run's log lines
+	ctx, requestID := w.startReviewTrace(ctx, prNumber)
+	defer func() {
+		w.endReviewTrace(reviewErr)
+	}()
+	// Bound after the trace starts so GitHub calls made without a context, e.g. loading the repo
+	// config, are recorded in the review's trace
+	defer w.bindGitHubClient(ctx)()
+
+	// What this run covers is only known once the files are narrowed down below
+	w.reviewScope = reviewScope{}
+
+	// Track token usage and cost of every model call made for this review
+	w.costLedger = NewReviewCostLedger()
+	defer w.persistCostLedger(prNumber)
+
+	// Record the run and its steps for the review dashboard
+	w.startReviewRun(prNumber, requestID)
+	defer func() {
+		w.finishReviewRun(reviewFiles, reviewErr)
+	}()
 
 	// Log workflow start
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"review_pull_request_start",
@@ -38,10 +72,12 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		},
 	)
 
-	prDetails, err := w.GetPullRequestDetails(prNumber)
+	prDetailsStart := time.Now()
+	prDetails, err := tracedCall(ctx, "github.get_pull_request", func(ctx context.Context) (*clients.PullRequestDetails, error) {
+		return w.github(ctx).GetPullRequestDetails(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	})
 	if err != nil {
-		logging.LogWorkflowError(
-			"CODE_REVIEW",
+		w.logWorkflowError(
 			prNumber,
 			requestID,
 			err,
@@ -65,8 +101,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	//	return nil, nil, fmt.Errorf("AI review skipped due to label 'ai:skip-review'")
 	//}
 
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"get_pr_details",
@@ -81,8 +116,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 
 	// Step 3: Double Check if a review is still required, skip review if already approved
 	if checkIfAlreadyApproved && w.IsPRAlreadyApproved(prNumber) {
-		logging.LogWorkflowStep(
-			"CODE_REVIEW",
+		w.logWorkflowStep(
 			prNumber,
 			requestID,
 			"check_already_approved",
@@ -98,14 +132,15 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 
 	// Step 4: Get pull request files
 	filesStart := time.Now()
-	files, err := w.githubConfig.Client.GetPullRequestFiles(
-		w.githubConfig.Token,
-		w.githubConfig.Owner,
-		w.githubConfig.Repo,
-		prNumber)
+	files, err := tracedCall(ctx, "github.list_pull_request_files", func(ctx context.Context) ([]clients.PullRequestFile, error) {
+		return w.github(ctx).GetPullRequestFiles(
+			w.githubConfig.Token,
+			w.githubConfig.Owner,
+			w.githubConfig.Repo,
+			prNumber)
+	})
 	if err != nil {
-		logging.LogWorkflowError(
-			"CODE_REVIEW",
+		w.logWorkflowError(
 			prNumber,
 			requestID,
 			err,
@@ -117,8 +152,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		)
 		return nil, nil, fmt.Errorf("failed to get PR files: %w", err)
 	}
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"get_pr_files",
@@ -133,6 +167,66 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	// Store files in workflow for later use
 	w.prFiles = files
 
+	// Learn from how the team reacted to the earlier comments before reviewing again
+	if models.IsFeatureEnabledForCompany(w.db, featureFeedbackLearning, w.repoWorkflowSetting.CompanyId) {
+		w.collectReviewFeedback(prNumber, prDetails.Head.SHA)
+	}
+
+	// Narrow the review to the commits pushed since the last review when incremental review is enabled
+	incrementalReview := false
+	if models.IsFeatureEnabledForCompany(w.db, featureIncrementalReview, w.repoWorkflowSetting.CompanyId) {
+		lastReviewedSHA := w.lastReviewedCommit(prNumber)
+		if incrementalFiles, ok := w.filesSinceLastReview(prNumber, files, lastReviewedSHA, prDetails.Head.SHA); ok {
+			w.logWorkflowStep(
+				prNumber,
+				requestID,
+				"incremental_review",
+				map[string]interface{}{
+					"repository":         w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+					"last_reviewed_sha":  lastReviewedSHA,
+					"head_sha":           prDetails.Head.SHA,
+					"pr_file_count":      len(files),
+					"changed_file_count": len(incrementalFiles),
+				},
+			)
+			if len(incrementalFiles) == 0 {
+				logger.Info("No new changes since the last review", zap.Int("prNumber", prNumber), zap.String("last_reviewed_sha", lastReviewedSHA))
+				// Return nil for files as it's a successful early exit
+				return nil, nil, nil
+			}
+			files = incrementalFiles
+			incrementalReview = true
+		}
+	}
+
+	// Limit the review to the requested paths, e.g. for "/review path/to/file.go"
+	if len(w.pathFilter) > 0 {
+		files = filterFilesByPath(files, w.pathFilter)
+		if len(files) == 0 {
+			logger.Info("No changed files match the requested paths", zap.Int("prNumber", prNumber), zap.Strings("paths", w.pathFilter))
+			return nil, nil, nil
+		}
+	}
+	w.reviewScope = reviewScope{
+		HeadSHA:      prDetails.Head.SHA,
+		Incremental:  incrementalReview,
+		PathFiltered: len(w.pathFilter) > 0,
+	}
+
+	// Apply the repository's review config from the base branch
+	w.repoConfig = w.loadRepoConfig(prNumber, prDetails.Base.SHA)
+	w.codeOwners = nil
+	if models.IsFeatureEnabledForCompany(w.db, featureOwnerMentions, w.repoWorkflowSetting.CompanyId) {
+		w.codeOwners = w.loadCodeOwners(prDetails.Base.SHA)
+	}
+	if w.repoConfig != nil {
+		files = filterFilesByRepoConfig(files, w.repoConfig)
+		if len(files) == 0 {
+			logger.Info("No changed files are included by the review config", zap.Int("prNumber", prNumber))
+			return nil, nil, nil
+		}
+	}
+
 	// Extract filenames for dependency analysis
 	filePaths := make([]string, len(files))
 	for i, file := range files {
@@ -143,7 +237,11 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	if checkExistingComments {
 		// Fetch existing comments for deduplication
 		commentsStart := time.Now()
-		existingComments, err = w.FetchExistingComments(prNumber)
+		existingComments, err = tracedCall(ctx, "github.fetch_existing_comments", func(ctx context.Context) ([]clients.PullRequestComment, error) {
+			return recordStep(w, "github", "FetchExistingComments", pullRequestRef{w.githubConfig.Owner, w.githubConfig.Repo, prNumber}, func() ([]clients.PullRequestComment, error) {
+				return w.FetchExistingComments(prNumber)
+			})
+		})
 		existingComments = filterOutExternalBotComments(existingComments)
 		if err != nil {
 			logger.Warn("Failed to fetch existing comments",
@@ -153,8 +251,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 			// Continue with the review even if we can't fetch existing comments
 			existingComments = []clients.PullRequestComment{}
 		}
-		logging.LogWorkflowStep(
-			"CODE_REVIEW",
+		w.logWorkflowStep(
 			prNumber,
 			requestID,
 			"fetch_existing_comments",
@@ -170,7 +267,10 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	// Build unified author context from PR description and comments
 	authorContextStart := time.Now()
 	authorContextBuilder := NewAuthorContextBuilder(w.githubConfig)
-	authorContext, err := authorContextBuilder.BuildAuthorContext(prNumber, prDetails.Body, prDetails.User.Login)
+	authorContextRequest := map[string]interface{}{"pull_request": pullRequestRef{w.githubConfig.Owner, w.githubConfig.Repo, prNumber}, "body": prDetails.Body, "author": prDetails.User.Login}
+	authorContext, err := recordStep(w, "github", "BuildAuthorContext", authorContextRequest, func() (*AuthorContext, error) {
+		return authorContextBuilder.BuildAuthorContext(prNumber, prDetails.Body, prDetails.User.Login)
+	})
 	if err != nil {
 		logger.Warn("Failed to build author context",
 			zap.Error(err),
@@ -183,8 +283,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 			PRAuthor:   prDetails.User.Login,
 		}
 	}
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"build_author_context",
@@ -232,7 +331,8 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	var additionalContext map[string]interface{}
 	if contextBuilder != nil {
 		var err error
-		additionalContext, err = contextBuilder.Build()
+		contextRequest := map[string]interface{}{"pull_request": pullRequestRef{w.githubConfig.Owner, w.githubConfig.Repo, prNumber}, "commit": commit, "files": filePaths}
+		additionalContext, err = recordStep(w, "context", "Build", contextRequest, contextBuilder.Build)
 		if err != nil {
 			logger.Warn("Failed to build additional context",
 				zap.Error(err),
@@ -296,6 +396,52 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		additionalContext["author_context"] = authorContext
 	}
 
+	// Let the models see what was already said so they don't repeat resolved feedback
+	if incrementalReview {
+		additionalContext["incremental_review"] = true
+		if previousComments := w.previousReviewComments(prNumber); len(previousComments) > 0 {
+			additionalContext["previous_review_comments"] = previousComments
+		}
+	}
+
+	// Run the deterministic checks first and tell the models about their findings so they aren't
+	// repeated. Like secrets, the findings skip duplicate detection and model validation.
+	var staticComments []*InternalReviewComment
+	if models.IsFeatureEnabledForCompany(w.db, featureStaticRules, w.repoWorkflowSetting.CompanyId) {
+		staticComments = withoutPostedFindings(w.runStaticRules(prNumber, requestID, files, commit), existingComments)
+		if len(staticComments) > 0 {
+			additionalContext["static_findings"] = summarizeStaticFindings(staticComments)
+		}
+	}
+
+	// Scan the added lines for secrets, the findings skip model validation so they can't be filtered out
+	var secretComments []*InternalReviewComment
+	if models.IsFeatureEnabledForCompany(w.db, featureSecretScanning, w.repoWorkflowSetting.CompanyId) {
+		secretComments = w.scanForSecrets(prNumber, requestID, files, w.loadSecretsAllowlist(prDetails.Base.SHA))
+	}
+
+	// Let the models know which feedback the maintainers asked to stop raising
+	if ignoreRules := w.loadIgnoreRules(); len(ignoreRules) > 0 {
+		additionalContext["ignored_rules"] = ignoreRules
+	}
+
+	// Let the models know which kinds of comments the team keeps dismissing
+	if models.IsFeatureEnabledForCompany(w.db, featureFeedbackLearning, w.repoWorkflowSetting.CompanyId) {
+		if suppressionRules := w.loadSuppressionRules(); len(suppressionRules) > 0 {
+			additionalContext["suppression_rules"] = suppressionRules
+		}
+	}
+
+	// Add the language instructions from the repository's review config
+	if w.repoConfig != nil {
+		if len(w.fileInstructions(files)) > 0 {
+			additionalContext["has_file_instructions"] = true
+		}
+		if instructions := w.repoConfig.LanguageInstructions(filePaths); len(instructions) > 0 {
+			additionalContext["language_instructions"] = instructions
+		}
+	}
+
 	// Add commitable suggestions flag from configuration (default to false for backward compatibility)
 	if w.config != nil {
 		additionalContext["committable_suggestions_enabled"] = w.config.CommittableSuggestions
@@ -304,8 +450,14 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	}
 	additionalContext["workflow_company_id"] = w.repoWorkflowSetting.CompanyId
 
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	// Add the code from the rest of the repository most related to the changes
+	if models.IsFeatureEnabledForCompany(w.db, string(types.AgenticWorkflow), w.repoWorkflowSetting.CompanyId) {
+		if relatedCode := w.retrieveRelatedCode(ctx, prNumber, requestID, files); len(relatedCode) > 0 {
+			additionalContext["related_code"] = relatedCode
+		}
+	}
+
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"prepare_context",
@@ -343,15 +495,21 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	tokenBudget := (MaxAllowedTokens - contextMessageTokenCount) * 75 / 100
 	userMessage := w.prepareUserMessage(files, tokenBudget)
 
-	if models.IsFeatureEnabledForCompany(w.db, string(types.AgenticWorkflow), w.repoWorkflowSetting.CompanyId) {
-		ragContext := w.prepareContextFromRAG(files, fmt.Sprintf("%s-%s", w.githubConfig.Owner, w.githubConfig.Repo))
-		logger.Info("RAG context", zap.String("rag_context", ragContext))
+	// Split diffs that don't fit the budget into batches of related files and hunks instead of pruning them,
+	// so every changed line gets reviewed
+	var reviewBatches [][]clients.PullRequestFile
+	if models.IsFeatureEnabledForCompany(w.db, featureChunkedReview, w.repoWorkflowSetting.CompanyId) && estimatePatchTokens(files) > tokenBudget {
+		reviewBatches = buildReviewBatches(files, additionalContext, tokenBudget)
+		logger.Info("Reviewing pull request in batches",
+			zap.Int("pr_number", prNumber),
+			zap.Int("file_count", len(files)),
+			zap.Int("batch_count", len(reviewBatches)))
 	}
+	batchContextMessage := contextMessage
 
 	// Log context message to file for debugging
 	if err := logContextMessage(contextMessage, userMessage, commit, prNumber, w, files, contextMessageTokenCount); err != nil {
-		logging.LogWorkflowError(
-			"CODE_REVIEW",
+		w.logWorkflowError(
 			prNumber,
 			requestID,
 			err,
@@ -378,8 +536,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	tokenCount = CountTokens(combinedMessage)
 	fmt.Printf("New Size of the current combinedMessage tokens: %d\n", tokenCount)
 
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"prepare_messages",
@@ -392,10 +549,28 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		},
 	)
 
+	// Generate the PR summary alongside the review, it works off the full set of PR files and the same context
+	if models.IsFeatureEnabledForCompany(w.db, featurePullRequestSummary, w.repoWorkflowSetting.CompanyId) {
+		summaryContext := make(map[string]interface{}, len(additionalContext))
+		for key, value := range additionalContext {
+			summaryContext[key] = value
+		}
+		summaryDone := make(chan struct{})
+		go func() {
+			defer close(summaryDone)
+			if err := w.generatePullRequestSummary(ctx, prNumber, requestID, w.prFiles, summaryContext, commit); err != nil {
+				logger.Warn("Failed to generate PR summary",
+					zap.Error(err),
+					zap.Int("pr_number", prNumber),
+					zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
+			}
+		}()
+		defer func() { <-summaryDone }()
+	}
+
 	// Step 7: Generate AI review
 	aiStart := time.Now()
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"call_ai_model_start",
@@ -405,29 +580,54 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		},
 	)
 
-	// Call all three AI models and merge their comments together
-	internalComments, err := w.callMultipleAIModels(
-		contextMessage,
-		userMessage,
-		tokenCount,
-		additionalContext,
-		commit,
-		files,
-		prNumber,
-		requestID,
-		aiStart,
-	)
+	// Call all enabled AI models and merge their comments together
+	var internalComments []*InternalReviewComment
+	if len(reviewBatches) > 1 {
+		internalComments, err = w.callMultipleAIModelsInBatches(
+			ctx,
+			batchContextMessage,
+			reviewBatches,
+			tokenBudget,
+			additionalContext,
+			commit,
+			prNumber,
+			requestID,
+			aiStart,
+		)
+	} else {
+		internalComments, err = w.callMultipleAIModels(
+			ctx,
+			contextMessage,
+			userMessage,
+			tokenCount,
+			additionalContext,
+			commit,
+			files,
+			prNumber,
+			requestID,
+			aiStart,
+		)
+	}
 	if err != nil {
 		logging.GetGlobalLogger().Warn("Failed to generate AI review", zap.Error(err))
-		// Return files as we have them, but also the error from AI model call
-		return nil, files, err
+		// Return files as we have them and the deterministic findings, which don't depend on the
+		// models, but also the error from AI model call
+		w.reviewScope.ModelsFailed = true
+		return append(secretComments, staticComments...), files, err
 	}
 
 	// NEW: Apply separate duplicate detection service AFTER AI generation
 	if useSeparateDuplicateDetection {
 		duplicateDetectionStart := time.Now()
 		duplicateDetectionService := NewDuplicateDetectionService(w.aiConfig)
-		internalComments, err = duplicateDetectionService.FilterDuplicates(internalComments, existingComments, prNumber, requestID)
+		duplicateRequest := map[string]interface{}{"comments": internalComments, "existing_comments": existingComments}
+		duplicatePrompt, _ := json.Marshal(duplicateRequest)
+		// Duplicate detection runs on the OpenAI model of the AI config
+		internalComments, err = meteredStep(w, prNumber, "openai", w.aiConfig.GetOpenAIModel(), "duplicate_detection", string(duplicatePrompt), func() ([]*InternalReviewComment, error) {
+			return recordStep(w, "model", "duplicate-detection", duplicateRequest, func() ([]*InternalReviewComment, error) {
+				return duplicateDetectionService.FilterDuplicates(internalComments, existingComments, prNumber, requestID)
+			})
+		})
 		if err != nil {
 			logger.Warn("Failed to apply duplicate detection",
 				zap.Error(err),
@@ -435,8 +635,7 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 				zap.String("repository", w.githubConfig.Owner+"/"+w.githubConfig.Repo))
 			// Continue with original comments if duplicate detection fails
 		}
-		logging.LogWorkflowStep(
-			"CODE_REVIEW",
+		w.logWorkflowStep(
 			prNumber,
 			requestID,
 			"duplicate_detection",
@@ -449,26 +648,29 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 
 	// Validate Review Comments
 	validateReviews := models.IsFeatureEnabledForCompany(w.db, string(types.ValidateReviews), w.repoWorkflowSetting.CompanyId)
-	if validateReviews {
-		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, w.aiConfig.CallOpenAI, w.aiConfig.GetOpenAIModel())
+	if validateReviews && !w.skipOptionalPass(prNumber, "validate_reviews") {
+		validateFn := w.meteredModelCall(prNumber, "openai", w.aiConfig.GetOpenAIModel(), "validate_reviews", w.recordModelCall("validate-openai", contextModelCall(ctx, w.aiConfig.CallOpenAIContext)))
+		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, validateFn, w.aiConfig.GetOpenAIModel())
 		if err != nil {
 			logging.GetGlobalLogger().Warn("Failed to validate comments", zap.Error(err))
 		}
 	}
 
 	validateReviewsGemini := models.IsFeatureEnabledForCompany(w.db, string(types.ValidateReviewsGemini), w.repoWorkflowSetting.CompanyId)
-	if validateReviewsGemini {
-		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, w.aiConfig.CallGemini, w.aiConfig.GetGeminiModel())
+	if validateReviewsGemini && !w.skipOptionalPass(prNumber, "validate_reviews_gemini") {
+		validateFn := w.meteredModelCall(prNumber, "google", w.aiConfig.GetGeminiModel(), "validate_reviews_gemini", w.recordModelCall("validate-gemini", contextModelCall(ctx, w.aiConfig.CallGeminiContext)))
+		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, validateFn, w.aiConfig.GetGeminiModel())
 		if err != nil {
 			logging.GetGlobalLogger().Warn("Failed to validate comments", zap.Error(err))
 		}
 	}
 
 	validateReviewsOpus := models.IsFeatureEnabledForCompany(w.db, string(types.ValidateReviewsOpus), w.repoWorkflowSetting.CompanyId)
-	if validateReviewsOpus {
-		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, func(contextMessage, userMessage string) (string, error) {
-			return w.aiConfig.CallAnthropicWithModel(contextMessage, userMessage, anthropic.ModelClaudeOpus4_20250514)
-		}, string(w.aiConfig.GetAnthropicModel()))
+	if validateReviewsOpus && !w.skipOptionalPass(prNumber, "validate_reviews_opus") {
+		validateFn := w.meteredModelCall(prNumber, "anthropic", string(anthropic.ModelClaudeOpus4_20250514), "validate_reviews_opus", w.recordModelCall("validate-opus", func(contextMessage, userMessage string) (string, error) {
+			return w.aiConfig.CallAnthropicWithModelContext(ctx, contextMessage, userMessage, anthropic.ModelClaudeOpus4_20250514)
+		}))
+		internalComments, err = w.ReviewComments(internalComments, files, authorContext, prNumber, requestID, validateFn, string(w.aiConfig.GetAnthropicModel()))
 
 		if err != nil {
 			logging.GetGlobalLogger().Warn("Failed to validate comments", zap.Error(err))
@@ -476,16 +678,26 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 	}
 
 	// Get Previously Provided Comments
-	previousComments, err := w.githubConfig.Client.GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	previousComments, err := tracedCall(ctx, "github.list_review_comments", func(ctx context.Context) ([]clients.PullRequestComment, error) {
+		return w.github(ctx).GetPullRequestReviewComments(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber)
+	})
 	if err != nil {
 		logging.GetGlobalLogger().Error("Failed to get PR comments", zap.Error(err))
 	}
 	// Remove comments left by external bots so we don't mis‑detect duplicates
 	previousComments = filterOutExternalBotComments(previousComments)
 	// Use a token budget of 0 as we only need patch structure, not full file content for this validation.
-	parsedFileChanges := ExtractAllFileChanges(w.githubConfig, files, 0)
+	parsedFileChanges := w.extractAllFileChanges(files, 0)
 
-	for _, comment := range internalComments {
+	for i, comment := range internalComments {
+		// Out of time, report what's left as filtered instead of posting it unchecked
+		if ctx.Err() != nil {
+			rejected := rejectUnvalidatedComments(internalComments[i:])
+			logger.Warn("Review deadline exceeded during validation",
+				zap.Int("pr_number", prNumber),
+				zap.Int("unvalidated_count", rejected))
+			break
+		}
 		if len(comment.RejectionReason) != 0 {
 			continue
 		}
@@ -524,6 +736,9 @@ func (w *CodeReviewWorkflow) ReviewPullRequest(prNumber int, contextBuilder *Con
 		}
 	}
 
+	internalComments = append(staticComments, internalComments...)
+	internalComments = append(secretComments, internalComments...)
+
 	// Return successfully generated comments and the files
 	return internalComments, files, nil
 }
@@ -569,8 +784,9 @@ func BuildFullPatch(files []clients.PullRequestFile) string {
 	return sb.String()
 }
 
-// callMultipleAIModels calls all three AI models in parallel using goroutines and merges their comments together
+// callMultipleAIModels calls every enabled review provider in parallel using goroutines and merges their comments together
 func (w *CodeReviewWorkflow) callMultipleAIModels(
+	ctx context.Context,
 	contextMessage string,
 	userMessage string,
 	tokenCount int,
@@ -583,65 +799,30 @@ func (w *CodeReviewWorkflow) callMultipleAIModels(
 ) ([]*InternalReviewComment, error) {
 	// Create channels for results and errors
 	type modelResult struct {
-		comments  []*InternalReviewComment
-		err       error
-		modelName string
+		comments []*InternalReviewComment
+		err      error
+		index    int
 	}
 
-	useGemini := models.IsFeatureEnabledForCompany(w.db, string(types.AddGeminiResults), w.repoWorkflowSetting.CompanyId)
-	useOpenAI := models.IsFeatureEnabledForCompany(w.db, string(types.AddOpenAIResults), w.repoWorkflowSetting.CompanyId)
-
-	// Determine how many models we'll call based on feature flags
-	modelCount := 1 // Anthropic is always enabled
-	if useOpenAI {
-		modelCount++
-	}
-	if useGemini {
-		modelCount++
+	// Providers come back ordered by merge priority, the first one is the primary
+	providers := w.resolveReviewProviders()
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no AI providers enabled for company %d", w.repoWorkflowSetting.CompanyId)
 	}
 
-	resultChan := make(chan modelResult, modelCount)
-	go func() {
-		comments, err := w.callAIModel(
-			contextMessage,
-			userMessage,
-			tokenCount,
-			additionalContext,
-			commit,
-			files,
-			prNumber,
-			requestID+"-anthropic",
-			aiStart,
-			w.aiConfig.CallAnthropic,
-			"anthropic",
-			string(w.aiConfig.GetAnthropicModel()),
-		)
-		resultChan <- modelResult{comments, err, "Anthropic"}
-	}()
-
-	if useOpenAI {
-		go func() {
-			comments, err := w.callAIModel(
-				contextMessage,
-				userMessage,
-				tokenCount,
-				additionalContext,
-				commit,
-				files,
-				prNumber,
-				requestID+"-openai",
-				aiStart,
-				w.aiConfig.CallOpenAI,
-				"openai",
-				w.aiConfig.GetOpenAIModel(),
-			)
-			resultChan <- modelResult{comments, err, "OpenAI"}
-		}()
+	activeNames := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		activeNames[provider.Name()] = true
 	}
 
-	if useGemini {
+	resultChan := make(chan modelResult, len(providers))
+	for i, provider := range providers {
 		go func() {
-			comments, err := w.callAIModel(
+			// A failing provider falls back along its chain so a vendor outage degrades the review instead of dropping it
+			comments, err := w.callAIModelWithFallback(
+				ctx,
+				provider,
+				activeNames,
 				contextMessage,
 				userMessage,
 				tokenCount,
@@ -649,111 +830,101 @@ func (w *CodeReviewWorkflow) callMultipleAIModels(
 				commit,
 				files,
 				prNumber,
-				requestID+"-gemini",
+				requestID,
 				aiStart,
-				w.aiConfig.CallGemini,
-				"google",
-				w.aiConfig.GetGeminiModel(),
 			)
-			resultChan <- modelResult{comments, err, "Gemini"}
+			resultChan <- modelResult{comments, err, i}
 		}()
-
 	}
 
 	// Collect results
-	var anthropicComments, openaiComments, geminiComments []*InternalReviewComment
-	var err1, err2, err3 error
+	providerComments := make([][]*InternalReviewComment, len(providers))
+	providerErrors := make([]error, len(providers))
 
-	// Wait for all results based on the number of models we're calling
-	for range modelCount {
+	// Wait for all results based on the number of providers we're calling
+	for range providers {
 		result := <-resultChan
-		switch result.modelName {
-		case "Anthropic":
-			anthropicComments = result.comments
-			err1 = result.err
-			if err1 != nil {
-				logging.GetGlobalLogger().Error("Error calling Anthropic", zap.Error(err1))
-			}
-		case "OpenAI":
-			openaiComments = result.comments
-			err2 = result.err
-			if err2 != nil {
-				logging.GetGlobalLogger().Error("Error calling OpenAI", zap.Error(err2))
-			}
-		case "Gemini":
-			geminiComments = result.comments
-			err3 = result.err
-			if err3 != nil {
-				logging.GetGlobalLogger().Error("Error calling Gemini", zap.Error(err3))
-			}
+		providerComments[result.index] = result.comments
+		providerErrors[result.index] = result.err
+		if result.err != nil {
+			logging.GetGlobalLogger().Error("Error calling "+providers[result.index].DisplayName(), zap.Error(result.err))
 		}
 	}
 
 	// Check if all calls failed
-	if err1 != nil && err2 != nil && err3 != nil {
-		return nil, fmt.Errorf("all AI model calls failed: Anthropic: %v, OpenAI: %v, Gemini: %v", err1, err2, err3)
+	failures := make([]string, 0, len(providers))
+	for i, err := range providerErrors {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", providers[i].DisplayName(), err))
+		}
+	}
+	if len(failures) == len(providers) {
+		return nil, fmt.Errorf("all AI model calls failed: %s", strings.Join(failures, ", "))
 	}
 
 	// Merge all comments, ensuring uniqueness
+	mergeSetting := w.loadReviewMergeSetting()
 	var mergedComments []*InternalReviewComment
-
-	// Helper function to check if a comment overlaps with any existing comments
-	isOverlapping := func(newComment *InternalReviewComment, existingComments []*InternalReviewComment) bool {
-		for _, existing := range existingComments {
-			if commentsOverlap(newComment.PullRequestComment, existing.PullRequestComment) {
-				return true
-			}
-		}
-		return false
+	if mergeSetting.Mode == ReviewMergeModeConsensus {
+		mergedComments = mergeCommentsByConsensus(ctx, providers, providerComments, providerErrors, mergeSetting, w.recordEmbedder(consensusEmbedder()))
+	} else {
+		mergedComments = mergeCommentsByPriority(providerComments)
 	}
 
-	// Add Anthropic comments first (prioritize these)
-	mergedComments = append(mergedComments, anthropicComments...)
-
-	// Add OpenAI comments if they don't overlap with existing comments
-	for _, comment := range openaiComments {
-		if comment.Body != "" && !isOverlapping(comment, mergedComments) {
-			mergedComments = append(mergedComments, comment)
-		}
+	mergeFields := map[string]interface{}{
+		"repository":   w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+		"duration":     time.Since(aiStart),
+		"merged_count": len(mergedComments),
+		"merge_mode":   mergeSetting.Mode,
 	}
-
-	// Add Gemini comments if they don't overlap with existing comments
-	for _, comment := range geminiComments {
-		if comment.Body != "" && !isOverlapping(comment, mergedComments) {
-			mergedComments = append(mergedComments, comment)
-		}
+	providerNames := make([]string, len(providers))
+	for i, provider := range providers {
+		providerNames[i] = provider.Name()
+		mergeFields[provider.Name()+"_count"] = len(providerComments[i])
 	}
+	mergeFields["providers"] = providerNames
 
-	logging.LogWorkflowStep(
-		"CODE_REVIEW",
+	w.logWorkflowStep(
 		prNumber,
 		requestID,
 		"merged_ai_models_complete",
-		map[string]interface{}{
-			"repository":      w.githubConfig.Owner + "/" + w.githubConfig.Repo,
-			"duration":        time.Since(aiStart),
-			"anthropic_count": len(anthropicComments),
-			"openai_count":    len(openaiComments),
-			"openai_enabled":  useOpenAI,
-			"gemini_count":    len(geminiComments),
-			"gemini_enabled":  useGemini,
-			"merged_count":    len(mergedComments),
-		},
+		mergeFields,
 	)
 
+	fullPatch := BuildFullPatch(files)
+	fullPatchSum := sha256.Sum256([]byte(fullPatch))
 	for _, comment := range mergedComments {
-		commentType, err := w.classifyCommentType(
-			comment.Body,
-			BuildFullPatch(files),
-			commit,
-			requestID+"-classify-"+comment.ID,
-		)
+		// Out of time, keep the comments unclassified, their severity falls back to a default
+		if ctx.Err() != nil {
+			break
+		}
+		// Comments rejected during the merge are never posted, so don't spend a model call on them
+		if len(comment.RejectionReason) != 0 {
+			continue
+		}
+		classifyRequest := map[string]string{"body": comment.Body, "commit": commit, "patch_sha256": hex.EncodeToString(fullPatchSum[:])}
+		// Classification runs on the OpenAI model of the AI config
+		commentType, err := meteredStep(w, prNumber, "openai", w.aiConfig.GetOpenAIModel(), "classify_comment_type", comment.Body+fullPatch, func() (string, error) {
+			return recordStep(w, "model", "classify", classifyRequest, func() (string, error) {
+				return w.classifyCommentType(
+					comment.Body,
+					fullPatch,
+					commit,
+					requestID,
+				)
+			})
+		})
 		if err != nil {
-			logging.GetGlobalLogger().Warn("Failed to classify type", zap.Error(err))
+			logging.GetGlobalLogger().Warn("Failed to classify type", zap.Error(err), zap.String("request_id", requestID), zap.String("comment_id", comment.ID))
 			continue
 		}
 		comment.Type = commentType
-		logging.GetGlobalLogger().Info("Comment type classified successfully", zap.String("comment_type", commentType), zap.String("comment_body", comment.Body))
+		logging.GetGlobalLogger().Info("Comment type classified successfully", zap.String("request_id", requestID), zap.String("comment_id", comment.ID), zap.String("comment_type", commentType), zap.String("comment_body", comment.Body))
+	}
+
+	// Fall back to a severity derived from the comment type when a model didn't tag one
+	for _, comment := range mergedComments {
+		normalizeCommentSeverity(comment)
 	}
 
 	titleCaser := cases.Title(language.English)
@@ -782,8 +953,39 @@ func (w *CodeReviewWorkflow) callMultipleAIModels(
 	return mergedComments, nil
 }
 
+// mergeCommentsByPriority keeps every comment from the primary provider, then adds comments from
+// the remaining providers in priority order if they don't overlap with comments already kept
+func mergeCommentsByPriority(providerComments [][]*InternalReviewComment) []*InternalReviewComment {
+	var mergedComments []*InternalReviewComment
+
+	// Helper function to check if a comment overlaps with any existing comments
+	isOverlapping := func(newComment *InternalReviewComment, existingComments []*InternalReviewComment) bool {
+		for _, existing := range existingComments {
+			if commentsOverlap(newComment.PullRequestComment, existing.PullRequestComment) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, comments := range providerComments {
+		if i == 0 {
+			mergedComments = append(mergedComments, comments...)
+			continue
+		}
+		for _, comment := range comments {
+			if comment.Body != "" && !isOverlapping(comment, mergedComments) {
+				mergedComments = append(mergedComments, comment)
+			}
+		}
+	}
+
+	return mergedComments
+}
+
 // callAIModel handles calling an AI model with appropriate error handling and retry logic
 func (w *CodeReviewWorkflow) callAIModel(
+	ctx context.Context,
 	contextMessage string,
 	userMessage string,
 	tokenCount int,
@@ -793,29 +995,58 @@ func (w *CodeReviewWorkflow) callAIModel(
 	prNumber int,
 	requestID string,
 	aiStart time.Time,
-	modelCallFn func(string, string) (string, error),
-	provider string,
-	model string,
+	provider ReviewProvider,
 ) ([]*InternalReviewComment, error) {
-	message, err := modelCallFn(contextMessage, userMessage)
+	promptTokens := tokenCount
+	retries := 0
+	message, streamedComments, err := w.callReviewProvider(ctx, prNumber, provider, contextMessage, userMessage)
+	if err != nil && len(streamedComments) > 0 {
+		// The response was cut short, keep the comments that arrived completely instead of dropping everything
+		logging.GetGlobalLogger().Warn("Model response interrupted, keeping partially streamed comments",
+			zap.Error(err),
+			zap.String("provider", provider.Name()),
+			zap.Int("comment_count", len(streamedComments)))
+		err = nil
+	}
 
-	if err != nil {
+	if err != nil && ctx.Err() != nil {
+		// The provider or the review ran out of time, there's no point in retrying
+		w.logWorkflowError(
+			prNumber,
+			requestID,
+			err,
+			map[string]interface{}{
+				"step":       "call_ai_model",
+				"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+				"duration":   time.Since(aiStart),
+				"error_type": "deadline_exceeded",
+				"provider":   provider.Name(),
+			},
+		)
+		w.recordModelUsage(prNumber, provider.Name(), provider.Model(), "code_review", promptTokens, 0, retries, true)
+		return nil, fmt.Errorf("%s review didn't finish in time: %w", provider.DisplayName(), err)
+	} else if err != nil {
 		currentTokenCount, errMsg := extractTokenCountFromAnthropicError(err)
 		if errMsg == nil {
 			adjustedMaxTokenLimit := computeAdjustedTokenLimit(tokenCount, currentTokenCount, 3.0)
-			SendSlackNotification(1, fmt.Sprintf(
-				"🤖 Anthropic token mismatch detected.\n• Count we estimated: %d\n• Anthropic actual: %d\n• Adjusted Max Limit: %d",
-				tokenCount, currentTokenCount, adjustedMaxTokenLimit,
-			))
+			w.notify(prNumber, notify.Event{
+				Type:  NotificationTokenMismatch,
+				Title: "🤖 Anthropic token mismatch detected.",
+				Fields: map[string]string{
+					"Count we estimated": fmt.Sprint(tokenCount),
+					"Anthropic actual":   fmt.Sprint(currentTokenCount),
+					"Adjusted Max Limit": fmt.Sprint(adjustedMaxTokenLimit),
+				},
+			})
 
-			logging.LogWorkflowError(
-				"CODE_REVIEW",
+			w.logWorkflowError(
 				prNumber,
 				requestID,
 				err,
 				map[string]interface{}{
 					"step":                  "call_ai_model",
 					"repository":            w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+					"provider":              provider.Name(),
 					"duration":              time.Since(aiStart),
 					"error_type":            "token_limit",
 					"estimated_token_count": tokenCount,
@@ -826,58 +1057,71 @@ func (w *CodeReviewWorkflow) callAIModel(
 
 			// Log the retry attempt
 			retryStart := time.Now()
-			logging.LogWorkflowStep(
-				"CODE_REVIEW",
+			w.logWorkflowStep(
 				prNumber,
 				requestID,
 				"retry_ai_model_call",
 				map[string]interface{}{
 					"repository":           w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+					"provider":             provider.Name(),
 					"adjusted_token_limit": adjustedMaxTokenLimit,
 				},
 			)
 
 			contextMessage, userMessage = PruneToTokenLimit(contextMessage, userMessage, additionalContext, commit, files, adjustedMaxTokenLimit)
+			promptTokens = CountTokens(contextMessage) + CountTokens(userMessage)
+			retries++
 
-			message, err = modelCallFn(contextMessage, userMessage)
+			message, err = callProviderContext(ctx, provider, contextMessage, userMessage)
 
 			if err != nil {
-				SendSlackNotification(1, fmt.Sprintf("❌ Retried after adjustment but still failed: %v", err))
-				logging.LogWorkflowError(
-					"CODE_REVIEW",
+				w.notify(prNumber, notify.Event{
+					Type:    NotificationModelRetry,
+					Title:   "❌ Retried after adjustment but still failed",
+					Message: err.Error(),
+				})
+				w.logWorkflowError(
 					prNumber,
 					requestID,
 					err,
 					map[string]interface{}{
 						"step":       "retry_ai_model_call",
 						"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+						"provider":   provider.Name(),
 						"duration":   time.Since(retryStart),
 						"error_type": "retry_failed",
 					},
 				)
 			} else {
-				SendSlackNotification(1, "✅ Retried after adjustment and succeeded.")
-				logging.LogWorkflowStep(
-					"CODE_REVIEW",
+				w.notify(prNumber, notify.Event{
+					Type:  NotificationModelRetry,
+					Title: "✅ Retried after adjustment and succeeded.",
+				})
+				w.logWorkflowStep(
 					prNumber,
 					requestID,
 					"retry_ai_model_success",
 					map[string]interface{}{
 						"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+						"provider":   provider.Name(),
 						"duration":   time.Since(retryStart),
 					},
 				)
 			}
 		} else {
-			SendSlackNotification(1, fmt.Sprintf("⚠️ Failed to extract token count from error: %v", errMsg))
-			logging.LogWorkflowError(
-				"CODE_REVIEW",
+			w.notify(prNumber, notify.Event{
+				Type:    NotificationModelError,
+				Title:   "⚠️ Failed to extract token count from error",
+				Message: errMsg.Error(),
+			})
+			w.logWorkflowError(
 				prNumber,
 				requestID,
 				err,
 				map[string]interface{}{
 					"step":       "call_ai_model",
 					"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+					"provider":   provider.Name(),
 					"duration":   time.Since(aiStart),
 					"error_type": "unknown",
 				},
@@ -890,38 +1134,47 @@ func (w *CodeReviewWorkflow) callAIModel(
 			"CODE_REVIEW",
 			prNumber,
 			requestID,
-			"anthropic",
+			provider.Name(),
 			"code_review",
 			tokenCount,
 			responseTokens,
 			time.Since(aiStart),
 		)
 
-		logging.LogWorkflowStep(
-			"CODE_REVIEW",
+		w.logWorkflowStep(
 			prNumber,
 			requestID,
 			"call_ai_model_complete",
 			map[string]interface{}{
 				"repository":      w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+				"provider":        provider.Name(),
 				"duration":        time.Since(aiStart),
 				"response_tokens": responseTokens,
 			},
 		)
 	}
 
+	w.recordModelUsage(prNumber, provider.Name(), provider.Model(), "code_review", promptTokens, logging.EstimateTokenCount(message), retries, err != nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s call failed: %w", provider.DisplayName(), err)
+	}
+
 	var internalComments []*InternalReviewComment
 	parseStart := time.Now()
 	err = SanitizeAndParseJSON(message, &internalComments)
+	if err != nil && len(streamedComments) > 0 {
+		internalComments = streamedComments
+		err = nil
+	}
 	if err != nil {
-		logging.LogWorkflowError(
-			"CODE_REVIEW",
+		w.logWorkflowError(
 			prNumber,
 			requestID,
 			err,
 			map[string]interface{}{
 				"step":       "parse_comments",
 				"repository": w.githubConfig.Owner + "/" + w.githubConfig.Repo,
+				"provider":   provider.Name(),
 				"duration":   time.Since(parseStart),
 			},
 		)
@@ -930,8 +1183,8 @@ func (w *CodeReviewWorkflow) callAIModel(
 	}
 
 	for _, c := range internalComments {
-		c.Provider = provider
-		c.Model = model
+		c.Provider = provider.Name()
+		c.Model = provider.Model()
 		c.CommitID = commit
 	}
 
@@ -945,8 +1198,8 @@ func (w *CodeReviewWorkflow) callAIModel(
 			"repository":     w.githubConfig.Owner + "/" + w.githubConfig.Repo,
 			"comments_count": len(internalComments),
 			"commit_sha":     commit,
-			"provider":       provider,
-			"model":          model,
+			"provider":       provider.Name(),
+			"model":          provider.Model(),
 		},
 	)
 
@@ -979,6 +1232,39 @@ func buildSystemMessage(additionalContext map[string]interface{}, commit string)
 		Brevity:        "Be concise and focused in your review, do not include any reviews that might be subjective or are not actionable. Having extra comments that are not actionable will not improve code quality, it will go against the best practices of code review.",
 	}
 
+	config.Guidelines += severityInstructions
+
+	if incremental, _ := additionalContext["incremental_review"].(bool); incremental {
+		config.Guidelines += " This is an incremental review: the file changes only contain commits pushed since the last review. " +
+			"Earlier review comments are listed in previous_review_comments. Do not repeat them, and do not raise feedback again if the new changes resolve it."
+	}
+
+	if _, ok := additionalContext["ignored_rules"]; ok {
+		config.Guidelines += " The repository's maintainers asked not to flag the issues listed in ignored_rules. Do not raise comments about them."
+	}
+
+	if _, ok := additionalContext["static_findings"]; ok {
+		config.Guidelines += " The static_findings were already reported by deterministic checks and will be posted as they are. Do not comment on them again."
+	}
+
+	if _, ok := additionalContext["suppression_rules"]; ok {
+		config.Guidelines += " The suppression_rules list kinds of comments this repository's team has repeatedly dismissed. Do not raise comments they cover, except for bugs, security issues and blockers, which must always be raised."
+	}
+
+	if hasInstructions, _ := additionalContext["has_file_instructions"].(bool); hasInstructions {
+		config.Guidelines += " Some files in the user message come with file_instructions from the repository, keyed by path. " +
+			"Review each of those files from the point of view of its personas and follow its instructions, they don't apply to other files."
+	}
+
+	if _, ok := additionalContext["language_instructions"]; ok {
+		config.Guidelines += " Follow the repository's language_instructions when reviewing files in those languages."
+	}
+
+	if _, ok := additionalContext["related_code"]; ok {
+		config.Guidelines += " The related_code is code from elsewhere in the repository that the changes may use or affect, such as callers, callees and types. " +
+			"Use it to understand the changes and spot breakages it reveals, but only comment on the changed lines."
+	}
+
 	// Build base message
 	message := builder.BuildBaseMessage(config, additionalContext)
 
@@ -993,7 +1279,7 @@ func buildSystemMessage(additionalContext map[string]interface{}, commit string)
 
 // prepareUserMessage creates the user message with file changes
 func (w *CodeReviewWorkflow) prepareUserMessage(files []clients.PullRequestFile, tokenBudget int) string {
-	fileChanges := ExtractAllFileChanges(w.githubConfig, files, tokenBudget)
+	fileChanges := w.extractAllFileChanges(files, tokenBudget)
 
 	message, err := json.Marshal(fileChanges)
 
@@ -1011,26 +1297,17 @@ func (w *CodeReviewWorkflow) prepareUserMessage(files []clients.PullRequestFile,
 				 "patch": "%s"
 			 }`, file.Filename, file.Additions, file.Deletions, file.Changes, file.Status, file.Patch))
 		}
-		return fmt.Sprintf(`{"file_changes": [%s]}`, strings.Join(messages, ","))
-	}
-
-	return fmt.Sprintf(`{"file_changes": %s}`, string(message))
-}
-
-func (w *CodeReviewWorkflow) prepareContextFromRAG(files []clients.PullRequestFile, collectionName string) string {
-	queries, err := GenerateQueriesFromPRFiles(w.githubConfig, w.aiConfig, files)
-	if err != nil {
-		logging.GetGlobalLogger().Error("Error generating queries", zap.Error(err))
-		return ""
+		message = []byte("[" + strings.Join(messages, ",") + "]")
 	}
 
-	chromaOutput, err := ExecuteChromaSearch(queries, collectionName)
-	if err != nil {
-		logging.GetGlobalLogger().Error("Error executing chroma search", zap.Error(err))
-		return ""
+	// Attach the repository's instructions for the files that have any
+	if instructions := w.fileInstructions(files); len(instructions) > 0 {
+		if instructionsJSON, err := json.Marshal(instructions); err == nil {
+			return fmt.Sprintf(`{"file_changes": %s, "file_instructions": %s}`, string(message), string(instructionsJSON))
+		}
 	}
 
-	return chromaOutput
+	return fmt.Sprintf(`{"file_changes": %s}`, string(message))
 }
 
 type FilteredPullRequestComment struct {
@@ -1040,6 +1317,19 @@ type FilteredPullRequestComment struct {
 
 // PostReviewComments posts the review comments to GitHub after validating them
 func (w *CodeReviewWorkflow) PostReviewComments(prNumber int, companyId uint, comments []*InternalReviewComment, pullRequestFiles []clients.PullRequestFile) (postedComments []*InternalReviewComment, filteredComments []*InternalReviewComment, err error) {
+	return w.PostReviewCommentsContext(context.Background(), prNumber, companyId, comments, pullRequestFiles)
+}
+
+// PostReviewCommentsContext posts the review comments to GitHub after validating them. The GitHub
+// and model requests are aborted when ctx is done.
+func (w *CodeReviewWorkflow) PostReviewCommentsContext(ctx context.Context, prNumber int, companyId uint, comments []*InternalReviewComment, pullRequestFiles []clients.PullRequestFile) (postedComments []*InternalReviewComment, filteredComments []*InternalReviewComment, err error) {
+	defer w.bindGitHubClient(ctx)()
+	// The approval decision may call a model, persist its usage with the rest of the review
+	defer w.persistCostLedger(prNumber)
+	defer func() {
+		w.completeReviewRun(postedComments, filteredComments, err)
+	}()
+
 	prospectiveComments := []*InternalReviewComment{}
 	for _, comment := range comments {
 		if len(comment.RejectionReason) > 0 {
@@ -1059,92 +1349,133 @@ func (w *CodeReviewWorkflow) PostReviewComments(prNumber int, companyId uint, co
 		}
 	}
 
+	// Secrets are always posted, keep them out of the tiered filtering. The findings of the
+	// deterministic rules skip it too, but still honour the repository's minimum severity.
+	var secretComments []*InternalReviewComment
+	var staticComments []*InternalReviewComment
+	var filterableComments []*InternalReviewComment
+	for _, comment := range prospectiveComments {
+		switch comment.Provider {
+		case secretScannerProviderName:
+			secretComments = append(secretComments, comment)
+		case staticProviderName:
+			staticComments = append(staticComments, comment)
+		default:
+			filterableComments = append(filterableComments, comment)
+		}
+	}
+
+	// Drop the comments below the minimum severity the repository's config asks for
+	if w.repoConfig != nil {
+		var belowMinimum []*InternalReviewComment
+		filterableComments, belowMinimum = filterCommentsBySeverity(filterableComments, w.repoConfig)
+		filteredComments = append(filteredComments, belowMinimum...)
+		staticComments, belowMinimum = filterCommentsBySeverity(staticComments, w.repoConfig)
+		filteredComments = append(filteredComments, belowMinimum...)
+	}
+
 	// Apply tiered filtering using the new centralized function
 	prospectiveComments = ApplyTieredCommentFiltering(
 		w.db,
 		companyId,
 		logging.GetGlobalLogger(),
-		prospectiveComments,
+		filterableComments,
 		w.config,
 		w.aiConfig,
 	)
-
-	// Post the validated comments
-	for _, comment := range prospectiveComments {
-		response, err := w.githubConfig.Client.PostPullRequestComment(
-			w.githubConfig.Token,
-			w.githubConfig.Owner,
-			w.githubConfig.Repo,
-			prNumber,
-			&comment.PullRequestComment)
-
+	prospectiveComments = append(staticComments, prospectiveComments...)
+	prospectiveComments = append(secretComments, prospectiveComments...)
+
+	// Decide on the review state, approval is decided by applying the repository's approval policy to the comments we're about to post.
+	// A run whose models failed only has the deterministic findings, it can't approve.
+	event := ReviewEventComment
+	approvalReason := ""
+	if w.config.AutomaticApproval && !w.reviewScope.ModelsFailed {
+		should, reason, err := w.shouldApprovePR(ctx, prNumber, prospectiveComments)
 		if err != nil {
-			logging.GetGlobalLogger().Error("Failed to post comment", zap.Error(err))
-			comment.RejectionReason = "failed to post comment, error: " + err.Error()
-			filteredComments = append(filteredComments, comment)
-		} else {
-			// Record PRComment in database
-			prComment := models.PRComment{
-				SingleCompanyModel: models.SingleCompanyModel{
-					CompanyId: companyId,
-				},
-				PRNumber:         prNumber,
-				Owner:            w.githubConfig.Owner,
-				Repo:             w.githubConfig.Repo,
-				CommentID:        response.ID,
-				Author:           response.User.Login,
-				Body:             comment.Body,
-				CommentCreatedAt: utils.ParseTimeOrDefault(response.CreatedAt, time.Now()),
-				CommentUpdatedAt: utils.ParseTimeOrDefault(response.UpdatedAt, time.Now()),
-				IsByWorkflow:     true,
-				CommentType:      models.ReviewCommentType(comment.Type),
-			}
-			if err := w.db.Create(&prComment).Error; err != nil {
-				// Log the error but continue with the next comment
-				logging.GetGlobalLogger().Error("Failed to record PRComment", zap.Error(err))
-			}
-
-			postedComments = append(postedComments, comment)
+			logging.GetGlobalLogger().Info("Failed to determine if PR should be approved", zap.Error(err))
+			// Continue without approving
+		} else if should {
+			event = ReviewEventApprove
+			approvalReason = reason
+		} else if reason != "" {
+			logging.GetGlobalLogger().Info("PR not approved", zap.Int("pr_number", prNumber), zap.String("reason", reason))
 		}
 	}
+	hasBlockers := hasBlockingComments(prospectiveComments)
+	if hasBlockers && w.codeOwners != nil {
+		mentionOwnersOnBlockers(prospectiveComments, w.codeOwners)
+	}
+	if hasBlockers && models.IsFeatureEnabledForCompany(w.db, featureRequestChanges, companyId) {
+		event = ReviewEventRequestChanges
+	}
 
-	// Fall back to the original automatic approval logic if no decision was made
-	if w.config.AutomaticApproval && len(postedComments) == 0 {
-		// Determine if the PR should be approved based on the comments
-		should, reason, err := w.shouldApprovePR(comments)
+	// Post the validated comments and the summary as a single review
+	if len(prospectiveComments) > 0 || event != ReviewEventComment {
+		body := buildReviewSummary(event, prospectiveComments, w.githubConfig.CommitSHA, approvalReason)
+		review, inlineComments, err := w.submitPullRequestReview(prNumber, companyId, event, body, prospectiveComments)
 		if err != nil {
-			logging.GetGlobalLogger().Info("Failed to determine if PR should be approved", zap.Error(err))
-			// Continue with the default approval logic
-		} else if should {
-			// Approve the PR with the provided reason
-			approveMessage := fmt.Sprintf(`LGTM, ship it! :ship:
-			<details>
-			<summary>Why was this auto-approved?</summary>
-			%s
-			</details>`, reason)
-			err := w.githubConfig.Client.ApprovePullRequest(w.githubConfig.Token, w.githubConfig.Owner, w.githubConfig.Repo, prNumber, approveMessage)
-			if err != nil {
-				return postedComments, filteredComments, fmt.Errorf("failed to approve PR: %w", err)
+			logging.GetGlobalLogger().Error("Failed to submit review", zap.Error(err))
+			for _, comment := range prospectiveComments {
+				comment.RejectionReason = "failed to post comment, error: " + err.Error()
+				filteredComments = append(filteredComments, comment)
 			}
-			SendSlackNotification(1, fmt.Sprintf("✅ PR %d approved", prNumber))
+			return postedComments, filteredComments, err
+		}
+
+		w.recordPostedReviewComments(prNumber, companyId, review.ID, inlineComments)
+		postedComments = append(postedComments, prospectiveComments...)
+
+		if event == ReviewEventApprove {
+			w.notify(prNumber, notify.Event{
+				Type:    NotificationApproval,
+				Title:   fmt.Sprintf("✅ PR %d approved", prNumber),
+				Message: approvalReason,
+			})
 			w.AddExecutionLog("PR is auto approved")
-			logging.GetGlobalLogger().Info("PR approved", zap.Int("pr_number", prNumber), zap.String("reason", reason))
-		} else if reason != "" {
-			// Post a comment explaining why the PR was not approved
-			logging.GetGlobalLogger().Info("PR not approved", zap.Int("pr_number", prNumber), zap.String("reason", reason))
+			logging.GetGlobalLogger().Info("PR approved", zap.Int("pr_number", prNumber), zap.String("reason", approvalReason))
 		}
 	}
 
+	// Blockers raised by earlier runs are gone, withdraw our change requests. Only a review of the
+	// whole diff can tell, an incremental or path-limited run doesn't look at every earlier blocker.
+	if !hasBlockers && w.reviewScope.fullDiff() {
+		w.dismissResolvedChangeRequests(prNumber, companyId)
+	}
+
+	// Remember the reviewed head so the next push can be reviewed incrementally. A review limited
+	// to some paths, or without the models, leaves the rest of the new commits unreviewed.
+	if !w.reviewScope.PathFiltered && !w.reviewScope.ModelsFailed {
+		w.recordReviewedCommit(prNumber, w.reviewScope.HeadSHA)
+	}
+
 	return postedComments, filteredComments, nil
 }
 
-// shouldApprovePR determines if a PR should be approved based on the comments
-func (w *CodeReviewWorkflow) shouldApprovePR(comments []*InternalReviewComment) (bool, string, error) {
-	// If there are no comments, we can approve the PR
-	if len(comments) == 0 {
-		return true, "AI analysis completed with no actionable comments or suggestions.", nil
+// shouldApprovePR determines if a PR should be approved by applying the repository's approval
+// policy to the posted comments. A model is only consulted when the policy asks for a tie-breaker.
+func (w *CodeReviewWorkflow) shouldApprovePR(ctx context.Context, prNumber int, comments []*InternalReviewComment) (bool, string, error) {
+	decision := w.loadApprovalPolicy().Evaluate(comments)
+	defer w.logApprovalDecision(prNumber, decision)
+
+	if decision.Outcome != ApprovalOutcomeTieBreak {
+		return decision.Approve, decision.Reason, nil
+	}
+
+	decision.UsedTieBreaker = true
+	approve, reason, err := w.approvalTieBreaker(ctx, prNumber, comments)
+	if err != nil {
+		decision.Trail = append(decision.Trail, "tie-breaker failed: "+err.Error())
+		return false, "", err
 	}
+	decision.Approve = approve
+	decision.Reason = reason
+	decision.Trail = append(decision.Trail, fmt.Sprintf("tie-breaker approve=%t", approve))
+	return approve, reason, nil
+}
 
+// approvalTieBreaker asks a model whether the PR should be approved based on the comments
+func (w *CodeReviewWorkflow) approvalTieBreaker(ctx context.Context, prNumber int, comments []*InternalReviewComment) (bool, string, error) {
 	// Build a system message for PR approval decision
 	systemMessage := `You are a code review approval decision maker. Your task is to determine if a pull request should be approved based on the code review comments.
 
@@ -1171,22 +1502,18 @@ Only provide a reason when it adds clarity about **why** the PR is safe to appro
 	// Build the user message with all the comments
 	userMessage := "Please evaluate if this pull request should be approved based on the following code review comments:\n\n"
 	for i, comment := range comments {
-		// Extract comment type from the body if available, otherwise use "general"
-		commentType := "general"
-
-		// Try to parse the comment body as JSON to extract the type if it exists
-		var commentData map[string]interface{}
-		if err := json.Unmarshal([]byte(comment.Body), &commentData); err == nil {
-			if typeVal, ok := commentData["type"].(string); ok {
-				commentType = typeVal
-			}
+		// Use the classified comment type if available, otherwise use "general"
+		commentType := comment.Type
+		if commentType == "" {
+			commentType = "general"
 		}
 
-		userMessage += fmt.Sprintf("Comment %d (type: %s):\n%s\n\n", i+1, commentType, comment.Body)
+		userMessage += fmt.Sprintf("Comment %d (type: %s, severity: %s):\n%s\n\n", i+1, commentType, comment.Severity, comment.Body)
 	}
 
 	// Call the AI to make the approval decision
-	response, err := w.aiConfig.CallAnthropic(systemMessage, userMessage)
+	approvalFn := w.meteredModelCall(prNumber, "anthropic", string(w.aiConfig.GetAnthropicModel()), "approval", w.recordModelCall("approval", contextModelCall(ctx, w.aiConfig.CallAnthropicContext)))
+	response, err := approvalFn(systemMessage, userMessage)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to determine if PR should be approved: %w", err)
 	}